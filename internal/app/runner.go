@@ -2,23 +2,42 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	"github.com/codozor/fwkeeper/internal/certs"
 	"github.com/codozor/fwkeeper/internal/config"
 	"github.com/codozor/fwkeeper/internal/forwarder"
+	"github.com/codozor/fwkeeper/internal/hosts"
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 	"github.com/codozor/fwkeeper/internal/locator"
+	"github.com/codozor/fwkeeper/internal/loopback"
+	"github.com/codozor/fwkeeper/internal/proxy"
+	"github.com/codozor/fwkeeper/internal/relay"
 )
 
+// relayTimeout bounds the relay pod API calls made outside of a forwarder.
+const relayTimeout = 10 * time.Second
+
+// hostsAddress is the address hostnames of forwards without address are mapped to: they
+// listen on loopback.
+const hostsAddress = "127.0.0.1"
+
 // Runner orchestrates multiple port forwarders and manages their lifecycle.
 type Runner struct {
 	configuration config.Configuration
@@ -33,6 +52,35 @@ type Runner struct {
 	kubeConfigSource  string
 	kubeConfigContext string
 
+	// cache is the informer cache shared by all locators, bound to the runner context
+	cache *kubeinternal.InformerCache
+
+	// relays manages the relay pods of host forwards
+	relays *relay.Manager
+
+	// proxy is the SOCKS5 and HTTP CONNECT proxy, nil when disabled; proxyCancel stops it
+	// and proxyDone is closed once stopped
+	proxy       *proxy.Server
+	proxyCancel context.CancelFunc
+	proxyDone   chan struct{}
+
+	// hostsFile holds the hostnames of forwards, nil when disabled
+	hostsFile *hosts.File
+
+	// hostsRecord records the path of hostsFile, so that the block of a crashed run is
+	// removed at the next start; empty when not recorded
+	hostsRecord string
+
+	// authority issues the certificates of self-signed TLS forwards, loaded on first use
+	authority *certs.Authority
+
+	// policies is the retry policy table of the current configuration, checked when it is loaded
+	policies forwarder.RetryPolicies
+
+	// transports remembers per cluster the port-forward transport that worked, shared by
+	// all forwarders and kept across reloads
+	transports *forwarder.TransportMemory
+
 	// forwarders is a map of forward name to forwarder for easy management
 	forwarders map[string]*forwarder.Forwarder
 
@@ -56,6 +104,10 @@ func New(
 	kubeConfigSource string,
 	kubeConfigContext string,
 ) *Runner {
+	// Without a configuration directory, the block of a crashed run is only replaced in the
+	// hosts file still configured
+	hostsRecord, _ := hosts.DefaultRecordPath()
+
 	return &Runner{
 		configuration:     configuration,
 		configPath:        configPath,
@@ -64,6 +116,9 @@ func New(
 		restCfg:           restCfg,
 		kubeConfigSource:  kubeConfigSource,
 		kubeConfigContext: kubeConfigContext,
+		hostsRecord:       hostsRecord,
+		policies:          forwarder.DefaultRetryPolicies(),
+		transports:        forwarder.NewTransportMemory(),
 		forwarders:        make(map[string]*forwarder.Forwarder),
 		forwarderCancel:   make(map[string]context.CancelFunc),
 	}
@@ -81,8 +136,46 @@ func (r *Runner) Start() error {
 
 	log.Info().Msgf("Kubernetes config source: %s (context: %s)", r.kubeConfigSource, r.kubeConfigContext)
 
+	policies, err := forwarder.RetryPoliciesFromConfig(r.configuration.Retry)
+	if err != nil {
+		return fmt.Errorf("cannot start: invalid retry configuration: %w", err)
+	}
+	r.policies = policies
+
+	cfg, err := r.allocateAddresses(log, r.configuration)
+	if err != nil {
+		return fmt.Errorf("cannot start: %w", err)
+	}
+	r.configuration = cfg
+
+	// Share one informer cache between all locators
+	if r.client != nil {
+		// The dynamic client is only used for Gateway API routes; without it, httproute targets fail to locate
+		var dynamicClient dynamic.Interface
+		if dc, err := dynamic.NewForConfig(r.restCfg); err != nil {
+			log.Warn().Err(err).Msg("Cannot create dynamic client, httproute resources are unavailable")
+		} else {
+			dynamicClient = dc
+		}
+		r.cache = kubeinternal.NewInformerCache(ctx, r.client, dynamicClient, r.kubeConfigContext)
+
+		r.relays = relay.NewManager(r.client, r.configuration.Relay, relay.DefaultOwner())
+		r.collectRelayOrphans(ctx)
+	}
+
 	// Start initial forwarders
 	nErr := r.startForwarders(ctx)
+
+	r.mu.Lock()
+	if err := r.startProxy(ctx, r.configuration); err != nil {
+		log.Err(err).Msg("Cannot start proxy")
+		nErr++
+	}
+	// Also replaces the block left by a crashed run
+	r.removeStaleHosts(log, r.configuration)
+	r.updateHosts(log, r.configuration)
+	r.mu.Unlock()
+
 	if nErr > 0 {
 		return fmt.Errorf("cannot start: %d configuration error(s) - see logs above", nErr)
 	}
@@ -116,9 +209,16 @@ func (r *Runner) startForwarders(ctx context.Context) int {
 	return nErr
 }
 
-// startForwarder creates and starts a single forwarder.
+// startForwarder creates and starts a single forwarder, with additional options.
+// Must be called with r.mu locked.
+func (r *Runner) startForwarder(ctx context.Context, pf config.PortForwardConfiguration, extra ...forwarder.Option) error {
+	return r.startForwarderAfter(ctx, pf, nil, extra...)
+}
+
+// startForwarderAfter creates a forwarder like startForwarder, and starts it once released is
+// closed when not nil, e.g. once the relay of the forwarder it replaces is deleted.
 // Must be called with r.mu locked.
-func (r *Runner) startForwarder(ctx context.Context, pf config.PortForwardConfiguration) error {
+func (r *Runner) startForwarderAfter(ctx context.Context, pf config.PortForwardConfiguration, released <-chan struct{}, extra ...forwarder.Option) error {
 	log := zerolog.Ctx(ctx)
 
 	// Skip if already running
@@ -126,12 +226,22 @@ func (r *Runner) startForwarder(ctx context.Context, pf config.PortForwardConfig
 		return nil
 	}
 
-	loc, err := locator.BuildLocator(pf.Resource, pf.Namespace, pf.Ports, r.client)
+	loc, err := r.buildLocator(pf)
 	if err != nil {
 		return fmt.Errorf("failed to build locator: %w", err)
 	}
 
-	f, err := forwarder.New(loc, pf, r.client, r.restCfg)
+	opts := []forwarder.Option{forwarder.WithRetryPolicies(r.policies), forwarder.WithTransportMemory(r.transports)}
+	if pf.TLS != nil && pf.TLS.SelfSigned {
+		authority, err := r.certificateAuthority(log)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, forwarder.WithAuthority(authority))
+	}
+	opts = append(opts, extra...)
+
+	f, err := forwarder.New(loc, pf, r.client, r.restCfg, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create forwarder: %w", err)
 	}
@@ -145,6 +255,12 @@ func (r *Runner) startForwarder(ctx context.Context, pf config.PortForwardConfig
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
+		if released != nil {
+			select {
+			case <-released:
+			case <-fwdCtx.Done():
+			}
+		}
 		log.Info().Msgf("Starting forwarder: %s", pf.Name)
 		f.Start(fwdCtx)
 		log.Info().Msgf("Forwarder stopped: %s", pf.Name)
@@ -153,9 +269,36 @@ func (r *Runner) startForwarder(ctx context.Context, pf config.PortForwardConfig
 	return nil
 }
 
-// stopForwarder gracefully stops a single forwarder.
+// buildLocator creates the locator of a forward: the relay pod of reverse forwards, the
+// configured resource otherwise.
+func (r *Runner) buildLocator(pf config.PortForwardConfiguration) (locator.Locator, error) {
+	if pf.Kind == "reverse" {
+		if pf.Reverse == nil {
+			return nil, fmt.Errorf("reverse forward %s has no reverse configuration", pf.Name)
+		}
+
+		var reverseRelay locator.ReverseRelay
+		if r.relays != nil {
+			reverseRelay = r.relays.Reverse(pf.Name, pf.Namespace, pf.Reverse.Service)
+		}
+		return locator.NewReverseLocator(pf.Reverse.Port, reverseRelay)
+	}
+
+	opts := []locator.Option{locator.WithNode(pf.Node)}
+	if pf.Match != nil {
+		opts = append(opts, locator.WithRouteMatch(pf.Match.Host, pf.Match.Path))
+	}
+
+	if r.relays != nil {
+		opts = append(opts, locator.WithRelay(r.relays.For(pf.Name)))
+	}
+
+	return locator.BuildLocator(pf.Resource, pf.Namespace, pf.Ports, r.cache, opts...)
+}
+
+// stopForwarder gracefully stops a single forwarder and releases its relay, see releaseRelay.
 // Must be called with r.mu locked.
-func (r *Runner) stopForwarder(name string) {
+func (r *Runner) stopForwarder(name string) <-chan struct{} {
 	log := r.logger
 	if cancel, exists := r.forwarderCancel[name]; exists {
 		log.Info().Msgf("Stopping forwarder: %s", name)
@@ -163,6 +306,299 @@ func (r *Runner) stopForwarder(name string) {
 		delete(r.forwarders, name)
 		delete(r.forwarderCancel, name)
 	}
+
+	return r.releaseRelay(name)
+}
+
+// releaseRelay forgets the relay pod and Service of a forward at once and deletes them in the
+// background, so that API calls do not hold the runner lock. It returns a channel closed once
+// they are deleted, nil when the forward has none.
+func (r *Runner) releaseRelay(name string) <-chan struct{} {
+	if r.relays == nil {
+		return nil
+	}
+	release := r.relays.Detach(name)
+	if release == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+		defer cancel()
+		if err := release(ctx); err != nil {
+			r.logger.Warn().Err(err).Msgf("Cannot delete relay pod of forwarder %s", name)
+		}
+	}()
+	return done
+}
+
+// isHostResource reports whether a forward reaches an in-cluster host through a relay pod.
+func isHostResource(pf config.PortForwardConfiguration) bool {
+	return pf.Kind != "reverse" && strings.HasPrefix(pf.Resource, "host/")
+}
+
+// replaceForwarder restarts a forwarder with a new configuration. The new forwarder takes
+// over the local ports both configurations share without closing them, and the previous
+// one drains its connections in the background (see forwarder.WithPredecessor). Reverse
+// forwards, bound to their relay pod, are stopped and started again once it is deleted.
+// Must be called with r.mu locked.
+func (r *Runner) replaceForwarder(ctx context.Context, pf config.PortForwardConfiguration) error {
+	previous := r.forwarders[pf.Name]
+	if pf.Kind == "reverse" || previous.Config().Kind == "reverse" {
+		released := r.stopForwarder(pf.Name)
+		return r.startForwarderAfter(ctx, pf, released)
+	}
+
+	stop := r.forwarderCancel[pf.Name]
+	if isHostResource(previous.Config()) && !isHostResource(pf) {
+		// The relay pod serves the forwarder replaced until it is stopped
+		cancel := stop
+		stop = func() {
+			cancel()
+			r.releaseRelay(pf.Name)
+		}
+	}
+	delete(r.forwarders, pf.Name)
+	delete(r.forwarderCancel, pf.Name)
+
+	if err := r.startForwarder(ctx, pf, forwarder.WithPredecessor(previous, stop)); err != nil {
+		stop()
+		return err
+	}
+	return nil
+}
+
+// startProxy starts the proxy when the configuration enables it.
+// Must be called with r.mu locked.
+func (r *Runner) startProxy(ctx context.Context, cfg config.Configuration) error {
+	if cfg.Proxy == nil {
+		return nil
+	}
+	if r.cache == nil {
+		return fmt.Errorf("the proxy requires a Kubernetes client")
+	}
+
+	server, err := proxy.New(*cfg.Proxy, r.client, r.restCfg, r.cache,
+		forwarder.WithRetryPolicies(r.policies),
+		forwarder.WithTransportMemory(r.transports),
+		forwarder.WithDefaultTransport(cfg.Transport),
+	)
+	if err != nil {
+		return err
+	}
+
+	proxyCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	r.proxy = server
+	r.proxyCancel = cancel
+	r.proxyDone = done
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(done)
+		server.Serve(proxyCtx)
+	}()
+
+	return nil
+}
+
+// stopProxy stops the proxy, if running, and waits until its address is released.
+// Must be called with r.mu locked.
+func (r *Runner) stopProxy() {
+	if r.proxy == nil {
+		return
+	}
+
+	r.proxyCancel()
+	<-r.proxyDone
+
+	r.proxy = nil
+	r.proxyCancel = nil
+	r.proxyDone = nil
+}
+
+// updateHosts writes the hostnames of the configured forwards to the hosts file. The block
+// is removed from a hosts file that is no longer configured.
+// Must be called with r.mu locked.
+func (r *Runner) updateHosts(log *zerolog.Logger, cfg config.Configuration) {
+	if r.hostsFile != nil && (cfg.Hosts == nil || cfg.Hosts.File != r.hostsFile.Path()) {
+		if err := r.hostsFile.Remove(); err != nil {
+			log.Err(err).Msgf("Cannot remove hostnames from %s", r.hostsFile.Path())
+		} else {
+			r.recordHosts(log, "")
+		}
+		r.hostsFile = nil
+	}
+
+	if cfg.Hosts == nil {
+		return
+	}
+	if r.hostsFile == nil {
+		r.hostsFile = hosts.NewFile(cfg.Hosts.File)
+		r.recordHosts(log, cfg.Hosts.File)
+	}
+
+	var entries []hosts.Entry
+	for _, pf := range cfg.Forwards {
+		if pf.Hostname != "" {
+			address := pf.Address
+			if address == "" {
+				address = hostsAddress
+			}
+			entries = append(entries, hosts.Entry{Address: address, Hostname: pf.Hostname, Comment: pf.Name})
+		}
+	}
+
+	if err := r.hostsFile.Update(entries); err != nil {
+		log.Err(err).Msgf("Cannot update hostnames in %s", r.hostsFile.Path())
+		return
+	}
+	log.Debug().Msgf("%d hostname(s) written to %s", len(entries), r.hostsFile.Path())
+}
+
+// removeStaleHosts removes the block a crashed run left in a hosts file that is no longer
+// configured. The block of a run still running is left alone, and the block in the configured
+// hosts file is replaced by updateHosts.
+func (r *Runner) removeStaleHosts(log *zerolog.Logger, cfg config.Configuration) {
+	if r.hostsRecord == "" {
+		return
+	}
+
+	record, err := hosts.ReadRecord(r.hostsRecord)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Cannot read the hosts file record %s", r.hostsRecord)
+		return
+	}
+	if record.Path == "" || (cfg.Hosts != nil && cfg.Hosts.File == record.Path) {
+		return
+	}
+	if record.Live() {
+		log.Debug().Msgf("Hostnames in %s are kept, they belong to the running process %d", record.Path, record.PID)
+		return
+	}
+
+	if err := hosts.NewFile(record.Path).Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Err(err).Msgf("Cannot remove hostnames left in %s", record.Path)
+		return
+	}
+	log.Info().Msgf("Hostnames left by a previous run removed from %s", record.Path)
+	if err := hosts.RemoveRecord(r.hostsRecord, record.PID); err != nil {
+		log.Warn().Err(err).Msgf("Cannot remove the hosts file record %s", r.hostsRecord)
+	}
+}
+
+// recordHosts records the path of the hosts file in use, removing the record of this process
+// when none.
+func (r *Runner) recordHosts(log *zerolog.Logger, path string) {
+	if r.hostsRecord == "" {
+		return
+	}
+
+	var err error
+	if path == "" {
+		err = hosts.RemoveRecord(r.hostsRecord, os.Getpid())
+	} else {
+		err = hosts.WriteRecord(r.hostsRecord, path)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("Cannot record the hosts file in %s", r.hostsRecord)
+	}
+}
+
+// allocateAddresses returns cfg with the "auto" address of forwards replaced by the address
+// allocated to them from the loopback pool.
+func (r *Runner) allocateAddresses(log *zerolog.Logger, cfg config.Configuration) (config.Configuration, error) {
+	var names []string
+	for _, pf := range cfg.Forwards {
+		if pf.Address == config.AutoAddress {
+			names = append(names, pf.Name)
+		}
+	}
+	if len(names) == 0 {
+		return cfg, nil
+	}
+
+	path := cfg.Loopback.File
+	if path == "" {
+		var err error
+		if path, err = loopback.DefaultPath(); err != nil {
+			return cfg, err
+		}
+	}
+
+	allocator, err := loopback.NewAllocator(cfg.Loopback.Pool, path)
+	if err != nil {
+		return cfg, err
+	}
+
+	addresses, err := allocator.Allocate(names)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot allocate forward addresses: %w", err)
+	}
+
+	forwards := slices.Clone(cfg.Forwards)
+	for i, pf := range forwards {
+		if addr, allocated := addresses[pf.Name]; allocated {
+			forwards[i].Address = addr.String()
+			log.Debug().Msgf("Address %s allocated to forward %s", addr, pf.Name)
+		}
+	}
+	cfg.Forwards = forwards
+
+	return cfg, nil
+}
+
+// certificateAuthority returns the local certificate authority, loading or creating it on
+// first use. Must be called with r.mu locked.
+func (r *Runner) certificateAuthority(log *zerolog.Logger) (*certs.Authority, error) {
+	if r.authority != nil {
+		return r.authority, nil
+	}
+
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+
+	authority, err := certs.LoadAuthority(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Self-signed TLS certificates are issued by the certificate authority %s", authority.CertPath())
+
+	r.authority = authority
+	return authority, nil
+}
+
+// collectRelayOrphans deletes the relay pods and Services left over by a previous run, e.g. after
+// a crash, in the relay namespace and the namespaces of reverse forwards.
+func (r *Runner) collectRelayOrphans(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+
+	var namespaces []string
+	for _, pf := range r.configuration.Forwards {
+		if pf.Kind == "reverse" {
+			namespaces = append(namespaces, pf.Namespace)
+		}
+	}
+
+	deleted, err := r.relays.CollectOrphans(ctx, namespaces...)
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot collect orphan relay pods")
+	}
+	if deleted > 0 {
+		log.Info().Msgf("Deleted %d orphan relay object(s)", deleted)
+	}
 }
 
 // startBanner logs the application startup banner.
@@ -290,11 +726,31 @@ func (r *Runner) reloadConfig(ctx context.Context) {
 		return
 	}
 
+	// Checked before any forwarder is touched
+	policies, err := forwarder.RetryPoliciesFromConfig(newConfig.Retry)
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration reload failed - keeping previous configuration: invalid retry configuration")
+		return
+	}
+
+	newConfig, err = r.allocateAddresses(log, newConfig)
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration reload failed - keeping previous configuration")
+		return
+	}
+
 	log.Info().Msg("Configuration reloaded successfully")
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.policies = policies
+
+	// New relay settings apply to the relay pods created from now on
+	if r.relays != nil {
+		r.relays.Configure(newConfig.Relay)
+	}
+
 	// Find forwarders to remove
 	for name := range r.forwarders {
 		found := false
@@ -315,12 +771,33 @@ func (r *Runner) reloadConfig(ctx context.Context) {
 		if existing, exists := r.forwarders[pf.Name]; exists {
 			// Check if configuration changed
 			if configChanged(existing.Config(), pf) {
-				r.stopForwarder(pf.Name)
-				if err := r.startForwarder(ctx, pf); err != nil {
+				if err := r.replaceForwarder(ctx, pf); err != nil {
 					log.Err(err).Msgf("Failed to restart forwarder: %s", pf.Name)
 				} else {
 					log.Info().Msgf("Restarted forward: %s", pf.Name)
 				}
+			} else if existing.Status().State == forwarder.StateFailed {
+				// Stopped by its retry policy: the reload may follow a fix of permissions or of the cluster
+				released := r.stopForwarder(pf.Name)
+				if err := r.startForwarderAfter(ctx, pf, released); err != nil {
+					log.Err(err).Msgf("Failed to restart forwarder: %s", pf.Name)
+				} else {
+					log.Info().Msgf("Restarted failed forward: %s", pf.Name)
+				}
+			} else {
+				// Capture and shaping are changed without restarting the forwarder
+				if !reflect.DeepEqual(existing.Config().Capture, pf.Capture) {
+					if err := existing.SetCapture(log, pf.Capture); err != nil {
+						log.Err(err).Msgf("Failed to change capture of forwarder: %s", pf.Name)
+					}
+				}
+				if !reflect.DeepEqual(existing.Config().Shaping, pf.Shaping) {
+					if err := existing.SetShaping(pf.Shaping); err != nil {
+						log.Err(err).Msgf("Failed to change shaping of forwarder: %s", pf.Name)
+					} else {
+						log.Info().Msgf("Updated shaping of forward: %s", pf.Name)
+					}
+				}
 			}
 		} else {
 			// New forwarder
@@ -332,14 +809,76 @@ func (r *Runner) reloadConfig(ctx context.Context) {
 		}
 	}
 
+	// Restart the proxy when its settings or the transport of its tunnels changed
+	if !reflect.DeepEqual(r.configuration.Proxy, newConfig.Proxy) || (newConfig.Proxy != nil && r.configuration.Transport != newConfig.Transport) {
+		r.stopProxy()
+		if err := r.startProxy(ctx, newConfig); err != nil {
+			log.Err(err).Msg("Failed to restart proxy")
+		} else if newConfig.Proxy != nil {
+			log.Info().Msg("Restarted proxy")
+		}
+	}
+
+	r.updateHosts(log, newConfig)
+
 	// Update the current configuration
 	r.configuration = newConfig
 }
 
 // configChanged checks if a forwarder's configuration has changed.
 func configChanged(oldConfig config.PortForwardConfiguration, newConfig config.PortForwardConfiguration) bool {
-	// Check if namespace or resource changed
-	if oldConfig.Namespace != newConfig.Namespace || oldConfig.Resource != newConfig.Resource {
+	// Check if namespace, resource or node changed
+	if oldConfig.Namespace != newConfig.Namespace || oldConfig.Resource != newConfig.Resource || oldConfig.Node != newConfig.Node {
+		return true
+	}
+
+	// Check if the kind or the reverse forward changed
+	if oldConfig.Kind != newConfig.Kind || !reflect.DeepEqual(oldConfig.Reverse, newConfig.Reverse) {
+		return true
+	}
+
+	// Check if the local address changed
+	if oldConfig.Address != newConfig.Address {
+		return true
+	}
+
+	// Check if the hold timeout or socket permissions changed
+	if oldConfig.HoldTimeout != newConfig.HoldTimeout || oldConfig.SocketMode != newConfig.SocketMode {
+		return true
+	}
+
+	// Check if the lazy mode or its idle timeout changed
+	if oldConfig.Lazy != newConfig.Lazy || oldConfig.IdleTimeout != newConfig.IdleTimeout {
+		return true
+	}
+
+	// Check if the load balancing changed
+	if oldConfig.Mode != newConfig.Mode || oldConfig.Replicas != newConfig.Replicas || oldConfig.LoadBalancing != newConfig.LoadBalancing {
+		return true
+	}
+
+	// Check if the route match or health check changed
+	if !reflect.DeepEqual(oldConfig.Match, newConfig.Match) || !reflect.DeepEqual(oldConfig.Health, newConfig.Health) {
+		return true
+	}
+
+	// Check if TLS changed, including the hostname a self-signed certificate is issued for
+	if !reflect.DeepEqual(oldConfig.TLS, newConfig.TLS) || (newConfig.TLS != nil && oldConfig.Hostname != newConfig.Hostname) {
+		return true
+	}
+
+	// Check if the HTTP inspection changed
+	if !reflect.DeepEqual(oldConfig.HTTP, newConfig.HTTP) {
+		return true
+	}
+
+	// Check if the transport changed
+	if oldConfig.Transport != newConfig.Transport {
+		return true
+	}
+
+	// Check if the faults changed: restarting replays them from their seed
+	if !reflect.DeepEqual(oldConfig.Faults, newConfig.Faults) {
 		return true
 	}
 
@@ -356,6 +895,50 @@ func configChanged(oldConfig config.PortForwardConfiguration, newConfig config.P
 	return false
 }
 
+// Status returns a snapshot of the status of all forwarders, including the tunnels
+// opened by the proxy, sorted by name.
+func (r *Runner) Status() []forwarder.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]forwarder.Status, 0, len(r.forwarders))
+	for _, f := range r.forwarders {
+		if f == nil {
+			continue
+		}
+		statuses = append(statuses, f.Status())
+	}
+	if r.proxy != nil {
+		statuses = append(statuses, r.proxy.Status()...)
+	}
+
+	slices.SortFunc(statuses, func(a, b forwarder.Status) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return statuses
+}
+
+// Metrics returns a snapshot of the connection counters of all forwarders, sorted by name.
+func (r *Runner) Metrics() []forwarder.Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]forwarder.Metrics, 0, len(r.forwarders))
+	for _, f := range r.forwarders {
+		if f == nil {
+			continue
+		}
+		metrics = append(metrics, f.Metrics())
+	}
+
+	slices.SortFunc(metrics, func(a, b forwarder.Metrics) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return metrics
+}
+
 // Shutdown gracefully shuts down the runner and all forwarders.
 func (r *Runner) Shutdown() {
 	log := r.logger
@@ -366,6 +949,26 @@ func (r *Runner) Shutdown() {
 
 	r.wg.Wait()
 
+	if r.hostsFile != nil {
+		if err := r.hostsFile.Remove(); err != nil {
+			log.Warn().Err(err).Msgf("Cannot remove hostnames from %s", r.hostsFile.Path())
+		} else {
+			r.recordHosts(&log, "")
+		}
+	}
+
+	if r.relays != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), relayTimeout)
+		if err := r.relays.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("Cannot delete relay pods")
+		}
+		cancel()
+	}
+
+	if r.cache != nil {
+		r.cache.Shutdown()
+	}
+
 	log.Info().Msg(`------------------------------------------------------------------`)
 	log.Info().Msg(`fwkeeper Stopped`)
 	log.Info().Msg(`------------------------------------------------------------------`)
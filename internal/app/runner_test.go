@@ -1,9 +1,15 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -11,12 +17,36 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/forwarder"
+	"github.com/codozor/fwkeeper/internal/relay"
 )
 
+// TestMain runs the tests with a temporary user configuration directory, so that runners do
+// not read or write the files of the developer, such as the hosts file record
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fwkeeper-app")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// The user configuration directory of Linux, macOS and Windows
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("HOME", dir)
+	os.Setenv("AppData", dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // TestRunnerStart tests basic runner initialization
 func TestRunnerStart(t *testing.T) {
 	cfg := config.Configuration{
@@ -274,6 +304,184 @@ func TestConfigChanged(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			name: "node changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ds/exporter",
+				Ports:     []string{"9100"},
+				Node:      "node-a",
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ds/exporter",
+				Ports:     []string{"9100"},
+				Node:      "node-b",
+			},
+			expected: true,
+		},
+		{
+			name: "route match changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ingress/app",
+				Ports:     []string{"8080"},
+				Match:     &config.RouteMatchConfiguration{Host: "app.example.com", Path: "/"},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ingress/app",
+				Ports:     []string{"8080"},
+				Match:     &config.RouteMatchConfiguration{Host: "app.example.com", Path: "/api"},
+			},
+			expected: true,
+		},
+		{
+			name: "health check changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "svc/api",
+				Ports:     []string{"8080"},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "svc/api",
+				Ports:     []string{"8080"},
+				Health:    &config.HealthCheckConfiguration{Type: "tcp", Interval: "10s", Timeout: "2s", FailureThreshold: 3},
+			},
+			expected: true,
+		},
+		{
+			name: "replicas changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "svc/api",
+				Ports:     []string{"8080"},
+				Mode:      "balance",
+				Replicas:  2,
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "svc/api",
+				Ports:     []string{"8080"},
+				Mode:      "balance",
+				Replicas:  3,
+			},
+			expected: true,
+		},
+		{
+			name: "socket mode changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:       "forward-1",
+				Namespace:  "default",
+				Resource:   "svc/db",
+				Ports:      []string{"unix:/tmp/pg.sock:5432"},
+				SocketMode: "0600",
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:       "forward-1",
+				Namespace:  "default",
+				Resource:   "svc/db",
+				Ports:      []string{"unix:/tmp/pg.sock:5432"},
+				SocketMode: "0660",
+			},
+			expected: true,
+		},
+		{
+			name: "reverse address changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Kind:      "reverse",
+				Namespace: "dev",
+				Reverse:   &config.ReverseConfiguration{Address: "localhost:3000", Port: 8080, Connections: 4},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Kind:      "reverse",
+				Namespace: "dev",
+				Reverse:   &config.ReverseConfiguration{Address: "localhost:3001", Port: 8080, Connections: 4},
+			},
+			expected: true,
+		},
+		{
+			name: "lazy mode enabled",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+				Lazy:      true,
+			},
+			expected: true,
+		},
+		{
+			name: "transport changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+				Transport: config.TransportAuto,
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+				Transport: config.TransportSPDY,
+			},
+			expected: true,
+		},
+		{
+			name: "faults seed changed",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+				Faults:    &config.FaultsConfiguration{Seed: 1, Reset: 10},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "pod-1",
+				Ports:     []string{"8080"},
+				Faults:    &config.FaultsConfiguration{Seed: 2, Reset: 10},
+			},
+			expected: true,
+		},
+		{
+			name: "route match unchanged",
+			oldCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ingress/app",
+				Ports:     []string{"8080"},
+				Match:     &config.RouteMatchConfiguration{Host: "app.example.com"},
+			},
+			newCfg: config.PortForwardConfiguration{
+				Name:      "forward-1",
+				Namespace: "default",
+				Resource:  "ingress/app",
+				Ports:     []string{"8080"},
+				Match:     &config.RouteMatchConfiguration{Host: "app.example.com"},
+			},
+			expected: false,
+		},
 		{
 			name: "ports added",
 			oldCfg: config.PortForwardConfiguration{
@@ -1904,3 +2112,594 @@ func TestMultipleSignalChannels(t *testing.T) {
 	assert.NotNil(t, sigChan1)
 	assert.NotNil(t, sigChan2)
 }
+
+// TestRunnerStatus tests that the runner aggregates the status of its forwarders
+func TestRunnerStatus(t *testing.T) {
+	cfg := config.Configuration{
+		Forwards: []config.PortForwardConfiguration{
+			{Name: "forward-b", Namespace: "default", Resource: "missing-b", Ports: []string{"8081"}},
+			{Name: "forward-a", Namespace: "default", Resource: "missing-a", Ports: []string{"8080"}},
+		},
+	}
+
+	runner := New(cfg, "", zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	// Nil entries (as used by other tests) are skipped
+	runner.mu.Lock()
+	runner.forwarders["placeholder"] = nil
+	runner.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		statuses := runner.Status()
+		return len(statuses) == 2 && statuses[0].State == forwarder.StateBackoff && statuses[1].State == forwarder.StateBackoff
+	}, 5*time.Second, 10*time.Millisecond)
+
+	statuses := runner.Status()
+	assert.Equal(t, "forward-a", statuses[0].Name)
+	assert.Equal(t, "forward-b", statuses[1].Name)
+	assert.Error(t, statuses[0].LastError)
+}
+
+// TestRunnerMetrics tests that the runner aggregates the metrics of its forwarders
+func TestRunnerMetrics(t *testing.T) {
+	cfg := config.Configuration{
+		Forwards: []config.PortForwardConfiguration{
+			{Name: "forward-b", Namespace: "default", Resource: "missing-b", Ports: []string{"8081"}},
+			{Name: "forward-a", Namespace: "default", Resource: "missing-a", Ports: []string{"8080"}},
+		},
+	}
+
+	runner := New(cfg, "", zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	metrics := runner.Metrics()
+
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "forward-a", metrics[0].Name)
+	assert.Equal(t, "forward-b", metrics[1].Name)
+	assert.Equal(t, uint64(0), metrics[0].TotalConnections)
+}
+
+// TestRunnerRelayPods tests that relay pods of host forwards are created, collected when
+// orphaned by a previous run, and deleted on shutdown
+func TestRunnerRelayPods(t *testing.T) {
+	relayCfg := config.RelayConfiguration{Namespace: "tools", Image: "alpine/socat:1.8.0.3", Command: []string{"socat"}}
+	cfg := config.Configuration{
+		Forwards: []config.PortForwardConfiguration{
+			{Name: "db", Namespace: "default", Resource: "host/db.internal:5432", Ports: []string{"15432"}},
+			{Name: "webhook", Kind: "reverse", Namespace: "dev", Reverse: &config.ReverseConfiguration{Address: "localhost:3000", Port: 8080, Service: "webhook", Connections: 1}},
+		},
+		Relay: relayCfg,
+	}
+
+	client := fake.NewClientset()
+	ctx := context.Background()
+
+	// Left over by a previous run
+	orphan, err := relay.NewManager(client, relayCfg, relay.DefaultOwner()).Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+
+	runner := New(cfg, "", zerolog.New(nil), client, &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+
+	relayPods := func() []string {
+		pods, err := client.CoreV1().Pods("tools").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		var names []string
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	// The relay pods never run with the fake client: the forwarders wait for them
+	require.Eventually(t, func() bool {
+		statuses := runner.Status()
+		return len(statuses) == 2 && statuses[0].State == forwarder.StateBackoff && statuses[1].State == forwarder.StateBackoff
+	}, 5*time.Second, 10*time.Millisecond)
+
+	names := relayPods()
+	require.Len(t, names, 1)
+	assert.NotEqual(t, orphan.Name, names[0])
+
+	_, err = client.CoreV1().Services("dev").Get(ctx, "webhook", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	runner.Shutdown()
+
+	assert.Empty(t, relayPods())
+	services, err := client.CoreV1().Services("dev").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, services.Items)
+}
+
+// TestRunnerProxyRequiresClient tests that the proxy is not started without a Kubernetes client
+func TestRunnerProxyRequiresClient(t *testing.T) {
+	cfg := config.Configuration{
+		Forwards: []config.PortForwardConfiguration{},
+		Proxy:    &config.ProxyConfiguration{Listen: "127.0.0.1:0", IdleTimeout: "5m"},
+	}
+
+	runner := New(cfg, "", zerolog.New(nil), nil, &rest.Config{}, "mock-source", "mock-context")
+	err := runner.Start()
+	defer runner.Shutdown()
+
+	assert.Error(t, err)
+	assert.Nil(t, runner.proxy)
+}
+
+// TestRunnerHostsFile tests that forward hostnames are written on start, updated on reload
+// and removed on shutdown, replacing the block left by a crashed run
+func TestRunnerHostsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	hostsPath := filepath.Join(tmpDir, "hosts")
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	original := "127.0.0.1\tlocalhost\n"
+	stale := "# BEGIN fwkeeper - managed block, do not edit\n127.0.0.1\told.example\t# old\n# END fwkeeper\n"
+	require.NoError(t, os.WriteFile(hostsPath, []byte(original+stale), 0o644))
+
+	writeConfig := func(hostname string) {
+		content := fmt.Sprintf(`
+hosts: {file: %q}
+forwards: [{
+	name: "db"
+	namespace: "db"
+	resource: "postgres-0"
+	ports: ["18432:5432"]
+	hostname: %q
+}]
+`, hostsPath, hostname)
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+	}
+	readHosts := func() string {
+		content, err := os.ReadFile(hostsPath)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	writeConfig("postgres.db.svc.cluster.local")
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	runner.hostsRecord = filepath.Join(tmpDir, "hosts-file")
+	require.NoError(t, runner.Start())
+
+	content := readHosts()
+	assert.True(t, strings.HasPrefix(content, original))
+	assert.Contains(t, content, "127.0.0.1\tpostgres.db.svc.cluster.local\t# db\n")
+	assert.NotContains(t, content, "old.example")
+
+	writeConfig("pg.db.svc.cluster.local")
+	runner.reloadConfig(runner.ctx)
+
+	content = readHosts()
+	assert.Contains(t, content, "127.0.0.1\tpg.db.svc.cluster.local\t# db\n")
+	assert.NotContains(t, content, "postgres.db.svc.cluster.local")
+
+	runner.Shutdown()
+
+	assert.Equal(t, original, readHosts())
+}
+
+// TestRunnerStaleHostsFile tests that the block left by a crashed run is removed at start
+// from a hosts file that is no longer configured, and from the configured one without hostnames,
+// while the block of a running process is kept
+func TestRunnerStaleHostsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old-hosts")
+	hostsPath := filepath.Join(tmpDir, "hosts")
+	recordPath := filepath.Join(tmpDir, "hosts-file")
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	original := "127.0.0.1\tlocalhost\n"
+	stale := "# BEGIN fwkeeper - managed block, do not edit\n127.0.0.1\told.example\t# old\n# END fwkeeper\n"
+	readHosts := func(path string) string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+	start := func(content string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+		cfg, err := config.ReadConfiguration(configPath)
+		require.NoError(t, err)
+
+		runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+		runner.hostsRecord = recordPath
+		require.NoError(t, runner.Start())
+		runner.Shutdown()
+	}
+
+	// hosts disabled after a crash
+	require.NoError(t, os.WriteFile(oldPath, []byte(original+stale), 0o644))
+	require.NoError(t, os.WriteFile(recordPath, []byte(oldPath+"\n"), 0o644))
+	start("forwards: []\n")
+	assert.Equal(t, original, readHosts(oldPath))
+	assert.NoFileExists(t, recordPath)
+
+	// file changed after a crash, no hostname configured
+	require.NoError(t, os.WriteFile(oldPath, []byte(original+stale), 0o644))
+	require.NoError(t, os.WriteFile(hostsPath, []byte(original+stale), 0o644))
+	require.NoError(t, os.WriteFile(recordPath, []byte(oldPath+"\n"), 0o644))
+	start(fmt.Sprintf("hosts: {file: %q}\nforwards: []\n", hostsPath))
+	assert.Equal(t, original, readHosts(oldPath))
+	assert.Equal(t, original, readHosts(hostsPath))
+	assert.NoFileExists(t, recordPath)
+
+	// the block of another run still running is kept, as its record
+	require.NoError(t, os.WriteFile(oldPath, []byte(original+stale), 0o644))
+	record := fmt.Sprintf("%s\n%d\n", oldPath, os.Getppid())
+	require.NoError(t, os.WriteFile(recordPath, []byte(record), 0o644))
+	start("forwards: []\n")
+	assert.Equal(t, original+stale, readHosts(oldPath))
+	content, err := os.ReadFile(recordPath)
+	require.NoError(t, err)
+	assert.Equal(t, record, string(content))
+}
+
+// TestRunnerAutoAddress tests that forwards with an "auto" address get distinct addresses,
+// kept across reloads and written to the hosts file
+func TestRunnerAutoAddress(t *testing.T) {
+	tmpDir := t.TempDir()
+	hostsPath := filepath.Join(tmpDir, "hosts")
+	addressesPath := filepath.Join(tmpDir, "addresses.json")
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	require.NoError(t, os.WriteFile(hostsPath, nil, 0o644))
+
+	writeConfig := func(names ...string) {
+		var forwards strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&forwards, "{name: %q, namespace: \"default\", resource: \"%s-0\", ports: [\"18443:443\"], address: \"auto\", hostname: \"%s.local\"},\n", name, name, name)
+		}
+		content := fmt.Sprintf("hosts: {file: %q}\nloopback: {pool: \"127.1.0.0/24\", file: %q}\nforwards: [%s]\n", hostsPath, addressesPath, forwards.String())
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+	}
+	writeConfig("api", "web")
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	runner.hostsRecord = filepath.Join(tmpDir, "hosts-file")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	assert.Equal(t, "127.1.0.1", runner.configuration.Forwards[0].Address)
+	assert.Equal(t, "127.1.0.2", runner.configuration.Forwards[1].Address)
+
+	content, err := os.ReadFile(hostsPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "127.1.0.1\tapi.local\t# api\n")
+	assert.Contains(t, string(content), "127.1.0.2\tweb.local\t# web\n")
+
+	// web keeps its address and its forwarder
+	web := runner.forwarders["web"]
+	writeConfig("web", "cache")
+	runner.reloadConfig(runner.ctx)
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	assert.Equal(t, "127.1.0.2", runner.configuration.Forwards[0].Address)
+	assert.Equal(t, "127.1.0.3", runner.configuration.Forwards[1].Address)
+	assert.Same(t, web, runner.forwarders["web"])
+
+	persisted, err := os.ReadFile(addressesPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(persisted), `"api": "127.1.0.1"`)
+}
+
+// TestRunnerCaptureReload tests that capture is switched on reload without restarting the forwarder
+func TestRunnerCaptureReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	capturePath := filepath.Join(tmpDir, "db.pcapng")
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	writeConfig := func(enabled bool) {
+		content := fmt.Sprintf(`
+forwards: [{
+	name: "db"
+	namespace: "db"
+	resource: "postgres-0"
+	ports: ["18433:5432"]
+	capture: {file: %q, enabled: %t}
+}]
+`, capturePath, enabled)
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+	}
+
+	writeConfig(false)
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	runner.mu.Lock()
+	fwd := runner.forwarders["db"]
+	runner.mu.Unlock()
+
+	writeConfig(true)
+	runner.reloadConfig(runner.ctx)
+
+	runner.mu.Lock()
+	assert.Same(t, fwd, runner.forwarders["db"], "the forwarder should not be restarted")
+	assert.True(t, fwd.Config().Capture.Enabled)
+	runner.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(capturePath)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestRunnerShapingReload tests that shaping changes on reload without restarting the forwarder
+func TestRunnerShapingReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	writeConfig := func(latency string) {
+		content := fmt.Sprintf(`
+forwards: [{
+	name: "api"
+	namespace: "default"
+	resource: "api-0"
+	ports: ["18434:8080"]
+	shaping: {latency: %q}
+}]
+`, latency)
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+	}
+
+	writeConfig("10ms")
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	runner.mu.Lock()
+	fwd := runner.forwarders["api"]
+	runner.mu.Unlock()
+
+	writeConfig("200ms")
+	runner.reloadConfig(runner.ctx)
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	assert.Same(t, fwd, runner.forwarders["api"], "the forwarder should not be restarted")
+	assert.Equal(t, "200ms", fwd.Config().Shaping.Latency)
+}
+
+// TestRunnerSelfSignedTLS tests that the certificate authority is created in the user
+// configuration directory and reused by the forwarders
+func TestRunnerSelfSignedTLS(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, "config"))
+	configPath := filepath.Join(tmpDir, "fwkeeper.cue")
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [
+	{name: "api", namespace: "default", resource: "api-0", ports: ["18443:8080"], tls: {selfSigned: true}},
+	{name: "web", namespace: "default", resource: "web-0", ports: ["18444:8080"], tls: {selfSigned: true, hosts: ["web.local"]}},
+]
+`), 0o644))
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	assert.Len(t, runner.forwarders, 2)
+	require.NotNil(t, runner.authority)
+	assert.Equal(t, filepath.Join(tmpDir, "config", "fwkeeper", "ca", "ca.crt"), runner.authority.CertPath())
+	assert.FileExists(t, runner.authority.CertPath())
+}
+
+// TestReloadConfigSwapsForwarder tests that a modified forward is replaced by a new forwarder
+// that keeps its port bound, while the previous one drains and stops
+func TestReloadConfigSwapsForwarder(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "fwkeeper.cue")
+
+	write := func(ports string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [{name: "api", namespace: "default", resource: "api-0", ports: [`+ports+`], drainTimeout: "100ms"}]
+`), 0o644))
+	}
+	write(`"18520:8080"`)
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	bound := func(addr string) bool {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return true
+		}
+		ln.Close()
+		return false
+	}
+	require.Eventually(t, func() bool { return bound("127.0.0.1:18520") }, 2*time.Second, 10*time.Millisecond)
+
+	runner.mu.Lock()
+	previous := runner.forwarders["api"]
+	runner.mu.Unlock()
+
+	write(`"18520:8080", "18521:8080"`)
+	runner.reloadConfig(runner.ctx)
+
+	runner.mu.Lock()
+	next := runner.forwarders["api"]
+	runner.mu.Unlock()
+	require.NotNil(t, next)
+	assert.NotSame(t, previous, next)
+
+	require.Eventually(t, func() bool { return previous.Status().State == forwarder.StateStopped && !previous.Status().Since.IsZero() }, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, bound("127.0.0.1:18520"), "the kept port stays bound")
+	assert.True(t, bound("127.0.0.1:18521"), "the added port is bound")
+}
+
+// TestReloadConfigRestartsFailedForwarder tests that a reload retries a forwarder stopped by
+// its retry policy, even when its configuration is unchanged
+func TestReloadConfigRestartsFailedForwarder(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "fwkeeper.cue")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [{name: "api", namespace: "default", resource: "api-0", ports: ["18530:8080"]}]
+retry: {"resource-not-found": {action: "stop"}}
+`), 0o644))
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	runner := New(cfg, configPath, zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	runner.mu.Lock()
+	failed := runner.forwarders["api"]
+	runner.mu.Unlock()
+	require.Eventually(t, func() bool { return failed.Status().State == forwarder.StateFailed }, 2*time.Second, 10*time.Millisecond)
+
+	runner.reloadConfig(runner.ctx)
+
+	runner.mu.Lock()
+	restarted := runner.forwarders["api"]
+	runner.mu.Unlock()
+	require.NotNil(t, restarted)
+	assert.NotSame(t, failed, restarted)
+}
+
+// TestReloadConfigReplacesRelayPod tests that a reload pointing a host forward to another host
+// replaces its relay pod, and that the relay pod is deleted once the forward no longer needs it
+func TestReloadConfigReplacesRelayPod(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "fwkeeper.cue")
+	write := func(resource string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [{name: "db", namespace: "default", resource: "`+resource+`", ports: ["18540:5432"], drainTimeout: "100ms"}]
+relay: {namespace: "tools"}
+`), 0o644))
+	}
+	write("host/a.internal:5432")
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	client := fake.NewClientset()
+	runner := New(cfg, configPath, zerolog.New(nil), client, &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+
+	relayTargets := func() []string {
+		pods, err := client.CoreV1().Pods("tools").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		var targets []string
+		for _, pod := range pods.Items {
+			targets = append(targets, pod.Annotations[relay.AnnotationTarget])
+		}
+		return targets
+	}
+	require.Eventually(t, func() bool { return slices.Equal(relayTargets(), []string{"a.internal:5432"}) }, 5*time.Second, 10*time.Millisecond)
+
+	write("host/b.internal:5432")
+	runner.reloadConfig(runner.ctx)
+	require.Eventually(t, func() bool { return slices.Equal(relayTargets(), []string{"b.internal:5432"}) }, 5*time.Second, 10*time.Millisecond)
+
+	write("svc/db")
+	runner.reloadConfig(runner.ctx)
+	require.Eventually(t, func() bool { return len(relayTargets()) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestReloadConfigReleasesRelayUnlocked tests that relay pods of removed forwards are deleted
+// without holding the runner lock, so that a slow API server does not block the runner
+func TestReloadConfigReleasesRelayUnlocked(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "fwkeeper.cue")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [
+	{name: "db", namespace: "default", resource: "host/db.internal:5432", ports: ["18550:5432"]},
+	{name: "api", namespace: "default", resource: "api-0", ports: ["18551:8080"]},
+]
+relay: {namespace: "tools"}
+`), 0o644))
+	cfg, err := config.ReadConfiguration(configPath)
+	require.NoError(t, err)
+
+	// The reactor blocks under the lock of the fake client: the client must not be called
+	// until the delete is released
+	client := fake.NewClientset()
+	deleting := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		select {
+		case deleting <- struct{}{}:
+		default:
+		}
+		<-unblock
+		return false, nil, nil
+	})
+
+	var unblockOnce sync.Once
+	release := func() { unblockOnce.Do(func() { close(unblock) }) }
+
+	runner := New(cfg, configPath, zerolog.New(nil), client, &rest.Config{}, "mock-source", "mock-context")
+	require.NoError(t, runner.Start())
+	defer runner.Shutdown()
+	defer release()
+
+	relayPods := func() int {
+		pods, err := client.CoreV1().Pods("tools").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		return len(pods.Items)
+	}
+	require.Eventually(t, func() bool { return relayPods() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+forwards: [{name: "api", namespace: "default", resource: "api-0", ports: ["18551:8080"]}]
+relay: {namespace: "tools"}
+`), 0o644))
+
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		runner.reloadConfig(runner.ctx)
+	}()
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the reload should not wait for the relay pod to be deleted")
+	}
+	assert.Len(t, runner.Status(), 1)
+	select {
+	case <-deleting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the relay pod should be deleted in the background")
+	}
+
+	release()
+	require.Eventually(t, func() bool { return relayPods() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestRunnerRejectsInvalidRetryPolicies tests that the retry table is checked before any forwarder starts
+func TestRunnerRejectsInvalidRetryPolicies(t *testing.T) {
+	cfg := config.Configuration{
+		Forwards: []config.PortForwardConfiguration{{Name: "api", Namespace: "default", Resource: "api-0", Ports: []string{"18531:8080"}}},
+		Retry:    map[string]config.RetryPolicyConfiguration{"network-transient": {Action: "abort"}},
+	}
+
+	runner := New(cfg, "", zerolog.New(nil), fake.NewClientset(), &rest.Config{}, "mock-source", "mock-context")
+	err := runner.Start()
+	defer runner.Shutdown()
+
+	assert.ErrorContains(t, err, "invalid retry configuration")
+	runner.mu.Lock()
+	assert.Empty(t, runner.forwarders)
+	runner.mu.Unlock()
+}
@@ -0,0 +1,402 @@
+// Package capture writes the data of forwarded connections to pcap-ng files. The forwarder
+// only sees the bytes of each connection, so IPv4 and TCP framing is synthesized around them:
+// a handshake when the connection opens, one segment per write, and a FIN exchange when it closes.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Backups is the number of rotated files kept besides the current one.
+const Backups = 5
+
+// pcap-ng block types and values.
+const (
+	blockSectionHeader     = 0x0A0D0D0A
+	blockInterface         = 0x00000001
+	blockEnhancedPacket    = 0x00000006
+	byteOrderMagic         = 0x1A2B3C4D
+	linkTypeRaw            = 101
+	sectionHeaderLength    = 28
+	interfaceLength        = 20
+	enhancedPacketOverhead = 32
+)
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+const (
+	ipv4HeaderLength = 20
+	tcpHeaderLength  = 20
+
+	// maxSegment keeps packets under the IPv4 size limit
+	maxSegment = 65535 - ipv4HeaderLength - tcpHeaderLength
+
+	// firstEphemeralPort is the first port given to clients without TCP address
+	firstEphemeralPort = 49152
+)
+
+// Writer writes the packets of connections to a pcap-ng file, rotated once it reaches
+// maxBytes. A write error stops the capture; it is logged once.
+type Writer struct {
+	path     string
+	maxBytes int64
+	log      *zerolog.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+	ipID   uint16
+	port   uint16
+}
+
+// Open opens the capture file at path, appending a new section to an existing file.
+// Files are rotated when they would exceed maxBytes, never when maxBytes is 0.
+func Open(path string, maxBytes int64, log *zerolog.Logger) (*Writer, error) {
+	w := &Writer{path: path, maxBytes: maxBytes, log: log}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	if w.maxBytes > 0 && w.size > 0 && w.size+sectionHeaderLength+interfaceLength > w.maxBytes {
+		if err := w.rotateLocked(); err != nil {
+			w.file.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	if err := w.writeLocked(header()); err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+
+	return w, nil
+}
+
+// Path returns the path of the current capture file.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Close closes the capture file. Later packets are discarded.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.file.Close()
+}
+
+// Connect records the handshake of a connection from client to server, and returns the
+// connection recording its data. A client without address gets a local ephemeral port.
+func (w *Writer) Connect(client, server netip.AddrPort) *Connection {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !client.IsValid() {
+		client = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), firstEphemeralPort+w.port)
+		w.port = (w.port + 1) % (65535 - firstEphemeralPort)
+	}
+
+	c := &Connection{
+		w:         w,
+		client:    client,
+		server:    server,
+		clientSeq: rand.Uint32(),
+		serverSeq: rand.Uint32(),
+	}
+
+	c.segmentLocked(true, flagSYN, nil)
+	c.clientSeq++
+	c.segmentLocked(false, flagSYN|flagACK, nil)
+	c.serverSeq++
+	c.segmentLocked(true, flagACK, nil)
+
+	return c
+}
+
+// packetLocked writes one packet. w.mu must be held.
+func (w *Writer) packetLocked(packet []byte) {
+	if w.closed {
+		return
+	}
+
+	block := enhancedPacket(time.Now(), packet)
+	if w.maxBytes > 0 && w.size+int64(len(block)) > w.maxBytes && w.size > sectionHeaderLength+interfaceLength {
+		if err := w.rotateLocked(); err != nil {
+			w.failLocked(err)
+			return
+		}
+	}
+
+	if err := w.writeLocked(block); err != nil {
+		w.failLocked(err)
+	}
+}
+
+// writeLocked appends data to the current file. w.mu must be held.
+func (w *Writer) writeLocked(data []byte) error {
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// rotateLocked shifts the backups, moves the current file to the first one and starts
+// a new file. w.mu must be held.
+func (w *Writer) rotateLocked() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate capture file: %w", err)
+	}
+
+	for i := Backups - 1; i >= 1; i-- {
+		if err := os.Rename(RotatedPath(w.path, i), RotatedPath(w.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate capture file: %w", err)
+		}
+	}
+	if err := os.Rename(w.path, RotatedPath(w.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate capture file: %w", err)
+	}
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rotate capture file: %w", err)
+	}
+	w.file = file
+	w.size = 0
+
+	return w.writeLocked(header())
+}
+
+// failLocked stops the capture after an error. w.mu must be held.
+func (w *Writer) failLocked(err error) {
+	w.log.Error().Err(err).Msgf("Capture to %s stopped", w.path)
+	w.closed = true
+	w.file.Close()
+}
+
+// RotatedPath returns the path of the i-th backup of a capture file: the index is inserted
+// before the extension, so that capture tools still recognize the file.
+func RotatedPath(path string, i int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + strconv.Itoa(i) + ext
+}
+
+// Connection records the data of one connection, in both directions.
+type Connection struct {
+	w              *Writer
+	client, server netip.AddrPort
+
+	// clientSeq and serverSeq are the next sequence numbers of each side, guarded by w.mu
+	clientSeq uint32
+	serverSeq uint32
+	closed    bool
+}
+
+// ClientData records data sent by the client.
+func (c *Connection) ClientData(data []byte) {
+	c.data(true, data)
+}
+
+// ServerData records data sent by the server.
+func (c *Connection) ServerData(data []byte) {
+	c.data(false, data)
+}
+
+func (c *Connection) data(fromClient bool, data []byte) {
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	for len(data) > 0 {
+		n := min(len(data), maxSegment)
+		c.segmentLocked(fromClient, flagPSH|flagACK, data[:n])
+		if fromClient {
+			c.clientSeq += uint32(n)
+		} else {
+			c.serverSeq += uint32(n)
+		}
+		data = data[n:]
+	}
+}
+
+// Close records the end of the connection.
+func (c *Connection) Close() {
+	c.w.mu.Lock()
+	defer c.w.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	c.segmentLocked(true, flagFIN|flagACK, nil)
+	c.clientSeq++
+	c.segmentLocked(false, flagFIN|flagACK, nil)
+	c.serverSeq++
+	c.segmentLocked(true, flagACK, nil)
+}
+
+// segmentLocked writes a TCP segment from one side. c.w.mu must be held.
+func (c *Connection) segmentLocked(fromClient bool, flags byte, payload []byte) {
+	src, dst := c.client, c.server
+	seq, ack := c.clientSeq, c.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+	if flags&flagACK == 0 {
+		ack = 0
+	}
+
+	c.w.ipID++
+	c.w.packetLocked(packet(c.w.ipID, src, dst, seq, ack, flags, payload))
+}
+
+// header returns the section header and interface description blocks starting a file.
+func header() []byte {
+	b := make([]byte, 0, sectionHeaderLength+interfaceLength)
+
+	b = binary.LittleEndian.AppendUint32(b, blockSectionHeader)
+	b = binary.LittleEndian.AppendUint32(b, sectionHeaderLength)
+	b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, 1)          // major version
+	b = binary.LittleEndian.AppendUint16(b, 0)          // minor version
+	b = binary.LittleEndian.AppendUint64(b, ^uint64(0)) // unknown section length
+	b = binary.LittleEndian.AppendUint32(b, sectionHeaderLength)
+
+	b = binary.LittleEndian.AppendUint32(b, blockInterface)
+	b = binary.LittleEndian.AppendUint32(b, interfaceLength)
+	b = binary.LittleEndian.AppendUint16(b, linkTypeRaw)
+	b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+	b = binary.LittleEndian.AppendUint32(b, 0) // no snapshot length
+	b = binary.LittleEndian.AppendUint32(b, interfaceLength)
+
+	return b
+}
+
+// enhancedPacket returns the block of a packet captured at t, with microsecond timestamps.
+func enhancedPacket(t time.Time, packet []byte) []byte {
+	padded := (len(packet) + 3) &^ 3
+	length := uint32(enhancedPacketOverhead + padded)
+	ts := uint64(t.UnixMicro())
+
+	b := make([]byte, 0, length)
+	b = binary.LittleEndian.AppendUint32(b, blockEnhancedPacket)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = binary.LittleEndian.AppendUint32(b, 0) // interface
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+	b = append(b, packet...)
+	b = append(b, make([]byte, padded-len(packet))...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+
+	return b
+}
+
+// packet returns an IPv4 packet holding a TCP segment. IPv6 addresses are mapped to
+// 127.0.0.1, the framing only has to be readable.
+func packet(id uint16, src, dst netip.AddrPort, seq, ack uint32, flags byte, payload []byte) []byte {
+	srcIP, dstIP := ipv4(src.Addr()), ipv4(dst.Addr())
+	length := ipv4HeaderLength + tcpHeaderLength + len(payload)
+
+	b := make([]byte, length)
+
+	ip := b[:ipv4HeaderLength]
+	ip[0] = 0x45 // version 4, 5 words
+	binary.BigEndian.PutUint16(ip[2:], uint16(length))
+	binary.BigEndian.PutUint16(ip[4:], id)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:16], srcIP[:])
+	copy(ip[16:20], dstIP[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+
+	tcp := b[ipv4HeaderLength:]
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = (tcpHeaderLength / 4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[tcpHeaderLength:], payload)
+
+	// The TCP checksum covers a pseudo header of the addresses, protocol and length
+	pseudo := make([]byte, 0, 12)
+	pseudo = append(pseudo, srcIP[:]...)
+	pseudo = append(pseudo, dstIP[:]...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(sum(0, pseudo), tcp))
+
+	return b
+}
+
+func ipv4(addr netip.Addr) [4]byte {
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return [4]byte{127, 0, 0, 1}
+	}
+	return addr.As4()
+}
+
+// sum adds data to a ones' complement sum.
+func sum(s uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	return s
+}
+
+// checksum returns the Internet checksum of data, starting from a partial sum.
+func checksum(s uint32, data []byte) uint16 {
+	s = sum(s, data)
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
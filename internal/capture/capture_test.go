@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// block is a pcap-ng block read back from a file
+type block struct {
+	kind uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, path string) []block {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var blocks []block
+	for len(content) > 0 {
+		require.GreaterOrEqual(t, len(content), 12)
+		kind := binary.LittleEndian.Uint32(content)
+		length := binary.LittleEndian.Uint32(content[4:])
+		require.Zero(t, length%4, "blocks are 32-bit aligned")
+		require.LessOrEqual(t, int(length), len(content))
+		require.Equal(t, length, binary.LittleEndian.Uint32(content[length-4:]), "trailing length")
+
+		blocks = append(blocks, block{kind: kind, body: content[8 : length-4]})
+		content = content[length:]
+	}
+	return blocks
+}
+
+// segment is a TCP segment decoded from an enhanced packet block
+type segment struct {
+	src, dst netip.AddrPort
+	seq, ack uint32
+	flags    byte
+	payload  string
+}
+
+func decodeSegment(t *testing.T, b block) segment {
+	require.Equal(t, uint32(blockEnhancedPacket), b.kind)
+	length := binary.LittleEndian.Uint32(b.body[12:])
+	packet := b.body[20 : 20+length]
+
+	ip := packet[:ipv4HeaderLength]
+	assert.Equal(t, byte(0x45), ip[0])
+	assert.Equal(t, uint16(0), checksum(0, ip), "IPv4 header checksum")
+
+	tcp := packet[ipv4HeaderLength:]
+	pseudo := append(append([]byte{}, ip[12:20]...), 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	assert.Equal(t, uint16(0), checksum(sum(0, pseudo), tcp), "TCP checksum")
+
+	return segment{
+		src:     netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip[12:16])), binary.BigEndian.Uint16(tcp[0:])),
+		dst:     netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip[16:20])), binary.BigEndian.Uint16(tcp[2:])),
+		seq:     binary.BigEndian.Uint32(tcp[4:]),
+		ack:     binary.BigEndian.Uint32(tcp[8:]),
+		flags:   tcp[13],
+		payload: string(tcp[tcpHeaderLength:]),
+	}
+}
+
+// TestWriterConnection tests the synthesized TCP framing of a connection
+func TestWriterConnection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	log := zerolog.Nop()
+
+	w, err := Open(path, 0, &log)
+	require.NoError(t, err)
+
+	client := netip.MustParseAddrPort("127.0.0.1:50000")
+	server := netip.MustParseAddrPort("127.0.0.1:5432")
+
+	c := w.Connect(client, server)
+	c.ClientData([]byte("ping"))
+	c.ServerData([]byte("pong!"))
+	c.Close()
+	c.ClientData([]byte("ignored"))
+	require.NoError(t, w.Close())
+
+	blocks := readBlocks(t, path)
+	require.Len(t, blocks, 2+3+2+3)
+	assert.Equal(t, uint32(blockSectionHeader), blocks[0].kind)
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body))
+	assert.Equal(t, uint32(blockInterface), blocks[1].kind)
+	assert.Equal(t, uint16(linkTypeRaw), binary.LittleEndian.Uint16(blocks[1].body))
+
+	var segments []segment
+	for _, b := range blocks[2:] {
+		segments = append(segments, decodeSegment(t, b))
+	}
+
+	syn, synAck, ack := segments[0], segments[1], segments[2]
+	assert.Equal(t, byte(flagSYN), syn.flags)
+	assert.Equal(t, client, syn.src)
+	assert.Equal(t, server, syn.dst)
+	assert.Equal(t, byte(flagSYN|flagACK), synAck.flags)
+	assert.Equal(t, syn.seq+1, synAck.ack)
+	assert.Equal(t, byte(flagACK), ack.flags)
+
+	ping, pong := segments[3], segments[4]
+	assert.Equal(t, "ping", ping.payload)
+	assert.Equal(t, client, ping.src)
+	assert.Equal(t, syn.seq+1, ping.seq)
+	assert.Equal(t, synAck.seq+1, ping.ack)
+	assert.Equal(t, "pong!", pong.payload)
+	assert.Equal(t, server, pong.src)
+	assert.Equal(t, ping.seq+4, pong.ack)
+
+	clientFin, serverFin := segments[5], segments[6]
+	assert.Equal(t, byte(flagFIN|flagACK), clientFin.flags)
+	assert.Equal(t, ping.seq+4, clientFin.seq)
+	assert.Equal(t, byte(flagFIN|flagACK), serverFin.flags)
+	assert.Equal(t, pong.seq+5, serverFin.seq)
+}
+
+// TestWriterRotate tests that files are rotated by size, keeping a bounded number of backups
+func TestWriterRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+	log := zerolog.Nop()
+
+	w, err := Open(path, 1024, &log)
+	require.NoError(t, err)
+
+	c := w.Connect(netip.MustParseAddrPort("127.0.0.1:50000"), netip.MustParseAddrPort("127.0.0.1:80"))
+	data := make([]byte, 400)
+	for range 20 {
+		c.ClientData(data)
+	}
+	require.NoError(t, w.Close())
+
+	for _, p := range []string{path, RotatedPath(path, 1), RotatedPath(path, Backups)} {
+		info, err := os.Stat(p)
+		require.NoError(t, err, p)
+		assert.LessOrEqual(t, info.Size(), int64(1024), p)
+
+		blocks := readBlocks(t, p)
+		require.GreaterOrEqual(t, len(blocks), 3, p)
+		assert.Equal(t, uint32(blockSectionHeader), blocks[0].kind, "each file starts with a section header")
+	}
+	assert.NoFileExists(t, RotatedPath(path, Backups+1))
+
+	// Reopening appends a new section
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+	w, err = Open(path, 0, &log)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, before.Size()+sectionHeaderLength+interfaceLength, after.Size())
+}
+
+// TestRotatedPath tests that the backup index is inserted before the extension
+func TestRotatedPath(t *testing.T) {
+	assert.Equal(t, "/tmp/api.1.pcapng", RotatedPath("/tmp/api.pcapng", 1))
+	assert.Equal(t, "/tmp/api.2", RotatedPath("/tmp/api", 2))
+}
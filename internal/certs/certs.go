@@ -0,0 +1,207 @@
+// Package certs issues the certificates of TLS forwards from a local certificate authority.
+// The authority is generated once and persisted, so that it is trusted only once, e.g. by
+// importing its certificate in the browser or the system trust store.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// certFile and keyFile are the files of the authority in its directory.
+	certFile = "ca.crt"
+	keyFile  = "ca.key"
+
+	// authorityValidity and leafValidity are the lifetimes of the authority and of the
+	// certificates it issues, which are issued again on each run.
+	authorityValidity = 10 * 365 * 24 * time.Hour
+	leafValidity      = 365 * 24 * time.Hour
+)
+
+// DefaultDir returns the directory of the authority in the user configuration directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the user configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "fwkeeper", "ca"), nil
+}
+
+// Authority is a certificate authority persisted in a directory.
+type Authority struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// LoadAuthority loads the authority persisted in dir, generating it on first use.
+func LoadAuthority(dir string) (*Authority, error) {
+	a := &Authority{dir: dir}
+
+	certPEM, certErr := os.ReadFile(a.CertPath())
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, keyFile))
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		if err := a.generate(); err != nil {
+			return nil, fmt.Errorf("failed to create certificate authority: %w", err)
+		}
+		return a, nil
+	}
+	if err := errors.Join(certErr, keyErr); err != nil {
+		return nil, fmt.Errorf("failed to read certificate authority: %w", err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate authority in %s: %w", dir, err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !pair.Leaf.IsCA {
+		return nil, fmt.Errorf("invalid certificate authority in %s: not a signing certificate", dir)
+	}
+
+	a.cert = pair.Leaf
+	a.key = signer
+	return a, nil
+}
+
+// CertPath returns the path of the certificate of the authority, the one to trust.
+func (a *Authority) CertPath() string {
+	return filepath.Join(a.dir, certFile)
+}
+
+// generate creates the authority and persists it, its key readable by the user only.
+func (a *Authority) generate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"fwkeeper"}, CommonName: "fwkeeper local CA " + hostname},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(authorityValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(a.dir, 0o700); err != nil {
+		return err
+	}
+	// The key is written first: a certificate without its key is never left behind
+	if err := writeFile(filepath.Join(a.dir, keyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := writeFile(a.CertPath(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+
+	a.cert = cert
+	a.key = key
+	return nil
+}
+
+// Issue issues a server certificate for hosts, names or IP addresses, chained to the authority.
+func (a *Authority) Issue(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return tls.Certificate{}, errors.New("no host to issue a certificate for")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"fwkeeper"}, CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range slices.Compact(slices.Sorted(slices.Values(hosts))) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, key.Public(), a.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der, a.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// serialNumber returns a random certificate serial number.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// writeFile writes a file atomically with the given permissions.
+func writeFile(path string, content []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
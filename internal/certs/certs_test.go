@@ -0,0 +1,59 @@
+package certs
+
+import (
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadAuthority tests that the authority is generated once and reused
+func TestLoadAuthority(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fwkeeper", "ca")
+
+	a, err := LoadAuthority(dir)
+	require.NoError(t, err)
+	assert.True(t, a.cert.IsCA)
+
+	info, err := os.Stat(filepath.Join(dir, keyFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	again, err := LoadAuthority(dir)
+	require.NoError(t, err)
+	assert.Equal(t, a.cert.Raw, again.cert.Raw)
+
+	// A missing key is not silently replaced
+	require.NoError(t, os.Remove(filepath.Join(dir, keyFile)))
+	_, err = LoadAuthority(dir)
+	assert.Error(t, err)
+}
+
+// TestIssue tests that issued certificates are valid for their hosts and chained to the authority
+func TestIssue(t *testing.T) {
+	a, err := LoadAuthority(t.TempDir())
+	require.NoError(t, err)
+
+	cert, err := a.Issue([]string{"localhost", "127.0.0.1", "api.example.com", "localhost"})
+	require.NoError(t, err)
+	require.Len(t, cert.Certificate, 2)
+	assert.ElementsMatch(t, []string{"localhost", "api.example.com"}, cert.Leaf.DNSNames)
+	require.Len(t, cert.Leaf.IPAddresses, 1)
+	assert.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a.cert)
+	for _, host := range []string{"localhost", "127.0.0.1", "api.example.com"} {
+		_, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "other.example.com", Roots: roots})
+	assert.Error(t, err)
+
+	_, err = a.Issue(nil)
+	assert.Error(t, err)
+}
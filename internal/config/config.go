@@ -4,7 +4,10 @@ import (
 	"io"
 	"os"
 	"fmt"
+	"net"
+	"net/netip"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -12,14 +15,202 @@ import (
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/cuecontext"
+
+	"github.com/codozor/fwkeeper/internal/loopback"
+)
+
+// AutoAddress is the address of forwards listening on an address allocated from the loopback pool.
+const AutoAddress = "auto"
+
+// Port-forward transports: TransportAuto tries WebSocket first and falls back to SPDY.
+const (
+	TransportAuto      = "auto"
+	TransportWebSocket = "websocket"
+	TransportSPDY      = "spdy"
 )
 
 type PortForwardConfiguration struct {
 	Name      string   `json:"name"`
+
+	// Kind is "forward" (pod ports exposed locally) or "reverse" (a local address exposed in the cluster)
+	Kind string `json:"kind,omitempty"`
+
 	Ports     []string `json:"ports"`
 
 	Namespace string   `json:"namespace"`
 	Resource  string   `json:"resource"`
+
+	// Node restricts selector-based resources to pods on a node (name or node label selector)
+	Node string `json:"node,omitempty"`
+
+	// Match selects the backend of ingress and httproute resources by request host and path
+	Match *RouteMatchConfiguration `json:"match,omitempty"`
+
+	// Health enables active probing of the tunnel once it is ready
+	Health *HealthCheckConfiguration `json:"health,omitempty"`
+
+	// HoldTimeout is how long new local connections wait for the tunnel while reconnecting
+	HoldTimeout string `json:"holdTimeout,omitempty"`
+
+	// Lazy forwards bind their local ports at once but locate the pod and open the tunnel on
+	// the first connection, and close it after IdleTimeout without connections
+	Lazy        bool   `json:"lazy,omitempty"`
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	// DrainTimeout is how long the connections of the previous configuration have to finish
+	// when the forward is reconfigured
+	DrainTimeout string `json:"drainTimeout,omitempty"`
+
+	// Mode is "single" (one pod) or "balance" (connections spread across several pods)
+	Mode string `json:"mode,omitempty"`
+
+	// Replicas is the maximum number of pods to balance across, all running pods when 0.
+	// More than one replica implies the balance mode
+	Replicas int `json:"replicas,omitempty"`
+
+	// LoadBalancing is how connections are spread in balance mode: "round-robin" or "least-connections"
+	LoadBalancing string `json:"loadBalancing,omitempty"`
+
+	// SocketMode is the octal permission mode of the local Unix sockets (e.g. "0600")
+	SocketMode string `json:"socketMode,omitempty"`
+
+	// Reverse defines a reverse forward (reverse kind only)
+	Reverse *ReverseConfiguration `json:"reverse,omitempty"`
+
+	// Hostname is mapped to the local address of the forward in the hosts file (see Hosts)
+	Hostname string `json:"hostname,omitempty"`
+
+	// Address is the local address the ports listen on, 127.0.0.1 and ::1 when empty.
+	// AutoAddress allocates one from the loopback pool (see Loopback)
+	Address string `json:"address,omitempty"`
+
+	// Capture records the local connections to a pcap-ng file
+	Capture *CaptureConfiguration `json:"capture,omitempty"`
+
+	// Shaping throttles and delays the data of the local connections
+	Shaping *ShapingConfiguration `json:"shaping,omitempty"`
+
+	// Faults injects network faults into the local connections and the tunnel
+	Faults *FaultsConfiguration `json:"faults,omitempty"`
+
+	// TLS terminates TLS on the local ports, the pod receiving plaintext
+	TLS *TLSConfiguration `json:"tls,omitempty"`
+
+	// HTTP inspects the HTTP/1 exchanges of the local connections
+	HTTP *HTTPConfiguration `json:"http,omitempty"`
+
+	// Transport is the port-forward transport, the global transport when empty
+	Transport string `json:"transport,omitempty"`
+}
+
+// HTTPConfiguration defines the inspection of the HTTP exchanges of a forward. Connections
+// that do not speak HTTP/1 are passed through.
+type HTTPConfiguration struct {
+	// Log logs each request: method, path, status and latency
+	Log bool `json:"log"`
+
+	// Headers are set on each request, overriding the ones sent; an empty value removes the header
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// HAR is the file the exchanges are recorded to, none when empty
+	HAR string `json:"har,omitempty"`
+}
+
+// TLSConfiguration defines the certificate local ports serve: a key pair, or a certificate
+// issued by the local certificate authority when SelfSigned is set.
+type TLSConfiguration struct {
+	// Cert and Key are the PEM files of the certificate and its private key
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+
+	// SelfSigned issues a certificate for localhost, the local address, the hostname and Hosts
+	SelfSigned bool     `json:"selfSigned,omitempty"`
+	Hosts      []string `json:"hosts,omitempty"`
+}
+
+// FaultsConfiguration injects network faults into a forward, to test how clients cope with them.
+type FaultsConfiguration struct {
+	// Seed makes the random faults reproducible, random when 0
+	Seed int64 `json:"seed,omitempty"`
+
+	// Reset is the percentage of new connections reset as soon as they are accepted
+	Reset float64 `json:"reset,omitempty"`
+
+	// Cut resets each connection after a random duration
+	Cut *FaultInterval `json:"cut,omitempty"`
+
+	// Blackhole stalls the traffic of all connections for a window
+	Blackhole *BlackholeConfiguration `json:"blackhole,omitempty"`
+
+	// Drop closes the tunnel after a random duration once ready, as if the pod was lost
+	Drop *FaultInterval `json:"drop,omitempty"`
+}
+
+// FaultInterval is a random duration between Min and Max, Min when Max is empty.
+type FaultInterval struct {
+	Min string `json:"min"`
+	Max string `json:"max,omitempty"`
+}
+
+// BlackholeConfiguration stalls the traffic for Duration, every Every.
+type BlackholeConfiguration struct {
+	Every    string `json:"every"`
+	Duration string `json:"duration"`
+}
+
+// ShapingConfiguration simulates a slow network on the connections of a forward.
+type ShapingConfiguration struct {
+	// RateIn and RateOut limit the bytes per second from and to the pod (e.g. "512Ki"), unlimited when empty
+	RateIn  string `json:"rateIn,omitempty"`
+	RateOut string `json:"rateOut,omitempty"`
+
+	// Latency delays the data in each direction, give or take Jitter
+	Latency string `json:"latency,omitempty"`
+	Jitter  string `json:"jitter,omitempty"`
+}
+
+// CaptureConfiguration defines the capture of the connections of a forward.
+type CaptureConfiguration struct {
+	// Enabled switches the capture, keeping its settings
+	Enabled bool `json:"enabled"`
+
+	// File is the pcap-ng file, rotated once it reaches MaxBytes (never when 0)
+	File     string `json:"file"`
+	MaxBytes int64  `json:"maxBytes"`
+}
+
+// ReverseConfiguration defines a reverse forward: connections accepted by a relay pod in the
+// cluster are tunnelled back to a local address.
+type ReverseConfiguration struct {
+	// Address is the local host:port connections are tunnelled back to
+	Address string `json:"address"`
+
+	// Port is the port exposed in the cluster, the port of Address when 0
+	Port int `json:"port,omitempty"`
+
+	// Service is the name of a Service created in front of the relay pod, none when empty
+	Service string `json:"service,omitempty"`
+
+	// Connections is the number of idle connections the relay pod holds for in-cluster clients
+	Connections int `json:"connections"`
+}
+
+// HealthCheckConfiguration defines how a forward is probed through its tunnel.
+type HealthCheckConfiguration struct {
+	Type string `json:"type"` // "tcp" or "http"
+
+	// Port is the local port to probe, the first forwarded port when 0
+	Port int    `json:"port,omitempty"`
+	Path string `json:"path,omitempty"` // HTTP only
+
+	Interval         string `json:"interval"`
+	Timeout          string `json:"timeout"`
+	FailureThreshold int    `json:"failureThreshold"`
+}
+
+type RouteMatchConfiguration struct {
+	Host string `json:"host,omitempty"`
+	Path string `json:"path,omitempty"`
 }
 
 type LogsConfiguration struct {
@@ -27,10 +218,85 @@ type LogsConfiguration struct {
 	Pretty bool   `json:"pretty"`
 }
 
+// RetryPolicyConfiguration overrides the retry policy of an error type.
+// Unset fields keep the default policy of the error type.
+type RetryPolicyConfiguration struct {
+	Action       string  `json:"action,omitempty"` // "retry" or "stop"
+	InitialDelay string  `json:"initialDelay,omitempty"`
+	MaxDelay     string  `json:"maxDelay,omitempty"`
+	Multiplier   float64 `json:"multiplier,omitempty"`
+}
+
+// RelayConfiguration defines the relay pods created for host forwards.
+type RelayConfiguration struct {
+	Namespace string `json:"namespace"`
+
+	Image           string `json:"image"`
+	ImagePullPolicy string `json:"imagePullPolicy"`
+
+	// Command runs in the relay container; $(RELAY_HOST) and $(RELAY_PORT) are expanded by Kubernetes
+	Command []string `json:"command"`
+
+	// ReverseCommand runs in the relay container of reverse forwards: it accepts connections on
+	// $(RELAY_PORT) and hands each one to a connection made to $(RELAY_TUNNEL_PORT)
+	ReverseCommand []string `json:"reverseCommand"`
+
+	Labels             map[string]string `json:"labels,omitempty"`
+	Annotations        map[string]string `json:"annotations,omitempty"`
+	NodeSelector       map[string]string `json:"nodeSelector,omitempty"`
+	ServiceAccountName string            `json:"serviceAccountName,omitempty"`
+
+	// CPU and Memory are the requests and limits of the relay container
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+// ProxyConfiguration defines the SOCKS5 and HTTP CONNECT proxy to cluster Services.
+type ProxyConfiguration struct {
+	// Listen is the local address of the proxy
+	Listen string `json:"listen"`
+
+	// IdleTimeout is how long the tunnel of a Service port is kept without connections
+	IdleTimeout string `json:"idleTimeout"`
+}
+
+// HostsConfiguration enables the managed block of forward hostnames in a hosts file.
+type HostsConfiguration struct {
+	// File is the path of the hosts file
+	File string `json:"file"`
+}
+
+// LoopbackConfiguration defines the pool of addresses allocated to "auto" forwards.
+type LoopbackConfiguration struct {
+	// Pool is the IPv4 loopback range addresses are allocated from
+	Pool string `json:"pool"`
+
+	// File persists the allocations, in the user configuration directory when empty
+	File string `json:"file,omitempty"`
+}
+
 type Configuration struct {
 	Forwards []PortForwardConfiguration `json:"forwards"`
 
 	Logs    LogsConfiguration `json:"logs"`
+
+	// Relay defines the relay pods of host and reverse forwards
+	Relay RelayConfiguration `json:"relay"`
+
+	// Loopback defines the addresses allocated to forwards
+	Loopback LoopbackConfiguration `json:"loopback"`
+
+	// Hosts enables the hostnames of forwards in a hosts file
+	Hosts *HostsConfiguration `json:"hosts,omitempty"`
+
+	// Proxy enables the SOCKS5 and HTTP CONNECT proxy to cluster Services
+	Proxy *ProxyConfiguration `json:"proxy,omitempty"`
+
+	// Retry maps error types (e.g. "permission-denied") to their retry policy
+	Retry map[string]RetryPolicyConfiguration `json:"retry,omitempty"`
+
+	// Transport is the port-forward transport of forwards without one
+	Transport string `json:"transport"`
 }
 
 //go:embed schema.cue
@@ -80,36 +346,191 @@ func loadConfiguration(finename string) ([]byte, error) {
 }
 
 func validateConfiguration(cfg Configuration) (Configuration, error) {
-	// Track local ports to detect conflicts
-	localPorts := make(map[int]string) // port -> forward name
+	// Track local ports and sockets to detect conflicts
+	localPorts := make(map[string]string)   // address:port -> forward name
+	localSockets := make(map[string]string) // socket path -> forward name
+	hostnames := make(map[string]string)    // hostname -> forward name
 
-	for _, pf := range cfg.Forwards {
+	var pool netip.Prefix
+	if cfg.Loopback.Pool != "" {
+		p, err := loopback.ParsePool(cfg.Loopback.Pool)
+		if err != nil {
+			return cfg, err
+		}
+		pool = p
+	}
+
+	for i, pf := range cfg.Forwards {
 		if pf.Name == "" {
 			return cfg, fmt.Errorf("each port forward must have a name")
 		}
 
+		// Forwards without transport use the global one
+		if pf.Transport == "" {
+			cfg.Forwards[i].Transport = cfg.Transport
+		}
+
+		if pf.Hostname != "" {
+			hostname := strings.ToLower(pf.Hostname)
+			if existingForward, exists := hostnames[hostname]; exists {
+				return cfg, fmt.Errorf("hostname conflict: %s used by both '%s' and '%s'", pf.Hostname, existingForward, pf.Name)
+			}
+			hostnames[hostname] = pf.Name
+
+			if !slices.ContainsFunc(pf.Ports, func(port string) bool {
+				spec, err := ParsePort(port)
+				return err == nil && spec.Socket == ""
+			}) {
+				return cfg, fmt.Errorf("hostname %s of port forward %s needs a local TCP port", pf.Hostname, pf.Name)
+			}
+		}
+
+		if pf.Kind == "reverse" {
+			if err := validateReverse(pf); err != nil {
+				return cfg, err
+			}
+			continue
+		}
+
+		addresses, err := listenAddresses(pf, pool)
+		if err != nil {
+			return cfg, err
+		}
+
+		if pf.Health != nil && pf.Health.Port != 0 {
+			if !slices.ContainsFunc(pf.Ports, func(port string) bool {
+				spec, err := ParsePort(port)
+				return err == nil && spec.Local == pf.Health.Port
+			}) {
+				return cfg, fmt.Errorf("health check port %d of port forward %s is not a forwarded local port", pf.Health.Port, pf.Name)
+			}
+		}
+
 		for _, port := range pf.Ports {
-			parts := strings.SplitN(port, ":", 2)
+			spec, err := ParsePort(port)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid port specification in port forward %s : %s", pf.Name, port)
+			}
 
-			if len(parts) >= 1 {
-				p1, err := strconv.Atoi(parts[0])
-				if err != nil || p1 < 1 || p1 > 65535 {
-					return cfg, fmt.Errorf("invalid port specification in port forward %s : %s", pf.Name, port)
+			// Check for port and socket conflicts
+			if spec.Socket != "" {
+				socket := filepath.Clean(spec.Socket)
+				if existingForward, exists := localSockets[socket]; exists {
+					return cfg, fmt.Errorf("socket conflict: local socket %s used by both '%s' and '%s'", socket, existingForward, pf.Name)
 				}
+				localSockets[socket] = pf.Name
+				continue
+			}
 
-				// Check for port conflicts
-				if existingForward, exists := localPorts[p1]; exists {
-					return cfg, fmt.Errorf("port conflict: local port %d used by both '%s' and '%s'", p1, existingForward, pf.Name)
+			portStr := strconv.Itoa(spec.Local)
+			for _, address := range addresses {
+				// An unspecified address takes the port on all the others
+				for key, existingForward := range localPorts {
+					other, otherPort, _ := net.SplitHostPort(key)
+					if otherPort == portStr && (addressesOverlap(address, other) || addressesOverlap(other, address)) {
+						return cfg, fmt.Errorf("port conflict: local port %d used by both '%s' and '%s'", spec.Local, existingForward, pf.Name)
+					}
 				}
-				localPorts[p1] = pf.Name
 			}
-			if len(parts) == 2 {
-				p2, err := strconv.Atoi(parts[1])
-				if err != nil || p2 < 1 || p2 > 65535 {
-					return cfg, fmt.Errorf("invalid port specification in port forward %s : %s", pf.Name, port)
+			for _, address := range addresses {
+				localPorts[net.JoinHostPort(address, portStr)] = pf.Name
+			}
+		}
+	}
+
+	if cfg.Proxy != nil {
+		host, portStr, err := net.SplitHostPort(cfg.Proxy.Listen)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid proxy address %s: %w", cfg.Proxy.Listen, err)
+		}
+		if port, err := parsePortNumber(portStr); err == nil {
+			for key, existingForward := range localPorts {
+				address, otherPort, _ := net.SplitHostPort(key)
+				if otherPort == portStr && addressesOverlap(host, address) {
+					return cfg, fmt.Errorf("port conflict: local port %d used by both '%s' and the proxy", port, existingForward)
 				}
 			}
 		}
 	}
+
 	return cfg, nil
 }
+
+// defaultAddresses are the local addresses of forwards without address.
+var defaultAddresses = []string{"127.0.0.1", "::1"}
+
+// listenAddresses returns the local addresses of a forward, for conflict detection. An allocated
+// address is distinct from any other: it gets a key of its own.
+func listenAddresses(pf PortForwardConfiguration, pool netip.Prefix) ([]string, error) {
+	switch pf.Address {
+	case "":
+		return defaultAddresses, nil
+	case AutoAddress:
+		if !pool.IsValid() {
+			return nil, fmt.Errorf("port forward %s needs a loopback pool to allocate its address", pf.Name)
+		}
+		return []string{AutoAddress + "/" + pf.Name}, nil
+	}
+
+	addr, err := netip.ParseAddr(pf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s of port forward %s: %w", pf.Address, pf.Name, err)
+	}
+	if pool.IsValid() && pool.Contains(addr) {
+		return nil, fmt.Errorf("address %s of port forward %s is part of the loopback pool %s, use \"auto\" instead", pf.Address, pf.Name, pool)
+	}
+
+	return []string{addr.String()}, nil
+}
+
+// addressesOverlap checks whether a listen host, possibly empty or "localhost", covers a
+// forward address. An unspecified host covers all of them.
+func addressesOverlap(host string, address string) bool {
+	if host == "localhost" {
+		return slices.Contains(defaultAddresses, address)
+	}
+	if host == "" {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return addr.IsUnspecified() || addr.String() == address
+}
+
+// validateReverse checks a reverse forward and defaults its cluster port to the port of its address.
+func validateReverse(pf PortForwardConfiguration) error {
+	if pf.Reverse == nil {
+		return fmt.Errorf("reverse port forward %s has no reverse configuration", pf.Name)
+	}
+
+	if pf.Health != nil || pf.Mode == "balance" || pf.Replicas > 1 {
+		return fmt.Errorf("reverse port forward %s does not support health checks or load balancing", pf.Name)
+	}
+
+	if pf.Lazy {
+		return fmt.Errorf("reverse port forward %s cannot be lazy", pf.Name)
+	}
+
+	if pf.Capture != nil || pf.Shaping != nil || pf.Faults != nil || pf.TLS != nil || pf.HTTP != nil {
+		return fmt.Errorf("reverse port forward %s does not support capture, shaping, faults, TLS or HTTP inspection", pf.Name)
+	}
+
+	_, portStr, err := net.SplitHostPort(pf.Reverse.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s of reverse port forward %s: %w", pf.Reverse.Address, pf.Name, err)
+	}
+
+	port, err := parsePortNumber(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid address %s of reverse port forward %s: %w", pf.Reverse.Address, pf.Name, err)
+	}
+
+	if pf.Reverse.Port == 0 {
+		pf.Reverse.Port = port
+	}
+
+	return nil
+}
@@ -97,3 +97,624 @@ forwards: [{
 func writeTestFile(path string, content string) error {
 	return os.WriteFile(path, []byte(content), 0644)
 }
+
+// TestReadConfigurationNode tests parsing of the optional node field
+func TestReadConfigurationNode(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "exporter"
+  ports: ["9100"]
+  namespace: "monitoring"
+  resource: "ds/node-exporter"
+  node: "kubernetes.io/hostname=worker-1"
+}, {
+  name: "api"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "dep/api"
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "kubernetes.io/hostname=worker-1", cfg.Forwards[0].Node)
+	assert.Equal(t, "", cfg.Forwards[1].Node)
+}
+
+// TestReadConfigurationRouteMatch tests parsing of the optional route match
+func TestReadConfigurationRouteMatch(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "web"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "ingress/web"
+  match: {
+    host: "app.example.com"
+    path: "/api"
+  }
+}, {
+  name: "api"
+  ports: ["9090"]
+  namespace: "default"
+  resource: "httproute/api"
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Forwards[0].Match)
+	assert.Equal(t, "app.example.com", cfg.Forwards[0].Match.Host)
+	assert.Equal(t, "/api", cfg.Forwards[0].Match.Path)
+	assert.Nil(t, cfg.Forwards[1].Match)
+}
+
+// TestReadConfigurationRetry tests parsing and validation of retry policy overrides
+func TestReadConfigurationRetry(t *testing.T) {
+	configStr := `
+forwards: []
+retry: {
+  "permission-denied": {action: "stop"}
+  "no-pod-available": {initialDelay: "5s", maxDelay: "2m", multiplier: 2}
+}
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "stop", cfg.Retry["permission-denied"].Action)
+	assert.Equal(t, RetryPolicyConfiguration{InitialDelay: "5s", MaxDelay: "2m", Multiplier: 2}, cfg.Retry["no-pod-available"])
+
+	invalid := []string{
+		`retry: {"bogus": {action: "stop"}}`,
+		`retry: {"pod-failed": {action: "panic"}}`,
+		`retry: {"pod-failed": {initialDelay: "soon"}}`,
+	}
+	for _, c := range invalid {
+		require.NoError(t, writeTestFile(tempFile, "forwards: []\n"+c))
+		_, err := ReadConfiguration(tempFile)
+		assert.Error(t, err, c)
+	}
+}
+
+// TestReadConfigurationHealth tests health check defaults and port validation
+func TestReadConfigurationHealth(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "api"
+  ports: ["8080", "9090:9000"]
+  namespace: "default"
+  resource: "svc/api"
+  health: {type: "http", port: 9090, path: "/healthz"}
+}, {
+  name: "db"
+  ports: ["5432"]
+  namespace: "default"
+  resource: "svc/db"
+  health: {}
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, &HealthCheckConfiguration{Type: "http", Port: 9090, Path: "/healthz", Interval: "10s", Timeout: "2s", FailureThreshold: 3}, cfg.Forwards[0].Health)
+	assert.Equal(t, &HealthCheckConfiguration{Type: "tcp", Interval: "10s", Timeout: "2s", FailureThreshold: 3}, cfg.Forwards[1].Health)
+
+	configStr = `
+forwards: [{
+  name: "api"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "svc/api"
+  health: {port: 9000}
+}]
+`
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+}
+
+// TestReadConfigurationHoldTimeout tests the hold timeout default and validation
+func TestReadConfigurationHoldTimeout(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "api"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "svc/api"
+}, {
+  name: "db"
+  ports: ["5432"]
+  namespace: "default"
+  resource: "svc/db"
+  holdTimeout: "1m30s"
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "30s", cfg.Forwards[0].HoldTimeout)
+	assert.Equal(t, "1m30s", cfg.Forwards[1].HoldTimeout)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", holdTimeout: "soon"}]`))
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+}
+
+// TestReadConfigurationBalance tests the load balancing settings and their defaults
+func TestReadConfigurationBalance(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "api"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "svc/api"
+}, {
+  name: "web"
+  ports: ["8081"]
+  namespace: "default"
+  resource: "dep/web"
+  replicas: 3
+  loadBalancing: "least-connections"
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "single", cfg.Forwards[0].Mode)
+	assert.Equal(t, "round-robin", cfg.Forwards[0].LoadBalancing)
+	assert.Equal(t, 0, cfg.Forwards[0].Replicas)
+	assert.Equal(t, 3, cfg.Forwards[1].Replicas)
+	assert.Equal(t, "least-connections", cfg.Forwards[1].LoadBalancing)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", loadBalancing: "random"}]`))
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", replicas: 0}]`))
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+}
+
+// TestReadConfigurationRelay tests the relay pod settings and their defaults
+func TestReadConfigurationRelay(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, `forwards: [{name: "db", ports: ["5432"], namespace: "default", resource: "host/db.internal:5432"}]`))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "default", cfg.Relay.Namespace)
+	assert.Equal(t, "alpine/socat:1.8.0.3", cfg.Relay.Image)
+	assert.Equal(t, "IfNotPresent", cfg.Relay.ImagePullPolicy)
+	assert.Equal(t, []string{"socat", "TCP-LISTEN:$(RELAY_PORT),fork,reuseaddr", "TCP:$(RELAY_HOST):$(RELAY_PORT)"}, cfg.Relay.Command)
+	assert.Equal(t, "50m", cfg.Relay.CPU)
+
+	configStr := `
+forwards: []
+relay: {
+  namespace: "tools"
+  image: "registry.local/relay:1"
+  command: ["relay", "$(RELAY_HOST)", "$(RELAY_PORT)"]
+  labels: {team: "platform"}
+  nodeSelector: {"kubernetes.io/os": "linux"}
+}
+`
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err = ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "tools", cfg.Relay.Namespace)
+	assert.Equal(t, "registry.local/relay:1", cfg.Relay.Image)
+	assert.Equal(t, []string{"relay", "$(RELAY_HOST)", "$(RELAY_PORT)"}, cfg.Relay.Command)
+	assert.Equal(t, map[string]string{"team": "platform"}, cfg.Relay.Labels)
+	assert.Equal(t, map[string]string{"kubernetes.io/os": "linux"}, cfg.Relay.NodeSelector)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: [], relay: {imagePullPolicy: "Sometimes"}`))
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+}
+
+// TestParsePort tests parsing of port and socket specifications
+func TestParsePort(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		expected PortSpec
+		str      string
+	}{
+		{"8080", PortSpec{Local: 8080}, "8080"},
+		{"8080:80", PortSpec{Local: 8080, Remote: 80}, "8080:80"},
+		{"unix:/tmp/pg.sock:5432", PortSpec{Socket: "/tmp/pg.sock", Remote: 5432}, "unix:/tmp/pg.sock:5432"},
+		{"unix:run/a:b.sock:80", PortSpec{Socket: "run/a:b.sock", Remote: 80}, "unix:run/a:b.sock:80"},
+	} {
+		spec, err := ParsePort(tc.spec)
+		require.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.expected, spec)
+		assert.Equal(t, tc.str, spec.String())
+	}
+
+	for _, spec := range []string{"", "abc", "0", "70000", "80:abc", "unix:/tmp/pg.sock", "unix::5432", "unix:/tmp/pg.sock:0"} {
+		_, err := ParsePort(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// TestReadConfigurationSocket tests Unix socket ports, their permissions and conflicts
+func TestReadConfigurationSocket(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "db"
+  ports: ["unix:/tmp/pg.sock:5432", "15432:5432"]
+  namespace: "default"
+  resource: "svc/db"
+}, {
+  name: "docker"
+  ports: ["unix:/tmp/docker.sock:2375"]
+  namespace: "default"
+  resource: "svc/docker"
+  socketMode: "0660"
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "0600", cfg.Forwards[0].SocketMode)
+	assert.Equal(t, "0660", cfg.Forwards[1].SocketMode)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "db", ports: ["unix:/tmp/pg.sock"], namespace: "default", resource: "svc/db"}]`,
+		`forwards: [{name: "db", ports: ["unix:/tmp/pg.sock:5432"], namespace: "default", resource: "svc/db", socketMode: "rw"}]`,
+		`forwards: [{name: "a", ports: ["unix:/tmp/pg.sock:5432"], namespace: "default", resource: "svc/a"}, {name: "b", ports: ["unix:/tmp/../tmp/pg.sock:5432"], namespace: "default", resource: "svc/b"}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationReverse tests reverse forwards and their defaults
+func TestReadConfigurationReverse(t *testing.T) {
+	configStr := `
+forwards: [{
+  name: "api"
+  ports: ["8080"]
+  namespace: "default"
+  resource: "svc/api"
+}, {
+  name: "webhook"
+  kind: "reverse"
+  namespace: "dev"
+  reverse: {
+    address: "localhost:3000"
+    service: "webhook-receiver"
+  }
+}]
+`
+	tempFile := t.TempDir() + "/test.cue"
+	require.NoError(t, writeTestFile(tempFile, configStr))
+
+	cfg, err := ReadConfiguration(tempFile)
+
+	require.NoError(t, err)
+	assert.Equal(t, "forward", cfg.Forwards[0].Kind)
+	assert.Nil(t, cfg.Forwards[0].Reverse)
+	assert.Equal(t, "reverse", cfg.Forwards[1].Kind)
+	require.NotNil(t, cfg.Forwards[1].Reverse)
+	assert.Equal(t, "localhost:3000", cfg.Forwards[1].Reverse.Address)
+	assert.Equal(t, 3000, cfg.Forwards[1].Reverse.Port)
+	assert.Equal(t, "webhook-receiver", cfg.Forwards[1].Reverse.Service)
+	assert.Equal(t, 4, cfg.Forwards[1].Reverse.Connections)
+	assert.Equal(t, []string{"socat", "TCP-LISTEN:$(RELAY_TUNNEL_PORT),fork,reuseaddr", "TCP-LISTEN:$(RELAY_PORT),reuseaddr,reuseport"}, cfg.Relay.ReverseCommand)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "webhook", kind: "reverse", namespace: "dev"}]`,
+		`forwards: [{name: "webhook", kind: "reverse", namespace: "dev", reverse: {address: "localhost"}}]`,
+		`forwards: [{name: "webhook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000", service: "Web_Hook"}}]`,
+		`forwards: [{name: "webhook", kind: "reverse", namespace: "dev", ports: ["3000"], reverse: {address: "localhost:3000"}}]`,
+		`forwards: [{name: "webhook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, health: {}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationProxy tests the proxy defaults and its port conflicts
+func TestReadConfigurationProxy(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `proxy: {}`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Proxy)
+	assert.Equal(t, "127.0.0.1:1080", cfg.Proxy.Listen)
+	assert.Equal(t, "5m", cfg.Proxy.IdleTimeout)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: []`))
+	cfg, err = ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Nil(t, cfg.Proxy)
+
+	require.NoError(t, writeTestFile(tempFile, `
+proxy: {listen: "127.0.0.1:8080"}
+forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api"}]
+`))
+	_, err = ReadConfiguration(tempFile)
+	assert.ErrorContains(t, err, "port conflict")
+}
+
+// TestReadConfigurationHostnames tests the hosts file default and hostname validation
+func TestReadConfigurationHostnames(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+hosts: {}
+forwards: [{name: "db", ports: ["5432"], namespace: "db", resource: "svc/postgres", hostname: "postgres.db.svc.cluster.local"}]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Hosts)
+	assert.Equal(t, "/etc/hosts", cfg.Hosts.File)
+	assert.Equal(t, "postgres.db.svc.cluster.local", cfg.Forwards[0].Hostname)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "db", ports: ["5432"], namespace: "db", resource: "svc/postgres", hostname: "postgres db"}]`,
+		`forwards: [{name: "a", ports: ["5432"], namespace: "db", resource: "svc/a", hostname: "db.local"}, {name: "b", ports: ["5433"], namespace: "db", resource: "svc/b", hostname: "DB.local"}]`,
+		`forwards: [{name: "db", ports: ["unix:/tmp/pg.sock:5432"], namespace: "db", resource: "svc/postgres", hostname: "db.local"}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, hostname: "hook.local"}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationAddress tests the loopback pool default and port conflicts between addresses
+func TestReadConfigurationAddress(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [
+	{name: "api", ports: ["443"], namespace: "default", resource: "svc/api", address: "auto"},
+	{name: "web", ports: ["443"], namespace: "default", resource: "svc/web", address: "auto"},
+	{name: "admin", ports: ["443"], namespace: "default", resource: "svc/admin", address: "127.0.0.2"},
+	{name: "docs", ports: ["443"], namespace: "default", resource: "svc/docs"},
+]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, "127.1.0.0/16", cfg.Loopback.Pool)
+	assert.Empty(t, cfg.Loopback.File)
+	assert.Equal(t, AutoAddress, cfg.Forwards[0].Address)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "127.0.0.1"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "127.0.0.2"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b", address: "127.0.0.2"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "127.1.0.5"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "localhost"}]`,
+		`loopback: {pool: "10.0.0.0/8"}`,
+		`proxy: {listen: ":443"}, forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "auto"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "0.0.0.0"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b", address: "::"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "0.0.0.0"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b", address: "auto"}]`,
+		`forwards: [{name: "a", ports: ["443"], namespace: "default", resource: "svc/a", address: "auto"}, {name: "b", ports: ["443"], namespace: "default", resource: "svc/b", address: "::"}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationCapture tests the capture defaults and validation
+func TestReadConfigurationCapture(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [{name: "db", ports: ["5432"], namespace: "db", resource: "svc/postgres", capture: {file: "/tmp/db.pcapng"}}]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Forwards[0].Capture)
+	assert.Equal(t, CaptureConfiguration{Enabled: true, File: "/tmp/db.pcapng", MaxBytes: 104857600}, *cfg.Forwards[0].Capture)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "db", ports: ["5432"], namespace: "db", resource: "svc/postgres", capture: {file: ""}}]`,
+		`forwards: [{name: "db", ports: ["5432"], namespace: "db", resource: "svc/postgres", capture: {file: "/tmp/db.pcapng", maxBytes: 100}}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, capture: {file: "/tmp/hook.pcapng"}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationShaping tests the shaping settings and their validation
+func TestReadConfigurationShaping(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", shaping: {rateIn: "512Ki", rateOut: "1.5M", latency: "80ms", jitter: "10ms"}}]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Forwards[0].Shaping)
+	assert.Equal(t, ShapingConfiguration{RateIn: "512Ki", RateOut: "1.5M", Latency: "80ms", Jitter: "10ms"}, *cfg.Forwards[0].Shaping)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", shaping: {rateIn: "fast"}}]`,
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", shaping: {latency: "80"}}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, shaping: {latency: "80ms"}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationFaults tests the fault injection settings
+func TestReadConfigurationFaults(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", faults: {
+	seed: 42
+	reset: 12.5
+	cut: {min: "10s", max: "2m"}
+	blackhole: {every: "5m", duration: "20s"}
+	drop: {min: "10m"}
+}}]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Forwards[0].Faults)
+	assert.Equal(t, FaultsConfiguration{
+		Seed:      42,
+		Reset:     12.5,
+		Cut:       &FaultInterval{Min: "10s", Max: "2m"},
+		Blackhole: &BlackholeConfiguration{Every: "5m", Duration: "20s"},
+		Drop:      &FaultInterval{Min: "10m"},
+	}, *cfg.Forwards[0].Faults)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", faults: {reset: 120}}]`,
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", faults: {cut: {max: "2m"}}}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, faults: {reset: 10}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationTLS tests the certificate files and self-signed TLS settings
+func TestReadConfigurationTLS(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [
+	{name: "api", ports: ["8443:80"], namespace: "default", resource: "svc/api", tls: {cert: "/tmp/api.crt", key: "/tmp/api.key"}},
+	{name: "web", ports: ["9443:80"], namespace: "default", resource: "svc/web", tls: {selfSigned: true, hosts: ["web.local"]}},
+]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, &TLSConfiguration{Cert: "/tmp/api.crt", Key: "/tmp/api.key"}, cfg.Forwards[0].TLS)
+	assert.Equal(t, &TLSConfiguration{SelfSigned: true, Hosts: []string{"web.local"}}, cfg.Forwards[1].TLS)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "api", ports: ["8443"], namespace: "default", resource: "svc/api", tls: {cert: "/tmp/api.crt"}}]`,
+		`forwards: [{name: "api", ports: ["8443"], namespace: "default", resource: "svc/api", tls: {selfSigned: false}}]`,
+		`forwards: [{name: "api", ports: ["8443"], namespace: "default", resource: "svc/api", tls: {cert: "/tmp/api.crt", key: "/tmp/api.key", selfSigned: true}}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, tls: {selfSigned: true}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestReadConfigurationHTTP tests the HTTP inspection settings
+func TestReadConfigurationHTTP(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [
+	{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", http: {headers: {"Authorization": "Bearer token", "X-Tenant-Id": "acme"}, har: "/tmp/api.har"}},
+	{name: "web", ports: ["8081"], namespace: "default", resource: "svc/web", http: {log: false}},
+]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, &HTTPConfiguration{Log: true, Headers: map[string]string{"Authorization": "Bearer token", "X-Tenant-Id": "acme"}, HAR: "/tmp/api.har"}, cfg.Forwards[0].HTTP)
+	assert.Equal(t, &HTTPConfiguration{Log: false}, cfg.Forwards[1].HTTP)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", http: {headers: {"Bad Header": "x"}}}]`,
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", http: {har: ""}}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, http: {}}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReadConfigurationTransport(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [
+	{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api"},
+	{name: "web", ports: ["8081"], namespace: "default", resource: "svc/web", transport: "websocket"},
+]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, TransportAuto, cfg.Transport)
+	assert.Equal(t, TransportAuto, cfg.Forwards[0].Transport)
+	assert.Equal(t, TransportWebSocket, cfg.Forwards[1].Transport)
+
+	require.NoError(t, writeTestFile(tempFile, `
+transport: "spdy"
+forwards: [
+	{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api"},
+	{name: "web", ports: ["8081"], namespace: "default", resource: "svc/web", transport: "auto"},
+]
+`))
+	cfg, err = ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, TransportSPDY, cfg.Forwards[0].Transport, "forwards inherit the global transport")
+	assert.Equal(t, TransportAuto, cfg.Forwards[1].Transport)
+
+	require.NoError(t, writeTestFile(tempFile, `forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", transport: "http2"}]`))
+	_, err = ReadConfiguration(tempFile)
+	assert.Error(t, err)
+}
+
+func TestReadConfigurationLazy(t *testing.T) {
+	tempFile := t.TempDir() + "/test.cue"
+
+	require.NoError(t, writeTestFile(tempFile, `
+forwards: [
+	{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", lazy: true},
+	{name: "web", ports: ["8081"], namespace: "default", resource: "svc/web", lazy: true, idleTimeout: "30s"},
+]
+`))
+	cfg, err := ReadConfiguration(tempFile)
+	require.NoError(t, err)
+	assert.True(t, cfg.Forwards[0].Lazy)
+	assert.Equal(t, "5m", cfg.Forwards[0].IdleTimeout)
+	assert.Equal(t, "30s", cfg.Forwards[1].IdleTimeout)
+	assert.Equal(t, "30s", cfg.Forwards[0].DrainTimeout)
+
+	for _, invalid := range []string{
+		`forwards: [{name: "api", ports: ["8080"], namespace: "default", resource: "svc/api", lazy: true, idleTimeout: "soon"}]`,
+		`forwards: [{name: "hook", kind: "reverse", namespace: "dev", reverse: {address: "localhost:3000"}, lazy: true}]`,
+	} {
+		require.NoError(t, writeTestFile(tempFile, invalid))
+		_, err = ReadConfiguration(tempFile)
+		assert.Error(t, err, invalid)
+	}
+}
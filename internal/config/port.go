@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// socketPrefix marks a local endpoint as a Unix socket path.
+const socketPrefix = "unix:"
+
+// PortSpec is a parsed port mapping: a local TCP port, or a Unix socket, forwarded to a remote port.
+type PortSpec struct {
+	// Local is the local TCP port, 0 for sockets
+	Local int
+
+	// Socket is the path of the local Unix socket, empty for TCP ports
+	Socket string
+
+	// Remote is the remote port, 0 when not specified (the local port is used)
+	Remote int
+}
+
+// ParsePort parses a port mapping: "port", "local:remote" or "unix:/path/to.sock:remote".
+// Sockets always need a remote port.
+func ParsePort(spec string) (PortSpec, error) {
+	if rest, ok := strings.CutPrefix(spec, socketPrefix); ok {
+		i := strings.LastIndex(rest, ":")
+		if i <= 0 {
+			return PortSpec{}, fmt.Errorf("invalid socket specification %s (use 'unix:/path/to.sock:port')", spec)
+		}
+
+		remote, err := parsePortNumber(rest[i+1:])
+		if err != nil {
+			return PortSpec{}, fmt.Errorf("invalid remote port in %s", spec)
+		}
+
+		return PortSpec{Socket: rest[:i], Remote: remote}, nil
+	}
+
+	local, remote, found := strings.Cut(spec, ":")
+
+	var result PortSpec
+	var err error
+
+	result.Local, err = parsePortNumber(local)
+	if err != nil {
+		return PortSpec{}, fmt.Errorf("invalid local port in %s", spec)
+	}
+
+	if found {
+		result.Remote, err = parsePortNumber(remote)
+		if err != nil {
+			return PortSpec{}, fmt.Errorf("invalid remote port in %s", spec)
+		}
+	}
+
+	return result, nil
+}
+
+// parsePortNumber parses a port number between 1 and 65535.
+func parsePortNumber(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
+
+// RemotePort returns the remote port, which defaults to the local port.
+func (p PortSpec) RemotePort() int {
+	if p.Remote == 0 {
+		return p.Local
+	}
+	return p.Remote
+}
+
+// LocalName returns the local endpoint: the port number, or "unix:" and the socket path.
+func (p PortSpec) LocalName() string {
+	if p.Socket != "" {
+		return socketPrefix + p.Socket
+	}
+	return strconv.Itoa(p.Local)
+}
+
+// String formats the mapping back to its specification, omitting a remote port equal to the local port.
+func (p PortSpec) String() string {
+	if p.Socket == "" && (p.Remote == 0 || p.Remote == p.Local) {
+		return strconv.Itoa(p.Local)
+	}
+	return fmt.Sprintf("%s:%d", p.LocalName(), p.Remote)
+}
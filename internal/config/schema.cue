@@ -1,6 +1,6 @@
 
 
-#Port: string & =~"^([0-9]{1,5})(:[0-9]{1,5})?$"
+#Port: string & (=~"^([0-9]{1,5})(:[0-9]{1,5})?$" | =~"^unix:.+:[0-9]{1,5}$")
 
 #LogsLevel: "error" | "warn" | "info" | "debug" | "trace"
 
@@ -12,12 +12,221 @@
 #PortForwardConfiguration: {
     name: string
 
-    ports: [#Port, ...#Port]
+    // "forward" exposes pod ports locally, "reverse" exposes a local address in the cluster
+    kind: *"forward" | "reverse"
 
     namespace: string
-    resource: string
+
+    if kind == "forward" {
+        ports: [#Port, ...#Port]
+        resource: string
+
+        // Local address of the ports (default: 127.0.0.1 and ::1), "auto" allocates one from loopback.pool
+        address?: "auto" | =~"^[0-9a-fA-F.:]+$"
+    }
+
+    if kind == "reverse" {
+        reverse: #ReverseConfiguration
+    }
+
+    // Node name (bare or "name:<node>") or node label selector (selector-based resources only)
+    node?: string
+
+    // Backend selection for ingress and httproute resources
+    match?: {
+        host?: string
+        path?: string
+    }
+
+    // Active health check through the tunnel
+    health?: #HealthCheck
+
+    // How long new connections are held while the tunnel reconnects
+    holdTimeout: *"30s" | #Duration
+
+    // Open the tunnel on the first connection, and close it after idleTimeout without any
+    lazy: *false | bool
+    idleTimeout: *"5m" | #Duration
+
+    // How long connections to the previous configuration have to finish after a change
+    drainTimeout: *"30s" | #Duration
+
+    // Spread connections across several pods (svc, dep, sts, ds, ingress and httproute resources)
+    mode: *"single" | "balance"
+    replicas?: int & >=1
+    loadBalancing: *"round-robin" | "least-connections"
+
+    // Hostname mapped to the local address in the hosts file (see hosts)
+    hostname?: string & =~"^[a-zA-Z0-9]([-a-zA-Z0-9.]*[a-zA-Z0-9])?$"
+
+    // Capture of the local connections to a pcap-ng file
+    capture?: #CaptureConfiguration
+
+    // Bandwidth and latency of the local connections
+    shaping?: #ShapingConfiguration
+
+    // Network faults injected into the local connections and the tunnel
+    faults?: #FaultsConfiguration
+
+    // TLS terminated on the local ports, plaintext sent to the pod
+    tls?: #TLSConfiguration
+
+    // Inspection of the HTTP/1 exchanges of the local connections
+    http?: #HTTPConfiguration
+
+    // Port-forward transport (default: the global transport)
+    transport?: #Transport
+
+    // Permissions of the local Unix sockets ("unix:/path:port" ports)
+    socketMode: *"0600" | =~"^0?[0-7]{3}$"
+}
+
+#CaptureConfiguration: {
+    enabled: *true | bool
+    file: string & !=""
+    // Size at which the file is rotated, 0 to never rotate (default: 100 MiB)
+    maxBytes: *104857600 | 0 | int & >=65536
+}
+
+#ShapingConfiguration: {
+    // Bytes per second from (in) and to (out) the pod, e.g. "512Ki" or "2M"
+    rateIn?: #Rate
+    rateOut?: #Rate
+    // Delay added in each direction, give or take jitter
+    latency?: #Duration
+    jitter?: #Duration
+}
+
+#HTTPConfiguration: {
+    // Log each request: method, path, status and latency
+    log: *true | bool
+    // Headers set on each request, removed when empty
+    headers?: [=~"^[!#$%&'*+.^_`|~0-9A-Za-z-]+$"]: string
+    // HAR file the exchanges are recorded to
+    har?: string & !=""
+}
+
+// A certificate and key (PEM files), or a certificate issued by the local CA for localhost,
+// the local address, the hostname and hosts
+#TLSConfiguration: {
+    cert: string & !=""
+    key: string & !=""
+} | {
+    selfSigned: true
+    hosts?: [...string & =~"^[a-zA-Z0-9*]([-a-zA-Z0-9.:*]*[a-zA-Z0-9])?$"]
+}
+
+#FaultsConfiguration: {
+    // Seed of the random faults, for reproducible runs (default: random)
+    seed?: int
+    // Percentage of new connections reset
+    reset?: number & >=0 & <=100
+    // Connections are reset after a random duration
+    cut?: #FaultInterval
+    // Traffic stalls for duration, every every
+    blackhole?: {
+        every: #Duration
+        duration: #Duration
+    }
+    // The tunnel is dropped after a random duration once ready, then reconnects
+    drop?: #FaultInterval
+}
+
+// Random duration between min and max (default: min)
+#FaultInterval: {
+    min: #Duration
+    max?: #Duration
+}
+
+#Rate: string & =~"^[0-9]+(\\.[0-9]+)?(k|M|G|Ki|Mi|Gi)?$"
+
+#ReverseConfiguration: {
+    // Local address connections are tunnelled back to
+    address: string & =~"^.+:[0-9]{1,5}$"
+
+    // Port exposed in the cluster (default: port of the address)
+    port?: int & >=1 & <=65535
+
+    // Service created in front of the relay pod
+    service?: string & =~"^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+
+    // Idle connections the relay pod holds for in-cluster clients
+    connections: *4 | int & >=1 & <=64
+}
+
+#HealthCheck: {
+    type: *"tcp" | "http"
+    port?: int & >=1 & <=65535
+    if type == "http" {
+        path: *"/" | string
+    }
+    interval: *"10s" | #Duration
+    timeout: *"2s" | #Duration
+    failureThreshold: *3 | int & >=1
+}
+
+#Duration: string & =~"^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"
+
+#ErrorType: "unknown" | "network-transient" | "api-transient" | "resource-not-found" | "pod-not-running" | "pod-failed" | "config-invalid" | "permission-denied" | "no-pod-available" | "no-pod-on-node"
+
+#RetryPolicy: {
+    action?: "retry" | "stop"
+    initialDelay?: #Duration
+    maxDelay?: #Duration
+    multiplier?: number & >=1
+}
+
+#RelayConfiguration: {
+    namespace: *"default" | string
+    image: *"alpine/socat:1.8.0.3" | string
+    imagePullPolicy: *"IfNotPresent" | "Always" | "Never"
+    command: *["socat", "TCP-LISTEN:$(RELAY_PORT),fork,reuseaddr", "TCP:$(RELAY_HOST):$(RELAY_PORT)"] | [string, ...string]
+    reverseCommand: *["socat", "TCP-LISTEN:$(RELAY_TUNNEL_PORT),fork,reuseaddr", "TCP-LISTEN:$(RELAY_PORT),reuseaddr,reuseport"] | [string, ...string]
+    labels?: [string]: string
+    annotations?: [string]: string
+    nodeSelector?: [string]: string
+    serviceAccountName?: string
+    cpu: *"50m" | string
+    memory: *"32Mi" | string
+}
+
+#LoopbackConfiguration: {
+    pool: *"127.1.0.0/16" | =~"^[0-9.]+/[0-9]{1,2}$"
+    // Allocations file (default: addresses.json in the fwkeeper user configuration directory)
+    file?: string
+}
+
+#HostsConfiguration: {
+    file: *"/etc/hosts" | string
+}
+
+#ProxyConfiguration: {
+    listen: *"127.0.0.1:1080" | =~"^.*:[0-9]{1,5}$"
+    idleTimeout: *"5m" | #Duration
 }
 
 forwards: [...#PortForwardConfiguration]
 
 logs: #LogsConfiguration
+
+// Relay pods created for host and reverse forwards
+relay: #RelayConfiguration
+
+// Addresses allocated to forwards with address "auto"
+loopback: #LoopbackConfiguration
+
+// Port-forward transport of forwards without one: WebSocket with a SPDY fallback (auto), or either one
+transport: #Transport
+
+#Transport: *"auto" | "websocket" | "spdy"
+
+// Managed block of forward hostnames in the hosts file
+hosts?: #HostsConfiguration
+
+// SOCKS5 and HTTP CONNECT proxy to cluster Services
+proxy?: #ProxyConfiguration
+
+// Retry policy overrides, by error type
+retry?: close({
+    [#ErrorType]: #RetryPolicy
+})
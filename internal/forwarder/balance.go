@@ -0,0 +1,214 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// balanceRefreshInterval is how often a balanced forwarder looks for pods to fill its pool.
+var balanceRefreshInterval = 10 * time.Second
+
+// removal reports a tunnel that left the rotation; dropped tells a simulated tunnel drop.
+type removal struct {
+	tunnel  *tunnel
+	dropped bool
+}
+
+// balance keeps tunnels to up to replicas pods and spreads new local connections across them.
+// A pod whose connection is lost, or fails its health check, leaves the rotation without
+// affecting the others, and the pool is refilled from the running pods. A pod whose tunnel is
+// dropped by a fault is held out of the pool for the retry backoff, as a single tunnel would be.
+// It returns nil when ctx is done, or the last error once no tunnel is left.
+func (f *Forwarder) balance(ctx context.Context, log *zerolog.Logger) error {
+	multi := f.locator.(locator.MultiLocator)
+
+	f.transition(StateLocating, nil, time.Time{})
+
+	pool := make(map[types.UID]*tunnel)
+	removed := make(chan removal)
+
+	// held maps the pods whose tunnel was dropped to when they may rejoin the pool
+	held := make(map[types.UID]time.Time)
+	var rejoin <-chan time.Time
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer func() {
+		cancelWatch()
+		for _, t := range pool {
+			f.removeTunnel(t)
+			t.close()
+		}
+	}()
+
+	ticker := time.NewTicker(balanceRefreshInterval)
+	defer ticker.Stop()
+
+	var idleCheck <-chan time.Time
+	if f.lazy {
+		idleTicker := time.NewTicker(idleCheckInterval(f.idleTimeout))
+		defer idleTicker.Stop()
+		idleCheck = idleTicker.C
+	}
+
+	ready := false
+	var lastErr error
+	for {
+		if err := f.fill(watchCtx, log, multi, pool, held, removed); err != nil {
+			lastErr = err
+			if len(pool) > 0 {
+				log.Warn().Err(err).Msgf("DEGRADED - Forwarder %s: balancing across %d pod(s)", f.forwarderInfo(), len(pool))
+			}
+		}
+
+		if len(pool) == 0 {
+			if lastErr == nil {
+				lastErr = errors.New("no pod available for balancing")
+			}
+			return lastErr
+		}
+
+		if !ready {
+			log.Info().Msgf("READY - Forwarder %s: balancing across %d pod(s)", f.forwarderInfo(), len(pool))
+			f.attempt = 0
+			f.errorType = locator.ErrorTypeUnknown
+			f.transition(StateReady, nil, time.Time{})
+			ready = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case r := <-removed:
+			t := r.tunnel
+			delete(pool, t.target.PodUID)
+			f.removeTunnel(t)
+			t.close()
+			lastErr = fmt.Errorf("pod %s left the rotation", t.target.PodName)
+
+			if r.dropped {
+				// The last pod is retried by Start after its backoff
+				lastErr = fmt.Errorf("lost connection to pod %s: simulated tunnel drop", t.target.PodName)
+				delay := f.calculateBackoff()
+				held[t.target.PodUID] = time.Now().Add(delay)
+				rejoin = time.After(delay)
+				withTarget(log.Info(), t.target).Msgf("Forwarder %s: pod %s rejoins the rotation in %s", f.forwarderInfo(), t.target.PodName, delay)
+			}
+		case <-rejoin:
+			rejoin = nil
+		case <-ticker.C:
+		case <-idleCheck:
+			if f.closeIdlePool(pool) {
+				log.Info().Msgf("IDLE - Forwarder %s: no connection for %s, closing the tunnels", f.forwarderInfo(), f.idleTimeout)
+				return errIdle
+			}
+		}
+	}
+}
+
+// fill dials the running pods that are not in the pool yet, until it holds replicas tunnels.
+// Pods held until a later time are skipped, as are pods that cannot be dialled; the errors of
+// the latter are returned together.
+func (f *Forwarder) fill(ctx context.Context, log *zerolog.Logger, multi locator.MultiLocator, pool map[types.UID]*tunnel, held map[types.UID]time.Time, removed chan<- removal) error {
+	if f.replicas > 0 && len(pool) >= f.replicas {
+		return nil
+	}
+
+	// Pods already in the pool are skipped, so all candidates are needed
+	targets, err := multi.LocateAll(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, target := range targets {
+		if f.replicas > 0 && len(pool) >= f.replicas {
+			break
+		}
+		if _, exists := pool[target.PodUID]; exists {
+			continue
+		}
+		if until, ok := held[target.PodUID]; ok {
+			if time.Now().Before(until) {
+				continue
+			}
+			delete(held, target.PodUID)
+		}
+
+		f.recordTarget(log, target)
+
+		conn, err := f.dial(log, target)
+		if err != nil {
+			withTarget(log.Error().Err(err), target).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
+			errs = append(errs, err)
+			continue
+		}
+
+		t, err := newTargetTunnel(conn, log, &f.metrics, target)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, err)
+			continue
+		}
+		t.capture = f.captureWriter
+		t.shaping = f.shaping.Load
+		t.faults = f.faults
+		t.http = f.http
+
+		pool[target.PodUID] = t
+		f.addTunnel(t)
+		withTarget(log.Info(), target).Msgf("JOINED - Forwarder %s: pod %s added to the rotation", f.forwarderInfo(), target.PodName)
+
+		go f.watchTunnel(ctx, log, t, removed)
+	}
+
+	return errors.Join(errs...)
+}
+
+// watchTunnel reports t on removed once its connection is lost, it is dropped by a fault or
+// its health check gives up.
+func (f *Forwarder) watchTunnel(ctx context.Context, log *zerolog.Logger, t *tunnel, removed chan<- removal) {
+	healthCtx, cancelHealth := context.WithCancel(ctx)
+	defer cancelHealth()
+
+	healthErrCh := make(chan error, 1)
+	if f.health != nil {
+		go func() {
+			if err := f.monitorHealth(healthCtx, log, f.health.through(t)); err != nil {
+				healthErrCh <- err
+			}
+		}()
+	}
+
+	var drop <-chan time.Time
+	if d := f.faults.dropAfter(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		drop = timer.C
+	}
+
+	r := removal{tunnel: t}
+	select {
+	case <-ctx.Done():
+		return
+	case <-drop:
+		withTarget(log.Warn(), t.target).Msgf("FAULT - Forwarder %s: dropping the tunnel to pod %s", f.forwarderInfo(), t.target.PodName)
+		t.conn.Close()
+		r.dropped = true
+	case <-t.conn.CloseChan():
+		withTarget(log.Warn(), t.target).Msgf("LEFT - Forwarder %s: lost connection to pod %s", f.forwarderInfo(), t.target.PodName)
+	case err := <-healthErrCh:
+		withTarget(log.Warn().Err(err), t.target).Msgf("LEFT - Forwarder %s: pod %s is unhealthy", f.forwarderInfo(), t.target.PodName)
+	}
+
+	select {
+	case removed <- r:
+	case <-ctx.Done():
+	}
+}
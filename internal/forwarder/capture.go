@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"io"
+	"net"
+	"net/netip"
+
+	"github.com/rs/zerolog"
+
+	"github.com/codozor/fwkeeper/internal/capture"
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// SetCapture changes the capture settings while the forwarder runs: the capture file is
+// opened, closed or replaced without affecting the tunnel. Connections already open keep
+// writing to the previous file until it is closed.
+func (f *Forwarder) SetCapture(log *zerolog.Logger, cfg *config.CaptureConfiguration) error {
+	f.captureMu.Lock()
+	defer f.captureMu.Unlock()
+
+	f.configuration.Capture = cfg
+	if !f.capturing {
+		// Applied when the forwarder starts
+		return nil
+	}
+	return f.applyCaptureLocked(log)
+}
+
+// startCapture opens the configured capture file, once the forwarder starts.
+func (f *Forwarder) startCapture(log *zerolog.Logger) error {
+	f.captureMu.Lock()
+	defer f.captureMu.Unlock()
+
+	f.capturing = true
+	return f.applyCaptureLocked(log)
+}
+
+// stopCapture closes the capture file, once the forwarder stops.
+func (f *Forwarder) stopCapture() {
+	f.captureMu.Lock()
+	defer f.captureMu.Unlock()
+
+	f.capturing = false
+	if f.capture != nil {
+		f.capture.Close()
+		f.capture = nil
+	}
+}
+
+// applyCaptureLocked replaces the capture writer by one following the configuration.
+// f.captureMu must be held.
+func (f *Forwarder) applyCaptureLocked(log *zerolog.Logger) error {
+	if f.capture != nil {
+		f.capture.Close()
+		f.capture = nil
+		log.Info().Msgf("Capture of forwarder %s stopped", f.configuration.Name)
+	}
+
+	cfg := f.configuration.Capture
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	w, err := capture.Open(cfg.File, cfg.MaxBytes, log)
+	if err != nil {
+		return err
+	}
+	f.capture = w
+	log.Info().Msgf("Capturing connections of forwarder %s to %s", f.configuration.Name, cfg.File)
+
+	return nil
+}
+
+// captureWriter returns the current capture writer, nil when not capturing.
+func (f *Forwarder) captureWriter() *capture.Writer {
+	f.captureMu.Lock()
+	defer f.captureMu.Unlock()
+
+	return f.capture
+}
+
+// captureConnection starts recording a local connection forwarded to a pod port, nil when
+// not capturing. The pod side uses the local address of the connection.
+func captureConnection(w *capture.Writer, local net.Conn, remotePort int) *capture.Connection {
+	if w == nil {
+		return nil
+	}
+
+	var client, server netip.AddrPort
+	if addr, ok := local.RemoteAddr().(*net.TCPAddr); ok {
+		client = addr.AddrPort()
+	}
+	if addr, ok := local.LocalAddr().(*net.TCPAddr); ok {
+		server = netip.AddrPortFrom(addr.AddrPort().Addr(), uint16(remotePort))
+	} else {
+		server = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), uint16(remotePort))
+	}
+
+	return w.Connect(client, server)
+}
+
+// recordingWriter records the data written to w.
+type recordingWriter struct {
+	w      io.Writer
+	record func([]byte)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if n > 0 {
+		rw.record(p[:n])
+	}
+	return n, err
+}
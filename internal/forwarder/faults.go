@@ -0,0 +1,215 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// faults injects network faults into the connections and the tunnel of a forwarder. Random
+// decisions come from generators derived from the seed: one per connection, from its sequence
+// number, and one for the tunnel drops, so that a run can be reproduced whatever the order
+// concurrent connections are handled in.
+type faults struct {
+	// seed is the configured seed, or the one drawn when none is configured
+	seed int64
+
+	// conns is the sequence number of the last connection
+	conns atomic.Uint64
+
+	// drops decides the tunnel drops
+	mu    sync.Mutex
+	drops *rand.Rand
+
+	// reset is the percentage of new connections reset
+	reset float64
+
+	// connections are cut, and the tunnel dropped, after a random duration between min and max
+	cutMin, cutMax   time.Duration
+	dropMin, dropMax time.Duration
+
+	// traffic stalls for blackholeDuration every blackholeEvery, counting from start
+	blackholeEvery    time.Duration
+	blackholeDuration time.Duration
+	start             time.Time
+}
+
+// parseFaults parses a faults configuration, nil when there is none.
+func parseFaults(cfg *config.FaultsConfiguration) (*faults, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	if cfg.Reset < 0 || cfg.Reset > 100 {
+		return nil, fmt.Errorf("invalid reset percentage %v", cfg.Reset)
+	}
+
+	seed := cfg.Seed
+	for seed == 0 {
+		seed = rand.Int64()
+	}
+	fl := &faults{seed: seed, drops: faultRand(seed, 0), reset: cfg.Reset, start: time.Now()}
+
+	var err error
+	if fl.cutMin, fl.cutMax, err = parseFaultInterval(cfg.Cut); err != nil {
+		return nil, fmt.Errorf("invalid cut: %w", err)
+	}
+	if fl.dropMin, fl.dropMax, err = parseFaultInterval(cfg.Drop); err != nil {
+		return nil, fmt.Errorf("invalid drop: %w", err)
+	}
+
+	if cfg.Blackhole != nil {
+		if fl.blackholeEvery, err = time.ParseDuration(cfg.Blackhole.Every); err != nil {
+			return nil, fmt.Errorf("invalid blackhole interval: %w", err)
+		}
+		if fl.blackholeDuration, err = time.ParseDuration(cfg.Blackhole.Duration); err != nil {
+			return nil, fmt.Errorf("invalid blackhole duration: %w", err)
+		}
+		if fl.blackholeEvery <= 0 || fl.blackholeDuration <= 0 || fl.blackholeDuration >= fl.blackholeEvery {
+			return nil, fmt.Errorf("blackhole duration %s must be positive and shorter than its interval %s", fl.blackholeDuration, fl.blackholeEvery)
+		}
+	}
+
+	return fl, nil
+}
+
+// parseFaultInterval parses the bounds of a random duration, zero when there is none.
+func parseFaultInterval(interval *config.FaultInterval) (time.Duration, time.Duration, error) {
+	if interval == nil {
+		return 0, 0, nil
+	}
+
+	minimum, err := time.ParseDuration(interval.Min)
+	if err != nil {
+		return 0, 0, err
+	}
+	maximum, err := parseDurationOr(interval.Max, minimum)
+	if err != nil {
+		return 0, 0, err
+	}
+	if minimum <= 0 || maximum < minimum {
+		return 0, 0, fmt.Errorf("interval %s-%s must be positive and ordered", minimum, maximum)
+	}
+
+	return minimum, maximum, nil
+}
+
+// faultRand returns the generator of stream n of seed: 0 for the tunnel drops, the sequence
+// number for a connection.
+func faultRand(seed int64, n uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(uint64(seed), n))
+}
+
+// between returns a random duration between minimum and maximum, 0 when there is no interval.
+func between(rng *rand.Rand, minimum, maximum time.Duration) time.Duration {
+	if minimum <= 0 {
+		return 0
+	}
+	if maximum == minimum {
+		return minimum
+	}
+	return minimum + time.Duration(rng.Int64N(int64(maximum-minimum)+1))
+}
+
+// connectionFaults are the faults injected into one connection.
+type connectionFaults struct {
+	*faults
+
+	// seq is the sequence number of the connection, from 1
+	seq uint64
+
+	// reset tells whether the connection is reset, cut how long it lives before it is cut,
+	// 0 for ever
+	reset bool
+	cut   time.Duration
+}
+
+// connection decides the faults of a new connection, nil on nil faults.
+func (fl *faults) connection() *connectionFaults {
+	if fl == nil {
+		return nil
+	}
+
+	cf := &connectionFaults{faults: fl, seq: fl.conns.Add(1)}
+	rng := faultRand(fl.seed, cf.seq)
+	if fl.reset > 0 {
+		cf.reset = rng.Float64()*100 < fl.reset
+	}
+	cf.cut = between(rng, fl.cutMin, fl.cutMax)
+	return cf
+}
+
+// dropAfter returns how long a ready tunnel lives before it is dropped, 0 for ever.
+func (fl *faults) dropAfter() time.Duration {
+	if fl == nil {
+		return 0
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	return between(fl.drops, fl.dropMin, fl.dropMax)
+}
+
+// blackholeUntil returns the end of the blackhole window at now, zero outside of a window.
+func (fl *faults) blackholeUntil(now time.Time) time.Time {
+	if fl == nil || fl.blackholeEvery <= 0 {
+		return time.Time{}
+	}
+
+	elapsed := now.Sub(fl.start)
+	if elapsed < fl.blackholeEvery {
+		return time.Time{}
+	}
+
+	windowStart := elapsed - elapsed%fl.blackholeEvery
+	if elapsed-windowStart >= fl.blackholeDuration {
+		return time.Time{}
+	}
+	return fl.start.Add(windowStart + fl.blackholeDuration)
+}
+
+// resetConn closes a connection abruptly: TCP connections send a reset instead of a FIN.
+func resetConn(conn net.Conn) {
+	underlying := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		underlying = tlsConn.NetConn()
+	}
+	if tcp, ok := underlying.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// blackholeWriter holds the writes to w during the blackhole windows, as if the packets were
+// dropped until the network comes back, or until stop is closed.
+type blackholeWriter struct {
+	w      io.Writer
+	faults *faults
+	stop   <-chan struct{}
+	closed <-chan bool
+}
+
+func (bw *blackholeWriter) Write(p []byte) (int, error) {
+	if until := bw.faults.blackholeUntil(time.Now()); !until.IsZero() {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-bw.stop:
+			return 0, net.ErrClosed
+		case <-bw.closed:
+			return 0, net.ErrClosed
+		}
+	}
+
+	return bw.w.Write(p)
+}
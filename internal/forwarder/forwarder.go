@@ -2,20 +2,26 @@ package forwarder
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"net/http"
-	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
 
+	"github.com/codozor/fwkeeper/internal/capture"
+	"github.com/codozor/fwkeeper/internal/certs"
 	"github.com/codozor/fwkeeper/internal/config"
 	"github.com/codozor/fwkeeper/internal/locator"
 )
@@ -49,48 +55,138 @@ type Forwarder struct {
 	transport http.RoundTripper
 	upgrader  spdy.Upgrader
 
+	// dialPod opens port-forward connections, dial when nil
+	dialPod func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error)
+
 	retryConfig RetryConfig
 	attempt     uint
-}
 
-// forwarderWriter adapts Kubernetes portforward output to structured logging.
-type forwarderWriter struct {
-	logger *zerolog.Logger
-	level  zerolog.Level
+	// retryPolicies override retryConfig per error type; errorType is the type of the last error
+	retryPolicies RetryPolicies
+	errorType     locator.ErrorType
+
+	// target is the last located pod, status the current state and tunnels the
+	// tunnels new connections are attached to (empty while not ready), guarded by mu
+	target        locator.Target
+	status        Status
+	tunnels       []*tunnel
+	nextTunnel    int
+	tunnelChanged chan struct{}
+	mu            sync.Mutex
+
+	// balanced keeps tunnels to up to replicas pods (all running pods when 0), and
+	// leastConnections attaches new connections to the least busy one instead of rotating
+	balanced         bool
+	replicas         int
+	leastConnections bool
+
+	// reverse tunnels the connections accepted by a relay pod back to a local address
+	// instead of forwarding local ports, nil for forward kinds
+	reverse *config.ReverseConfiguration
+	// reverseDown is set while the local address of a reverse forward cannot be reached, so
+	// that the outage is reported once
+	reverseDown atomic.Bool
+
+	// onTransition is called with the new status after each state change
+	onTransition func(Status)
+
+	// health probes the tunnel once ready, nil when health checks are disabled
+	health *healthCheck
+
+	// metrics counts the connections piped through the tunnel
+	metrics metrics
+
+	// listeners are the bound local ports and sockets, kept across reconnects; new connections
+	// wait up to holdTimeout for a tunnel. Sockets are created with socketMode permissions
+	listeners   map[endpoint]*boundEndpoint
+	listenMu    sync.Mutex
+	holdTimeout time.Duration
+	socketMode  os.FileMode
+
+	// detached forwarders bind no local port, connections are handed over with Attach
+	detached bool
+
+	// lazy forwarders open the tunnel once a connection is held, and close it after
+	// idleTimeout without connections; held counts the connections waiting for a tunnel
+	// and heldChanged is closed when it grows, guarded by mu
+	lazy        bool
+	idleTimeout time.Duration
+	held        int
+	heldChanged chan struct{}
+
+	// predecessor is the forwarder this one replaces, nil when none; inheriting are the
+	// endpoints it serves until they are adopted, and released the endpoints handed over to
+	// a successor, guarded by listenMu. handoverMu makes the adoption of the inherited
+	// endpoints atomic with respect to their release to a successor. drainTimeout is how
+	// long the connections of a forwarder replaced by this one have to finish
+	predecessor  *handover
+	inheriting   map[endpoint]bool
+	released     map[endpoint]bool
+	handoverMu   sync.Mutex
+	drainTimeout time.Duration
+
+	// shaping throttles and delays the data of connections, none when nil
+	shaping atomic.Pointer[shaping]
+
+	// faults are injected into the connections and the tunnel, none when nil
+	faults *faults
+
+	// tlsConfig terminates TLS on the local ports, none when nil; authority issues
+	// self-signed certificates
+	tlsConfig *tls.Config
+	authority *certs.Authority
+
+	// transports remembers the transport that worked per cluster, shared across forwarders;
+	// defaultTransport applies when the configuration sets none
+	transports       *TransportMemory
+	defaultTransport string
+
+	// http inspects the HTTP exchanges of the connections, none when nil
+	http *httpInspector
+
+	// capture records local connections while capturing (between start and stop), guarded by captureMu
+	capture   *capture.Writer
+	capturing bool
+	captureMu sync.Mutex
 }
 
-func (w *forwarderWriter) Write(buf []byte) (n int, err error) {
-	n = len(buf)
+// Option customizes a Forwarder.
+type Option func(*Forwarder)
+
+// WithRetryPolicies sets the retry policy table used to react to each error type.
+func WithRetryPolicies(policies RetryPolicies) Option {
+	return func(f *Forwarder) {
+		f.retryPolicies = policies
+	}
+}
 
-	if n > 0 && buf[n-1] == '\n' {
-		buf = buf[:n-1]
+// WithTransitionHook registers a function called with the new status after each state change.
+// It runs on the forwarder goroutine and must not block.
+func WithTransitionHook(hook func(Status)) Option {
+	return func(f *Forwarder) {
+		f.onTransition = hook
 	}
+}
 
-	switch w.level {
-	case zerolog.ErrorLevel:
-		w.logger.Error().Msg(string(buf))
-	case zerolog.WarnLevel:
-		w.logger.Warn().Msg(string(buf))
-	case zerolog.InfoLevel:
-		w.logger.Info().Msg(string(buf))
-	default:
-		w.logger.Debug().Msg(string(buf))
+// WithoutListeners creates a forwarder that does not bind its local ports: connections
+// are handed over with Attach instead, for example by a proxy.
+func WithoutListeners() Option {
+	return func(f *Forwarder) {
+		f.detached = true
 	}
-	return n, nil
 }
 
 // New creates a new forwarder for the given pod and configuration.
 // Each forwarder gets its own SPDY transport and upgrader to avoid data races
 // when multiple forwarders run concurrently.
-func New(loc locator.Locator, configuration config.PortForwardConfiguration, client kubernetes.Interface, restCfg *rest.Config) (*Forwarder, error) {
+func New(loc locator.Locator, configuration config.PortForwardConfiguration, client kubernetes.Interface, restCfg *rest.Config, opts ...Option) (*Forwarder, error) {
 	// Create a dedicated transport AND upgrader for this forwarder.
-	// They must come from the same RoundTripperFor() call to be compatible.
-	transport, upgrader, err := spdy.RoundTripperFor(restCfg)
+	transport, upgrader, err := spdyRoundTripperFor(restCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SPDY transport: %w", err)
 	}
 
-	return &Forwarder{
+	f := &Forwarder{
 		locator:       loc,
 		configuration: configuration,
 		client:        client,
@@ -100,109 +196,315 @@ func New(loc locator.Locator, configuration config.PortForwardConfiguration, cli
 		transport:     transport,
 		upgrader:      upgrader,
 
-		retryConfig: DefaultRetryConfig(),
-		attempt:     0,
-	}, nil
+		retryConfig:   DefaultRetryConfig(),
+		attempt:       0,
+		retryPolicies: DefaultRetryPolicies(),
+		holdTimeout:   DefaultHoldTimeout,
+		socketMode:    DefaultSocketMode,
+		lazy:          configuration.Lazy,
+		idleTimeout:   DefaultIdleTimeout,
+		drainTimeout:  DefaultDrainTimeout,
+	}
+
+	if configuration.HoldTimeout != "" {
+		holdTimeout, err := time.ParseDuration(configuration.HoldTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hold timeout: %w", err)
+		}
+		f.holdTimeout = holdTimeout
+	}
+
+	if configuration.IdleTimeout != "" {
+		idleTimeout, err := time.ParseDuration(configuration.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idle timeout: %w", err)
+		}
+		f.idleTimeout = idleTimeout
+	}
+
+	if configuration.DrainTimeout != "" {
+		drainTimeout, err := time.ParseDuration(configuration.DrainTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid drain timeout: %w", err)
+		}
+		f.drainTimeout = drainTimeout
+	}
+
+	if configuration.SocketMode != "" {
+		mode, err := strconv.ParseUint(configuration.SocketMode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return nil, fmt.Errorf("invalid socket mode %s", configuration.SocketMode)
+		}
+		f.socketMode = os.FileMode(mode)
+	}
+
+	f.balanced = configuration.Mode == "balance" || configuration.Replicas > 1
+	f.replicas = configuration.Replicas
+	f.leastConnections = configuration.LoadBalancing == "least-connections"
+	if _, ok := loc.(locator.MultiLocator); f.balanced && !ok {
+		return nil, fmt.Errorf("load balancing is not supported for resource %s", configuration.Resource)
+	}
+
+	if configuration.Kind == "reverse" {
+		if configuration.Reverse == nil {
+			return nil, errors.New("reverse forward without reverse configuration")
+		}
+		if configuration.Health != nil {
+			return nil, errors.New("health checks are not supported by reverse forwards")
+		}
+		f.reverse = configuration.Reverse
+	}
+
+	shaping, err := parseShaping(configuration.Shaping)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shaping: %w", err)
+	}
+	f.shaping.Store(shaping)
+
+	if f.faults, err = parseFaults(configuration.Faults); err != nil {
+		return nil, fmt.Errorf("invalid faults: %w", err)
+	}
+
+	if configuration.Health != nil {
+		health, err := newHealthCheck(configuration.Health, configuration.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check: %w", err)
+		}
+		f.health = health
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if f.tlsConfig, err = f.serverTLS(configuration.TLS); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+
+	f.http = newHTTPInspector(configuration.HTTP, configuration.Name, f.tlsConfig != nil)
+
+	return f, nil
 }
 
 // forwarderInfo returns a formatted string with forwarder details for logging.
 func (f *Forwarder) forwarderInfo() string {
+	if f.reverse != nil {
+		return fmt.Sprintf("%s(%s reverse) port:%d -> %s", f.configuration.Name, f.configuration.Namespace, f.reverse.Port, f.reverse.Address)
+	}
+	if f.configuration.Address != "" {
+		return fmt.Sprintf("%s(%s %s) ports:%v address:%s", f.configuration.Name, f.configuration.Namespace, f.configuration.Resource, f.configuration.Ports, f.configuration.Address)
+	}
 	return fmt.Sprintf("%s(%s %s) ports:%v", f.configuration.Name, f.configuration.Namespace, f.configuration.Resource, f.configuration.Ports)
 }
 
 // Start begins the port forwarding loop, attempting to locate and forward to the pod.
-// It runs until the context is cancelled.
+// It runs until the context is cancelled, or until a retry policy stops it (Failed state).
 func (f *Forwarder) Start(ctx context.Context) {
 	log := zerolog.Ctx(ctx)
 
 	log.Info().Msgf("START - Forwarder %s", f.forwarderInfo())
+	if f.faults != nil {
+		log.Warn().Int64("seed", f.faults.seed).Msgf("FAULT - Forwarder %s injects faults with seed %d", f.configuration.Name, f.faults.seed)
+	}
+
+	// The forwarder replaced stops recording to the files this one records to
+	f.predecessor.stopRecording(log)
+
+	// A capture error does not prevent forwarding
+	if err := f.startCapture(log); err != nil {
+		log.Error().Err(err).Msgf("Cannot capture connections of forwarder %s", f.configuration.Name)
+	}
+	defer f.stopCapture()
+
+	// Neither does a HAR file error
+	if err := f.http.startHAR(log); err != nil {
+		log.Error().Err(err).Msgf("Cannot record HTTP exchanges of forwarder %s", f.configuration.Name)
+	}
+	defer f.http.stopHAR(log)
+
+	// Local ports stay bound until the forwarder stops; held connections are released with listenCtx
+	listenCtx, cancelListen := context.WithCancel(ctx)
+	var handoverWg sync.WaitGroup
+	defer func() {
+		cancelListen()
+		handoverWg.Wait()
+		f.unbind()
+	}()
+
+	// The forwarder replaced serves the endpoints both share until this one is ready
+	if f.predecessor != nil {
+		f.listenMu.Lock()
+		f.inheriting = f.sharedEndpoints()
+		f.listenMu.Unlock()
+
+		handoverWg.Add(1)
+		go func() {
+			defer handoverWg.Done()
+			f.takeOver(listenCtx, log)
+		}()
+	}
 
 	for {
 		if ctx.Err() != nil {
 			break
 		}
 
-		podName, ports, err := f.locator.Locate(ctx)
-		if err != nil {
+		if err := f.bind(listenCtx, log); err != nil {
 			log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
-			f.delayRetry(ctx)
-			f.attempt++
+			if !f.retry(ctx, log, err) {
+				break
+			}
 			continue
 		}
 
-		// Prepare URL
-		req := f.client.CoreV1().RESTClient().Post().
-			Resource("pods").
-			Namespace(f.configuration.Namespace).
-			Name(podName).
-			SubResource("portforward")
+		// A lazy forwarder opens its tunnel once a connection waits for it
+		if f.lazy && !f.waitDemand(ctx, log) {
+			break
+		}
 
-		// Create the dialer
-		dialer := f.createDialer(req.URL(), log)
+		if f.balanced {
+			err := f.balance(ctx, log)
+			if ctx.Err() != nil {
+				break
+			}
+			if errors.Is(err, errIdle) {
+				continue
+			}
 
-		// Prepare channel for stop/ready
-		stopCh := make(chan struct{})
-		readyCh := make(chan struct{})
-		doneCh := make(chan struct{})
-		errCh := make(chan error)
+			log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
+			if !f.retry(ctx, log, err) {
+				break
+			}
+			continue
+		}
 
-		outWriter := &forwarderWriter{logger: log, level: zerolog.InfoLevel}
-		errWriter := &forwarderWriter{logger: log, level: zerolog.ErrorLevel}
+		f.transition(StateLocating, nil, time.Time{})
 
-		fw, err := portforward.New(dialer, ports, stopCh, readyCh, outWriter, errWriter)
+		target, err := f.locator.Locate(ctx)
 		if err != nil {
 			log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
-			f.delayRetry(ctx)
-			f.attempt++
+			if !f.retry(ctx, log, err) {
+				break
+			}
 			continue
 		}
 
-		// Stop the forwarder when context canceled
-		go func(stop chan struct{}) {
-			select {
-			case <-ctx.Done():
-			case <-doneCh:
-			}
-			close(stop)
-		}(stopCh)
+		f.recordTarget(log, target)
+		f.transition(StateConnecting, nil, time.Time{})
 
-		// Start forwards
-		go func() {
-			errCh <- fw.ForwardPorts()
-			close(doneCh)
-		}()
+		conn, err := f.dial(log, target)
+		if err != nil {
+			withTarget(log.Error().Err(err), target).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
+			if !f.retry(ctx, log, err) {
+				break
+			}
+			continue
+		}
 
-		select {
-		case <-readyCh:
-			log.Info().Msgf("READY - Forwarder %s", f.forwarderInfo())
-			f.attempt = 0
-		case err = <-errCh:
-			log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
-			f.delayRetry(ctx)
-			f.attempt++
+		if f.reverse != nil {
+			err = f.serveReverse(ctx, log, conn, target)
+		} else {
+			err = f.forward(ctx, log, conn, target)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if errors.Is(err, errIdle) {
 			continue
 		}
 
-		err = <-errCh
+		withTarget(log.Error().Err(err), target).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
+		if !f.retry(ctx, log, err) {
+			break
+		}
+	}
 
-		log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
-		f.delayRetry(ctx)
-		f.attempt++
+	if f.Status().State != StateFailed {
+		f.transition(StateStopped, nil, time.Time{})
 	}
 
 	log.Info().Msgf("STOP Forwarder %s", f.forwarderInfo())
 }
 
-// calculateBackoff computes exponential backoff with optional jitter.
+// dial opens a port-forward connection to the target pod.
+func (f *Forwarder) dial(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+	if f.dialPod != nil {
+		return f.dialPod(log, target)
+	}
+
+	// Prepare URL
+	req := f.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(target.Namespace).
+		Name(target.PodName).
+		SubResource("portforward")
+
+	// Upgrade the connection with the configured transport, and remember the one that worked
+	conn, transport, err := f.dialTransport(req.URL(), log)
+	if err != nil {
+		return nil, err
+	}
+	f.rememberTransport(transport)
+	withTarget(log.Info(), target).Str("transport", transport).Msgf("Forwarder %s connected over %s", f.forwarderInfo(), transport)
+
+	return conn, nil
+}
+
+// recordTarget stores the located target and logs when the pod behind it was
+// recreated (same name, new UID) or one of its containers restarted.
+func (f *Forwarder) recordTarget(log *zerolog.Logger, target locator.Target) {
+	f.mu.Lock()
+	previous := f.target
+	f.target = target
+	f.mu.Unlock()
+
+	if previous.PodName != target.PodName || previous.Namespace != target.Namespace {
+		return
+	}
+
+	if previous.PodUID != "" && previous.PodUID != target.PodUID {
+		withTarget(log.Warn(), target).
+			Str("previous_uid", string(previous.PodUID)).
+			Msgf("RECREATED - Forwarder %s: pod %s was recreated", f.forwarderInfo(), target.PodName)
+	} else if target.RestartCount > previous.RestartCount {
+		withTarget(log.Warn(), target).
+			Msgf("RESTARTED - Forwarder %s: container %s restarted", f.forwarderInfo(), target.Container)
+	}
+}
+
+// Target returns the last pod located by the forwarder.
+// It is the zero Target until the first successful Locate.
+func (f *Forwarder) Target() locator.Target {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.target
+}
+
+// withTarget adds the target metadata to a log event.
+func withTarget(e *zerolog.Event, target locator.Target) *zerolog.Event {
+	return e.
+		Str("namespace", target.Namespace).
+		Str("pod", target.PodName).
+		Str("uid", string(target.PodUID)).
+		Str("node", target.NodeName).
+		Str("container", target.Container).
+		Int32("restarts", target.RestartCount).
+		Str("strategy", string(target.Strategy))
+}
+
+// calculateBackoff computes exponential backoff with optional jitter, using the
+// retry policy of the last error type.
 // Formula: initialDelay * (multiplier ^ attempt), capped at maxDelay
 func (f *Forwarder) calculateBackoff() time.Duration {
-	delay := f.retryConfig.InitialDelay * time.Duration(math.Pow(f.retryConfig.Multiplier, float64(f.attempt)))
+	retryConfig := f.policy(f.errorType).Backoff
+
+	delay := retryConfig.InitialDelay * time.Duration(math.Pow(retryConfig.Multiplier, float64(f.attempt)))
 
-	if delay > f.retryConfig.MaxDelay {
-		delay = f.retryConfig.MaxDelay
+	if delay > retryConfig.MaxDelay {
+		delay = retryConfig.MaxDelay
 	}
 
-	if f.retryConfig.Jitter {
+	if retryConfig.Jitter && delay >= 10 {
 		// Add jitter: ±10% randomization
 		jitterAmount := delay / 10
 		jitterRange := rand.Int63n(int64(2 * jitterAmount))
@@ -214,36 +516,89 @@ func (f *Forwarder) calculateBackoff() time.Duration {
 
 // delayRetry pauses before retrying with exponential backoff, respecting context cancellation.
 func (f *Forwarder) delayRetry(ctx context.Context) {
-	delay := f.calculateBackoff()
+	sleep(ctx, f.calculateBackoff())
+}
+
+// sleep waits for the delay or until the context is cancelled.
+func sleep(ctx context.Context, delay time.Duration) {
 	select {
 	case <-time.After(delay):
 	case <-ctx.Done():
 	}
 }
 
-// createDialer creates a port-forward dialer with WebSocket primary and SPDY fallback.
-// This helps resolve connection stability issues on some Kubernetes clusters.
-func (f *Forwarder) createDialer(forwardURL *url.URL, log *zerolog.Logger) httpstream.Dialer {
-	// Create the standard SPDY dialer as fallback
-	spdyDialer := spdy.NewDialer(f.upgrader, &http.Client{Transport: f.transport}, "POST", forwardURL)
-
-	// Try to create the WebSocket tunneling dialer
-	tunnelingDialer, err := portforward.NewSPDYOverWebsocketDialer(forwardURL, f.restConfig)
+// forward attaches local connections to streams of conn until ctx is done (nil error),
+// the connection is lost, or the health check gives up.
+func (f *Forwarder) forward(ctx context.Context, log *zerolog.Logger, conn httpstream.Connection, target locator.Target) error {
+	t, err := newTargetTunnel(conn, log, &f.metrics, target)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to create WebSocket dialer, using SPDY only")
-		return spdyDialer
+		conn.Close()
+		return err
+	}
+	t.capture = f.captureWriter
+	t.shaping = f.shaping.Load
+	t.faults = f.faults
+	t.http = f.http
+
+	f.addTunnel(t)
+	defer func() {
+		// New connections are held again until the next tunnel is ready
+		f.removeTunnel(t)
+		t.close()
+	}()
+
+	withTarget(log.Info(), target).Msgf("READY - Forwarder %s", f.forwarderInfo())
+	f.attempt = 0
+	f.errorType = locator.ErrorTypeUnknown
+	f.transition(StateReady, nil, time.Time{})
+
+	healthErrCh := make(chan error, 1)
+	healthCtx, cancelHealth := context.WithCancel(ctx)
+	defer cancelHealth()
+
+	// Probes go through the tunnel, not the local listener: they are not client connections
+	if f.health != nil {
+		go func() {
+			if err := f.monitorHealth(healthCtx, log, f.health.through(t)); err != nil {
+				healthErrCh <- err
+			}
+		}()
+	}
+
+	// A simulated drop goes through the same retry as a lost connection
+	var drop <-chan time.Time
+	if d := f.faults.dropAfter(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		drop = timer.C
 	}
 
-	log.Debug().Msg("WebSocket port-forward enabled")
+	var idleCheck <-chan time.Time
+	if f.lazy {
+		ticker := time.NewTicker(idleCheckInterval(f.idleTimeout))
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
 
-	// Use WebSocket as primary with SPDY as fallback
-	return portforward.NewFallbackDialer(
-		tunnelingDialer,
-		spdyDialer,
-		func(err error) bool {
-			return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
-		},
-	)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-conn.CloseChan():
+			return errors.New("lost connection to pod")
+		case err := <-healthErrCh:
+			return err
+		case <-drop:
+			withTarget(log.Warn(), target).Msgf("FAULT - Forwarder %s: dropping the tunnel", f.forwarderInfo())
+			conn.Close()
+			return errors.New("lost connection to pod: simulated tunnel drop")
+		case <-idleCheck:
+			if t.closeIdle(f.idleTimeout) {
+				withTarget(log.Info(), target).Msgf("IDLE - Forwarder %s: no connection for %s, closing the tunnel", f.forwarderInfo(), f.idleTimeout)
+				return errIdle
+			}
+		}
+	}
 }
 
 // Config returns the port forward configuration for this forwarder.
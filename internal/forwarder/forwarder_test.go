@@ -1,8 +1,26 @@
 package forwarder
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -12,8 +30,14 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
 
+	"github.com/codozor/fwkeeper/internal/capture"
+	"github.com/codozor/fwkeeper/internal/certs"
 	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/har"
+	"github.com/codozor/fwkeeper/internal/locator"
 )
 
 // Phase 9 Tests - Forwarder Logic (No Kubernetes dependency)
@@ -378,12 +402,12 @@ type MockLocator struct {
 	calls   int
 }
 
-func (m *MockLocator) Locate(ctx context.Context) (string, []string, error) {
+func (m *MockLocator) Locate(ctx context.Context) (locator.Target, error) {
 	m.calls++
 	if m.err != nil {
-		return "", nil, m.err
+		return locator.Target{}, m.err
 	}
-	return m.podName, m.ports, nil
+	return locator.Target{Namespace: "default", PodName: m.podName, Ports: m.ports}, nil
 }
 
 // Helper function to create a context with a logger for tests
@@ -432,7 +456,7 @@ func TestForwarderStartWithValidPod(t *testing.T) {
 	}
 
 	// Verify that Locate was called
-	_, _, err := fwd.locator.Locate(ctx)
+	_, err := fwd.locator.Locate(ctx)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, mockLocator.calls)
 }
@@ -648,3 +672,2519 @@ func TestForwarderConfig(t *testing.T) {
 	assert.Equal(t, cfg.Resource, retrievedCfg.Resource)
 	assert.Equal(t, cfg.Ports, retrievedCfg.Ports)
 }
+
+// TestForwarderRecordTarget tests that the located target is recorded and exposed
+func TestForwarderRecordTarget(t *testing.T) {
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "db", Namespace: "databases", Resource: "sts/postgres"},
+	}
+	assert.Equal(t, locator.Target{}, fwd.Target())
+
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+
+	first := locator.Target{Namespace: "databases", PodName: "postgres-0", PodUID: "uid-1", Ports: []string{"5432"}}
+	fwd.recordTarget(&log, first)
+	assert.Equal(t, first, fwd.Target())
+	assert.Empty(t, buf.String())
+
+	// Same pod name with a new UID: the StatefulSet pod was recreated
+	second := first
+	second.PodUID = "uid-2"
+	fwd.recordTarget(&log, second)
+	assert.Equal(t, second, fwd.Target())
+	assert.Contains(t, buf.String(), "RECREATED")
+	assert.Contains(t, buf.String(), `"previous_uid":"uid-1"`)
+	assert.Contains(t, buf.String(), `"uid":"uid-2"`)
+
+	// Same UID with more restarts: a container restarted in place
+	buf.Reset()
+	third := second
+	third.RestartCount = 1
+	fwd.recordTarget(&log, third)
+	assert.Contains(t, buf.String(), "RESTARTED")
+}
+
+// TestForwarderStopPolicy tests that a stop policy ends the forwarder on the first error
+func TestForwarderStopPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(contextWithLogger(), 2*time.Second)
+	defer cancel()
+
+	mockLocator := &MockLocator{
+		err: locator.NewPermissionDeniedError("list", "pods", nil),
+	}
+
+	policies := DefaultRetryPolicies()
+	policies[locator.ErrorTypePermissionDenied] = RetryPolicy{Action: RetryActionStop}
+
+	fwd := &Forwarder{
+		locator:       mockLocator,
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Resource: "pod", Ports: []string{"8080"}},
+		client:        fake.NewClientset(),
+		retryConfig:   DefaultRetryConfig(),
+		retryPolicies: policies,
+	}
+
+	startTime := time.Now()
+	fwd.Start(ctx)
+
+	assert.Equal(t, 1, mockLocator.calls, "Should not retry after a stop policy")
+	assert.Less(t, time.Since(startTime), time.Second)
+}
+
+// TestForwarderPolicyBackoff tests that the backoff follows the policy of the last error type
+func TestForwarderPolicyBackoff(t *testing.T) {
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd"},
+		retryConfig: RetryConfig{
+			InitialDelay: 10 * time.Millisecond,
+			MaxDelay:     100 * time.Millisecond,
+			Multiplier:   2.0,
+		},
+		retryPolicies: RetryPolicies{
+			locator.ErrorTypeNoPodAvailable: {
+				Action:  RetryActionRetry,
+				Backoff: RetryConfig{InitialDelay: 2 * time.Second, MaxDelay: time.Minute, Multiplier: 2.0},
+			},
+		},
+	}
+
+	// Unknown and transient errors use the base retry config
+	assert.Equal(t, 10*time.Millisecond, fwd.calculateBackoff())
+
+	fwd.errorType = locator.ErrorTypeNoPodAvailable
+	assert.Equal(t, 2*time.Second, fwd.calculateBackoff())
+
+	fwd.errorType = locator.ErrorTypeAPITransient
+	assert.Equal(t, 10*time.Millisecond, fwd.calculateBackoff())
+}
+
+// TestForwarderRetryResetsAttemptOnErrorTypeChange tests the attempt counter restarts per error type
+func TestForwarderRetryResetsAttemptOnErrorTypeChange(t *testing.T) {
+	ctx := contextWithLogger()
+	log := zerolog.Ctx(ctx)
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd"},
+		retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1.0},
+	}
+
+	assert.True(t, fwd.retry(ctx, log, errors.New("boom")))
+	assert.True(t, fwd.retry(ctx, log, errors.New("boom")))
+	assert.Equal(t, uint(2), fwd.attempt)
+
+	assert.True(t, fwd.retry(ctx, log, locator.NewAPITransientError("timeout", nil)))
+	assert.Equal(t, uint(1), fwd.attempt)
+	assert.Equal(t, locator.ErrorTypeAPITransient, fwd.errorType)
+}
+
+// TestDefaultRetryPolicies tests the default policy table
+func TestDefaultRetryPolicies(t *testing.T) {
+	policies := DefaultRetryPolicies()
+
+	// Transient errors keep the fast base backoff
+	_, exists := policies[locator.ErrorTypeAPITransient]
+	assert.False(t, exists)
+
+	assert.Greater(t, policies[locator.ErrorTypeNoPodAvailable].Backoff.InitialDelay, DefaultRetryConfig().InitialDelay)
+	assert.Equal(t, RetryActionRetry, policies[locator.ErrorTypeConfigInvalid].Action)
+	assert.GreaterOrEqual(t, policies[locator.ErrorTypePermissionDenied].Backoff.InitialDelay, time.Minute)
+}
+
+// TestRetryPoliciesFromConfig tests overriding the policy table from configuration
+func TestRetryPoliciesFromConfig(t *testing.T) {
+	policies, err := RetryPoliciesFromConfig(map[string]config.RetryPolicyConfiguration{
+		"permission-denied": {Action: "stop"},
+		"no-pod-available":  {InitialDelay: "5s"},
+		"api-transient":     {MaxDelay: "10s", Multiplier: 3},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, RetryActionStop, policies[locator.ErrorTypePermissionDenied].Action)
+	assert.Equal(t, 5*time.Second, policies[locator.ErrorTypeNoPodAvailable].Backoff.InitialDelay)
+	assert.Equal(t, DefaultRetryPolicies()[locator.ErrorTypeNoPodAvailable].Backoff.MaxDelay, policies[locator.ErrorTypeNoPodAvailable].Backoff.MaxDelay)
+	assert.Equal(t, 10*time.Second, policies[locator.ErrorTypeAPITransient].Backoff.MaxDelay)
+	assert.Equal(t, 3.0, policies[locator.ErrorTypeAPITransient].Backoff.Multiplier)
+	assert.Equal(t, DefaultRetryConfig().InitialDelay, policies[locator.ErrorTypeAPITransient].Backoff.InitialDelay)
+
+	_, err = RetryPoliciesFromConfig(map[string]config.RetryPolicyConfiguration{"bogus": {}})
+	assert.Error(t, err)
+
+	_, err = RetryPoliciesFromConfig(map[string]config.RetryPolicyConfiguration{"pod-failed": {InitialDelay: "soon"}})
+	assert.Error(t, err)
+}
+
+// recordTransitions returns a transition hook collecting the states, and a function returning them
+func recordTransitions() (func(Status), func() []State) {
+	var mu sync.Mutex
+	var states []State
+
+	hook := func(s Status) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, s.State)
+	}
+	get := func() []State {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(states)
+	}
+	return hook, get
+}
+
+// TestForwarderStatusInitial tests the status of a forwarder that was never started
+func TestForwarderStatusInitial(t *testing.T) {
+	fwd := &Forwarder{configuration: config.PortForwardConfiguration{Name: "test-fwd"}}
+
+	status := fwd.Status()
+
+	assert.Equal(t, "test-fwd", status.Name)
+	assert.Equal(t, StateStopped, status.State)
+	assert.NoError(t, status.LastError)
+}
+
+// TestForwarderTransitionsOnLocateError tests the Locating -> Backoff cycle and the final Stopped state
+func TestForwarderTransitionsOnLocateError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(contextWithLogger(), 100*time.Millisecond)
+	defer cancel()
+
+	hook, transitions := recordTransitions()
+	locateErr := errors.New("pod not found")
+
+	fwd := &Forwarder{
+		locator:       &MockLocator{err: locateErr},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Resource: "pod", Ports: []string{"8080"}},
+		client:        fake.NewClientset(),
+		retryConfig:   RetryConfig{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1.0},
+		onTransition:  hook,
+	}
+
+	fwd.Start(ctx)
+
+	states := transitions()
+	require.GreaterOrEqual(t, len(states), 5)
+	assert.Equal(t, []State{StateLocating, StateBackoff, StateLocating, StateBackoff}, states[:4])
+	assert.Equal(t, StateStopped, states[len(states)-1])
+
+	status := fwd.Status()
+	assert.Equal(t, StateStopped, status.State)
+	assert.Equal(t, locateErr, status.LastError)
+	assert.Greater(t, status.Attempt, uint(1))
+	assert.True(t, status.NextRetry.IsZero())
+}
+
+// TestForwarderBackoffStatus tests that the backoff state records the error, attempt and next retry
+func TestForwarderBackoffStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+
+	statusCh := make(chan Status, 1)
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd"},
+		retryConfig:   RetryConfig{InitialDelay: time.Hour, MaxDelay: time.Hour, Multiplier: 1.0},
+		onTransition:  func(s Status) { statusCh <- s },
+	}
+
+	locateErr := locator.NewAPITransientError("timeout", nil)
+	go fwd.retry(ctx, zerolog.Ctx(ctx), locateErr)
+
+	status := <-statusCh
+
+	assert.Equal(t, StateBackoff, status.State)
+	assert.Equal(t, locateErr, status.LastError)
+	assert.Equal(t, uint(1), status.Attempt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.NextRetry, time.Minute)
+	assert.Equal(t, status.State, fwd.Status().State)
+}
+
+// TestForwarderFailedState tests that a stop policy leaves the forwarder in the Failed state
+func TestForwarderFailedState(t *testing.T) {
+	hook, transitions := recordTransitions()
+	locateErr := locator.NewConfigInvalidError("bad selector", nil)
+
+	fwd := &Forwarder{
+		locator:       &MockLocator{err: locateErr},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Resource: "pod", Ports: []string{"8080"}},
+		client:        fake.NewClientset(),
+		retryConfig:   DefaultRetryConfig(),
+		retryPolicies: RetryPolicies{locator.ErrorTypeConfigInvalid: {Action: RetryActionStop}},
+		onTransition:  hook,
+	}
+
+	fwd.Start(contextWithLogger())
+
+	assert.Equal(t, []State{StateLocating, StateFailed}, transitions())
+
+	status := fwd.Status()
+	assert.Equal(t, StateFailed, status.State)
+	assert.Equal(t, locateErr, status.LastError)
+}
+
+// newTestHealthCheck creates a health check probing the pod port of e with short timings
+func newTestHealthCheck(kind string, e endpoint) *healthCheck {
+	return &healthCheck{kind: kind, endpoint: e, path: "/", interval: 10 * time.Millisecond, timeout: 100 * time.Millisecond, threshold: 2}
+}
+
+// newTestProbeTunnel creates a tunnel forwarding local port 18080 to pod port 8080 over conn
+func newTestProbeTunnel(t *testing.T, conn *fakeConnection) *tunnel {
+	log := zerolog.Nop()
+	tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	t.Cleanup(tun.close)
+	return tun
+}
+
+// startTestListener accepts connections and passes them to handle
+func startTestListener(t *testing.T, handle func(net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// serveStatus answers the HTTP requests of data streams with status, on path only
+func serveStatus(status *atomic.Int32, path string) func(pod *fakeStream) {
+	return func(pod *fakeStream) {
+		go func() {
+			defer pod.Close()
+
+			req, err := http.ReadRequest(bufio.NewReader(pod))
+			if err != nil {
+				return
+			}
+			code := int(status.Load())
+			if req.URL.Path != path {
+				code = http.StatusNotFound
+			}
+			resp := &http.Response{StatusCode: code, ProtoMajor: 1, ProtoMinor: 1, Body: http.NoBody}
+			_ = resp.Write(pod)
+		}()
+	}
+}
+
+// TestNewHealthCheck tests health check defaults and port selection
+func TestNewHealthCheck(t *testing.T) {
+	h, err := newHealthCheck(&config.HealthCheckConfiguration{}, []string{"15432:5432", "8080"})
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", h.kind)
+	assert.Equal(t, endpoint{port: 15432}, h.endpoint)
+	assert.Equal(t, 10*time.Second, h.interval)
+	assert.Equal(t, 2*time.Second, h.timeout)
+	assert.Equal(t, 1, h.threshold)
+
+	h, err = newHealthCheck(&config.HealthCheckConfiguration{Type: "http", Port: 8080, Interval: "5s", FailureThreshold: 3}, []string{"15432:5432", "8080"})
+	require.NoError(t, err)
+	assert.Equal(t, endpoint{port: 8080}, h.endpoint)
+	assert.Equal(t, "/", h.path)
+	assert.Equal(t, 5*time.Second, h.interval)
+	assert.Equal(t, 3, h.threshold)
+
+	h, err = newHealthCheck(&config.HealthCheckConfiguration{}, []string{"unix:/tmp/db.sock:5432"})
+	require.NoError(t, err)
+	assert.Equal(t, endpoint{socket: "/tmp/db.sock"}, h.endpoint)
+
+	_, err = newHealthCheck(&config.HealthCheckConfiguration{Type: "grpc"}, []string{"8080"})
+	assert.Error(t, err)
+}
+
+// TestHealthCheckTCPProbe tests TCP probes through open, refusing and closed tunnels
+func TestHealthCheckTCPProbe(t *testing.T) {
+	h := newTestHealthCheck("tcp", endpoint{port: 18080})
+
+	// The stream stays open when the pod accepts the connection
+	open := newTestProbeTunnel(t, newFakeConnection())
+	assert.NoError(t, h.through(open).probe(context.Background()))
+
+	// The error stream reports a refused connection
+	conn := newFakeConnection()
+	conn.streamError = "connection refused"
+	refusing := newTestProbeTunnel(t, conn)
+	assert.ErrorContains(t, h.through(refusing).probe(context.Background()), "connection refused")
+
+	closed := newTestProbeTunnel(t, newFakeConnection())
+	closed.close()
+	assert.Error(t, h.through(closed).probe(context.Background()))
+
+	// Only forwarded endpoints can be probed
+	assert.Error(t, newTestHealthCheck("tcp", endpoint{port: 9999}).through(open).probe(context.Background()))
+}
+
+// TestHealthCheckHTTPProbe tests HTTP probes on healthy and failing servers
+func TestHealthCheckHTTPProbe(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	conn := newFakeConnection()
+	conn.onStream = serveStatus(&status, "/healthz")
+
+	h := newTestHealthCheck("http", endpoint{port: 18080})
+	h.path = "/healthz"
+	probe := h.through(newTestProbeTunnel(t, conn))
+
+	assert.NoError(t, probe.probe(context.Background()))
+
+	status.Store(http.StatusServiceUnavailable)
+	assert.Error(t, probe.probe(context.Background()))
+}
+
+// TestForwarderMonitorHealth tests that probe streams refused by the pod count towards the
+// failure threshold without closing the connection to the pod
+func TestForwarderMonitorHealth(t *testing.T) {
+	conn := newFakeConnection()
+	conn.streamError = "connection refused"
+	tun := newTestProbeTunnel(t, conn)
+
+	h := newTestHealthCheck("tcp", endpoint{port: 18080})
+	h.threshold = 3
+
+	fwd := &Forwarder{configuration: config.PortForwardConfiguration{Name: "test-fwd"}}
+
+	ctx, cancel := context.WithTimeout(contextWithLogger(), 2*time.Second)
+	defer cancel()
+
+	err := fwd.monitorHealth(ctx, zerolog.Ctx(ctx), h.through(tun))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 consecutive times")
+	assert.Contains(t, err.Error(), "connection refused")
+
+	select {
+	case <-conn.CloseChan():
+		t.Fatal("a refused probe should not close the connection to the pod")
+	default:
+	}
+
+	status := fwd.Status()
+	assert.Equal(t, 3, status.ProbeFailures)
+	assert.ErrorContains(t, status.LastProbeError, "connection refused")
+	assert.False(t, status.LastProbe.IsZero())
+}
+
+// TestForwarderMonitorHealthCancelled tests that a healthy tunnel is probed until cancellation
+func TestForwarderMonitorHealthCancelled(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	conn := newFakeConnection()
+	conn.onStream = serveStatus(&status, "/")
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd"},
+		health:        newTestHealthCheck("http", endpoint{port: 18080}),
+	}
+
+	ctx, cancel := context.WithTimeout(contextWithLogger(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, fwd.monitorHealth(ctx, zerolog.Ctx(ctx), fwd.health.through(newTestProbeTunnel(t, conn))))
+	assert.Equal(t, 0, fwd.Status().ProbeFailures)
+	assert.False(t, fwd.Status().LastProbe.IsZero())
+}
+
+// fakeStream is one side of an in-memory httpstream.Stream; Close only ends the write side
+type fakeStream struct {
+	r       *io.PipeReader
+	w       *io.PipeWriter
+	headers http.Header
+}
+
+func (s *fakeStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *fakeStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s *fakeStream) Close() error                { return s.w.Close() }
+func (s *fakeStream) Headers() http.Header        { return s.headers }
+func (s *fakeStream) Identifier() uint32          { return 0 }
+func (s *fakeStream) Reset() error {
+	s.r.Close()
+	return s.w.Close()
+}
+
+// newFakeStreamPair creates the client and pod sides of a stream
+func newFakeStreamPair(headers http.Header) (*fakeStream, *fakeStream) {
+	clientR, podW := io.Pipe()
+	podR, clientW := io.Pipe()
+	return &fakeStream{r: clientR, w: clientW, headers: headers}, &fakeStream{r: podR, w: podW, headers: headers}
+}
+
+// fakeConnection is an in-memory httpstream.Connection to a pod echoing data streams.
+// When streamError is set, it is reported on the error stream of every connection, and
+// when onStream is set, the pod side of data streams is passed to it instead of echoing.
+// Closing it resets all its streams, as closing a real connection does.
+type fakeConnection struct {
+	streamError string
+	onStream    func(pod *fakeStream)
+
+	mu      sync.Mutex
+	headers []http.Header
+	streams []*fakeStream
+	closeCh chan bool
+	once    sync.Once
+}
+
+func newFakeConnection() *fakeConnection {
+	return &fakeConnection{closeCh: make(chan bool)}
+}
+
+func (c *fakeConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	client, pod := newFakeStreamPair(headers.Clone())
+
+	c.mu.Lock()
+	c.headers = append(c.headers, headers.Clone())
+	c.streams = append(c.streams, client, pod)
+	c.mu.Unlock()
+
+	if headers.Get(corev1.StreamType) == corev1.StreamTypeError {
+		go func() {
+			if c.streamError != "" {
+				_, _ = pod.Write([]byte(c.streamError))
+			}
+			pod.Close()
+		}()
+		return client, nil
+	}
+
+	if c.onStream != nil {
+		c.onStream(pod)
+		return client, nil
+	}
+
+	go func() {
+		if c.streamError == "" {
+			_, _ = io.Copy(pod, pod)
+		}
+		pod.Close()
+	}()
+	return client, nil
+}
+
+func (c *fakeConnection) Close() error {
+	c.once.Do(func() {
+		close(c.closeCh)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, s := range c.streams {
+			_ = s.Reset()
+		}
+	})
+	return nil
+}
+
+func (c *fakeConnection) CloseChan() <-chan bool                     { return c.closeCh }
+func (c *fakeConnection) SetIdleTimeout(timeout time.Duration)       {}
+func (c *fakeConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+func (c *fakeConnection) streamHeaders() []http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.headers)
+}
+
+// freePort returns a local port that is currently free
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// echo sends msg over a new connection to addr and returns the reply
+func echo(t *testing.T, addr string, msg string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte(msg))
+	require.NoError(t, err)
+
+	reply := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	return string(reply)
+}
+
+// TestParsePorts tests parsing of port mappings
+func TestParsePorts(t *testing.T) {
+	mappings, err := parsePorts([]string{"8080", "15432:5432"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []portMapping{{local: 8080, remote: 8080}, {local: 15432, remote: 5432}}, mappings)
+
+	mappings, err = parsePorts([]string{"unix:/tmp/pg.sock:5432"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []portMapping{{socket: "/tmp/pg.sock", remote: 5432}}, mappings)
+	assert.Equal(t, "unix:/tmp/pg.sock", mappings[0].endpoint().String())
+
+	_, err = parsePorts([]string{"unix:/tmp/pg.sock"})
+	assert.Error(t, err)
+
+	_, err = parsePorts([]string{"80:abc"})
+	assert.Error(t, err)
+
+	_, err = parsePorts([]string{"70000"})
+	assert.Error(t, err)
+}
+
+// serveTunnel dispatches the connections accepted on a new local listener to the tunnel,
+// as the forwarder does for localPort, and returns the listener address
+func serveTunnel(t *testing.T, tun *tunnel, localPort int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			local, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if !tun.dispatch(local, endpoint{port: localPort}) {
+				local.Close()
+			}
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// TestTunnelForwardsConnection tests that local connections are piped into port-forward streams and counted
+func TestTunnelForwardsConnection(t *testing.T) {
+	conn := newFakeConnection()
+	log := zerolog.Nop()
+	var m metrics
+
+	tun := newTunnel(conn, &log, &m, []portMapping{{local: 18080, remote: 8080}})
+	addr := serveTunnel(t, tun, 18080)
+
+	assert.Equal(t, "hello", echo(t, addr, "hello"))
+	assert.Equal(t, "world!", echo(t, addr, "world!"))
+
+	tun.close()
+
+	headers := conn.streamHeaders()
+	require.Len(t, headers, 4)
+	assert.Equal(t, corev1.StreamTypeError, headers[0].Get(corev1.StreamType))
+	assert.Equal(t, corev1.StreamTypeData, headers[1].Get(corev1.StreamType))
+	assert.Equal(t, "8080", headers[1].Get(corev1.PortHeader))
+	assert.Equal(t, headers[0].Get(corev1.PortForwardRequestIDHeader), headers[1].Get(corev1.PortForwardRequestIDHeader))
+	assert.NotEqual(t, headers[1].Get(corev1.PortForwardRequestIDHeader), headers[3].Get(corev1.PortForwardRequestIDHeader))
+
+	snapshot := m.snapshot()
+	assert.Equal(t, []PortMetrics{{Port: 18080, ActiveConnections: 0, TotalConnections: 2, BytesIn: 11, BytesOut: 11}}, snapshot.Ports)
+	assert.Equal(t, uint64(2), snapshot.TotalConnections)
+	assert.Equal(t, uint64(11), snapshot.BytesIn)
+
+	// A closed tunnel, or a port it does not forward, does not take connections
+	local, _ := net.Pipe()
+	defer local.Close()
+	assert.False(t, tun.dispatch(local, endpoint{port: 18080}))
+	assert.False(t, newTunnel(conn, &log, &m, nil).dispatch(local, endpoint{port: 18080}))
+}
+
+// TestTunnelStreamErrorClosesConnection tests that an error reported by the pod closes the connection
+func TestTunnelStreamErrorClosesConnection(t *testing.T) {
+	conn := newFakeConnection()
+	conn.streamError = "connection refused"
+	log := zerolog.Nop()
+
+	tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	addr := serveTunnel(t, tun, 18080)
+
+	local, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer local.Close()
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection should be closed after a stream error")
+	}
+}
+
+// TestForwarderForward tests the ready state, per-port metrics and teardown on connection loss
+func TestForwarderForward(t *testing.T) {
+	port := freePort(t)
+	conn := newFakeConnection()
+	hook, transitions := recordTransitions()
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		onTransition:  hook,
+		holdTimeout:   time.Second,
+	}
+
+	ctx := contextWithLogger()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	defer fwd.unbind()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{PodName: "test-pod", Ports: fwd.configuration.Ports})
+	}()
+
+	require.Eventually(t, func() bool { return fwd.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "ping", echo(t, fmt.Sprintf("127.0.0.1:%d", port), "ping"))
+
+	conn.Close()
+
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("forward should return when the connection is lost")
+	}
+
+	assert.Equal(t, []State{StateReady}, transitions())
+
+	metrics := fwd.Metrics()
+	assert.Equal(t, "test-fwd", metrics.Name)
+	require.Len(t, metrics.Ports, 1)
+	assert.Equal(t, port, metrics.Ports[0].Port)
+	assert.Equal(t, uint64(1), metrics.TotalConnections)
+	assert.Equal(t, uint64(4), metrics.BytesOut)
+}
+
+// TestForwarderProbesTunnel tests that health probes open streams on the tunnel without
+// going through the local port, so that they are not counted as client connections
+func TestForwarderProbesTunnel(t *testing.T) {
+	port := freePort(t)
+	conn := newFakeConnection()
+
+	health := newTestHealthCheck("tcp", endpoint{port: port})
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		health:        health,
+		holdTimeout:   time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	defer fwd.unbind()
+	go fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{PodName: "test-pod", Ports: fwd.configuration.Ports})
+
+	require.Eventually(t, func() bool {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.streams) >= 6
+	}, 2*time.Second, 10*time.Millisecond, "probes open data streams on the tunnel")
+
+	status := fwd.Status()
+	assert.False(t, status.LastProbe.IsZero())
+	assert.Zero(t, status.ProbeFailures)
+	assert.Zero(t, fwd.Metrics().TotalConnections, "probes are not client connections")
+}
+
+// TestForwarderHoldsConnectionsUntilReady tests that connections made while reconnecting
+// are attached once a tunnel is ready
+func TestForwarderHoldsConnectionsUntilReady(t *testing.T) {
+	port := freePort(t)
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		holdTimeout:   5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	defer fwd.unbind()
+
+	// The port is bound before any tunnel exists
+	local, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer local.Close()
+
+	_, err = local.Write([]byte("held"))
+	require.NoError(t, err)
+
+	replyCh := make(chan string, 1)
+	go func() {
+		reply := make([]byte, 4)
+		_, _ = io.ReadFull(local, reply)
+		replyCh <- string(reply)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		_ = fwd.forward(ctx, zerolog.Ctx(ctx), newFakeConnection(), locator.Target{Ports: fwd.configuration.Ports})
+	}()
+
+	select {
+	case reply := <-replyCh:
+		assert.Equal(t, "held", reply)
+	case <-time.After(3 * time.Second):
+		t.Fatal("held connection should be attached once ready")
+	}
+}
+
+// TestForwarderHoldTimeout tests that held connections are closed when the hold timeout expires
+func TestForwarderHoldTimeout(t *testing.T) {
+	port := freePort(t)
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		holdTimeout:   50 * time.Millisecond,
+	}
+
+	ctx := contextWithLogger()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	defer fwd.unbind()
+
+	local, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer local.Close()
+
+	require.NoError(t, local.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = local.Read(make([]byte, 1))
+
+	assert.ErrorIs(t, err, io.EOF, "connection should be closed cleanly after the hold timeout")
+}
+
+// TestForwarderKeepsListenersAcrossReconnects tests that the local port stays bound between tunnels
+func TestForwarderKeepsListenersAcrossReconnects(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		holdTimeout:   time.Second,
+	}
+
+	ctx := contextWithLogger()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+
+	for i := 0; i < 2; i++ {
+		conn := newFakeConnection()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{Ports: fwd.configuration.Ports})
+		}()
+
+		assert.Equal(t, "ping", echo(t, addr, "ping"))
+
+		conn.Close()
+		<-done
+
+		// Still bound while no tunnel is ready
+		_, err := net.Listen("tcp", addr)
+		assert.Error(t, err)
+	}
+
+	// Binding again is a no-op
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+
+	fwd.unbind()
+
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	ln.Close()
+}
+
+// failingListener is a listener whose next Accept fails with err
+type failingListener struct {
+	net.Listener
+	err atomic.Pointer[error]
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if err := l.err.Swap(nil); err != nil {
+		return nil, *err
+	}
+	return l.Listener.Accept()
+}
+
+// TestForwarderServeAcceptError tests that a transient accept error is retried, and that
+// another error closes the endpoint so that it is bound again
+func TestForwarderServeAcceptError(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	conn := newFakeConnection()
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Address: "127.0.0.1", Ports: []string{fmt.Sprintf("%d:80", port)}},
+		holdTimeout:   time.Second,
+	}
+	e := endpoint{port: port}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	defer fwd.unbind()
+
+	inner, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	ln := &failingListener{Listener: inner}
+	transient := error(&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)})
+	ln.err.Store(&transient)
+
+	fwd.listenMu.Lock()
+	fwd.listeners = make(map[endpoint]*boundEndpoint)
+	fwd.serveLocked(ctx, zerolog.Ctx(ctx), e, []net.Listener{ln})
+	fwd.listenMu.Unlock()
+
+	go fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{Ports: fwd.configuration.Ports})
+	require.Eventually(t, func() bool { return fwd.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "ping", echo(t, addr, "ping"), "still accepting after a transient error")
+	assert.Contains(t, fwd.boundEndpoints(), e)
+
+	permanent := errors.New("accept failed")
+	ln.err.Store(&permanent)
+	assert.Equal(t, "pong", echo(t, addr, "pong"), "the failure is reported on the next accept")
+
+	require.Eventually(t, func() bool { return !slices.Contains(fwd.boundEndpoints(), e) }, 2*time.Second, 10*time.Millisecond)
+
+	// The endpoint is closed, and bound again
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	assert.Contains(t, fwd.boundEndpoints(), e)
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+}
+
+// TestListenSocket tests socket permissions, stale socket cleanup and removal on close
+func TestListenSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fw.sock")
+
+	ln, err := listenSocket(path, 0o660)
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode().Type())
+	assert.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+
+	// A socket in use is not taken over
+	_, err = listenSocket(path, DefaultSocketMode)
+	assert.ErrorContains(t, err, "in use")
+
+	ln.Close()
+	_, err = os.Lstat(path)
+	assert.True(t, os.IsNotExist(err), "socket should be removed on close")
+
+	// A socket left behind by a crashed process is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err = listenSocket(path, DefaultSocketMode)
+	require.NoError(t, err)
+	ln.Close()
+
+	// Other files are never removed
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+	_, err = listenSocket(path, DefaultSocketMode)
+	assert.ErrorContains(t, err, "not a socket")
+}
+
+// TestForwarderForwardsSocket tests forwarding from a Unix socket and its removal on unbind
+func TestForwarderForwardsSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg.sock")
+	conn := newFakeConnection()
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{"unix:" + path + ":5432"}},
+		holdTimeout:   time.Second,
+	}
+
+	ctx := contextWithLogger()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+
+	go func() {
+		_ = fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{Ports: fwd.configuration.Ports})
+	}()
+
+	local, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_, err = local.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(local, reply)
+	require.NoError(t, err)
+	local.Close()
+
+	assert.Equal(t, "ping", string(reply))
+	assert.Equal(t, "5432", conn.streamHeaders()[1].Get(corev1.PortHeader))
+
+	metrics := fwd.Metrics()
+	require.Len(t, metrics.Ports, 1)
+	assert.Equal(t, path, metrics.Ports[0].Socket)
+
+	conn.Close()
+	fwd.unbind()
+
+	_, err = os.Lstat(path)
+	assert.True(t, os.IsNotExist(err), "socket should be removed on unbind")
+}
+
+// fakeMultiLocator returns a mutable list of running pods
+type fakeMultiLocator struct {
+	mu      sync.Mutex
+	targets []locator.Target
+}
+
+func (l *fakeMultiLocator) Locate(ctx context.Context) (locator.Target, error) {
+	targets, err := l.LocateAll(ctx, 1)
+	if err != nil {
+		return locator.Target{}, err
+	}
+	return targets[0], nil
+}
+
+func (l *fakeMultiLocator) LocateAll(ctx context.Context, max int) ([]locator.Target, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.targets) == 0 {
+		return nil, &locator.LocateError{Type: locator.ErrorTypeNoPodAvailable, Message: "no running pod"}
+	}
+	if max > 0 && len(l.targets) > max {
+		return slices.Clone(l.targets[:max]), nil
+	}
+	return slices.Clone(l.targets), nil
+}
+
+func (l *fakeMultiLocator) setTargets(targets ...locator.Target) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.targets = targets
+}
+
+// TestPickTunnelRoundRobin tests that connections rotate over the tunnels
+func TestPickTunnelRoundRobin(t *testing.T) {
+	a, b, c := &tunnel{}, &tunnel{}, &tunnel{}
+	fwd := &Forwarder{tunnels: []*tunnel{a, b, c}}
+
+	var picked []*tunnel
+	for i := 0; i < 4; i++ {
+		picked = append(picked, fwd.pickTunnelLocked(nil))
+	}
+	assert.Equal(t, []*tunnel{a, b, c, a}, picked)
+
+	// The previous tunnel of a connection is skipped
+	assert.Equal(t, c, fwd.pickTunnelLocked(b))
+
+	assert.Nil(t, (&Forwarder{tunnels: []*tunnel{a}}).pickTunnelLocked(a))
+	assert.Nil(t, (&Forwarder{}).pickTunnelLocked(nil))
+}
+
+// TestPickTunnelLeastConnections tests that connections go to the least busy tunnel
+func TestPickTunnelLeastConnections(t *testing.T) {
+	a, b, c := &tunnel{}, &tunnel{}, &tunnel{}
+	a.active.Store(2)
+	b.active.Store(1)
+	c.active.Store(1)
+	fwd := &Forwarder{tunnels: []*tunnel{a, b, c}, leastConnections: true}
+
+	assert.Equal(t, b, fwd.pickTunnelLocked(nil))
+	// Ties rotate
+	assert.Equal(t, c, fwd.pickTunnelLocked(nil))
+
+	c.active.Store(5)
+	assert.Equal(t, b, fwd.pickTunnelLocked(nil))
+	assert.Equal(t, a, fwd.pickTunnelLocked(b))
+}
+
+// TestNewBalancedForwarder tests the balance settings and their validation
+func TestNewBalancedForwarder(t *testing.T) {
+	restCfg := &rest.Config{Host: "https://localhost:6443"}
+
+	fwd, err := New(&fakeMultiLocator{}, config.PortForwardConfiguration{Name: "api", Replicas: 3, LoadBalancing: "least-connections"}, nil, restCfg)
+	require.NoError(t, err)
+	assert.True(t, fwd.balanced)
+	assert.Equal(t, 3, fwd.replicas)
+	assert.True(t, fwd.leastConnections)
+
+	fwd, err = New(&fakeMultiLocator{}, config.PortForwardConfiguration{Name: "api", Mode: "single", Replicas: 1}, nil, restCfg)
+	require.NoError(t, err)
+	assert.False(t, fwd.balanced)
+
+	_, err = New(&MockLocator{}, config.PortForwardConfiguration{Name: "api", Mode: "balance"}, nil, restCfg)
+	assert.Error(t, err)
+}
+
+// TestForwarderBalance tests that connections are spread across pods, and that a pod
+// leaving the rotation does not disrupt the connections to the others
+func TestForwarderBalance(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	podA := locator.Target{Namespace: "default", PodName: "api-a", PodUID: "uid-a", Ports: ports}
+	podB := locator.Target{Namespace: "default", PodName: "api-b", PodUID: "uid-b", Ports: ports}
+	loc := &fakeMultiLocator{}
+	loc.setTargets(podA, podB)
+
+	var mu sync.Mutex
+	conns := make(map[string]*fakeConnection)
+
+	fwd := &Forwarder{
+		locator:       loc,
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+		holdTimeout:   time.Second,
+		balanced:      true,
+		dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			conn := newFakeConnection()
+			conns[target.PodName] = conn
+			return conn, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fwd.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(fwd.Status().Targets) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, StateReady, fwd.Status().State)
+
+	// A long-lived connection on each pod
+	onA, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer onA.Close()
+	_, err = onA.Write([]byte("a"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(onA, make([]byte, 1))
+	require.NoError(t, err)
+
+	onB, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer onB.Close()
+	_, err = onB.Write([]byte("b"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(onB, make([]byte, 1))
+	require.NoError(t, err)
+
+	mu.Lock()
+	connA, connB := conns["api-a"], conns["api-b"]
+	mu.Unlock()
+	assert.Len(t, connA.streamHeaders(), 2)
+	assert.Len(t, connB.streamHeaders(), 2)
+
+	// Pod a goes away
+	loc.setTargets(podB)
+	connA.Close()
+
+	require.Eventually(t, func() bool {
+		return len(fwd.Status().Targets) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "api-b", fwd.Status().Targets[0].PodName)
+	assert.Equal(t, StateReady, fwd.Status().State)
+
+	// The connection to pod b is untouched, new connections go to pod b
+	_, err = onB.Write([]byte("still"))
+	require.NoError(t, err)
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(onB, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "still", string(reply))
+
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+	assert.Len(t, connB.streamHeaders(), 4)
+
+	cancel()
+	<-done
+	assert.Empty(t, fwd.Status().Targets)
+}
+
+// TestForwarderBalanceFaultDrop tests that a pod whose tunnel is dropped by a fault is not
+// dialled again before the retry backoff
+func TestForwarderBalanceFaultDrop(t *testing.T) {
+	port := freePort(t)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	loc := &fakeMultiLocator{}
+	loc.setTargets(locator.Target{Namespace: "default", PodName: "api-a", PodUID: "uid-a", Ports: ports})
+
+	var mu sync.Mutex
+	var dials []time.Time
+	var states []Status
+	fwd := &Forwarder{
+		locator:       loc,
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		retryConfig:   RetryConfig{InitialDelay: 300 * time.Millisecond, MaxDelay: 300 * time.Millisecond, Multiplier: 1},
+		holdTimeout:   time.Second,
+		balanced:      true,
+		faults:        &faults{seed: 1, drops: faultRand(1, 0), dropMin: 50 * time.Millisecond, dropMax: 50 * time.Millisecond},
+		dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dials = append(dials, time.Now())
+			return newFakeConnection(), nil
+		},
+		onTransition: func(s Status) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, s)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fwd.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dials) >= 2
+	}, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, dials[1].Sub(dials[0]), 300*time.Millisecond, "the pod is dialled again after the backoff")
+
+	var backoff *Status
+	for i := range states {
+		if states[i].State == StateBackoff {
+			backoff = &states[i]
+			break
+		}
+	}
+	require.NotNil(t, backoff, "the drop goes through the backoff")
+	assert.ErrorContains(t, backoff.LastError, "simulated tunnel drop")
+}
+
+// TestForwarderServeReverse tests that connections handed over by the relay pod are piped
+// to the local address, and that idle connections are replaced once used
+func TestForwarderServeReverse(t *testing.T) {
+	addr := startTestListener(t, func(c net.Conn) {
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	})
+
+	streams := make(chan *fakeStream, 10)
+	conn := newFakeConnection()
+	conn.onStream = func(pod *fakeStream) { streams <- pod }
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-rev", Kind: "reverse", Namespace: "dev"},
+		reverse:       &config.ReverseConfiguration{Address: addr, Port: 8080, Connections: 2},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fwd.serveReverse(ctx, zerolog.Ctx(ctx), conn, locator.Target{Ports: []string{"8080:17170"}})
+	}()
+
+	nextStream := func() *fakeStream {
+		select {
+		case s := <-streams:
+			return s
+		case <-time.After(2 * time.Second):
+			t.Fatal("an idle connection should be opened to the relay pod")
+			return nil
+		}
+	}
+
+	// Two idle connections to the tunnel port
+	pod := nextStream()
+	nextStream()
+	assert.Equal(t, StateReady, fwd.Status().State)
+	assert.Equal(t, "17170", pod.Headers().Get(corev1.PortHeader))
+
+	// An in-cluster client is paired with the first one
+	_, err := pod.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(pod, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+
+	// and the idle connection is replaced
+	nextStream()
+
+	pod.Close()
+	require.Eventually(t, func() bool { return fwd.Metrics().ActiveConnections == 0 }, 2*time.Second, 10*time.Millisecond)
+
+	metrics := fwd.Metrics()
+	require.Len(t, metrics.Ports, 1)
+	assert.Equal(t, 8080, metrics.Ports[0].Port)
+	assert.Equal(t, uint64(1), metrics.TotalConnections)
+	assert.Equal(t, uint64(4), metrics.BytesIn)
+	assert.Equal(t, uint64(4), metrics.BytesOut)
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("serveReverse should return when the context is cancelled")
+	}
+}
+
+// TestForwarderServeReverseServerFirst tests that a local server speaking first greets the
+// in-cluster client before it sends anything
+func TestForwarderServeReverseServerFirst(t *testing.T) {
+	addr := startTestListener(t, func(c net.Conn) {
+		defer c.Close()
+		_, _ = c.Write([]byte("220 ready\n"))
+		_, _ = io.Copy(c, c)
+	})
+
+	streams := make(chan *fakeStream, 10)
+	conn := newFakeConnection()
+	conn.onStream = func(pod *fakeStream) { streams <- pod }
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-rev", Kind: "reverse", Namespace: "dev"},
+		reverse:       &config.ReverseConfiguration{Address: addr, Port: 25, Connections: 1},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	go fwd.serveReverse(ctx, zerolog.Ctx(ctx), conn, locator.Target{Ports: []string{"25:17170"}})
+
+	var pod *fakeStream
+	select {
+	case pod = <-streams:
+	case <-time.After(2 * time.Second):
+		t.Fatal("an idle connection should be opened to the relay pod")
+	}
+
+	greeting := make([]byte, 10)
+	_, err := io.ReadFull(pod, greeting)
+	require.NoError(t, err)
+	assert.Equal(t, "220 ready\n", string(greeting))
+	assert.Zero(t, fwd.Metrics().TotalConnections, "the connection is counted once the client sends data")
+
+	_, err = pod.Write([]byte("EHLO"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(pod, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "EHLO", string(reply))
+	assert.Equal(t, uint64(1), fwd.Metrics().TotalConnections)
+}
+
+// TestForwarderServeReverseLocalDown tests that a local address refusing connections is
+// reported once, retried with a backoff, and used again once it is back
+func TestForwarderServeReverseLocalDown(t *testing.T) {
+	initial, maximum := reverseRetryDelay, reverseMaxRetryDelay
+	reverseRetryDelay, reverseMaxRetryDelay = 5*time.Millisecond, 80*time.Millisecond
+	t.Cleanup(func() { reverseRetryDelay, reverseMaxRetryDelay = initial, maximum })
+
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	streams := make(chan *fakeStream, 100)
+	conn := newFakeConnection()
+	conn.onStream = func(pod *fakeStream) { streams <- pod }
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-rev", Kind: "reverse", Namespace: "dev"},
+		reverse:       &config.ReverseConfiguration{Address: addr, Port: 8080, Connections: 2},
+	}
+
+	var logs syncBuffer
+	log := zerolog.New(&logs)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		_ = fwd.serveReverse(ctx, &log, conn, locator.Target{Ports: []string{"8080:17170"}})
+	}()
+
+	time.Sleep(400 * time.Millisecond)
+
+	output := logs.String()
+	attempts := strings.Count(output, "Cannot connect to "+addr)
+	assert.Equal(t, 1, strings.Count(output, `"level":"warn"`), "the outage is reported once")
+	assert.GreaterOrEqual(t, attempts, 4)
+	assert.Less(t, attempts, 40, "attempts back off")
+
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "Connected again to "+addr) && !fwd.reverseDown.Load()
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestTunnelCapturesConnection tests that the data of local connections is written to the capture
+func TestTunnelCapturesConnection(t *testing.T) {
+	conn := newFakeConnection()
+	log := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	w, err := capture.Open(path, 0, &log)
+	require.NoError(t, err)
+
+	tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	tun.capture = func() *capture.Writer { return w }
+	addr := serveTunnel(t, tun, 18080)
+
+	assert.Equal(t, "captured-hello", echo(t, addr, "captured-hello"))
+
+	tun.close()
+	require.NoError(t, w.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(content, []byte("captured-hello")), "data should be recorded in both directions")
+}
+
+// TestForwarderSetCapture tests that capture is switched at runtime, only while the forwarder runs
+func TestForwarderSetCapture(t *testing.T) {
+	log := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	fwd := &Forwarder{configuration: config.PortForwardConfiguration{Name: "test-fwd"}}
+
+	require.NoError(t, fwd.SetCapture(&log, &config.CaptureConfiguration{Enabled: true, File: path}))
+	assert.Nil(t, fwd.captureWriter())
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, fwd.startCapture(&log))
+	require.NotNil(t, fwd.captureWriter())
+	assert.FileExists(t, path)
+
+	require.NoError(t, fwd.SetCapture(&log, &config.CaptureConfiguration{Enabled: false, File: path}))
+	assert.Nil(t, fwd.captureWriter())
+	assert.False(t, fwd.Config().Capture.Enabled)
+
+	require.NoError(t, fwd.SetCapture(&log, &config.CaptureConfiguration{Enabled: true, File: path}))
+	assert.NotNil(t, fwd.captureWriter())
+
+	fwd.stopCapture()
+	assert.Nil(t, fwd.captureWriter())
+}
+
+// TestParseShaping tests the parsing of rates and delays
+func TestParseShaping(t *testing.T) {
+	s, err := parseShaping(nil)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = parseShaping(&config.ShapingConfiguration{RateIn: "512Ki", RateOut: "2M", Latency: "100ms", Jitter: "20ms"})
+	require.NoError(t, err)
+	assert.Equal(t, &shaping{rateIn: 512 * 1024, rateOut: 2000000, latency: 100 * time.Millisecond, jitter: 20 * time.Millisecond}, s)
+
+	for i := 0; i < 100; i++ {
+		d := s.delay()
+		assert.GreaterOrEqual(t, d, 80*time.Millisecond)
+		assert.Less(t, d, 120*time.Millisecond)
+	}
+
+	_, err = parseShaping(&config.ShapingConfiguration{RateIn: "fast"})
+	assert.Error(t, err)
+	_, err = parseShaping(&config.ShapingConfiguration{Latency: "soon"})
+	assert.Error(t, err)
+}
+
+// syncBuffer is a buffer safe for concurrent use, recording when it was last written
+type syncBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	written time.Time
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.written = time.Now()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestShapedWriterLatency tests that writes are delayed in order, and direct without latency
+func TestShapedWriterLatency(t *testing.T) {
+	var current atomic.Pointer[shaping]
+	current.Store(&shaping{latency: 100 * time.Millisecond, jitter: 50 * time.Millisecond})
+
+	var out syncBuffer
+	w := newShapedWriter(&out, current.Load, true)
+
+	start := time.Now()
+	for _, chunk := range []string{"a", "b", "c", "d"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	assert.Empty(t, out.String(), "data should be delayed")
+
+	// Without latency, new data still waits for the data queued before it
+	current.Store(&shaping{})
+	_, err := w.Write([]byte("e"))
+	require.NoError(t, err)
+
+	w.flush()
+	assert.Equal(t, "abcde", out.String())
+	assert.GreaterOrEqual(t, out.written.Sub(start), 50*time.Millisecond)
+
+	// Once the queue is empty, writes are direct
+	w = newShapedWriter(&out, current.Load, true)
+	_, err = w.Write([]byte("f"))
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", out.String())
+	w.flush()
+}
+
+// TestShapedWriterRate tests that writes are throttled at the current rate of their direction
+func TestShapedWriterRate(t *testing.T) {
+	var current atomic.Pointer[shaping]
+	current.Store(&shaping{rateIn: 1000000, rateOut: 10000})
+
+	var out syncBuffer
+	w := newShapedWriter(&out, current.Load, false)
+
+	start := time.Now()
+	_, err := w.Write(make([]byte, 5000))
+	require.NoError(t, err)
+	w.flush()
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	assert.Equal(t, 5000, len(out.String()))
+}
+
+// TestForwarderSetShaping tests that shaping changes apply to the running forwarder
+func TestForwarderSetShaping(t *testing.T) {
+	fwd := &Forwarder{configuration: config.PortForwardConfiguration{Name: "test-fwd"}}
+	assert.Nil(t, fwd.shaping.Load())
+
+	cfg := &config.ShapingConfiguration{Latency: "50ms"}
+	require.NoError(t, fwd.SetShaping(cfg))
+	assert.Equal(t, 50*time.Millisecond, fwd.shaping.Load().latency)
+	assert.Same(t, cfg, fwd.Config().Shaping)
+
+	assert.Error(t, fwd.SetShaping(&config.ShapingConfiguration{RateOut: "-1"}))
+	assert.Equal(t, 50*time.Millisecond, fwd.shaping.Load().latency, "invalid settings should be ignored")
+
+	require.NoError(t, fwd.SetShaping(nil))
+	assert.Nil(t, fwd.shaping.Load())
+}
+
+// TestTunnelShapesConnection tests that latency is added to both directions of local connections
+func TestTunnelShapesConnection(t *testing.T) {
+	conn := newFakeConnection()
+	log := zerolog.Nop()
+	var m metrics
+
+	tun := newTunnel(conn, &log, &m, []portMapping{{local: 18080, remote: 8080}})
+	tun.shaping = func() *shaping { return &shaping{latency: 50 * time.Millisecond} }
+	addr := serveTunnel(t, tun, 18080)
+
+	start := time.Now()
+	assert.Equal(t, "slow", echo(t, addr, "slow"))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	tun.close()
+	assert.Equal(t, uint64(4), m.snapshot().BytesIn)
+}
+
+// TestParseFaults tests that faults with the same seed make the same decisions, and that a
+// seed is drawn when none is configured
+func TestParseFaults(t *testing.T) {
+	fl, err := parseFaults(nil)
+	require.NoError(t, err)
+	assert.Nil(t, fl)
+	assert.Nil(t, fl.connection())
+	assert.Zero(t, fl.dropAfter())
+
+	cfg := &config.FaultsConfiguration{Seed: 42, Reset: 30, Cut: &config.FaultInterval{Min: "1s", Max: "10s"}, Drop: &config.FaultInterval{Min: "1m"}}
+	decisions := func() []connectionFaults {
+		fl, err := parseFaults(cfg)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), fl.seed)
+
+		var conns []connectionFaults
+		for i := 0; i < 100; i++ {
+			cf := fl.connection()
+			assert.Equal(t, uint64(i+1), cf.seq)
+			conns = append(conns, connectionFaults{seq: cf.seq, reset: cf.reset, cut: cf.cut})
+		}
+		assert.Equal(t, time.Minute, fl.dropAfter())
+		return conns
+	}
+
+	conns := decisions()
+	assert.Equal(t, conns, decisions())
+	var resets []bool
+	for _, cf := range conns {
+		resets = append(resets, cf.reset)
+		assert.GreaterOrEqual(t, cf.cut, time.Second)
+		assert.LessOrEqual(t, cf.cut, 10*time.Second)
+	}
+	assert.Contains(t, resets, true)
+	assert.Contains(t, resets, false)
+
+	random, err := parseFaults(&config.FaultsConfiguration{Reset: 30})
+	require.NoError(t, err)
+	assert.NotZero(t, random.seed)
+
+	for _, invalid := range []*config.FaultsConfiguration{
+		{Reset: 101},
+		{Cut: &config.FaultInterval{Min: "10s", Max: "1s"}},
+		{Drop: &config.FaultInterval{Min: "soon"}},
+		{Blackhole: &config.BlackholeConfiguration{Every: "10s", Duration: "1m"}},
+	} {
+		_, err := parseFaults(invalid)
+		assert.Error(t, err)
+	}
+}
+
+// TestFaultsConcurrentConnections tests that connections handled concurrently get the
+// decisions of their sequence number, whatever the order they ask for them
+func TestFaultsConcurrentConnections(t *testing.T) {
+	cfg := &config.FaultsConfiguration{Seed: 7, Reset: 50, Cut: &config.FaultInterval{Min: "1s", Max: "1m"}, Drop: &config.FaultInterval{Min: "1s", Max: "1m"}}
+
+	run := func() (map[uint64]connectionFaults, time.Duration) {
+		fl, err := parseFaults(cfg)
+		require.NoError(t, err)
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		conns := make(map[uint64]connectionFaults)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cf := fl.connection()
+				mu.Lock()
+				conns[cf.seq] = connectionFaults{seq: cf.seq, reset: cf.reset, cut: cf.cut}
+				mu.Unlock()
+			}()
+		}
+		// Tunnel drops do not consume the decisions of connections
+		drop := fl.dropAfter()
+		wg.Wait()
+		return conns, drop
+	}
+
+	conns, drop := run()
+	require.Len(t, conns, 50)
+	again, againDrop := run()
+	assert.Equal(t, conns, again)
+	assert.Equal(t, drop, againDrop)
+}
+
+// TestFaultsBlackholeWindow tests that traffic is held during the windows only
+func TestFaultsBlackholeWindow(t *testing.T) {
+	fl, err := parseFaults(&config.FaultsConfiguration{Blackhole: &config.BlackholeConfiguration{Every: "1m", Duration: "10s"}})
+	require.NoError(t, err)
+
+	start := fl.start
+	assert.True(t, fl.blackholeUntil(start.Add(30*time.Second)).IsZero())
+	assert.Equal(t, start.Add(70*time.Second), fl.blackholeUntil(start.Add(65*time.Second)))
+	assert.True(t, fl.blackholeUntil(start.Add(75*time.Second)).IsZero())
+	assert.Equal(t, start.Add(130*time.Second), fl.blackholeUntil(start.Add(120*time.Second)))
+}
+
+// TestTunnelFaults tests that new connections are reset and long-lived ones cut
+func TestTunnelFaults(t *testing.T) {
+	log := zerolog.Nop()
+	var m metrics
+
+	reset := newTunnel(newFakeConnection(), &log, &m, []portMapping{{local: 18080, remote: 8080}})
+	reset.faults = &faults{seed: 1, reset: 100}
+	// The reset may already be seen by the dial
+	local, err := net.Dial("tcp", serveTunnel(t, reset, 18080))
+	if err == nil {
+		defer local.Close()
+		require.NoError(t, local.SetReadDeadline(time.Now().Add(2*time.Second)))
+		_, err = local.Read(make([]byte, 1))
+	}
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	reset.close()
+	assert.Zero(t, m.snapshot().TotalConnections, "reset connections are not forwarded")
+
+	cut := newTunnel(newFakeConnection(), &log, &m, []portMapping{{local: 18080, remote: 8080}})
+	cut.faults = &faults{seed: 1, cutMin: 50 * time.Millisecond, cutMax: 50 * time.Millisecond}
+	addr := serveTunnel(t, cut, 18080)
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+
+	local, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer local.Close()
+
+	start := time.Now()
+	require.NoError(t, local.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = local.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	cut.close()
+}
+
+// TestForwarderFaultDrop tests that a simulated drop ends the tunnel like a lost connection
+func TestForwarderFaultDrop(t *testing.T) {
+	conn := newFakeConnection()
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{"8080"}},
+		faults:        &faults{seed: 1, drops: faultRand(1, 0), dropMin: 50 * time.Millisecond, dropMax: 50 * time.Millisecond},
+		holdTimeout:   time.Second,
+	}
+
+	ctx := contextWithLogger()
+	err := fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{PodName: "test-pod", Ports: fwd.configuration.Ports})
+	assert.ErrorContains(t, err, "simulated tunnel drop")
+	assert.Equal(t, int64(1), fwd.Status().FaultSeed)
+
+	select {
+	case <-conn.CloseChan():
+	default:
+		t.Fatal("the connection should be closed by the drop")
+	}
+}
+
+// TestForwarderTLS tests that local ports terminate TLS with a certificate of the local authority
+func TestForwarderTLS(t *testing.T) {
+	port := freePort(t)
+	conn := newFakeConnection()
+
+	authority, err := certs.LoadAuthority(t.TempDir())
+	require.NoError(t, err)
+
+	fwd := &Forwarder{
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: []string{fmt.Sprintf("%d:80", port)}, Hostname: "api.test"},
+		authority:     authority,
+		holdTimeout:   time.Second,
+	}
+
+	_, err = fwd.serverTLS(&config.TLSConfiguration{Cert: "/nonexistent.crt", Key: "/nonexistent.key"})
+	assert.Error(t, err)
+	fwd.tlsConfig, err = fwd.serverTLS(&config.TLSConfiguration{SelfSigned: true, Hosts: []string{"api.example.com"}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"localhost", "api.test", "api.example.com"}, fwd.tlsConfig.Certificates[0].Leaf.DNSNames)
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	require.NoError(t, fwd.bind(ctx, zerolog.Ctx(ctx)))
+	defer fwd.unbind()
+	go fwd.forward(ctx, zerolog.Ctx(ctx), conn, locator.Target{PodName: "test-pod", Ports: fwd.configuration.Ports})
+
+	caPEM, err := os.ReadFile(authority.CertPath())
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	local, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{RootCAs: roots, ServerName: "api.example.com"})
+	require.NoError(t, err)
+	defer local.Close()
+
+	_, err = local.Write([]byte("hello"))
+	require.NoError(t, err)
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(local, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(reply), "the pod receives plaintext")
+}
+
+// serveHTTP serves HTTP on the pod side of data streams, replying with the tenant header
+// and body of each request
+func serveHTTP(pod *fakeStream) {
+	go func() {
+		defer pod.Close()
+
+		br := bufio.NewReader(pod)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			body, _ := io.ReadAll(req.Body)
+
+			reply := fmt.Sprintf("tenant=%s cookie=%s ua=%s body=%s", req.Header.Get("X-Tenant"), req.Header.Get("Cookie"), req.UserAgent(), body)
+			resp := &http.Response{
+				StatusCode:    http.StatusCreated,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{"Content-Type": {"text/plain"}},
+				ContentLength: int64(len(reply)),
+				Body:          io.NopCloser(strings.NewReader(reply)),
+			}
+			if err := resp.Write(pod); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// TestTunnelInspectsHTTP tests that requests are logged, their headers set and the exchanges recorded
+func TestTunnelInspectsHTTP(t *testing.T) {
+	conn := newFakeConnection()
+	conn.onStream = serveHTTP
+
+	var logs syncBuffer
+	log := zerolog.New(&logs)
+	harPath := filepath.Join(t.TempDir(), "api.har")
+
+	tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	tun.http = newHTTPInspector(&config.HTTPConfiguration{Log: true, Headers: map[string]string{"x-tenant": "acme", "Cookie": ""}, HAR: harPath}, "api", false)
+	require.NoError(t, tun.http.startHAR(&log))
+	addr := serveTunnel(t, tun, 18080)
+
+	client := &http.Client{Transport: &http.Transport{}}
+	for _, body := range []string{"first", "second"} {
+		req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/orders?page=2", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("User-Agent", "test")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		reply, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "tenant=acme cookie= ua=test body="+body, string(reply))
+	}
+	client.CloseIdleConnections()
+	tun.close()
+	tun.http.stopHAR(&log)
+
+	assert.Contains(t, logs.String(), `"method":"POST","path":"/orders?page=2","status":201`)
+	assert.Equal(t, 1, len(conn.streamHeaders())/2, "requests share the keep-alive connection")
+
+	content, err := os.ReadFile(harPath)
+	require.NoError(t, err)
+	var doc har.Log
+	require.NoError(t, json.Unmarshal(content, &doc))
+	require.Len(t, doc.Log.Entries, 2)
+	entry := doc.Log.Entries[1]
+	assert.Equal(t, "POST", entry.Request.Method)
+	assert.Equal(t, "http://"+addr+"/orders?page=2", entry.Request.URL)
+	assert.Contains(t, entry.Request.Headers, har.NameValue{Name: "X-Tenant", Value: "acme"})
+	assert.Equal(t, []har.NameValue{{Name: "page", Value: "2"}}, entry.Request.QueryString)
+	require.NotNil(t, entry.Request.PostData)
+	assert.Equal(t, "second", entry.Request.PostData.Text)
+	assert.Equal(t, 201, entry.Response.Status)
+	assert.Equal(t, "tenant=acme cookie= ua=test body=second", entry.Response.Content.Text)
+}
+
+// TestTunnelHTTPUnchanged tests that requests and responses are sent as received, with only
+// the configured headers changed: protocol version, header names, order and framing are kept
+func TestTunnelHTTPUnchanged(t *testing.T) {
+	for _, tc := range []struct {
+		name, request, sent, response string
+	}{
+		{
+			name:     "HTTP/1.0 without host",
+			request:  "GET /x HTTP/1.0\r\nx-lower: a\r\nX-TENANT: old\r\naccept: */*\r\n\r\n",
+			sent:     "GET /x HTTP/1.0\r\nx-lower: a\r\nX-TENANT: acme\r\naccept: */*\r\n\r\n",
+			response: "HTTP/1.0 200 OK\r\ncontent-type: text/plain\r\n\r\nuntil close",
+		},
+		{
+			name:     "chunked",
+			request:  "POST /c HTTP/1.1\r\nhost: api\r\ntransfer-encoding: chunked\r\nCookie: a=b\r\n\r\n5;ext=1\r\nhello\r\n0\r\nx-sum: 1\r\n\r\n",
+			sent:     "POST /c HTTP/1.1\r\nhost: api\r\ntransfer-encoding: chunked\r\nX-Tenant: acme\r\n\r\n5;ext=1\r\nhello\r\n0\r\nx-sum: 1\r\n\r\n",
+			response: "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			conn := newFakeConnection()
+			conn.onStream = func(pod *fakeStream) {
+				go func() {
+					defer pod.Close()
+					buf := make([]byte, len(tc.sent))
+					if _, err := io.ReadFull(pod, buf); err != nil {
+						return
+					}
+					received <- string(buf)
+					_, _ = pod.Write([]byte(tc.response))
+				}()
+			}
+
+			var logs syncBuffer
+			log := zerolog.New(&logs)
+			tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+			tun.http = newHTTPInspector(&config.HTTPConfiguration{Log: true, Headers: map[string]string{"x-tenant": "acme", "Cookie": ""}}, "api", false)
+			addr := serveTunnel(t, tun, 18080)
+
+			local, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer local.Close()
+			_, err = local.Write([]byte(tc.request))
+			require.NoError(t, err)
+
+			select {
+			case sent := <-received:
+				assert.Equal(t, tc.sent, sent)
+			case <-time.After(2 * time.Second):
+				t.Fatal("the pod should receive the request")
+			}
+
+			require.NoError(t, local.SetReadDeadline(time.Now().Add(2*time.Second)))
+			reply, err := io.ReadAll(local)
+			require.NoError(t, err)
+			assert.Equal(t, tc.response, string(reply))
+
+			tun.close()
+			assert.Contains(t, logs.String(), `"status":200`)
+		})
+	}
+}
+
+// TestTunnelHTTPPassthrough tests that connections which do not speak HTTP are passed through
+func TestTunnelHTTPPassthrough(t *testing.T) {
+	log := zerolog.Nop()
+
+	tun := newTunnel(newFakeConnection(), &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	tun.http = newHTTPInspector(&config.HTTPConfiguration{Log: true}, "db", false)
+	addr := serveTunnel(t, tun, 18080)
+
+	assert.Equal(t, "\x00binary", echo(t, addr, "\x00binary"))
+	assert.Equal(t, "PING\r\n", echo(t, addr, "PING\r\n"))
+	tun.close()
+
+	// The pod speaks first
+	conn := newFakeConnection()
+	conn.onStream = func(pod *fakeStream) {
+		go func() {
+			defer pod.Close()
+			_, _ = pod.Write([]byte("220 ready\r\n"))
+			_, _ = io.Copy(pod, pod)
+		}()
+	}
+	tun = newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	tun.http = newHTTPInspector(&config.HTTPConfiguration{Log: true}, "smtp", false)
+	addr = serveTunnel(t, tun, 18080)
+
+	local, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer local.Close()
+	br := bufio.NewReader(local)
+	greeting, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "220 ready\r\n", greeting)
+
+	_, err = local.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", line, "bytes after an unsolicited greeting are not parsed")
+	local.Close()
+	tun.close()
+}
+
+// TestIsHTTPRequest tests the detection of HTTP/1 request lines
+func TestIsHTTPRequest(t *testing.T) {
+	for input, expected := range map[string]bool{
+		"GET / HTTP/1.1\r\n":        true,
+		"OPTIONS * HTTP/1.1\r\n":    true,
+		"CONNECT host:443 HTTP/1.1": false,
+		"PRI * HTTP/2.0\r\n":        false,
+		"PING\r\n":                  false,
+		"get / HTTP/1.1\r\n":        false,
+		"":                          false,
+	} {
+		assert.Equal(t, expected, isHTTPRequest(bufio.NewReader(strings.NewReader(input))), input)
+	}
+}
+
+// TestTunnelHTTPUpgrade tests that bytes are passed through once the protocol is switched
+func TestTunnelHTTPUpgrade(t *testing.T) {
+	conn := newFakeConnection()
+	conn.onStream = func(pod *fakeStream) {
+		go func() {
+			defer pod.Close()
+			br := bufio.NewReader(pod)
+			if _, err := http.ReadRequest(br); err != nil {
+				return
+			}
+			_, _ = pod.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+			_, _ = io.Copy(pod, br)
+		}()
+	}
+
+	log := zerolog.Nop()
+	tun := newTunnel(conn, &log, &metrics{}, []portMapping{{local: 18080, remote: 8080}})
+	tun.http = newHTTPInspector(&config.HTTPConfiguration{Log: true}, "ws", false)
+	addr := serveTunnel(t, tun, 18080)
+
+	local, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer local.Close()
+
+	_, err = local.Write([]byte("GET /ws HTTP/1.1\r\nHost: ws\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(local)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = local.Write([]byte("GET not http\x00"))
+	require.NoError(t, err)
+	reply := make([]byte, 13)
+	_, err = io.ReadFull(br, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "GET not http\x00", string(reply))
+
+	local.Close()
+	tun.close()
+}
+
+// TestTransportMemory tests the transport chosen per forward and remembered per cluster
+func TestTransportMemory(t *testing.T) {
+	var none *TransportMemory
+	none.Set("https://cluster", config.TransportSPDY)
+	assert.Empty(t, none.Get("https://cluster"))
+
+	memory := NewTransportMemory()
+	memory.Set("https://a", config.TransportSPDY)
+	memory.Set("https://b", config.TransportWebSocket)
+	assert.Equal(t, config.TransportSPDY, memory.Get("https://a"))
+	assert.Equal(t, config.TransportWebSocket, memory.Get("https://b"))
+	assert.Empty(t, memory.Get("https://c"))
+
+	// SPDY is forgotten once expired, so that auto forwards try WebSocket again
+	memory.ttl = 20 * time.Millisecond
+	memory.Set("https://a", config.TransportSPDY)
+	assert.Equal(t, config.TransportSPDY, memory.Get("https://a"))
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, memory.Get("https://a"))
+
+	f := &Forwarder{}
+	assert.Equal(t, config.TransportAuto, f.transportMode())
+	WithDefaultTransport(config.TransportSPDY)(f)
+	assert.Equal(t, config.TransportSPDY, f.transportMode())
+	f.configuration.Transport = config.TransportWebSocket
+	assert.Equal(t, config.TransportWebSocket, f.transportMode())
+}
+
+// TestRememberTransport tests that only forwards in auto mode record the transport that
+// worked, so that a pinned forward does not steer the auto forwards of its cluster
+func TestRememberTransport(t *testing.T) {
+	memory := NewTransportMemory()
+	restConfig := &rest.Config{Host: "https://cluster"}
+
+	pinned := &Forwarder{configuration: config.PortForwardConfiguration{Name: "pinned", Transport: config.TransportSPDY}, restConfig: restConfig}
+	WithTransportMemory(memory)(pinned)
+	auto := &Forwarder{configuration: config.PortForwardConfiguration{Name: "auto"}, restConfig: restConfig}
+	WithTransportMemory(memory)(auto)
+
+	pinned.rememberTransport(config.TransportSPDY)
+	assert.Empty(t, memory.Get("https://cluster"), "a pinned transport is not recorded")
+
+	auto.rememberTransport(config.TransportWebSocket)
+	assert.Equal(t, config.TransportWebSocket, memory.Get("https://cluster"))
+
+	pinned.rememberTransport(config.TransportSPDY)
+	assert.Equal(t, config.TransportWebSocket, memory.Get("https://cluster"), "a pinned transport does not override auto mode")
+
+	auto.rememberTransport(config.TransportSPDY)
+	assert.Equal(t, config.TransportSPDY, memory.Get("https://cluster"))
+}
+
+// fakeDialer dials conn once release is closed
+func fakeDialer(release chan struct{}, conn *fakeConnection) upgradeDialer {
+	return func(ctx context.Context) (httpstream.Connection, error) {
+		<-release
+		return conn, nil
+	}
+}
+
+// TestDialWithTimeout tests that a hanging upgrade times out and its late connection is closed
+func TestDialWithTimeout(t *testing.T) {
+	release, want := make(chan struct{}), newFakeConnection()
+	close(release)
+	conn, err := dialWithTimeout(fakeDialer(release, want), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, want, conn)
+
+	release, late := make(chan struct{}), newFakeConnection()
+	_, err = dialWithTimeout(fakeDialer(release, late), 20*time.Millisecond)
+	assert.ErrorIs(t, err, errDialTimeout)
+
+	close(release)
+	select {
+	case <-late.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("the late connection was not closed")
+	}
+}
+
+// TestDialTransportTimeout tests that a hanging upgrade, over SPDY pinned or remembered and
+// over WebSocket, times out instead of keeping the forwarder connecting, and leaves no
+// goroutine blocked on the hanging server
+func TestDialTransportTimeout(t *testing.T) {
+	// The server accepts connections and never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	defer func() {
+		listener.Close()
+		<-served
+	}()
+
+	previousSPDY, previousWebSocket := spdyDialTimeout, websocketDialTimeout
+	spdyDialTimeout, websocketDialTimeout = 50*time.Millisecond, 50*time.Millisecond
+	defer func() { spdyDialTimeout, websocketDialTimeout = previousSPDY, previousWebSocket }()
+
+	host := "http://" + listener.Addr().String()
+	restConfig := &rest.Config{Host: host}
+	memory := NewTransportMemory()
+	memory.Set(host, config.TransportSPDY)
+	forwardURL, err := url.Parse(host + "/api/v1/namespaces/default/pods/web-0/portforward")
+	require.NoError(t, err)
+	log := zerolog.Nop()
+
+	baseline := runtime.NumGoroutine()
+	for mode, expected := range map[string]string{
+		config.TransportSPDY:      config.TransportSPDY,
+		config.TransportAuto:      config.TransportSPDY,
+		config.TransportWebSocket: config.TransportWebSocket,
+	} {
+		transport, upgrader, err := spdyRoundTripperFor(restConfig)
+		require.NoError(t, err)
+		f := &Forwarder{configuration: config.PortForwardConfiguration{Name: "web", Transport: mode}, restConfig: restConfig, transport: transport, upgrader: upgrader}
+		WithTransportMemory(memory)(f)
+
+		start := time.Now()
+		_, used, err := f.dialTransport(forwardURL, &log)
+		assert.ErrorIs(t, err, errDialTimeout, mode)
+		assert.Equal(t, expected, used, mode)
+		assert.Less(t, time.Since(start), time.Second, mode)
+	}
+
+	// Polled here, as assert.Eventually runs the condition in a goroutine of its own
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline, "the hanging dials should return")
+}
+
+// TestForwarderLazy tests that a lazy forwarder binds at once, dials on the first connection
+// and closes the tunnel once idle
+func TestForwarderLazy(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	var dials atomic.Int32
+	hook, states := recordTransitions()
+	fwd := &Forwarder{
+		locator:       &MockLocator{podName: "test-pod", ports: ports},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+		holdTimeout:   time.Second,
+		lazy:          true,
+		idleTimeout:   100 * time.Millisecond,
+		onTransition:  hook,
+		dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+			dials.Add(1)
+			return newFakeConnection(), nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fwd.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return fwd.Status().State == StateIdle }, 2*time.Second, 10*time.Millisecond)
+	_, err := net.Listen("tcp", addr)
+	assert.Error(t, err, "the port is bound while idle")
+	assert.Zero(t, dials.Load())
+
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+	assert.Equal(t, int32(1), dials.Load())
+
+	// An open connection keeps the tunnel up
+	local, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = local.Write([]byte("x"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(local, make([]byte, 1))
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, StateReady, fwd.Status().State)
+	local.Close()
+
+	require.Eventually(t, func() bool { return fwd.Status().State == StateIdle }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []State{StateIdle, StateLocating, StateConnecting, StateReady, StateIdle}, states())
+
+	assert.Equal(t, "pong", echo(t, addr, "pong"))
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+// TestForwarderLazyHealthCheck tests that health probes do not keep the tunnel of a lazy
+// forwarder open
+func TestForwarderLazyHealthCheck(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	health := newTestHealthCheck("tcp", endpoint{port: port})
+
+	fwd := &Forwarder{
+		locator:       &MockLocator{podName: "test-pod", ports: ports},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+		holdTimeout:   time.Second,
+		lazy:          true,
+		idleTimeout:   200 * time.Millisecond,
+		health:        health,
+		dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+			return newFakeConnection(), nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fwd.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return fwd.Status().State == StateIdle }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+
+	require.Eventually(t, func() bool { return !fwd.Status().LastProbe.IsZero() }, 2*time.Second, 10*time.Millisecond, "the tunnel is probed")
+	require.Eventually(t, func() bool { return fwd.Status().State == StateIdle }, 2*time.Second, 10*time.Millisecond,
+		"the forwarder goes idle although it is probed")
+}
+
+// TestForwarderHandover tests that a new configuration takes over the shared port without
+// closing its connections, binds its new port first and drains the previous configuration
+func TestForwarderHandover(t *testing.T) {
+	shared, removed, added := freePort(t), freePort(t), freePort(t)
+	addr := func(port int) string { return fmt.Sprintf("127.0.0.1:%d", port) }
+
+	newForwarder := func(ports []string, conn *fakeConnection) *Forwarder {
+		return &Forwarder{
+			locator:       &MockLocator{podName: "test-pod", ports: ports},
+			configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+			retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+			holdTimeout:   time.Second,
+			drainTimeout:  5 * time.Second,
+			dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+				return conn, nil
+			},
+		}
+	}
+	oldConn, newConn := newFakeConnection(), newFakeConnection()
+	previous := newForwarder([]string{fmt.Sprintf("%d:80", shared), fmt.Sprintf("%d:81", removed)}, oldConn)
+	next := newForwarder([]string{fmt.Sprintf("%d:82", shared), fmt.Sprintf("%d:83", added)}, newConn)
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+
+	previousCtx, stopPrevious := context.WithCancel(ctx)
+	previousDone := make(chan struct{})
+	go func() {
+		defer close(previousDone)
+		previous.Start(previousCtx)
+	}()
+	require.Eventually(t, func() bool { return previous.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+
+	// Long-lived connections on the shared and the removed ports
+	dialEcho := func(port int) net.Conn {
+		c, err := net.Dial("tcp", addr(port))
+		require.NoError(t, err)
+		assert.Equal(t, "a", echoOn(t, c, "a"))
+		return c
+	}
+	onShared, onRemoved := dialEcho(shared), dialEcho(removed)
+	defer onShared.Close()
+	defer onRemoved.Close()
+
+	WithPredecessor(previous, stopPrevious)(next)
+	nextDone := make(chan struct{})
+	go func() {
+		defer close(nextDone)
+		next.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-nextDone
+	}()
+
+	require.Eventually(t, func() bool { return len(previous.boundEndpoints()) == 0 }, 2*time.Second, 10*time.Millisecond)
+
+	// The new configuration serves the shared and added ports, the removed one is closed
+	assert.Equal(t, "ping", echo(t, addr(shared), "ping"))
+	assert.Equal(t, "ping", echo(t, addr(added), "ping"))
+	_, err := net.Dial("tcp", addr(removed))
+	assert.Error(t, err)
+	newConn.mu.Lock()
+	assert.Len(t, newConn.streams, 8, "two connections of two streams each, both sides")
+	newConn.mu.Unlock()
+
+	// Connections to the previous configuration go on until they end
+	assert.Equal(t, "b", echoOn(t, onShared, "b"))
+	assert.Equal(t, "b", echoOn(t, onRemoved, "b"))
+	select {
+	case <-previousDone:
+		t.Fatal("the previous configuration should drain its connections")
+	default:
+	}
+
+	onShared.Close()
+	onRemoved.Close()
+	select {
+	case <-previousDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the previous configuration should stop once drained")
+	}
+	assert.Equal(t, "pong", echo(t, addr(shared), "pong"))
+}
+
+// TestForwarderHandoverChained tests that a forwarder replaced before its own handover is
+// done hands over the endpoints it still inherits, instead of leaving them bound
+func TestForwarderHandoverChained(t *testing.T) {
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	newForwarder := func(dial func() (httpstream.Connection, error)) *Forwarder {
+		return &Forwarder{
+			locator:       &MockLocator{podName: "test-pod", ports: ports},
+			configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+			retryConfig:   RetryConfig{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+			holdTimeout:   time.Second,
+			drainTimeout:  5 * time.Second,
+			dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+				return dial()
+			},
+		}
+	}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+
+	start := func(f *Forwarder, ctx context.Context) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f.Start(ctx)
+		}()
+		return done
+	}
+
+	// The first configuration is in service
+	firstConn := newFakeConnection()
+	first := newForwarder(func() (httpstream.Connection, error) { return firstConn, nil })
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	firstDone := start(first, firstCtx)
+	require.Eventually(t, func() bool { return first.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+
+	// The second one never gets ready
+	secondCtx, stopSecond := context.WithCancel(ctx)
+	second := newForwarder(func() (httpstream.Connection, error) {
+		<-secondCtx.Done()
+		return nil, secondCtx.Err()
+	})
+	WithPredecessor(first, stopFirst)(second)
+	secondDone := start(second, secondCtx)
+	require.Eventually(t, func() bool {
+		second.listenMu.Lock()
+		defer second.listenMu.Unlock()
+		return second.inheriting[endpoint{port: port}]
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// and is replaced in turn
+	thirdConn := newFakeConnection()
+	third := newForwarder(func() (httpstream.Connection, error) { return thirdConn, nil })
+	WithPredecessor(second, stopSecond)(third)
+	thirdDone := start(third, ctx)
+	defer func() {
+		cancel()
+		<-thirdDone
+	}()
+
+	require.Eventually(t, func() bool { return third.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(first.boundEndpoints()) == 0 }, 2*time.Second, 10*time.Millisecond)
+	assert.Contains(t, third.boundEndpoints(), endpoint{port: port})
+
+	assert.Equal(t, "ping", echo(t, addr, "ping"))
+	thirdConn.mu.Lock()
+	assert.NotEmpty(t, thirdConn.streams, "the last configuration serves the port")
+	thirdConn.mu.Unlock()
+
+	for name, done := range map[string]<-chan struct{}{"first": firstDone, "second": secondDone} {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the %s configuration should stop once replaced", name)
+		}
+	}
+}
+
+// stallingListener is a listener whose release stalls until resume is closed: stalled is
+// closed once it is interrupted
+type stallingListener struct {
+	*net.TCPListener
+	stalled chan struct{}
+	resume  chan struct{}
+	once    sync.Once
+}
+
+func (l *stallingListener) SetDeadline(t time.Time) error {
+	if !t.IsZero() {
+		l.once.Do(func() { close(l.stalled) })
+		<-l.resume
+	}
+	return l.TCPListener.SetDeadline(t)
+}
+
+// TestForwarderHandoverReleasedDuringTakeOver tests that a forwarder replaced while it takes
+// over the ports of its predecessor hands them to its successor instead of serving them
+func TestForwarderHandoverReleasedDuringTakeOver(t *testing.T) {
+	port := freePort(t)
+	e := endpoint{port: port}
+	configuration := config.PortForwardConfiguration{Name: "test-fwd", Address: "127.0.0.1", Ports: []string{fmt.Sprintf("%d:80", port)}}
+
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	log := zerolog.Nop()
+
+	inner, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	ln := &stallingListener{TCPListener: inner.(*net.TCPListener), stalled: make(chan struct{}), resume: make(chan struct{})}
+
+	first := &Forwarder{configuration: configuration, listeners: make(map[endpoint]*boundEndpoint)}
+	first.listenMu.Lock()
+	first.serveLocked(ctx, &log, e, []net.Listener{ln})
+	first.listenMu.Unlock()
+	defer first.unbind()
+
+	second := &Forwarder{configuration: configuration, drainTimeout: time.Millisecond}
+	WithPredecessor(first, func() {})(second)
+	second.inheriting = map[endpoint]bool{e: true}
+	second.predecessor.markReady()
+	defer second.unbind()
+
+	// The second configuration is taking the port over from the first one
+	tookOver := make(chan struct{})
+	go func() {
+		defer close(tookOver)
+		second.takeOver(ctx, &log)
+	}()
+	<-ln.stalled
+
+	// when the third one releases it
+	released := make(chan map[endpoint][]net.Listener, 1)
+	go func() { released <- second.release([]endpoint{e}) }()
+	// The release waits for the takeover, or finds the port neither inherited nor bound
+	time.Sleep(50 * time.Millisecond)
+	close(ln.resume)
+	<-tookOver
+
+	select {
+	case listeners := <-released:
+		assert.Equal(t, []net.Listener{ln}, listeners[e], "the third configuration should get the port")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the release should complete once the takeover is done")
+	}
+	assert.NotContains(t, second.boundEndpoints(), e, "the second configuration should not serve the port")
+	ln.Close()
+}
+
+// echoOn sends msg on an open connection and returns the reply
+func echoOn(t *testing.T, conn net.Conn, msg string) string {
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+
+	reply := make([]byte, len(msg))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	return string(reply)
+}
+
+// TestForwarderHandoverFromFailed tests that the ports of a failed predecessor, which it no
+// longer holds, are bound by the new configuration
+func TestForwarderHandoverFromFailed(t *testing.T) {
+	port := freePort(t)
+	ports := []string{fmt.Sprintf("%d:80", port)}
+
+	previous := &Forwarder{
+		locator:       &MockLocator{err: errors.New("forbidden")},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		retryPolicies: RetryPolicies{locator.ErrorTypeUnknown: {Action: RetryActionStop}},
+		holdTimeout:   time.Second,
+	}
+	ctx, cancel := context.WithCancel(contextWithLogger())
+	defer cancel()
+	previous.Start(ctx)
+	require.Equal(t, StateFailed, previous.Status().State)
+
+	stopped := make(chan struct{})
+	next := &Forwarder{
+		locator:       &MockLocator{podName: "test-pod", ports: ports},
+		configuration: config.PortForwardConfiguration{Name: "test-fwd", Ports: ports},
+		holdTimeout:   time.Second,
+		drainTimeout:  time.Minute,
+		dialPod: func(log *zerolog.Logger, target locator.Target) (httpstream.Connection, error) {
+			return newFakeConnection(), nil
+		},
+	}
+	WithPredecessor(previous, func() { close(stopped) })(next)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		next.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return next.Status().State == StateReady }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "ping", echo(t, fmt.Sprintf("127.0.0.1:%d", port), "ping"))
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the failed predecessor should be stopped")
+	}
+}
@@ -0,0 +1,171 @@
+package forwarder
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultDrainTimeout is how long the connections of a replaced forwarder have to finish.
+const DefaultDrainTimeout = 30 * time.Second
+
+// drainCheckInterval is how often a draining forwarder checks for connections in progress.
+var drainCheckInterval = 100 * time.Millisecond
+
+// handover is the replacement of a running forwarder by a new one, for a new configuration.
+// The forwarder replaced keeps serving the local endpoints both share until the new one is
+// ready, or for the drain timeout at most. The endpoints are then moved to the new forwarder
+// without closing them, and the forwarder replaced stops accepting connections and lets those
+// in progress finish within the drain timeout, before it is stopped.
+type handover struct {
+	from *Forwarder
+	stop func()
+
+	// ready is closed once the new forwarder is ready (or idle)
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// WithPredecessor makes the forwarder replace a running one without downtime, see handover.
+// stop stops the forwarder replaced: it is called once it is drained, or as soon as the new
+// forwarder stops.
+func WithPredecessor(previous *Forwarder, stop func()) Option {
+	return func(f *Forwarder) {
+		f.predecessor = &handover{from: previous, stop: stop, ready: make(chan struct{})}
+	}
+}
+
+// markReady signals that the new forwarder is ready. It is a no-op on a nil handover.
+func (h *handover) markReady() {
+	if h == nil {
+		return
+	}
+	h.readyOnce.Do(func() { close(h.ready) })
+}
+
+// stopRecording stops the capture and HAR file of the forwarder replaced, before the new
+// forwarder records to the same files. It is a no-op on a nil handover.
+func (h *handover) stopRecording(log *zerolog.Logger) {
+	if h == nil {
+		return
+	}
+	h.from.stopCapture()
+	h.from.http.stopHAR(log)
+}
+
+// sharedEndpoints returns the local endpoints held by the forwarder replaced that f serves
+// the same way: on the same addresses, and with the same permissions for sockets. Endpoints
+// it does not hold, e.g. once it failed, are bound by f at once. Those it still inherits,
+// when it is replaced during its own handover, are taken from its predecessor.
+func (f *Forwarder) sharedEndpoints() map[endpoint]bool {
+	previous := f.predecessor.from
+	if f.detached || previous.detached || !slices.Equal(f.listenAddresses(), previous.listenAddresses()) {
+		return nil
+	}
+
+	mine, err := parsePorts(f.configuration.Ports)
+	if err != nil {
+		return nil
+	}
+
+	shared := make(map[endpoint]bool)
+	for _, e := range previous.heldEndpoints() {
+		if e.socket != "" && f.socketMode != previous.socketMode {
+			continue
+		}
+		if slices.ContainsFunc(mine, func(other portMapping) bool { return other.endpoint() == e }) {
+			shared[e] = true
+		}
+	}
+	return shared
+}
+
+// takeOver moves the shared endpoints from the forwarder replaced once f is ready, then
+// drains and stops the forwarder replaced. It returns early when ctx is done.
+func (f *Forwarder) takeOver(ctx context.Context, log *zerolog.Logger) {
+	h := f.predecessor
+	defer h.stop()
+
+	timer := time.NewTimer(f.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-h.ready:
+	case <-timer.C:
+		log.Warn().Msgf("Forwarder %s not ready within %s, taking over the ports of its previous configuration", f.forwarderInfo(), f.drainTimeout)
+	case <-ctx.Done():
+		return
+	}
+
+	// A successor releasing the inherited endpoints meanwhile would find them neither
+	// inherited nor bound
+	f.handoverMu.Lock()
+	f.listenMu.Lock()
+	endpoints := make([]endpoint, 0, len(f.inheriting))
+	for e := range f.inheriting {
+		endpoints = append(endpoints, e)
+	}
+	f.listenMu.Unlock()
+
+	f.adopt(ctx, log, h.from.release(endpoints))
+	f.handoverMu.Unlock()
+	log.Info().Msgf("HANDOVER - Forwarder %s: new configuration in service, draining the previous one", f.forwarderInfo())
+
+	// Endpoints the forwarder replaced no longer held are bound now
+	if ctx.Err() == nil {
+		if err := f.bind(ctx, log); err != nil {
+			log.Error().Err(err).Msgf("ERROR - Forwarder %s", f.forwarderInfo())
+		}
+	}
+
+	h.from.drain(ctx, log, f.drainTimeout)
+}
+
+// drain stops accepting connections and waits up to timeout for the connections in progress,
+// including those held for a tunnel, to finish. It returns early when ctx is done.
+func (f *Forwarder) drain(ctx context.Context, log *zerolog.Logger, timeout time.Duration) {
+	for _, lns := range f.release(f.boundEndpoints()) {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		n := f.connections()
+		if n == 0 {
+			log.Debug().Msgf("Forwarder %s drained", f.forwarderInfo())
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Warn().Msgf("Forwarder %s: closing %d connection(s) still open after %s", f.forwarderInfo(), n, timeout)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connections counts the connections in progress: attached to a tunnel, or held for one.
+func (f *Forwarder) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := f.held
+	for _, t := range f.tunnels {
+		t.mu.Lock()
+		n += t.conns
+		t.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,218 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// healthCheck probes a forwarded port through the tunnel: each probe opens a new stream on
+// the existing port-forward connection, see through.
+type healthCheck struct {
+	kind string // "tcp" or "http"
+
+	// endpoint is the local endpoint whose pod port is probed
+	endpoint  endpoint
+	path      string
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+
+	// dial opens the probe connections, see through
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+// newHealthCheck builds a health check from its configuration. Without a configured
+// port, the pod port of the first forwarded port (or socket) is probed.
+func newHealthCheck(cfg *config.HealthCheckConfiguration, ports []string) (*healthCheck, error) {
+	e := endpoint{port: cfg.Port}
+	if e.port == 0 {
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("no port to probe")
+		}
+
+		mappings, err := parsePorts(ports[:1])
+		if err != nil {
+			return nil, err
+		}
+		e = mappings[0].endpoint()
+	}
+
+	interval, err := parseDurationOr(cfg.Interval, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+
+	timeout, err := parseDurationOr(cfg.Timeout, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	h := &healthCheck{
+		kind:      cfg.Type,
+		endpoint:  e,
+		path:      cfg.Path,
+		interval:  interval,
+		timeout:   timeout,
+		threshold: max(cfg.FailureThreshold, 1),
+	}
+
+	switch h.kind {
+	case "", "tcp":
+		h.kind = "tcp"
+	case "http":
+		if h.path == "" {
+			h.path = "/"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported health check type: %s", cfg.Type)
+	}
+
+	return h, nil
+}
+
+// parseDurationOr parses a duration, returning def for an empty string.
+func parseDurationOr(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// through returns a copy of the health check probing a tunnel directly. Probes bypass the
+// local port, so that they are not counted, captured, inspected or subject to faults and
+// TLS termination like client connections.
+func (h *healthCheck) through(t *tunnel) *healthCheck {
+	probe := *h
+	probe.dial = func(context.Context) (net.Conn, error) {
+		return t.probe(h.endpoint)
+	}
+	return &probe
+}
+
+// probe runs a single health check.
+func (h *healthCheck) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	if h.kind == "http" {
+		return h.probeHTTP(ctx)
+	}
+	return h.probeTCP(ctx)
+}
+
+// probeTCP opens a stream through the tunnel. Opening it always succeeds, so the probe
+// fails only when the stream reports an error or is closed, which happens when the pod
+// refuses the connection. Servers that never write are healthy once the timeout elapses.
+func (h *healthCheck) probeTCP(ctx context.Context) error {
+	conn, err := h.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot probe %s: %w", h.endpoint, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("probe of %s closed by the tunnel: remote port is not accepting connections", h.endpoint)
+	}
+	return fmt.Errorf("probe of %s failed: %w", h.endpoint, err)
+}
+
+// probeHTTP sends a GET request through the tunnel and expects a non-5xx response.
+func (h *healthCheck) probeHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+h.path, nil)
+	if err != nil {
+		return err
+	}
+
+	// A dedicated transport avoids reusing connections across probes: each probe
+	// must open a new stream through the tunnel
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return h.dial(ctx)
+		},
+	}}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", h.path, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("GET %s returned %s", h.path, resp.Status)
+	}
+
+	return nil
+}
+
+// monitorHealth probes the tunnel with h until ctx is done. It returns an error after
+// threshold consecutive failures, and nil when ctx is cancelled.
+func (f *Forwarder) monitorHealth(ctx context.Context, log *zerolog.Logger, h *healthCheck) error {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := h.probe(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err == nil {
+			if failures > 0 {
+				log.Info().Msgf("HEALTHY - Forwarder %s: health check recovered", f.forwarderInfo())
+			}
+			failures = 0
+			f.recordProbe(0, nil)
+			continue
+		}
+
+		failures++
+		f.recordProbe(failures, err)
+
+		log.Warn().Err(err).Int("failures", failures).
+			Msgf("UNHEALTHY - Forwarder %s: %s health check failed (%d/%d)", f.forwarderInfo(), h.kind, failures, h.threshold)
+
+		if failures >= h.threshold {
+			return fmt.Errorf("%s health check failed %d consecutive times: %w", h.kind, failures, err)
+		}
+	}
+}
+
+// recordProbe stores the health check result in the forwarder status.
+func (f *Forwarder) recordProbe(failures int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status.LastProbe = time.Now()
+	f.status.ProbeFailures = failures
+	if err != nil {
+		f.status.LastProbeError = err
+	}
+}
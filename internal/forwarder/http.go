@@ -0,0 +1,470 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/har"
+)
+
+// httpPipeline bounds the requests sent ahead of their responses on a connection.
+const httpPipeline = 64
+
+// httpMethods are the request methods inspected. Other bytes, including CONNECT tunnels and
+// the HTTP/2 preface, are passed through.
+var httpMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions, http.MethodTrace,
+}
+
+// httpInspector follows the HTTP/1 exchanges of the connections of a forwarder, to log them,
+// change their request headers and record them to a HAR file.
+type httpInspector struct {
+	name string
+	log  bool
+
+	// headers are set on requests, removed when empty
+	headers map[string]string
+
+	// scheme is the scheme of the recorded URLs; harPath is the HAR file, recorded to
+	// har while the forwarder runs
+	scheme  string
+	harPath string
+	har     atomic.Pointer[har.Recorder]
+}
+
+// newHTTPInspector creates the inspector of a forwarder, nil when HTTP is not inspected.
+func newHTTPInspector(cfg *config.HTTPConfiguration, name string, tls bool) *httpInspector {
+	if cfg == nil {
+		return nil
+	}
+
+	hi := &httpInspector{name: name, log: cfg.Log, headers: make(map[string]string, len(cfg.Headers)), scheme: "http", harPath: cfg.HAR}
+	for key, value := range cfg.Headers {
+		hi.headers[http.CanonicalHeaderKey(key)] = value
+	}
+	if tls {
+		hi.scheme = "https"
+	}
+	return hi
+}
+
+// startHAR opens the HAR file, once the forwarder starts. It is a no-op on a nil inspector.
+func (hi *httpInspector) startHAR(log *zerolog.Logger) error {
+	if hi == nil || hi.harPath == "" {
+		return nil
+	}
+
+	r, err := har.Open(hi.harPath, log)
+	if err != nil {
+		return err
+	}
+	hi.har.Store(r)
+	log.Info().Msgf("Recording HTTP exchanges of forwarder %s to %s", hi.name, hi.harPath)
+	return nil
+}
+
+// stopHAR writes and closes the HAR file, once the forwarder stops. It is a no-op on a nil inspector.
+func (hi *httpInspector) stopHAR(log *zerolog.Logger) {
+	if hi == nil {
+		return
+	}
+
+	if r := hi.har.Swap(nil); r != nil {
+		if err := r.Close(); err != nil {
+			log.Error().Err(err).Msgf("Failed to write HAR file %s", r.Path())
+		}
+	}
+}
+
+// exchange starts following a connection accepted on a local endpoint.
+func (hi *httpInspector) exchange(log *zerolog.Logger, e endpoint) *httpExchange {
+	return &httpExchange{inspector: hi, log: log, endpoint: e, pending: make(chan *httpRequest, httpPipeline)}
+}
+
+// httpExchange follows the requests and responses of one connection: requests are parsed
+// from the local side and queued, then matched with the responses parsed from the pod. As
+// soon as either side is not HTTP, or after a protocol upgrade, bytes are passed through.
+type httpExchange struct {
+	inspector *httpInspector
+	log       *zerolog.Logger
+	endpoint  endpoint
+
+	// pending are the requests sent and waiting for a response, closed once no more
+	// requests are parsed; raw is set once the pod sends bytes nobody asked for
+	pending      chan *httpRequest
+	closePending sync.Once
+	raw          atomic.Bool
+}
+
+// httpRequest is a request sent to the pod, waiting for its response. req is parsed from
+// the request as sent, with the configured headers.
+type httpRequest struct {
+	req   *http.Request
+	body  *bodyRecorder
+	start time.Time
+}
+
+// requests copies the local side to the pod, parsing its requests on the side. Requests are
+// sent as received, only their configured headers are changed.
+func (x *httpExchange) requests(dst io.Writer, src io.Reader) error {
+	defer x.closePending.Do(func() { close(x.pending) })
+
+	br := bufio.NewReader(src)
+
+	if isHTTPRequest(br) && !x.raw.Load() {
+		for !x.raw.Load() {
+			header, err := readHeader(br)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid HTTP request: %w", err)
+			}
+
+			header = x.setHeaders(header)
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+			if err != nil {
+				return fmt.Errorf("invalid HTTP request: %w", err)
+			}
+			pr := &httpRequest{req: req, body: &bodyRecorder{}, start: time.Now()}
+
+			// Queued before sending, so that the response always finds its request
+			x.pending <- pr
+
+			// Headers are sent before the body is read, for "Expect: 100-continue" and streams
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if err := copyBody(dst, br, pr.body, slices.Contains(req.TransferEncoding, "chunked"), req.ContentLength); err != nil {
+				return err
+			}
+
+			if isUpgrade(req.Header) {
+				break
+			}
+		}
+	}
+
+	x.closePending.Do(func() { close(x.pending) })
+	_, err := io.Copy(dst, br)
+	return err
+}
+
+// responses copies the pod side to the local side, parsing the responses of the pending
+// requests to log and record them.
+func (x *httpExchange) responses(dst io.Writer, src io.Reader) error {
+	br := bufio.NewReader(src)
+
+	for {
+		if _, err := br.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var pr *httpRequest
+		select {
+		case pr = <-x.pending:
+		default:
+		}
+		if pr == nil {
+			// Unsolicited bytes: the pod does not speak HTTP, or the connection was upgraded
+			x.raw.Store(true)
+			break
+		}
+
+		upgraded, err := x.response(dst, br, pr)
+		if err != nil {
+			return err
+		}
+		if upgraded {
+			x.raw.Store(true)
+			break
+		}
+	}
+
+	_, err := io.Copy(dst, br)
+	return err
+}
+
+// response copies the response of a request as received, after its informational responses.
+// It returns whether the connection switched protocols.
+func (x *httpExchange) response(dst io.Writer, br *bufio.Reader, pr *httpRequest) (bool, error) {
+	for {
+		header, err := readHeader(br)
+		if err != nil {
+			return false, fmt.Errorf("invalid HTTP response: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), pr.req)
+		if err != nil {
+			return false, fmt.Errorf("invalid HTTP response: %w", err)
+		}
+		wait := time.Since(pr.start)
+
+		if _, err := dst.Write(header); err != nil {
+			return false, err
+		}
+		body := &bodyRecorder{}
+		chunked, length := responseFraming(pr.req, resp)
+		if err := copyBody(dst, br, body, chunked, length); err != nil {
+			return false, err
+		}
+
+		// "100 Continue" and other informational responses precede the final one
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+
+		x.record(pr, resp, body, wait, time.Since(pr.start))
+		return resp.StatusCode == http.StatusSwitchingProtocols, nil
+	}
+}
+
+// setHeaders returns the header of a request with the configured headers: a header set is
+// written in place of the first line of the same name, or added at the end; the other lines
+// of that name are removed. Other lines are kept as received.
+func (x *httpExchange) setHeaders(header []byte) []byte {
+	headers := x.inspector.headers
+	if len(headers) == 0 {
+		return header
+	}
+
+	var b bytes.Buffer
+	written := make(map[string]bool, len(headers))
+	dropping := false
+	for i, line := range slices.Collect(bytes.Lines(header)) {
+		switch {
+		case i == 0:
+			// The request line
+			b.Write(line)
+		case isBlankLine(line):
+			eol := line
+			for _, key := range slices.Sorted(maps.Keys(headers)) {
+				if !written[key] && headers[key] != "" {
+					fmt.Fprintf(&b, "%s: %s%s", key, headers[key], eol)
+				}
+			}
+			b.Write(line)
+		case line[0] == ' ' || line[0] == '\t':
+			// The continuation of the previous line
+			if !dropping {
+				b.Write(line)
+			}
+		default:
+			name, _, _ := bytes.Cut(line, []byte(":"))
+			key := http.CanonicalHeaderKey(string(bytes.TrimSpace(name)))
+			value, configured := headers[key]
+			dropping = configured
+			if !configured {
+				b.Write(line)
+				continue
+			}
+			if !written[key] && value != "" {
+				fmt.Fprintf(&b, "%s: %s%s", name, value, lineEnding(line))
+			}
+			written[key] = true
+		}
+	}
+
+	return b.Bytes()
+}
+
+// record logs an exchange and adds it to the HAR file.
+func (x *httpExchange) record(pr *httpRequest, resp *http.Response, body *bodyRecorder, wait, total time.Duration) {
+	if x.inspector.log {
+		x.log.Info().
+			Str("method", pr.req.Method).
+			Str("path", pr.req.URL.RequestURI()).
+			Int("status", resp.StatusCode).
+			Dur("latency", wait).
+			Msgf("HTTP - Forwarder %s %s", x.inspector.name, x.endpoint)
+	}
+
+	if r := x.inspector.har.Load(); r != nil {
+		r.Add(har.NewEntry(x.inspector.scheme, pr.req, pr.body.recorded(), resp, body.recorded(), pr.start, wait, total))
+	}
+}
+
+// isHTTPRequest reports whether the first bytes of br are an HTTP/1 request line. It waits
+// for more bytes only while they can still be a method.
+func isHTTPRequest(br *bufio.Reader) bool {
+	n := 1
+	for {
+		peeked, _ := br.Peek(max(n, br.Buffered()))
+		if len(peeked) < n {
+			return false
+		}
+
+		if i := bytes.IndexByte(peeked, ' '); i >= 0 {
+			return slices.Contains(httpMethods, string(peeked[:i]))
+		}
+		if len(peeked) >= len(http.MethodOptions) || bytes.ContainsFunc(peeked, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+			return false
+		}
+		n = len(peeked) + 1
+	}
+}
+
+// isUpgrade reports whether request headers ask to switch protocols, e.g. to WebSocket.
+func isUpgrade(h http.Header) bool {
+	if h.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range h.Values("Connection") {
+		for token := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readHeader reads the start line and the header lines of a message, up to and including the
+// empty line, as received. It returns io.EOF when br ends before the message.
+func readHeader(br *bufio.Reader) ([]byte, error) {
+	var header []byte
+	lineStart := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		header = append(header, chunk...)
+		if len(header) > http.DefaultMaxHeaderBytes {
+			return nil, errors.New("header too large")
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(header) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if isBlankLine(header[lineStart:]) {
+			return header, nil
+		}
+		lineStart = len(header)
+	}
+}
+
+// isBlankLine reports whether line is the empty line ending a header.
+func isBlankLine(line []byte) bool {
+	return string(line) == "\r\n" || string(line) == "\n"
+}
+
+// lineEnding returns the end of line, CRLF or LF.
+func lineEnding(line []byte) string {
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// responseFraming returns how the body of a response to req ends: chunked, after length
+// bytes, or when the connection closes when length is -1.
+func responseFraming(req *http.Request, resp *http.Response) (bool, int64) {
+	switch {
+	case req.Method == http.MethodHead, resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false, 0
+	case slices.Contains(resp.TransferEncoding, "chunked"):
+		return true, 0
+	}
+	return false, resp.ContentLength
+}
+
+// copyBody copies a body from br to dst as received, recording its data to record. A chunked
+// body is copied with its chunk sizes and trailers, only the chunk data is recorded; otherwise
+// length bytes are copied, or everything until br ends when length is -1.
+func copyBody(dst io.Writer, br *bufio.Reader, record io.Writer, chunked bool, length int64) error {
+	// Recorded first: the response may be recorded as soon as the data is sent
+	data := io.MultiWriter(record, dst)
+
+	switch {
+	case !chunked && length < 0:
+		_, err := io.Copy(data, br)
+		return err
+	case !chunked:
+		_, err := io.CopyN(data, br, length)
+		return err
+	}
+
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			return fmt.Errorf("invalid chunk: %w", err)
+		}
+		if _, err := dst.Write(line); err != nil {
+			return err
+		}
+
+		sizeField, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(";"))
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(sizeField)), 16, 63)
+		if err != nil {
+			return fmt.Errorf("invalid chunk size %q", sizeField)
+		}
+
+		if size == 0 {
+			trailer, err := readHeader(br)
+			if err != nil {
+				return fmt.Errorf("invalid chunk trailer: %w", err)
+			}
+			_, err = dst.Write(trailer)
+			return err
+		}
+
+		if _, err := io.CopyN(data, br, int64(size)); err != nil {
+			return err
+		}
+		end, err := br.ReadSlice('\n')
+		if err != nil {
+			return fmt.Errorf("invalid chunk: %w", err)
+		}
+		if _, err := dst.Write(end); err != nil {
+			return err
+		}
+	}
+}
+
+// bodyRecorder records the first bytes of a body, and counts all of them. A request body
+// may still be sent while its response is recorded.
+type bodyRecorder struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	size int64
+}
+
+func (br *bodyRecorder) Write(p []byte) (int, error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.size += int64(len(p))
+	if room := har.MaxBodySize - br.buf.Len(); room > 0 {
+		br.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// recorded returns the recorded body.
+func (br *bodyRecorder) recorded() har.Body {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	return har.Body{Data: bytes.Clone(br.buf.Bytes()), Size: br.size}
+}
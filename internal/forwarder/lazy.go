@@ -0,0 +1,104 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultIdleTimeout is how long the tunnel of a lazy forwarder stays open without connections.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// errIdle ends a tunnel closed for lack of connections. It is not retried: the next
+// connection opens a new tunnel.
+var errIdle = errors.New("tunnel closed after the idle timeout")
+
+// idleCheckInterval is how often an idle timeout is checked.
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	return max(min(timeout/4, time.Second), time.Millisecond)
+}
+
+// waitDemand waits in the Idle state until a local connection is held for a tunnel. It
+// returns at once when one is already held, and false when ctx is done.
+func (f *Forwarder) waitDemand(ctx context.Context, log *zerolog.Logger) bool {
+	idle := false
+	for {
+		f.mu.Lock()
+		held := f.held
+		if f.heldChanged == nil {
+			f.heldChanged = make(chan struct{})
+		}
+		changed := f.heldChanged
+		f.mu.Unlock()
+
+		if held > 0 {
+			if idle {
+				log.Info().Msgf("WAKE - Forwarder %s: opening the tunnel for a new connection", f.forwarderInfo())
+			}
+			return true
+		}
+
+		if !idle {
+			log.Debug().Msgf("Forwarder %s idle, waiting for a connection", f.forwarderInfo())
+			f.transition(StateIdle, nil, time.Time{})
+			idle = true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// notifyHeldLocked wakes up an idle forwarder once a connection is held. f.mu must be held.
+func (f *Forwarder) notifyHeldLocked() {
+	if f.heldChanged != nil {
+		close(f.heldChanged)
+	}
+	f.heldChanged = make(chan struct{})
+}
+
+// closeIdlePool closes the tunnels of a balanced pool once none of them had a connection
+// for the idle timeout, and reports whether the pool is empty.
+func (f *Forwarder) closeIdlePool(pool map[types.UID]*tunnel) bool {
+	for _, t := range pool {
+		if !t.idle(f.idleTimeout) {
+			return false
+		}
+	}
+
+	for uid, t := range pool {
+		if t.closeIdle(f.idleTimeout) {
+			delete(pool, uid)
+			f.removeTunnel(t)
+			t.close()
+		}
+	}
+	return len(pool) == 0
+}
+
+// idle reports whether the tunnel had no connection for timeout.
+func (t *tunnel) idle(timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.conns == 0 && time.Since(t.idleSince) >= timeout
+}
+
+// closeIdle closes the tunnel to new connections when it had none for timeout, and reports
+// whether it did. The port-forward connection is left to close.
+func (t *tunnel) closeIdle(timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed || t.conns > 0 || time.Since(t.idleSince) < timeout {
+		return false
+	}
+	t.closed = true
+	return true
+}
@@ -0,0 +1,417 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultHoldTimeout is how long a new local connection waits for the tunnel
+// when the forwarder is not ready.
+const DefaultHoldTimeout = 30 * time.Second
+
+// boundEndpoint is a bound local endpoint: its listeners, and their accept loops. Once
+// released, the accept loops end without closing the listeners.
+type boundEndpoint struct {
+	listeners []net.Listener
+	released  atomic.Bool
+	serving   sync.WaitGroup
+}
+
+// bind binds the configured local ports and sockets that are not bound yet and starts accepting
+// on them. Listeners stay bound across reconnects, until unbind, which also removes the sockets.
+// Endpoints inherited from a predecessor are left to it until they are adopted.
+func (f *Forwarder) bind(ctx context.Context, log *zerolog.Logger) error {
+	if f.detached {
+		return nil
+	}
+
+	mappings, err := parsePorts(f.configuration.Ports)
+	if err != nil {
+		return err
+	}
+
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	if f.listeners == nil {
+		f.listeners = make(map[endpoint]*boundEndpoint)
+	}
+
+	socketMode := f.socketMode
+	if socketMode == 0 {
+		socketMode = DefaultSocketMode
+	}
+
+	for _, m := range mappings {
+		e := m.endpoint()
+		if _, bound := f.listeners[e]; bound || f.inheriting[e] || f.released[e] {
+			continue
+		}
+
+		lns, err := listen(e, f.listenAddresses(), socketMode)
+		if err != nil {
+			return err
+		}
+		f.serveLocked(ctx, log, e, lns)
+	}
+
+	return nil
+}
+
+// serveLocked starts accepting on the listeners of an endpoint. f.listenMu must be held.
+func (f *Forwarder) serveLocked(ctx context.Context, log *zerolog.Logger, e endpoint, lns []net.Listener) {
+	b := &boundEndpoint{listeners: lns}
+	f.listeners[e] = b
+
+	for _, ln := range lns {
+		b.serving.Add(1)
+		go func() {
+			defer b.serving.Done()
+			f.serve(ctx, log, ln, e, b)
+		}()
+	}
+}
+
+// boundEndpoints returns the endpoints currently bound.
+func (f *Forwarder) boundEndpoints() []endpoint {
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	endpoints := make([]endpoint, 0, len(f.listeners))
+	for e := range f.listeners {
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// heldEndpoints returns the endpoints bound, and those still inherited from a predecessor.
+func (f *Forwarder) heldEndpoints() []endpoint {
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	endpoints := make([]endpoint, 0, len(f.listeners)+len(f.inheriting))
+	for e := range f.listeners {
+		endpoints = append(endpoints, e)
+	}
+	for e := range f.inheriting {
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// release stops accepting on the given endpoints and returns their listeners, still open,
+// for another forwarder to adopt. Endpoints still inherited are released by the predecessor,
+// those that are not bound are skipped, and released endpoints are not bound again. It waits
+// for an adoption in progress: locks are taken from the successor to the predecessor.
+func (f *Forwarder) release(endpoints []endpoint) map[endpoint][]net.Listener {
+	f.handoverMu.Lock()
+	defer f.handoverMu.Unlock()
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	if f.released == nil {
+		f.released = make(map[endpoint]bool)
+	}
+
+	released := make(map[endpoint][]net.Listener)
+	var inherited []endpoint
+	for _, e := range endpoints {
+		f.released[e] = true
+		if f.inheriting[e] {
+			delete(f.inheriting, e)
+			inherited = append(inherited, e)
+			continue
+		}
+		b, ok := f.listeners[e]
+		if !ok {
+			continue
+		}
+		delete(f.listeners, e)
+
+		// A past deadline interrupts the pending Accept calls
+		b.released.Store(true)
+		for _, ln := range b.listeners {
+			if d, ok := ln.(interface{ SetDeadline(time.Time) error }); ok {
+				_ = d.SetDeadline(time.Now())
+			}
+		}
+		b.serving.Wait()
+		for _, ln := range b.listeners {
+			if d, ok := ln.(interface{ SetDeadline(time.Time) error }); ok {
+				_ = d.SetDeadline(time.Time{})
+			}
+		}
+
+		released[e] = b.listeners
+	}
+
+	// The handover of f is still pending: the predecessor holds them
+	if len(inherited) > 0 {
+		for e, lns := range f.predecessor.from.release(inherited) {
+			released[e] = lns
+		}
+	}
+	return released
+}
+
+// adopt starts accepting on listeners released by a predecessor, which ends the inheritance:
+// endpoints not released are left to bind. The listeners are closed instead when ctx is done.
+func (f *Forwarder) adopt(ctx context.Context, log *zerolog.Logger, listeners map[endpoint][]net.Listener) {
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	if f.listeners == nil {
+		f.listeners = make(map[endpoint]*boundEndpoint)
+	}
+	f.inheriting = nil
+
+	for e, lns := range listeners {
+		if ctx.Err() != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			continue
+		}
+		f.serveLocked(ctx, log, e, lns)
+	}
+}
+
+// listenAddresses returns the addresses local ports are bound to: the configured address,
+// or the loopback addresses.
+func (f *Forwarder) listenAddresses() []string {
+	if f.configuration.Address != "" {
+		return []string{f.configuration.Address}
+	}
+	return localAddresses
+}
+
+// unbind closes all listeners and waits for the accept loops to end.
+func (f *Forwarder) unbind() {
+	f.listenMu.Lock()
+	var unbound []*boundEndpoint
+	for e, b := range f.listeners {
+		for _, ln := range b.listeners {
+			ln.Close()
+		}
+		delete(f.listeners, e)
+		unbound = append(unbound, b)
+	}
+	f.listenMu.Unlock()
+
+	for _, b := range unbound {
+		b.serving.Wait()
+	}
+}
+
+// serve accepts connections on ln until it is closed or released, and attaches each one to
+// the tunnel. Transient accept errors, such as running out of file descriptors, are retried
+// with a backoff. Other errors close the endpoint, which is bound again on the next bind.
+func (f *Forwarder) serve(ctx context.Context, log *zerolog.Logger, ln net.Listener, e endpoint, b *boundEndpoint) {
+	var delay time.Duration
+	for {
+		local, err := ln.Accept()
+		if err != nil {
+			if b.released.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			if transientAcceptError(err) {
+				delay = min(max(2*delay, acceptRetryMin), acceptRetryMax)
+				log.Warn().Err(err).Msgf("Error accepting connection on %s, retrying in %s", e, delay)
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+				continue
+			}
+
+			log.Error().Err(err).Msgf("Error accepting connection on %s, closing it", e)
+			// release waits for this loop under f.listenMu
+			go f.drop(e, b)
+			return
+		}
+		delay = 0
+
+		if f.tlsConfig != nil {
+			local = tls.Server(local, f.tlsConfig)
+		}
+
+		go f.attach(ctx, log, local, e)
+	}
+}
+
+// Bounds of the delay between retries of a transient accept error.
+const (
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = time.Second
+)
+
+// transientAcceptError reports whether an accept error is expected to clear up by itself.
+func transientAcceptError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// drop closes the listeners of an endpoint that failed and forgets it, unless it was
+// released or unbound in the meantime.
+func (f *Forwarder) drop(e endpoint, b *boundEndpoint) {
+	f.listenMu.Lock()
+	defer f.listenMu.Unlock()
+
+	if f.listeners[e] != b {
+		return
+	}
+	delete(f.listeners, e)
+	for _, ln := range b.listeners {
+		ln.Close()
+	}
+}
+
+// attach hands a local connection to a tunnel in rotation. While the forwarder is
+// not ready, the connection is held until a tunnel is up or the hold timeout expires,
+// in which case it is closed.
+func (f *Forwarder) attach(ctx context.Context, log *zerolog.Logger, local net.Conn, e endpoint) {
+	deadline := time.Now().Add(f.holdTimeout)
+
+	var previous *tunnel
+	for {
+		t := f.waitTunnel(ctx, deadline, previous)
+		if t == nil {
+			log.Debug().Msgf("Closing connection on %s: forwarder %s not ready within %s", e, f.configuration.Name, f.holdTimeout)
+			local.Close()
+			return
+		}
+
+		if t.dispatch(local, e) {
+			return
+		}
+
+		// The tunnel closed in the meantime, wait for the next one
+		previous = t
+	}
+}
+
+// Attach hands a connection to the tunnel of a local port, as if it was accepted on it,
+// and returns once it is forwarded or closed. See WithoutListeners.
+func (f *Forwarder) Attach(ctx context.Context, conn net.Conn, port int) {
+	f.attach(ctx, zerolog.Ctx(ctx), conn, endpoint{port: port})
+}
+
+// addTunnel puts a tunnel in rotation and wakes up held connections.
+func (f *Forwarder) addTunnel(t *tunnel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tunnels = append(f.tunnels, t)
+	f.notifyTunnelsLocked()
+}
+
+// removeTunnel takes a tunnel out of rotation. Connections already attached to it are not affected.
+func (f *Forwarder) removeTunnel(t *tunnel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tunnels = slices.DeleteFunc(f.tunnels, func(other *tunnel) bool {
+		return other == t
+	})
+	f.notifyTunnelsLocked()
+}
+
+// notifyTunnelsLocked wakes up the connections waiting for a tunnel. f.mu must be held.
+func (f *Forwarder) notifyTunnelsLocked() {
+	if f.tunnelChanged != nil {
+		close(f.tunnelChanged)
+	}
+	f.tunnelChanged = make(chan struct{})
+}
+
+// pickTunnelLocked returns the tunnel the next connection is attached to, other than
+// previous, or nil when there is none. f.mu must be held.
+// Round-robin rotates over the tunnels; least-connections picks the tunnel with the fewest
+// active connections, rotating between ties.
+func (f *Forwarder) pickTunnelLocked(previous *tunnel) *tunnel {
+	var picked *tunnel
+	pickedIndex := 0
+
+	for i := range f.tunnels {
+		index := (f.nextTunnel + i) % len(f.tunnels)
+		t := f.tunnels[index]
+		if t == previous {
+			continue
+		}
+
+		if picked == nil || (f.leastConnections && t.active.Load() < picked.active.Load()) {
+			picked = t
+			pickedIndex = index
+		}
+		if !f.leastConnections {
+			break
+		}
+	}
+
+	if picked != nil {
+		f.nextTunnel = pickedIndex + 1
+	}
+	return picked
+}
+
+// waitTunnel returns a tunnel in rotation, other than previous, waiting for one until
+// the deadline. It returns nil on timeout or when ctx is done.
+func (f *Forwarder) waitTunnel(ctx context.Context, deadline time.Time, previous *tunnel) *tunnel {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	// A connection without tunnel is held, which wakes up an idle forwarder
+	held := false
+	defer func() {
+		if held {
+			f.mu.Lock()
+			f.held--
+			f.mu.Unlock()
+		}
+	}()
+
+	for {
+		f.mu.Lock()
+		t := f.pickTunnelLocked(previous)
+		if t == nil && !held {
+			held = true
+			f.held++
+			f.notifyHeldLocked()
+		}
+		if f.tunnelChanged == nil {
+			f.tunnelChanged = make(chan struct{})
+		}
+		changed := f.tunnelChanged
+		f.mu.Unlock()
+
+		if t != nil {
+			return t
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
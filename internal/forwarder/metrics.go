@@ -0,0 +1,126 @@
+package forwarder
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PortMetrics are the connection counters of one forwarded local port or socket.
+type PortMetrics struct {
+	// Port is the local port, 0 for sockets; Socket is the socket path
+	Port   int
+	Socket string
+
+	ActiveConnections int64
+	TotalConnections  uint64
+
+	// BytesIn are received from the pod (written to local clients),
+	// BytesOut are sent to the pod (read from local clients)
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Metrics are the connection counters of a forwarder, in total and per local port.
+type Metrics struct {
+	Name string
+
+	ActiveConnections int64
+	TotalConnections  uint64
+	BytesIn           uint64
+	BytesOut          uint64
+
+	// Ports are sorted by port number, sockets last
+	Ports []PortMetrics
+}
+
+// portCounters holds the live counters of a local port or socket.
+type portCounters struct {
+	active   atomic.Int64
+	total    atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+// metrics holds the counters of all local endpoints of a forwarder.
+// Counters survive reconnections so totals cover the forwarder lifetime.
+type metrics struct {
+	mu    sync.Mutex
+	ports map[endpoint]*portCounters
+}
+
+// port returns the counters of a local endpoint, creating them if needed.
+func (m *metrics) port(e endpoint) *portCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ports == nil {
+		m.ports = make(map[endpoint]*portCounters)
+	}
+
+	c, exists := m.ports[e]
+	if !exists {
+		c = &portCounters{}
+		m.ports[e] = c
+	}
+	return c
+}
+
+// snapshot returns the current value of all counters.
+func (m *metrics) snapshot() Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result Metrics
+	for e, c := range m.ports {
+		pm := PortMetrics{
+			Port:              e.port,
+			Socket:            e.socket,
+			ActiveConnections: c.active.Load(),
+			TotalConnections:  c.total.Load(),
+			BytesIn:           c.bytesIn.Load(),
+			BytesOut:          c.bytesOut.Load(),
+		}
+
+		result.ActiveConnections += pm.ActiveConnections
+		result.TotalConnections += pm.TotalConnections
+		result.BytesIn += pm.BytesIn
+		result.BytesOut += pm.BytesOut
+		result.Ports = append(result.Ports, pm)
+	}
+
+	slices.SortFunc(result.Ports, func(a, b PortMetrics) int {
+		if (a.Socket == "") != (b.Socket == "") {
+			if a.Socket == "" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(a.Port-b.Port, strings.Compare(a.Socket, b.Socket))
+	})
+
+	return result
+}
+
+// countingWriter adds the number of bytes written to a counter.
+type countingWriter struct {
+	w       io.Writer
+	counter *atomic.Uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(uint64(n))
+	return n, err
+}
+
+// Metrics returns a snapshot of the connection counters of the forwarder.
+// It is safe for concurrent use.
+func (f *Forwarder) Metrics() Metrics {
+	m := f.metrics.snapshot()
+	m.Name = f.configuration.Name
+	return m
+}
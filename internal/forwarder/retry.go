@@ -0,0 +1,158 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// RetryAction tells the forwarder what to do after an error.
+type RetryAction int
+
+const (
+	RetryActionRetry RetryAction = iota // Retry after the policy backoff
+	RetryActionStop                     // Stop the forwarder
+)
+
+// RetryPolicy defines how the forwarder reacts to one type of error.
+type RetryPolicy struct {
+	Action  RetryAction
+	Backoff RetryConfig
+}
+
+// RetryPolicies maps error types to their retry policy.
+// Error types without a policy are retried with the forwarder's base RetryConfig.
+type RetryPolicies map[locator.ErrorType]RetryPolicy
+
+// DefaultRetryPolicies returns the default policy table:
+// transient errors are absent and keep the fast base backoff, missing pods are
+// retried at a slower cadence, and errors that need a configuration or RBAC
+// change are retried only every few minutes.
+func DefaultRetryPolicies() RetryPolicies {
+	slow := RetryPolicy{
+		Action: RetryActionRetry,
+		Backoff: RetryConfig{
+			InitialDelay: 1 * time.Second,
+			MaxDelay:     1 * time.Minute,
+			Multiplier:   2,
+			Jitter:       true,
+		},
+	}
+
+	longInterval := RetryPolicy{
+		Action: RetryActionRetry,
+		Backoff: RetryConfig{
+			InitialDelay: 5 * time.Minute,
+			MaxDelay:     5 * time.Minute,
+			Multiplier:   1,
+			Jitter:       true,
+		},
+	}
+
+	return RetryPolicies{
+		locator.ErrorTypeResourceNotFound: slow,
+		locator.ErrorTypePodFailed:        slow,
+		locator.ErrorTypeNoPodAvailable:   slow,
+		locator.ErrorTypeNoPodOnNode:      slow,
+		locator.ErrorTypeConfigInvalid:    longInterval,
+		locator.ErrorTypePermissionDenied: longInterval,
+	}
+}
+
+// RetryPoliciesFromConfig overrides the default policy table with the configured policies.
+// Unset fields keep the default policy of the error type (or the default retry config).
+func RetryPoliciesFromConfig(cfg map[string]config.RetryPolicyConfiguration) (RetryPolicies, error) {
+	policies := DefaultRetryPolicies()
+
+	for name, pc := range cfg {
+		errType, err := locator.ParseErrorType(name)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, exists := policies[errType]
+		if !exists {
+			policy = RetryPolicy{Action: RetryActionRetry, Backoff: DefaultRetryConfig()}
+		}
+
+		switch pc.Action {
+		case "", "retry":
+			policy.Action = RetryActionRetry
+		case "stop":
+			policy.Action = RetryActionStop
+		default:
+			return nil, fmt.Errorf("invalid retry action for %s: %s", name, pc.Action)
+		}
+
+		if pc.InitialDelay != "" {
+			if policy.Backoff.InitialDelay, err = time.ParseDuration(pc.InitialDelay); err != nil {
+				return nil, fmt.Errorf("invalid initial delay for %s: %w", name, err)
+			}
+		}
+		if pc.MaxDelay != "" {
+			if policy.Backoff.MaxDelay, err = time.ParseDuration(pc.MaxDelay); err != nil {
+				return nil, fmt.Errorf("invalid max delay for %s: %w", name, err)
+			}
+		}
+		if pc.Multiplier != 0 {
+			policy.Backoff.Multiplier = pc.Multiplier
+		}
+
+		if policy.Backoff.MaxDelay < policy.Backoff.InitialDelay {
+			policy.Backoff.MaxDelay = policy.Backoff.InitialDelay
+		}
+
+		policies[errType] = policy
+	}
+
+	return policies, nil
+}
+
+// policy returns the retry policy for an error type.
+func (f *Forwarder) policy(errType locator.ErrorType) RetryPolicy {
+	if p, ok := f.retryPolicies[errType]; ok {
+		return p
+	}
+	return RetryPolicy{Action: RetryActionRetry, Backoff: f.retryConfig}
+}
+
+// retry applies the retry policy of err: it waits for the backoff delay (Backoff state)
+// and returns true, or returns false when the forwarder must stop (Failed state).
+// The attempt counter restarts when the type of error changes.
+func (f *Forwarder) retry(ctx context.Context, log *zerolog.Logger, err error) bool {
+	errType := locator.GetErrorType(err)
+	if errType != f.errorType {
+		f.errorType = errType
+		f.attempt = 0
+	}
+
+	if f.policy(errType).Action == RetryActionStop {
+		f.transition(StateFailed, err, time.Time{})
+		log.Error().Err(err).Str("error_type", errType.String()).
+			Msgf("FAILED - Forwarder %s: giving up on %s error, fix the configuration or permissions and reload", f.forwarderInfo(), errType)
+		return false
+	}
+
+	delay := f.calculateBackoff()
+	f.attempt++
+	f.transition(StateBackoff, err, time.Now().Add(delay))
+
+	if isPermanentError(errType) {
+		log.Error().Err(err).Str("error_type", errType.String()).
+			Msgf("PERMANENT ERROR - Forwarder %s: %s error will not resolve without a configuration or permission change, next attempt in %s", f.forwarderInfo(), errType, delay.Round(time.Second))
+	}
+
+	sleep(ctx, delay)
+
+	return true
+}
+
+// isPermanentError reports whether an error type needs a human to fix it.
+func isPermanentError(errType locator.ErrorType) bool {
+	return errType == locator.ErrorTypeConfigInvalid || errType == locator.ErrorTypePermissionDenied
+}
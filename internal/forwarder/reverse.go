@@ -0,0 +1,191 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// reverseRetryDelay is how long an idle reverse connection waits before being replaced when
+// the relay pod closed it without handing over an in-cluster connection, or the local address
+// refused it. The delay doubles on consecutive failures, up to reverseMaxRetryDelay.
+var (
+	reverseRetryDelay    = time.Second
+	reverseMaxRetryDelay = 30 * time.Second
+)
+
+// reverseDialTimeout bounds the connection to the local address of a reverse forward.
+const reverseDialTimeout = 5 * time.Second
+
+// serveReverse keeps idle connections open to the tunnel port of the relay pod, each piped to
+// a connection to the local address. The relay pod pairs each in-cluster connection with one of
+// them; once the in-cluster client sends data, it is replaced by a new idle one. Data the local
+// process sends first waits in the tunnel until a client is paired.
+// It returns nil when ctx is done, or an error once the connection to the pod is lost.
+func (f *Forwarder) serveReverse(ctx context.Context, log *zerolog.Logger, conn httpstream.Connection, target locator.Target) error {
+	t, err := newTargetTunnel(conn, log, &f.metrics, target)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	e := endpoint{port: f.reverse.Port}
+
+	var wg sync.WaitGroup
+	defer func() {
+		// Closing the tunnel ends idle and piped connections
+		t.close()
+		wg.Wait()
+	}()
+
+	connections := max(f.reverse.Connections, 1)
+	for range connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.holdReverse(ctx, log, t, e, &wg)
+		}()
+	}
+
+	withTarget(log.Info(), target).Msgf("READY - Forwarder %s", f.forwarderInfo())
+	f.attempt = 0
+	f.errorType = locator.ErrorTypeUnknown
+	f.transition(StateReady, nil, time.Time{})
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-conn.CloseChan():
+		return errors.New("lost connection to pod")
+	}
+}
+
+// holdReverse keeps one idle connection to the relay pod until the tunnel closes. Each one is
+// connected to the local address at once, so that servers speaking first can greet the
+// in-cluster client, and handed to pipeReverse. While the local address refuses connections,
+// attempts back off and the outage is logged once.
+func (f *Forwarder) holdReverse(ctx context.Context, log *zerolog.Logger, t *tunnel, e endpoint, wg *sync.WaitGroup) {
+	var delay time.Duration
+	refused := false
+	backoff := func() {
+		delay = min(max(2*delay, reverseRetryDelay), reverseMaxRetryDelay)
+		sleep(ctx, delay)
+	}
+
+	for ctx.Err() == nil {
+		remote, err := t.open(e)
+		if err != nil {
+			return
+		}
+
+		local, err := net.DialTimeout("tcp", f.reverse.Address, reverseDialTimeout)
+		if err != nil {
+			// Reported once per outage, and by one of the idle connections
+			event := log.Debug()
+			if f.reverseDown.CompareAndSwap(false, true) {
+				event = log.Warn()
+			}
+			event.Err(err).Msgf("Cannot connect to %s for forwarder %s", f.reverse.Address, f.configuration.Name)
+			remote.Close()
+			refused = true
+			backoff()
+			continue
+		}
+		if refused {
+			refused, delay = false, 0
+		}
+		if f.reverseDown.CompareAndSwap(true, false) {
+			log.Info().Msgf("Connected again to %s for forwarder %s", f.reverse.Address, f.configuration.Name)
+		}
+
+		// paired reports whether an in-cluster client sent data, or false when the connection
+		// ended before, e.g. because the relay pod or the local process closed it
+		paired := make(chan bool, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.pipeReverse(log, remote, local, f.metrics.port(e), paired)
+		}()
+
+		// Blocks until an in-cluster client is paired with this connection and sends data
+		select {
+		case ok := <-paired:
+			if !ok {
+				backoff()
+			} else {
+				delay = 0
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pipeReverse pipes a connection to the relay pod to a local connection. It counts the
+// connection and reports it on paired once the in-cluster client sends data, or reports false
+// when the connection ends without.
+func (f *Forwarder) pipeReverse(log *zerolog.Logger, remote net.Conn, local net.Conn, counters *portCounters, paired chan<- bool) {
+	defer remote.Close()
+	defer local.Close()
+
+	var once sync.Once
+	counted := false
+	defer func() {
+		once.Do(func() { paired <- false })
+		if counted {
+			counters.active.Add(-1)
+		}
+	}()
+
+	// Bytes coming from the pod are counted in, bytes sent back to the pod out
+	toLocal := &countingWriter{w: local, counter: &counters.bytesIn}
+	first := &pairingWriter{w: toLocal, paired: func() {
+		once.Do(func() {
+			log.Debug().Msgf("Handling reverse connection for %s", f.reverse.Address)
+			counters.total.Add(1)
+			counters.active.Add(1)
+			counted = true
+			paired <- true
+		})
+	}}
+
+	remoteDone := make(chan struct{})
+	go func() {
+		defer close(remoteDone)
+		if _, err := io.Copy(first, remote); err != nil && !isClosedError(err) {
+			log.Debug().Err(err).Msg("Error copying from remote stream to local connection")
+		}
+		// Tell the local process no more data is coming
+		if tcp, ok := local.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+	}()
+
+	if _, err := io.Copy(&countingWriter{w: remote, counter: &counters.bytesOut}, local); err != nil && !isClosedError(err) {
+		log.Debug().Err(err).Msg("Error copying from local connection to remote stream")
+	}
+
+	remote.Close()
+	<-remoteDone
+}
+
+// pairingWriter calls paired before the first bytes are written.
+type pairingWriter struct {
+	w      io.Writer
+	paired func()
+}
+
+func (p *pairingWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		p.paired()
+	}
+	return p.w.Write(b)
+}
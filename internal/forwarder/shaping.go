@@ -0,0 +1,194 @@
+package forwarder
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/mxk/go-flowrate/flowrate"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// shapedQueue bounds the chunks waiting for their latency, blocking the copy beyond it.
+const shapedQueue = 64
+
+// shaping throttles and delays the data of connections. Rates are in bytes per second,
+// unlimited when 0; latency, give or take jitter, is added to each direction.
+type shaping struct {
+	rateIn  int64
+	rateOut int64
+	latency time.Duration
+	jitter  time.Duration
+}
+
+// parseShaping parses a shaping configuration, nil when there is none.
+func parseShaping(cfg *config.ShapingConfiguration) (*shaping, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	s := &shaping{}
+
+	for _, rate := range []struct {
+		value string
+		dest  *int64
+	}{{cfg.RateIn, &s.rateIn}, {cfg.RateOut, &s.rateOut}} {
+		if rate.value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(rate.value)
+		if err != nil || q.Sign() < 0 {
+			return nil, fmt.Errorf("invalid rate %s", rate.value)
+		}
+		*rate.dest = q.Value()
+	}
+
+	var err error
+	if s.latency, err = parseDurationOr(cfg.Latency, 0); err != nil {
+		return nil, fmt.Errorf("invalid latency: %w", err)
+	}
+	if s.jitter, err = parseDurationOr(cfg.Jitter, 0); err != nil {
+		return nil, fmt.Errorf("invalid jitter: %w", err)
+	}
+
+	return s, nil
+}
+
+// delay returns the latency of a chunk, with jitter.
+func (s *shaping) delay() time.Duration {
+	d := s.latency
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*s.jitter))) - s.jitter
+	}
+	return max(d, 0)
+}
+
+// SetShaping changes the shaping of the connections while the forwarder runs, including
+// the connections already open.
+func (f *Forwarder) SetShaping(cfg *config.ShapingConfiguration) error {
+	s, err := parseShaping(cfg)
+	if err != nil {
+		return err
+	}
+
+	f.configuration.Shaping = cfg
+	f.shaping.Store(s)
+	return nil
+}
+
+// shapedWriter throttles writes to w at the rate of the current shaping, and delays
+// them by its latency while keeping their order. Settings are read on each write, so
+// that changes apply to open connections.
+type shapedWriter struct {
+	w       io.Writer
+	current func() *shaping
+	in      bool
+	monitor *flowrate.Monitor
+
+	// chunks waiting for their latency are written by a goroutine started on the first one;
+	// pending counts them so that writes are direct when nothing is waiting
+	chunks  chan shapedChunk
+	done    chan struct{}
+	pending atomic.Int64
+	release time.Time
+	err     atomic.Pointer[error]
+}
+
+// shapedChunk is data written once its release time is reached.
+type shapedChunk struct {
+	data    []byte
+	release time.Time
+}
+
+// newShapedWriter shapes writes to w with the settings returned by current, for the data
+// coming from the pod when in is set, going to it otherwise.
+func newShapedWriter(w io.Writer, current func() *shaping, in bool) *shapedWriter {
+	return &shapedWriter{w: w, current: current, in: in, monitor: flowrate.New(0, 0)}
+}
+
+func (sw *shapedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := sw.err.Load(); err != nil {
+			return written, *err
+		}
+
+		s := sw.current()
+		if s == nil {
+			s = &shaping{}
+		}
+
+		rate := s.rateOut
+		if sw.in {
+			rate = s.rateIn
+		}
+		n := sw.monitor.Limit(len(p), rate, true)
+		chunk := p[:n]
+
+		if delay := s.delay(); delay > 0 || sw.pending.Load() > 0 {
+			sw.enqueue(chunk, delay)
+		} else if _, err := sw.w.Write(chunk); err != nil {
+			sw.err.Store(&err)
+			return written, err
+		}
+
+		sw.monitor.Update(n)
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// enqueue queues a copy of data, released after delay but never before the previous chunk.
+func (sw *shapedWriter) enqueue(data []byte, delay time.Duration) {
+	if sw.chunks == nil {
+		sw.chunks = make(chan shapedChunk, shapedQueue)
+		sw.done = make(chan struct{})
+		go sw.run()
+	}
+
+	release := time.Now().Add(delay)
+	if release.Before(sw.release) {
+		release = sw.release
+	}
+	sw.release = release
+
+	sw.pending.Add(1)
+	sw.chunks <- shapedChunk{data: append([]byte(nil), data...), release: release}
+}
+
+// run writes the queued chunks at their release time, until flush.
+func (sw *shapedWriter) run() {
+	defer close(sw.done)
+
+	for c := range sw.chunks {
+		if wait := time.Until(c.release); wait > 0 {
+			time.Sleep(wait)
+		}
+		if sw.err.Load() == nil {
+			if _, err := sw.w.Write(c.data); err != nil {
+				sw.err.Store(&err)
+			}
+		}
+		sw.pending.Add(-1)
+	}
+}
+
+// flush waits for the queued chunks to be written, once the copy is done. It is a no-op
+// on a nil writer.
+func (sw *shapedWriter) flush() {
+	if sw == nil {
+		return
+	}
+
+	sw.monitor.Done()
+	if sw.chunks != nil {
+		close(sw.chunks)
+		<-sw.done
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package forwarder
+
+import "syscall"
+
+// shutdownSocket shuts down both directions of a socket, waking up its blocked reads.
+func shutdownSocket(fd uintptr) {
+	_ = syscall.Shutdown(int(fd), syscall.SHUT_RDWR)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package forwarder
+
+import "syscall"
+
+// shutdownSocket shuts down both directions of a socket and cancels its pending operations,
+// waking up its blocked reads.
+func shutdownSocket(fd uintptr) {
+	_ = syscall.Shutdown(syscall.Handle(fd), syscall.SHUT_RDWR)
+	_ = syscall.CancelIoEx(syscall.Handle(fd), nil)
+}
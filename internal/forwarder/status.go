@@ -0,0 +1,109 @@
+package forwarder
+
+import (
+	"time"
+
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// State is a step of the forwarder lifecycle.
+type State string
+
+const (
+	StateIdle       State = "idle"       // Lazy forwarder with its ports bound, waiting for a connection to open the tunnel
+	StateLocating   State = "locating"   // Looking for the target pod
+	StateConnecting State = "connecting" // Pod located, establishing the port-forward tunnel
+	StateReady      State = "ready"      // Tunnel established, ports are forwarded
+	StateBackoff    State = "backoff"    // Waiting before the next attempt after an error
+	StateFailed     State = "failed"     // Stopped by the retry policy, needs a configuration change
+	StateStopped    State = "stopped"    // Not running (not started yet, or context cancelled)
+)
+
+// Status is a snapshot of a forwarder state.
+type Status struct {
+	Name  string
+	State State
+
+	// Since is when the forwarder entered State
+	Since time.Time
+
+	// LastError is the last error encountered, kept across states until the next error
+	LastError error
+
+	// Attempt is the number of consecutive failed attempts for the current error type
+	Attempt uint
+
+	// NextRetry is when the next attempt starts (Backoff state only)
+	NextRetry time.Time
+
+	// Target is the last located pod
+	Target locator.Target
+
+	// Targets are the pods in rotation, in the order they joined it
+	Targets []locator.Target
+
+	// LastProbe is when the tunnel was last health checked, ProbeFailures the number of
+	// consecutive failed probes and LastProbeError the last probe error
+	LastProbe      time.Time
+	ProbeFailures  int
+	LastProbeError error
+
+	// FaultSeed is the seed of the injected faults, drawn at random when none is configured;
+	// 0 without faults
+	FaultSeed int64
+}
+
+// Status returns a snapshot of the forwarder state. It is safe for concurrent use.
+func (f *Forwarder) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := f.status
+	status.Name = f.configuration.Name
+	status.Target = f.target
+	if f.faults != nil {
+		status.FaultSeed = f.faults.seed
+	}
+	for _, t := range f.tunnels {
+		status.Targets = append(status.Targets, t.target)
+	}
+	if status.State == "" {
+		status.State = StateStopped
+	}
+
+	return status
+}
+
+// transition moves the forwarder to a new state. err is recorded as the last error
+// when non-nil, and nextRetry is only kept for the Backoff state.
+func (f *Forwarder) transition(state State, err error, nextRetry time.Time) {
+	f.mu.Lock()
+
+	f.status.State = state
+	f.status.Since = time.Now()
+	f.status.Attempt = f.attempt
+	if err != nil {
+		f.status.LastError = err
+	}
+	f.status.NextRetry = time.Time{}
+	if state == StateBackoff {
+		f.status.NextRetry = nextRetry
+	}
+	if state == StateReady {
+		f.status.ProbeFailures = 0
+	}
+
+	status := f.status
+	status.Name = f.configuration.Name
+	status.Target = f.target
+
+	f.mu.Unlock()
+
+	if state == StateReady || state == StateIdle {
+		f.predecessor.markReady()
+	}
+
+	if f.onTransition != nil {
+		f.onTransition(status)
+	}
+}
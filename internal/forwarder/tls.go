@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+
+	"github.com/codozor/fwkeeper/internal/certs"
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// WithAuthority sets the certificate authority issuing the certificates of self-signed TLS.
+func WithAuthority(authority *certs.Authority) Option {
+	return func(f *Forwarder) {
+		f.authority = authority
+	}
+}
+
+// serverTLS builds the TLS configuration of the local ports, nil when TLS is not terminated.
+func (f *Forwarder) serverTLS(cfg *config.TLSConfiguration) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	var err error
+	if cfg.SelfSigned {
+		if f.authority == nil {
+			return nil, errors.New("self-signed certificate without a certificate authority")
+		}
+		cert, err = f.authority.Issue(f.tlsHosts(cfg))
+	} else {
+		cert, err = tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// tlsHosts returns the hosts a self-signed certificate is issued for: localhost, the local
+// addresses, the hostname and the configured hosts.
+func (f *Forwarder) tlsHosts(cfg *config.TLSConfiguration) []string {
+	hosts := append([]string{"localhost"}, f.listenAddresses()...)
+	if f.configuration.Hostname != "" {
+		hosts = append(hosts, f.configuration.Hostname)
+	}
+	hosts = append(hosts, cfg.Hosts...)
+
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
@@ -0,0 +1,317 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	portforwardconstants "k8s.io/apimachinery/pkg/util/portforward"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/transport/websocket"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// websocketDialTimeout bounds the WebSocket upgrade: proxies that half-support WebSockets
+// hang instead of failing it.
+var websocketDialTimeout = 10 * time.Second
+
+// spdyDialTimeout bounds the SPDY upgrade, so that a hanging proxy is retried instead of
+// keeping the forwarder connecting. It is longer: there is no transport to fall back to.
+var spdyDialTimeout = 30 * time.Second
+
+// errDialTimeout reports a port-forward upgrade that did not complete in time.
+var errDialTimeout = errors.New("port-forward upgrade timed out")
+
+// transportMemoryTTL is how long a transport is remembered: WebSocket is tried again once the
+// SPDY fallback expires, in case the upgrade failed for a transient reason.
+const transportMemoryTTL = 10 * time.Minute
+
+// TransportMemory remembers per cluster the transport port-forward connections last
+// succeeded with, so that forwards in auto mode go straight to SPDY on clusters where
+// WebSocket does not work. Transports are forgotten after a while. It is safe for concurrent use.
+type TransportMemory struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	clusters map[string]rememberedTransport
+}
+
+// rememberedTransport is a transport remembered until expires.
+type rememberedTransport struct {
+	transport string
+	expires   time.Time
+}
+
+// NewTransportMemory creates an empty transport memory.
+func NewTransportMemory() *TransportMemory {
+	return &TransportMemory{ttl: transportMemoryTTL, clusters: make(map[string]rememberedTransport)}
+}
+
+// Get returns the transport that last worked on a cluster, empty when unknown, expired or on
+// a nil memory.
+func (m *TransportMemory) Get(cluster string) string {
+	if m == nil {
+		return ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	remembered, ok := m.clusters[cluster]
+	if !ok || !time.Now().Before(remembered.expires) {
+		delete(m.clusters, cluster)
+		return ""
+	}
+	return remembered.transport
+}
+
+// Set remembers the transport that worked on a cluster. It is a no-op on a nil memory.
+func (m *TransportMemory) Set(cluster string, transport string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clusters[cluster] = rememberedTransport{transport: transport, expires: time.Now().Add(m.ttl)}
+}
+
+// WithTransportMemory shares the transports that worked per cluster across forwarders.
+func WithTransportMemory(memory *TransportMemory) Option {
+	return func(f *Forwarder) {
+		f.transports = memory
+	}
+}
+
+// WithDefaultTransport sets the transport of forwarders whose configuration has none.
+func WithDefaultTransport(transport string) Option {
+	return func(f *Forwarder) {
+		f.defaultTransport = transport
+	}
+}
+
+// transportMode returns the configured transport, auto by default.
+func (f *Forwarder) transportMode() string {
+	switch {
+	case f.configuration.Transport != "":
+		return f.configuration.Transport
+	case f.defaultTransport != "":
+		return f.defaultTransport
+	}
+	return config.TransportAuto
+}
+
+// cluster identifies the cluster of the forwarder in the transport memory.
+func (f *Forwarder) cluster() string {
+	if f.restConfig == nil {
+		return ""
+	}
+	return f.restConfig.Host
+}
+
+// rememberTransport records the transport a connection succeeded with in auto mode, WebSocket
+// replacing an expired SPDY fallback. A pinned transport says nothing about what works on the
+// cluster, and is not recorded.
+func (f *Forwarder) rememberTransport(transport string) {
+	if f.transportMode() == config.TransportAuto {
+		f.transports.Set(f.cluster(), transport)
+	}
+}
+
+// dialTransport opens a port-forward connection with the configured transport and returns
+// the one used. In auto mode, WebSocket is tried first unless SPDY is known to be needed on
+// the cluster, and SPDY is used when the WebSocket upgrade fails or times out. Both upgrades
+// are bounded, so that a hanging proxy becomes a retryable error.
+func (f *Forwarder) dialTransport(forwardURL *url.URL, log *zerolog.Logger) (httpstream.Connection, string, error) {
+	mode := f.transportMode()
+	spdyDialer := f.spdyDialer(forwardURL)
+
+	if mode == config.TransportSPDY || (mode == config.TransportAuto && f.transports.Get(f.cluster()) == config.TransportSPDY) {
+		conn, err := dialWithTimeout(spdyDialer, spdyDialTimeout)
+		return conn, config.TransportSPDY, err
+	}
+
+	tunnelingDialer, err := f.websocketDialer(forwardURL)
+	if err != nil {
+		if mode == config.TransportWebSocket {
+			return nil, config.TransportWebSocket, fmt.Errorf("failed to create WebSocket dialer: %w", err)
+		}
+		log.Warn().Err(err).Msg("Failed to create WebSocket dialer, using SPDY only")
+		conn, err := dialWithTimeout(spdyDialer, spdyDialTimeout)
+		return conn, config.TransportSPDY, err
+	}
+
+	conn, err := dialWithTimeout(tunnelingDialer, websocketDialTimeout)
+	if err == nil || mode == config.TransportWebSocket {
+		return conn, config.TransportWebSocket, err
+	}
+	if !errors.Is(err, errDialTimeout) && !httpstream.IsUpgradeFailure(err) && !httpstream.IsHTTPSProxyError(err) {
+		return nil, config.TransportWebSocket, err
+	}
+
+	log.Warn().Err(err).Msgf("Forwarder %s: WebSocket port-forward failed, falling back to SPDY", f.configuration.Name)
+	conn, err = dialWithTimeout(spdyDialer, spdyDialTimeout)
+	return conn, config.TransportSPDY, err
+}
+
+// upgradeDialer opens a port-forward connection with an upgrade request bound to ctx.
+type upgradeDialer func(ctx context.Context) (httpstream.Connection, error)
+
+// spdyDialer dials like the dialer of spdy.NewDialer, with a request bound to the context.
+func (f *Forwarder) spdyDialer(forwardURL *url.URL) upgradeDialer {
+	client := &http.Client{Transport: f.transport}
+
+	return func(ctx context.Context) (httpstream.Connection, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, forwardURL.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		return checkProtocol(spdy.Negotiate(f.upgrader, client, req, portforward.PortForwardProtocolV1Name))
+	}
+}
+
+// websocketDialer dials like the dialer of portforward.NewSPDYOverWebsocketDialer, with a
+// request bound to the context: the WebSocket handshake, proxy included, ends with it.
+func (f *Forwarder) websocketDialer(forwardURL *url.URL) (upgradeDialer, error) {
+	transport, holder, err := websocket.RoundTripperFor(f.restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) (httpstream.Connection, error) {
+		// WebSockets require GET
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, forwardURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		ws, err := websocket.Negotiate(transport, holder, req, portforwardconstants.WebsocketsSPDYTunnelingPrefix+portforward.PortForwardProtocolV1Name)
+		if err != nil {
+			return nil, err
+		}
+
+		protocol := strings.TrimPrefix(ws.Subprotocol(), portforwardconstants.WebsocketsSPDYTunnelingPrefix)
+		conn, err := spdystream.NewClientConnectionWithPings(portforward.NewTunnelingConnection("client", ws), portforward.PingPeriod)
+		return checkProtocol(conn, protocol, err)
+	}, nil
+}
+
+// spdyRoundTripperFor returns the SPDY transport and upgrader of a cluster, as
+// spdy.RoundTripperFor, recording the sockets it dials in the dialAttempt of the request
+// context. They come from the same call, to be compatible.
+func spdyRoundTripperFor(restCfg *rest.Config) (http.RoundTripper, spdy.Upgrader, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(restCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rt, ok := upgrader.(*spdystream.SpdyRoundTripper); ok {
+		rt.Dialer = &net.Dialer{ControlContext: recordSocket}
+	}
+	return transport, upgrader, nil
+}
+
+// dialAttempt records the sockets opened by a dial, to shut them down when it times out:
+// the SPDY upgrade, and the CONNECT request of a proxy, only honor the request context
+// until the connection is established.
+type dialAttempt struct {
+	mu      sync.Mutex
+	sockets []syscall.RawConn
+	aborted bool
+}
+
+type dialAttemptKey struct{}
+
+// recordSocket records a socket in the dialAttempt of ctx, if any. A socket opened once the
+// attempt is aborted is shut down at once.
+func recordSocket(ctx context.Context, network, address string, c syscall.RawConn) error {
+	attempt, ok := ctx.Value(dialAttemptKey{}).(*dialAttempt)
+	if !ok {
+		return nil
+	}
+
+	attempt.mu.Lock()
+	defer attempt.mu.Unlock()
+
+	if attempt.aborted {
+		shutdown(c)
+	}
+	attempt.sockets = append(attempt.sockets, c)
+	return nil
+}
+
+// abort shuts down the sockets of the attempt, so that reads and writes blocked on them return.
+func (a *dialAttempt) abort() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.aborted = true
+	for _, c := range a.sockets {
+		shutdown(c)
+	}
+}
+
+// shutdown shuts a socket down, leaving it to be closed by its connection.
+func shutdown(c syscall.RawConn) {
+	_ = c.Control(shutdownSocket)
+}
+
+// dialWithTimeout dials, giving up after timeout: the request context expires, and the sockets
+// the dial opened are shut down, so that the dial returns instead of waiting on a hanging server
+// or proxy. A connection established after the timeout is closed.
+func dialWithTimeout(dial upgradeDialer, timeout time.Duration) (httpstream.Connection, error) {
+	type result struct {
+		conn httpstream.Connection
+		err  error
+	}
+
+	attempt := &dialAttempt{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), dialAttemptKey{}, attempt), timeout)
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dial(ctx)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		if deadline, _ := ctx.Deadline(); r.err != nil && !time.Now().Before(deadline) {
+			// The dial honored the deadline before the context expired
+			return nil, errDialTimeout
+		}
+		return r.conn, r.err
+	case <-ctx.Done():
+		attempt.abort()
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errDialTimeout
+	}
+}
+
+// checkProtocol returns the connection of a dial that negotiated the port-forward protocol.
+func checkProtocol(conn httpstream.Connection, protocol string, err error) (httpstream.Connection, error) {
+	if err != nil {
+		return nil, err
+	}
+	if protocol != portforward.PortForwardProtocolV1Name {
+		conn.Close()
+		return nil, fmt.Errorf("unable to negotiate protocol: client supports %q, server returned %q", portforward.PortForwardProtocolV1Name, protocol)
+	}
+	return conn, nil
+}
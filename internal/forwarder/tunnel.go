@@ -0,0 +1,481 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+
+	"github.com/codozor/fwkeeper/internal/capture"
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// localAddresses are the addresses local ports are bound to, as kubectl port-forward does.
+var localAddresses = []string{"127.0.0.1", "::1"}
+
+// DefaultSocketMode is the permission mode of local Unix sockets.
+const DefaultSocketMode os.FileMode = 0o600
+
+// endpoint is a local endpoint of a forward: a TCP port, or a Unix socket when socket is set.
+type endpoint struct {
+	port   int
+	socket string
+}
+
+func (e endpoint) String() string {
+	if e.socket != "" {
+		return "unix:" + e.socket
+	}
+	return strconv.Itoa(e.port)
+}
+
+// portMapping is a local port, or Unix socket, forwarded to a pod port.
+type portMapping struct {
+	local  int
+	socket string
+	remote int
+}
+
+// endpoint returns the local endpoint of the mapping.
+func (pm portMapping) endpoint() endpoint {
+	return endpoint{port: pm.local, socket: pm.socket}
+}
+
+// parsePorts parses "local:remote", "port" or "unix:/path/to.sock:remote" mappings.
+func parsePorts(ports []string) ([]portMapping, error) {
+	result := make([]portMapping, 0, len(ports))
+
+	for _, port := range ports {
+		spec, err := config.ParsePort(port)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, portMapping{local: spec.Local, socket: spec.Socket, remote: spec.RemotePort()})
+	}
+
+	return result, nil
+}
+
+// listen binds a local endpoint. TCP ports are bound on all the given addresses, and it fails
+// only if no address can be bound. Sockets are created with the given permissions.
+func listen(e endpoint, addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	if e.socket != "" {
+		ln, err := listenSocket(e.socket, socketMode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+
+	var listeners []net.Listener
+	var errs []error
+
+	for _, addr := range addresses {
+		ln, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(e.port)))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("unable to listen on port %d: %w", e.port, errors.Join(errs...))
+	}
+
+	return listeners, nil
+}
+
+// listenSocket creates a Unix socket listener, removed when closed. A stale socket file
+// left by a crashed process is removed first; a socket still in use, or any other file, is kept.
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("unable to listen on socket %s: file exists and is not a socket", path)
+		}
+
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to listen on socket %s: socket is in use", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on socket %s: %w", path, err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("unable to set permissions of socket %s: %w", path, err)
+	}
+
+	return ln, nil
+}
+
+// tunnel pipes local connections into streams of a port-forward connection.
+type tunnel struct {
+	conn    httpstream.Connection
+	log     *zerolog.Logger
+	metrics *metrics
+
+	// remotes maps local endpoints to the pod ports of the located target
+	remotes map[endpoint]int
+
+	// target is the pod behind the connection
+	target locator.Target
+
+	// capture returns the writer local connections are recorded to, none when nil
+	capture func() *capture.Writer
+
+	// shaping returns the current shaping of the connections, none when nil
+	shaping func() *shaping
+
+	// faults are injected into the local connections, none when nil
+	faults *faults
+
+	// http inspects the HTTP exchanges of the local connections, none when nil
+	http *httpInspector
+
+	requestID atomic.Int64
+
+	// active counts the connections currently handled, for least-connections balancing
+	active atomic.Int64
+
+	// conns counts the dispatched connections, idleSince is when the last one ended
+	mu        sync.Mutex
+	closed    bool
+	conns     int
+	idleSince time.Time
+	wg        sync.WaitGroup
+}
+
+// newTunnel creates a tunnel over an established port-forward connection.
+func newTunnel(conn httpstream.Connection, log *zerolog.Logger, m *metrics, mappings []portMapping) *tunnel {
+	remotes := make(map[endpoint]int, len(mappings))
+	for _, pm := range mappings {
+		remotes[pm.endpoint()] = pm.remote
+	}
+
+	return &tunnel{conn: conn, log: log, metrics: m, remotes: remotes, idleSince: time.Now()}
+}
+
+// newTargetTunnel creates a tunnel to a located pod, forwarding its port mappings.
+func newTargetTunnel(conn httpstream.Connection, log *zerolog.Logger, m *metrics, target locator.Target) (*tunnel, error) {
+	mappings, err := parsePorts(target.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTunnel(conn, log, m, mappings)
+	t.target = target
+	return t, nil
+}
+
+// dispatch forwards a local connection accepted on a local endpoint in the background.
+// It returns false, leaving local open, when the tunnel is closed or does not forward the endpoint.
+func (t *tunnel) dispatch(local net.Conn, e endpoint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	remote, ok := t.remotes[e]
+	if t.closed || !ok {
+		return false
+	}
+
+	// Decided in the order connections are accepted, before they are handled concurrently
+	fl := t.faults.connection()
+
+	t.conns++
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer func() {
+			t.mu.Lock()
+			t.conns--
+			if t.conns == 0 {
+				t.idleSince = time.Now()
+			}
+			t.mu.Unlock()
+		}()
+
+		if fl != nil && fl.reset {
+			t.log.Warn().Msgf("FAULT - Resetting connection #%d for %s", fl.seq, e)
+			resetConn(local)
+			return
+		}
+
+		var recorded *capture.Connection
+		if t.capture != nil {
+			recorded = captureConnection(t.capture(), local, remote)
+		}
+		t.handle(local, portMapping{local: e.port, socket: e.socket, remote: remote}, t.metrics.port(e), recorded, fl, t.http, nil)
+	}()
+
+	return true
+}
+
+// open returns a new in-memory connection forwarded to the pod port of a local endpoint,
+// bypassing the local listener and the metrics. Stream errors close the connection to the
+// pod, as for local connections.
+func (t *tunnel) open(e endpoint) (net.Conn, error) {
+	return t.openStream(e, nil)
+}
+
+// probe opens a connection like open, for health checks: stream errors, such as a connection
+// refused by the pod, are returned by its reads instead of closing the connection to the pod,
+// so that the health check decides when to reconnect.
+func (t *tunnel) probe(e endpoint) (net.Conn, error) {
+	p := &probeConn{}
+	conn, err := t.openStream(e, p.fail)
+	if err != nil {
+		return nil, err
+	}
+	p.Conn = conn
+	return p, nil
+}
+
+// openStream opens a connection forwarded to the pod port of e, passing stream errors to
+// streamErr when not nil, see handle.
+func (t *tunnel) openStream(e endpoint, streamErr func(error)) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	remote, ok := t.remotes[e]
+	if t.closed {
+		return nil, errors.New("tunnel closed")
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not forwarded", e)
+	}
+
+	client, local := net.Pipe()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.handle(local, portMapping{local: e.port, socket: e.socket, remote: remote}, &portCounters{}, nil, nil, nil, streamErr)
+	}()
+
+	return client, nil
+}
+
+// close closes the connection, which ends all streams, and waits for the connection handlers.
+func (t *tunnel) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	t.conn.Close()
+	t.wg.Wait()
+}
+
+// handle forwards one local connection through a pair of error and data streams, recording
+// its data when recorded is not nil, injecting faults when fl is not nil and inspecting its
+// HTTP exchanges when inspector is not nil. Stream errors are passed to streamErr when not
+// nil, and close the connection to the pod otherwise.
+func (t *tunnel) handle(local net.Conn, port portMapping, counters *portCounters, recorded *capture.Connection, fl *connectionFaults, inspector *httpInspector, streamErr func(error)) {
+	defer local.Close()
+
+	if recorded != nil {
+		defer recorded.Close()
+	}
+
+	counters.total.Add(1)
+	counters.active.Add(1)
+	t.active.Add(1)
+	defer func() {
+		counters.active.Add(-1)
+		t.active.Add(-1)
+	}()
+
+	t.log.Debug().Msgf("Handling connection for %s", port.endpoint())
+
+	requestID := t.requestID.Add(1)
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port.remote))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.FormatInt(requestID, 10))
+
+	errorStream, err := t.conn.CreateStream(headers)
+	if err != nil {
+		t.log.Error().Err(err).Msgf("Error creating error stream for %s -> %d", port.endpoint(), port.remote)
+		return
+	}
+	// The error stream is read only
+	errorStream.Close()
+	defer t.conn.RemoveStreams(errorStream)
+
+	errorCh := make(chan error, 1)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errorCh <- fmt.Errorf("error reading from error stream for %s -> %d: %w", port.endpoint(), port.remote, err)
+		case len(message) > 0:
+			errorCh <- fmt.Errorf("an error occurred forwarding %s -> %d: %s", port.endpoint(), port.remote, string(message))
+		}
+		close(errorCh)
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := t.conn.CreateStream(headers)
+	if err != nil {
+		t.log.Error().Err(err).Msgf("Error creating data stream for %s -> %d", port.endpoint(), port.remote)
+		return
+	}
+	defer t.conn.RemoveStreams(dataStream)
+
+	// Bytes coming from the pod are counted in, bytes sent to the pod out
+	var toLocal io.Writer = &countingWriter{w: local, counter: &counters.bytesIn}
+	var toRemote io.Writer = &countingWriter{w: dataStream, counter: &counters.bytesOut}
+	if recorded != nil {
+		toLocal = &recordingWriter{w: toLocal, record: recorded.ServerData}
+		toRemote = &recordingWriter{w: toRemote, record: recorded.ClientData}
+	}
+
+	// Shaped data is recorded and counted when it is delivered
+	var shapedIn, shapedOut *shapedWriter
+	if t.shaping != nil {
+		shapedIn = newShapedWriter(toLocal, t.shaping, true)
+		shapedOut = newShapedWriter(toRemote, t.shaping, false)
+		toLocal, toRemote = shapedIn, shapedOut
+	}
+
+	// Data held by a blackhole is not delivered yet, so neither counted nor recorded
+	stop := make(chan struct{})
+	if fl != nil {
+		toLocal = &blackholeWriter{w: toLocal, faults: fl.faults, stop: stop, closed: t.conn.CloseChan()}
+		toRemote = &blackholeWriter{w: toRemote, faults: fl.faults, stop: stop, closed: t.conn.CloseChan()}
+
+		if d := fl.cut; d > 0 {
+			cut := time.AfterFunc(d, func() {
+				t.log.Warn().Msgf("FAULT - Cutting connection #%d for %s after %s", fl.seq, port.endpoint(), d)
+				resetConn(local)
+				_ = dataStream.Reset()
+			})
+			defer cut.Stop()
+		}
+	}
+
+	// Without inspection, both directions are copied as is
+	copyToLocal := func() error {
+		_, err := io.Copy(toLocal, dataStream)
+		return err
+	}
+	copyToRemote := func() error {
+		_, err := io.Copy(toRemote, local)
+		return err
+	}
+	if inspector != nil {
+		exchange := inspector.exchange(t.log, port.endpoint())
+		copyToLocal = func() error { return exchange.responses(toLocal, dataStream) }
+		copyToRemote = func() error { return exchange.requests(toRemote, local) }
+	}
+
+	localError := make(chan struct{})
+	localDone := make(chan struct{})
+	remoteDone := make(chan struct{})
+
+	go func() {
+		// Copy from the pod to the local connection
+		if err := copyToLocal(); err != nil && !isClosedError(err) {
+			t.log.Debug().Err(err).Msg("Error copying from remote stream to local connection")
+		}
+		shapedIn.flush()
+		close(remoteDone)
+	}()
+
+	go func() {
+		defer close(localDone)
+
+		// Tell the pod no more data is coming once the local side is done
+		defer dataStream.Close()
+
+		// Copy from the local connection to the pod
+		err := copyToRemote()
+		shapedOut.flush()
+		if err != nil && !isClosedError(err) {
+			t.log.Debug().Err(err).Msg("Error copying from local connection to remote stream")
+			close(localError)
+		}
+	}()
+
+	select {
+	case <-remoteDone:
+	case <-localError:
+	}
+
+	// Discard unsent data so that the error stream is not blocked behind it
+	_ = dataStream.Reset()
+
+	// An error on the error stream usually means the pod is gone: close the
+	// connection so that the forwarder reconnects, as kubectl port-forward does
+	if err := <-errorCh; err != nil && streamErr != nil {
+		streamErr(err)
+	} else if err != nil {
+		select {
+		case <-t.conn.CloseChan():
+			// Already torn down, the stream error is a consequence
+		default:
+			t.log.Error().Err(err).Msg("Port-forward stream error")
+			t.conn.Close()
+		}
+	}
+
+	// Unblock the local copy so that its byte count is final when handle returns
+	close(stop)
+	local.Close()
+	<-localDone
+}
+
+// probeConn is a probe connection whose reads return the stream error, if any, once the
+// pod side is done.
+type probeConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	err error
+}
+
+// fail records the stream error, before the pod side of the connection is closed.
+func (p *probeConn) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *probeConn) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	if errors.Is(err, io.EOF) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.err != nil {
+			return n, p.err
+		}
+	}
+	return n, err
+}
+
+// isClosedError reports whether err comes from a closed connection.
+func isClosedError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || strings.Contains(strings.ToLower(err.Error()), "use of closed network connection")
+}
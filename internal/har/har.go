@@ -0,0 +1,322 @@
+// Package har records HTTP exchanges to a HAR 1.2 file (HTTP Archive), which browsers'
+// developer tools and most HTTP tools can import.
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// MaxEntries bounds the entries kept in the file, the oldest ones are dropped beyond it.
+	MaxEntries = 1000
+
+	// MaxBodySize bounds the body bytes recorded per request or response.
+	MaxBodySize = 64 * 1024
+
+	// flushDelay batches the entries written to the file.
+	flushDelay = time.Second
+)
+
+// Log is the root of a HAR file.
+type Log struct {
+	Log struct {
+		Version string   `json:"version"`
+		Creator Creator  `json:"creator"`
+		Entries []*Entry `json:"entries"`
+	} `json:"log"`
+}
+
+// Creator names the application that created the file.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is one HTTP exchange.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	Comment         string    `json:"comment,omitempty"`
+}
+
+// Request is the request of an exchange.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// Response is the response of an exchange.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// NameValue is a header, cookie or query parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is the body of a request.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Content is the body of a response.
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings splits the time of an exchange, in milliseconds.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Body is a recorded body: its first bytes, up to MaxBodySize, and its full size.
+type Body struct {
+	Data []byte
+	Size int64
+}
+
+// NewEntry builds the entry of an exchange started at start, whose response headers came
+// after wait and whose response body was received after total. The URL uses scheme and the
+// Host of the request.
+func NewEntry(scheme string, req *http.Request, reqBody Body, resp *http.Response, respBody Body, start time.Time, wait, total time.Duration) *Entry {
+	u := *req.URL
+	u.Scheme = scheme
+	u.Host = req.Host
+
+	e := &Entry{
+		StartedDateTime: start,
+		Time:            milliseconds(total),
+		Request: Request{
+			Method:      req.Method,
+			URL:         u.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     cookies(req.Cookies()),
+			Headers:     headers(req.Header),
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+			BodySize:    reqBody.Size,
+		},
+		Response: Response{
+			Status:      resp.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+			HTTPVersion: resp.Proto,
+			Cookies:     cookies(resp.Cookies()),
+			Headers:     headers(resp.Header),
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    respBody.Size,
+		},
+		Timings: Timings{Send: 0, Wait: milliseconds(wait), Receive: milliseconds(total - wait)},
+	}
+
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			e.Request.QueryString = append(e.Request.QueryString, NameValue{Name: name, Value: value})
+		}
+	}
+
+	if reqBody.Size > 0 {
+		text, encoding := encodeBody(reqBody.Data, req.Header)
+		e.Request.PostData = &PostData{MimeType: req.Header.Get("Content-Type"), Text: text, Encoding: encoding}
+	}
+
+	e.Response.Content = Content{Size: respBody.Size, MimeType: resp.Header.Get("Content-Type")}
+	e.Response.Content.Text, e.Response.Content.Encoding = encodeBody(respBody.Data, resp.Header)
+
+	if reqBody.Size > int64(len(reqBody.Data)) || respBody.Size > int64(len(respBody.Data)) {
+		e.Comment = fmt.Sprintf("bodies truncated to %d bytes", MaxBodySize)
+	}
+
+	return e
+}
+
+// encodeBody returns a body as text, or base64 encoded when it is binary or compressed.
+func encodeBody(data []byte, header http.Header) (string, string) {
+	if header.Get("Content-Encoding") == "" && isText(header.Get("Content-Type")) && utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+// isText reports whether a content type is textual.
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		strings.HasSuffix(mediaType, "javascript") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+func headers(h http.Header) []NameValue {
+	result := []NameValue{}
+	for name, values := range h {
+		for _, value := range values {
+			result = append(result, NameValue{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+func cookies(cs []*http.Cookie) []NameValue {
+	result := []NameValue{}
+	for _, c := range cs {
+		result = append(result, NameValue{Name: c.Name, Value: c.Value})
+	}
+	return result
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Recorder writes the entries of a session to a HAR file, replacing its content. Entries are
+// written in batches and on Close.
+type Recorder struct {
+	path string
+	log  *zerolog.Logger
+
+	mu      sync.Mutex
+	entries []*Entry
+	timer   *time.Timer
+	closed  bool
+}
+
+// Open creates the HAR file at path, empty until entries are added.
+func Open(path string, log *zerolog.Logger) (*Recorder, error) {
+	r := &Recorder{path: path, log: log}
+	if err := r.write(nil); err != nil {
+		return nil, fmt.Errorf("failed to create HAR file: %w", err)
+	}
+	return r, nil
+}
+
+// Path returns the path of the HAR file.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Add records an entry. It is a no-op once closed.
+func (r *Recorder) Add(e *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.entries = append(r.entries, e)
+	if len(r.entries) > MaxEntries {
+		r.entries = r.entries[len(r.entries)-MaxEntries:]
+	}
+
+	if r.timer == nil {
+		r.timer = time.AfterFunc(flushDelay, r.flush)
+	}
+}
+
+// flush writes the entries recorded so far.
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timer = nil
+	if r.closed {
+		return
+	}
+	if err := r.write(r.entries); err != nil {
+		r.log.Error().Err(err).Msgf("Failed to write HAR file %s", r.path)
+	}
+}
+
+// Close writes the pending entries and stops recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	return r.write(r.entries)
+}
+
+// write replaces the file by one holding entries.
+func (r *Recorder) write(entries []*Entry) error {
+	var doc Log
+	doc.Log.Version = "1.2"
+	doc.Log.Creator = Creator{Name: "fwkeeper", Version: "1"}
+	doc.Log.Entries = entries
+	if doc.Log.Entries == nil {
+		doc.Log.Entries = []*Entry{}
+	}
+
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, append(content, '\n'), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package har
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewEntry tests the entry of an exchange, with text and binary bodies
+func TestNewEntry(t *testing.T) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: "/login", RawQuery: "next=%2Fhome"},
+		Host:   "api.local:8080",
+		Proto:  "HTTP/1.1",
+		Header: http.Header{"Content-Type": {"application/json"}, "Cookie": {"session=abc"}},
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusFound,
+		Status:     "302 Found",
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Location": {"/home"}, "Content-Type": {"image/png"}},
+	}
+	start := time.Now()
+
+	e := NewEntry("https", req, Body{Data: []byte(`{"user":"me"}`), Size: 13}, resp, Body{Data: []byte{0x89, 'P'}, Size: MaxBodySize + 1}, start, 20*time.Millisecond, 50*time.Millisecond)
+
+	assert.Equal(t, "https://api.local:8080/login?next=%2Fhome", e.Request.URL)
+	assert.Equal(t, []NameValue{{Name: "next", Value: "/home"}}, e.Request.QueryString)
+	assert.Equal(t, []NameValue{{Name: "session", Value: "abc"}}, e.Request.Cookies)
+	require.NotNil(t, e.Request.PostData)
+	assert.Equal(t, `{"user":"me"}`, e.Request.PostData.Text)
+	assert.Empty(t, e.Request.PostData.Encoding)
+
+	assert.Equal(t, "Found", e.Response.StatusText)
+	assert.Equal(t, "/home", e.Response.RedirectURL)
+	assert.Equal(t, "base64", e.Response.Content.Encoding)
+	assert.Equal(t, "iVA=", e.Response.Content.Text)
+	assert.Equal(t, int64(MaxBodySize+1), e.Response.Content.Size)
+	assert.NotEmpty(t, e.Comment, "truncated bodies are noted")
+
+	assert.Equal(t, 50.0, e.Time)
+	assert.Equal(t, Timings{Wait: 20, Receive: 30}, e.Timings)
+}
+
+// TestRecorder tests that the file holds the last entries once closed
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.har")
+	log := zerolog.Nop()
+
+	r, err := Open(path, &log)
+	require.NoError(t, err)
+
+	read := func() Log {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var doc Log
+		require.NoError(t, json.Unmarshal(content, &doc))
+		return doc
+	}
+	assert.Equal(t, "1.2", read().Log.Version)
+	assert.Empty(t, read().Log.Entries)
+
+	for i := range MaxEntries + 5 {
+		r.Add(&Entry{Time: float64(i)})
+	}
+	require.NoError(t, r.Close())
+	r.Add(&Entry{})
+
+	entries := read().Log.Entries
+	require.Len(t, entries, MaxEntries)
+	assert.Equal(t, 5.0, entries[0].Time, "the oldest entries are dropped")
+}
@@ -0,0 +1,123 @@
+// Package hosts maintains a delimited block of entries in a hosts file, so that in-cluster
+// DNS names resolve to the local addresses of forwards. Lines outside the block are kept as is.
+package hosts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPath is the hosts file of the system.
+const DefaultPath = "/etc/hosts"
+
+// Markers delimiting the managed block.
+const (
+	beginMarker = "# BEGIN fwkeeper - managed block, do not edit"
+	endMarker   = "# END fwkeeper"
+)
+
+// Entry maps a hostname to an address.
+type Entry struct {
+	Address  string
+	Hostname string
+
+	// Comment is written after the entry, usually the forward name
+	Comment string
+}
+
+// File is a hosts file with a block managed by fwkeeper.
+type File struct {
+	path string
+}
+
+// NewFile returns the hosts file at path.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the path of the hosts file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Update replaces the managed block with entries, removing any block left by a previous
+// run. The block is removed when there is no entry. The file is left untouched when the
+// content does not change.
+func (f *File) Update(entries []Entry) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	updated := stripBlock(content)
+	if block := formatBlock(entries); len(block) > 0 {
+		// The block starts on its own line
+		if len(updated) > 0 && updated[len(updated)-1] != '\n' {
+			updated = append(updated, '\n')
+		}
+		updated = append(updated, block...)
+	}
+
+	if bytes.Equal(updated, content) {
+		return nil
+	}
+
+	// Written in place: the hosts file is often a mount point that cannot be replaced
+	if err := os.WriteFile(f.path, updated, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write hosts file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// Remove removes the managed block.
+func (f *File) Remove() error {
+	return f.Update(nil)
+}
+
+// stripBlock returns content without the managed blocks. An unterminated block runs to
+// the end of the file.
+func stripBlock(content []byte) []byte {
+	var result []byte
+	inBlock := false
+
+	for line := range bytes.Lines(content) {
+		trimmed := strings.TrimSpace(string(line))
+		switch {
+		case trimmed == beginMarker:
+			inBlock = true
+		case inBlock && trimmed == endMarker:
+			inBlock = false
+		case !inBlock:
+			result = append(result, line...)
+		}
+	}
+
+	return result
+}
+
+// formatBlock formats the managed block, empty when there is no entry.
+func formatBlock(entries []Entry) []byte {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString(beginMarker + "\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s\t%s", e.Address, e.Hostname)
+		if e.Comment != "" {
+			fmt.Fprintf(&b, "\t# %s", e.Comment)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(endMarker + "\n")
+
+	return b.Bytes()
+}
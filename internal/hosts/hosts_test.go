@@ -0,0 +1,107 @@
+package hosts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const block = "# BEGIN fwkeeper - managed block, do not edit\n"
+
+func writeHosts(t *testing.T, content string) *File {
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return NewFile(path)
+}
+
+func readHosts(t *testing.T, f *File) string {
+	content, err := os.ReadFile(f.Path())
+	require.NoError(t, err)
+	return string(content)
+}
+
+// TestFileUpdate tests that the block is written after the other lines, then replaced and removed
+func TestFileUpdate(t *testing.T) {
+	f := writeHosts(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n")
+
+	require.NoError(t, f.Update([]Entry{
+		{Address: "127.0.0.1", Hostname: "postgres.db.svc.cluster.local", Comment: "db"},
+		{Address: "127.0.0.1", Hostname: "api.default.svc"},
+	}))
+
+	assert.Equal(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n"+
+		block+
+		"127.0.0.1\tpostgres.db.svc.cluster.local\t# db\n"+
+		"127.0.0.1\tapi.default.svc\n"+
+		"# END fwkeeper\n", readHosts(t, f))
+
+	require.NoError(t, f.Update([]Entry{{Address: "127.0.0.1", Hostname: "api.default.svc"}}))
+
+	assert.Equal(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n"+block+"127.0.0.1\tapi.default.svc\n# END fwkeeper\n", readHosts(t, f))
+
+	require.NoError(t, f.Remove())
+
+	assert.Equal(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n", readHosts(t, f))
+}
+
+// TestFileUpdateKeepsOtherLines tests blocks left in the middle of the file, unterminated blocks
+// and files without a final newline
+func TestFileUpdateKeepsOtherLines(t *testing.T) {
+	f := writeHosts(t, "127.0.0.1\tlocalhost\n"+block+"127.0.0.1\told\n# END fwkeeper\n10.0.0.1\tnas")
+
+	require.NoError(t, f.Update([]Entry{{Address: "127.0.0.1", Hostname: "new"}}))
+
+	assert.Equal(t, "127.0.0.1\tlocalhost\n10.0.0.1\tnas\n"+block+"127.0.0.1\tnew\n# END fwkeeper\n", readHosts(t, f))
+
+	f = writeHosts(t, "127.0.0.1\tlocalhost\n"+block+"127.0.0.1\told\n")
+
+	require.NoError(t, f.Remove())
+
+	assert.Equal(t, "127.0.0.1\tlocalhost\n", readHosts(t, f))
+
+	// Without block, the file is not rewritten
+	f = writeHosts(t, "10.0.0.1\tnas")
+	require.NoError(t, f.Remove())
+	assert.Equal(t, "10.0.0.1\tnas", readHosts(t, f))
+}
+
+// TestFileUpdateMissing tests that a missing hosts file is an error
+func TestFileUpdateMissing(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "hosts"))
+
+	assert.Error(t, f.Update([]Entry{{Address: "127.0.0.1", Hostname: "api"}}))
+}
+
+// TestRecord tests that the hosts file in use is recorded with its process, and only
+// forgotten by that process
+func TestRecord(t *testing.T) {
+	record := filepath.Join(t.TempDir(), "fwkeeper", "hosts-file")
+
+	r, err := ReadRecord(record)
+	require.NoError(t, err)
+	assert.Equal(t, Record{}, r)
+	assert.False(t, r.Live())
+
+	require.NoError(t, WriteRecord(record, "/etc/hosts"))
+	r, err = ReadRecord(record)
+	require.NoError(t, err)
+	assert.Equal(t, Record{Path: "/etc/hosts", PID: os.Getpid()}, r)
+	assert.True(t, r.Live())
+
+	require.NoError(t, RemoveRecord(record, os.Getpid()+1))
+	assert.FileExists(t, record, "the record of another process is kept")
+
+	require.NoError(t, RemoveRecord(record, os.Getpid()))
+	require.NoError(t, RemoveRecord(record, os.Getpid()))
+	assert.NoFileExists(t, record)
+
+	// A record without process is left by a run that is gone
+	require.NoError(t, os.WriteFile(record, []byte("/etc/hosts\n"), 0o644))
+	r, err = ReadRecord(record)
+	require.NoError(t, err)
+	assert.Equal(t, Record{Path: "/etc/hosts"}, r)
+	assert.False(t, r.Live())
+}
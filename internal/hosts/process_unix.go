@@ -0,0 +1,15 @@
+//go:build !windows
+
+package hosts
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning tells whether the process pid is running. A process of another user
+// cannot be signaled but is running.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package hosts
+
+import "os"
+
+// processRunning tells whether the process pid is running: it can only be opened while it is.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
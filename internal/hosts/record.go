@@ -0,0 +1,79 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// recordName is the name of the record of the hosts file in use in the user configuration directory.
+const recordName = "hosts-file"
+
+// Record tells which hosts file a run wrote its block to, so that the block of a crashed run
+// can be removed by the next one.
+type Record struct {
+	// Path is the hosts file, empty when there is no record
+	Path string
+
+	// PID is the process that wrote the block, 0 when unknown
+	PID int
+}
+
+// Live tells whether the process that wrote the record is still running: its block is
+// in use and must be left alone.
+func (r Record) Live() bool {
+	return r.PID > 0 && processRunning(r.PID)
+}
+
+// DefaultRecordPath returns the record of the hosts file in use in the user configuration directory.
+func DefaultRecordPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the user configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "fwkeeper", recordName), nil
+}
+
+// ReadRecord returns the record at record, empty when there is none.
+func ReadRecord(record string) (Record, error) {
+	content, err := os.ReadFile(record)
+	if errors.Is(err, os.ErrNotExist) {
+		return Record{}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	// The hosts file on the first line, the process on the second
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	r := Record{Path: strings.TrimSpace(lines[0])}
+	if len(lines) > 1 {
+		r.PID, _ = strconv.Atoi(strings.TrimSpace(lines[1]))
+	}
+	return r, nil
+}
+
+// WriteRecord records at record that the current process writes its block to path.
+func WriteRecord(record string, path string) error {
+	if err := os.MkdirAll(filepath.Dir(record), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(record, fmt.Appendf(nil, "%s\n%d\n", path, os.Getpid()), 0o644)
+}
+
+// RemoveRecord removes the record at record when it was written by pid, so that the record
+// of another run is kept.
+func RemoveRecord(record string, pid int) error {
+	r, err := ReadRecord(record)
+	if err != nil || r.Path == "" || r.PID != pid {
+		return err
+	}
+
+	if err := os.Remove(record); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
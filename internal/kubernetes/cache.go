@@ -0,0 +1,287 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultCacheSyncTimeout bounds how long a lookup waits for an informer to sync.
+const DefaultCacheSyncTimeout = 10 * time.Second
+
+// cacheSyncPollInterval is how often sync progress is checked while waiting.
+const cacheSyncPollInterval = 50 * time.Millisecond
+
+// HTTPRouteResource is the Gateway API HTTPRoute resource, read through the dynamic client
+// since Gateway API types are not part of client-go.
+var HTTPRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// ReferenceGrantResource is the Gateway API ReferenceGrant resource, which allows HTTPRoutes to
+// reference Services in another namespace.
+var ReferenceGrantResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1beta1", Resource: "referencegrants"}
+
+// cacheKey identifies a shared informer factory.
+type cacheKey struct {
+	context   string
+	namespace string
+}
+
+// informerKey identifies a single informer inside a factory.
+type informerKey struct {
+	cacheKey
+	kind string
+}
+
+// InformerCache shares informer factories between all locators so that the API server
+// is watched once per namespace instead of being polled by every forwarder retry loop.
+// Informers are registered lazily on first use and run until the cache context is done.
+type InformerCache struct {
+	ctx           context.Context
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+	kubeContext   string
+
+	syncTimeout time.Duration
+
+	mu               sync.Mutex
+	factories        map[cacheKey]informers.SharedInformerFactory
+	dynamicFactories map[cacheKey]dynamicinformer.DynamicSharedInformerFactory
+	registered       map[informerKey]bool
+	watchErrs        map[informerKey]error
+}
+
+// NewInformerCache creates an informer cache bound to ctx.
+// The dynamic client is only needed for resources outside client-go (e.g. HTTPRoutes) and may be nil.
+// All informers stop when ctx is cancelled; call Shutdown to wait for them.
+func NewInformerCache(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, kubeContext string) *InformerCache {
+	return &InformerCache{
+		ctx:              ctx,
+		client:           client,
+		dynamicClient:    dynamicClient,
+		kubeContext:      kubeContext,
+		syncTimeout:      DefaultCacheSyncTimeout,
+		factories:        make(map[cacheKey]informers.SharedInformerFactory),
+		dynamicFactories: make(map[cacheKey]dynamicinformer.DynamicSharedInformerFactory),
+		registered:       make(map[informerKey]bool),
+		watchErrs:        make(map[informerKey]error),
+	}
+}
+
+// Pods returns a synced pod lister for the namespace.
+func (c *InformerCache) Pods(ctx context.Context, namespace string) (corelisters.PodNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "pods", factory.Core().V1().Pods().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Core().V1().Pods().Lister().Pods(namespace), nil
+}
+
+// Services returns a synced service lister for the namespace.
+func (c *InformerCache) Services(ctx context.Context, namespace string) (corelisters.ServiceNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "services", factory.Core().V1().Services().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Core().V1().Services().Lister().Services(namespace), nil
+}
+
+// Deployments returns a synced deployment lister for the namespace.
+func (c *InformerCache) Deployments(ctx context.Context, namespace string) (appslisters.DeploymentNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "deployments", factory.Apps().V1().Deployments().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Apps().V1().Deployments().Lister().Deployments(namespace), nil
+}
+
+// StatefulSets returns a synced statefulset lister for the namespace.
+func (c *InformerCache) StatefulSets(ctx context.Context, namespace string) (appslisters.StatefulSetNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "statefulsets", factory.Apps().V1().StatefulSets().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Apps().V1().StatefulSets().Lister().StatefulSets(namespace), nil
+}
+
+// DaemonSets returns a synced daemonset lister for the namespace.
+func (c *InformerCache) DaemonSets(ctx context.Context, namespace string) (appslisters.DaemonSetNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "daemonsets", factory.Apps().V1().DaemonSets().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Apps().V1().DaemonSets().Lister().DaemonSets(namespace), nil
+}
+
+// Ingresses returns a synced ingress lister for the namespace.
+func (c *InformerCache) Ingresses(ctx context.Context, namespace string) (networkinglisters.IngressNamespaceLister, error) {
+	factory := c.factory(namespace)
+	if err := c.sync(ctx, namespace, "ingresses", factory.Networking().V1().Ingresses().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Networking().V1().Ingresses().Lister().Ingresses(namespace), nil
+}
+
+// HTTPRoutes returns a synced lister of Gateway API HTTPRoutes (as unstructured objects) for the namespace.
+func (c *InformerCache) HTTPRoutes(ctx context.Context, namespace string) (cache.GenericNamespaceLister, error) {
+	if c.dynamicClient == nil {
+		return nil, fmt.Errorf("dynamic client is not available: cannot watch %s", HTTPRouteResource.Resource)
+	}
+
+	factory := c.dynamicFactory(namespace)
+	informer := factory.ForResource(HTTPRouteResource)
+	if err := c.sync(ctx, namespace, HTTPRouteResource.Resource, informer.Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return informer.Lister().ByNamespace(namespace), nil
+}
+
+// ReferenceGrants returns a synced lister of Gateway API ReferenceGrants (as unstructured objects) for the namespace.
+func (c *InformerCache) ReferenceGrants(ctx context.Context, namespace string) (cache.GenericNamespaceLister, error) {
+	if c.dynamicClient == nil {
+		return nil, fmt.Errorf("dynamic client is not available: cannot watch %s", ReferenceGrantResource.Resource)
+	}
+
+	factory := c.dynamicFactory(namespace)
+	informer := factory.ForResource(ReferenceGrantResource)
+	if err := c.sync(ctx, namespace, ReferenceGrantResource.Resource, informer.Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return informer.Lister().ByNamespace(namespace), nil
+}
+
+// Nodes returns a synced, cluster-scoped node lister.
+func (c *InformerCache) Nodes(ctx context.Context) (corelisters.NodeLister, error) {
+	factory := c.factory(metav1.NamespaceAll)
+	if err := c.sync(ctx, metav1.NamespaceAll, "nodes", factory.Core().V1().Nodes().Informer, factory.Start); err != nil {
+		return nil, err
+	}
+	return factory.Core().V1().Nodes().Lister(), nil
+}
+
+// Shutdown waits for all informers to stop. The cache context must be cancelled first.
+func (c *InformerCache) Shutdown() {
+	c.mu.Lock()
+	shutdowns := make([]func(), 0, len(c.factories)+len(c.dynamicFactories))
+	for _, f := range c.factories {
+		shutdowns = append(shutdowns, f.Shutdown)
+	}
+	for _, f := range c.dynamicFactories {
+		shutdowns = append(shutdowns, f.Shutdown)
+	}
+	c.mu.Unlock()
+
+	for _, shutdown := range shutdowns {
+		shutdown()
+	}
+}
+
+// factory returns the informer factory for a namespace, creating it if needed.
+// Cluster-scoped resources use the factory of metav1.NamespaceAll.
+func (c *InformerCache) factory(namespace string) informers.SharedInformerFactory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{context: c.kubeContext, namespace: namespace}
+	if f, exists := c.factories[key]; exists {
+		return f
+	}
+
+	f := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(namespace))
+	c.factories[key] = f
+	return f
+}
+
+// dynamicFactory returns the dynamic informer factory for a namespace, creating it if needed.
+func (c *InformerCache) dynamicFactory(namespace string) dynamicinformer.DynamicSharedInformerFactory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{context: c.kubeContext, namespace: namespace}
+	if f, exists := c.dynamicFactories[key]; exists {
+		return f
+	}
+
+	f := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, 0, namespace, nil)
+	c.dynamicFactories[key] = f
+	return f
+}
+
+// sync registers the informer on first use, starts it, and waits until it has synced.
+// If the informer cannot sync, the last watch error is returned so callers can classify it.
+func (c *InformerCache) sync(ctx context.Context, namespace string, kind string, get func() cache.SharedIndexInformer, start func(<-chan struct{})) error {
+	key := informerKey{cacheKey: cacheKey{context: c.kubeContext, namespace: namespace}, kind: kind}
+
+	c.mu.Lock()
+	informer := get()
+	if !c.registered[key] {
+		_ = informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			c.mu.Lock()
+			c.watchErrs[key] = err
+			c.mu.Unlock()
+		})
+		// Events are only delivered once listing and watching work again
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(any) { c.clearWatchErr(key) },
+			UpdateFunc: func(any, any) { c.clearWatchErr(key) },
+			DeleteFunc: func(any) { c.clearWatchErr(key) },
+		})
+		c.registered[key] = true
+		start(c.ctx.Done())
+	}
+	c.mu.Unlock()
+
+	if informer.HasSynced() {
+		c.clearWatchErr(key)
+		return nil
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, c.syncTimeout)
+	defer cancel()
+
+	// Fail fast on watch errors (e.g. forbidden) instead of waiting for the full timeout;
+	// the informer keeps retrying in the background.
+	var watchErr error
+	err := wait.PollUntilContextCancel(syncCtx, cacheSyncPollInterval, true, func(context.Context) (bool, error) {
+		if informer.HasSynced() {
+			return true, nil
+		}
+
+		c.mu.Lock()
+		watchErr = c.watchErrs[key]
+		c.mu.Unlock()
+
+		return watchErr != nil, nil
+	})
+
+	if err == nil && watchErr == nil {
+		c.clearWatchErr(key)
+		return nil
+	}
+	if watchErr != nil {
+		return watchErr
+	}
+	if c.ctx.Err() != nil {
+		return fmt.Errorf("informer cache stopped: %w", c.ctx.Err())
+	}
+	return fmt.Errorf("timed out waiting for %s cache in namespace %s: %w", kind, namespace, err)
+}
+
+// clearWatchErr forgets the last watch error of an informer, once it synced or received events.
+func (c *InformerCache) clearWatchErr(key informerKey) {
+	c.mu.Lock()
+	delete(c.watchErrs, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,51 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestInformerCacheClearsWatchError tests that the watch error of an informer is reported
+// while it cannot sync, and forgotten once it recovers
+func TestInformerCacheClearsWatchError(t *testing.T) {
+	client := fake.NewClientset()
+
+	var lists atomic.Int32
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if lists.Add(1) == 1 {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewInformerCache(ctx, client, nil, "test")
+	t.Cleanup(func() {
+		cancel()
+		c.Shutdown()
+	})
+
+	_, err := c.Pods(context.Background(), "default")
+	require.Error(t, err)
+	assert.True(t, apierrors.IsForbidden(err))
+
+	require.Eventually(t, func() bool {
+		_, err := c.Pods(context.Background(), "default")
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Empty(t, c.watchErrs)
+}
@@ -3,6 +3,8 @@ package locator
 import (
 	"errors"
 	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 // ErrorType categorizes locator errors for intelligent error handling
@@ -22,8 +24,41 @@ const (
 	ErrorTypeConfigInvalid     // Invalid configuration (port, selector, etc)
 	ErrorTypePermissionDenied  // No permission to access resource
 	ErrorTypeNoPodAvailable    // No running pods available for resource (might retry longer)
+	ErrorTypeNoPodOnNode       // Resource has pods, but none on the requested node(s)
 )
 
+// errorTypeNames are the names of error types, as used in configuration files and logs
+var errorTypeNames = map[ErrorType]string{
+	ErrorTypeUnknown:          "unknown",
+	ErrorTypeNetworkTransient: "network-transient",
+	ErrorTypeAPITransient:     "api-transient",
+	ErrorTypeResourceNotFound: "resource-not-found",
+	ErrorTypePodNotRunning:    "pod-not-running",
+	ErrorTypePodFailed:        "pod-failed",
+	ErrorTypeConfigInvalid:    "config-invalid",
+	ErrorTypePermissionDenied: "permission-denied",
+	ErrorTypeNoPodAvailable:   "no-pod-available",
+	ErrorTypeNoPodOnNode:      "no-pod-on-node",
+}
+
+// String returns the name of the error type
+func (t ErrorType) String() string {
+	if name, ok := errorTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorType(%d)", int(t))
+}
+
+// ParseErrorType returns the error type with the given name
+func ParseErrorType(name string) (ErrorType, error) {
+	for t, n := range errorTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return ErrorTypeUnknown, fmt.Errorf("unknown error type: %s", name)
+}
+
 // LocateError wraps location errors with type information for intelligent retry handling
 type LocateError struct {
 	Type    ErrorType
@@ -86,6 +121,15 @@ func NewPodFailedError(podName string, err error) error {
 	}
 }
 
+// NewNoPodOnNodeError creates an error for resources with no pod on the requested node
+func NewNoPodOnNodeError(resource, node string) error {
+	return &LocateError{
+		Type:    ErrorTypeNoPodOnNode,
+		Message: fmt.Sprintf("no pod of %s runs on node %s", resource, node),
+		Err:     nil,
+	}
+}
+
 // NewConfigInvalidError creates an error for invalid configuration
 func NewConfigInvalidError(msg string, err error) error {
 	return &LocateError{
@@ -121,3 +165,12 @@ func NewNetworkTransientError(msg string, err error) error {
 		Err:     err,
 	}
 }
+
+// NewCacheSyncError classifies a failure to sync the informer cache for a resource type.
+// Permission problems are reported as such; anything else is treated as transient.
+func NewCacheSyncError(resourceType, namespace string, err error) error {
+	if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+		return NewPermissionDeniedError("list", fmt.Sprintf("%s in namespace %s", resourceType, namespace), err)
+	}
+	return NewAPITransientError(fmt.Sprintf("failed to sync %s in namespace %s", resourceType, namespace), err)
+}
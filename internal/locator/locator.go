@@ -3,60 +3,147 @@ package locator
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
-	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 )
 
 // Locator is the interface for discovering pods or services in Kubernetes.
 type Locator interface {
-	// Locate returns the pod name and ports for port forwarding.
-	Locate(ctx context.Context) (string, []string, error)
+	// Locate returns the target pod and ports for port forwarding.
+	Locate(ctx context.Context) (Target, error)
+}
+
+// MultiLocator is implemented by locators that can return several candidate pods,
+// used to balance connections across replicas.
+type MultiLocator interface {
+	Locator
+
+	// LocateAll returns up to max running pods (all when max <= 0) in a stable order.
+	LocateAll(ctx context.Context, max int) ([]Target, error)
 }
 
 // BuildLocator creates the appropriate locator based on the resource string.
 // Supported formats:
-// - "pod-name" - direct pod reference
+// - "pod-name" or "pod/pod-name" - direct pod reference
+// - "pod/api-worker-*" - first running pod whose name matches a glob pattern
+// - "pod/~^api-[0-9]+$" - first running pod whose name matches a regular expression
 // - "svc/service-name" or "service/service-name" - service reference
 // - "dep/deployment-name" or "deployment/deployment-name" - deployment reference
 // - "sts/statefulset-name" or "statefulset/statefulset-name" - statefulset reference
 // - "ds/daemonset-name" or "daemonset/daemonset-name" - daemonset reference
-func BuildLocator(resource string, namespace string, ports []string, client kubernetes.Interface) (Locator, error) {
-	if client == nil {
-		return nil, fmt.Errorf("kubernetes client is required")
+// - "ing/ingress-name" or "ingress/ingress-name" - backend service of an ingress
+// - "httproute/route-name" - backend service of a Gateway API HTTPRoute
+// - "host/db.internal:5432" - in-cluster address reached through a relay pod (requires WithRelay)
+// All locators read from the shared informer cache instead of querying the API server.
+// Options (such as WithNode) only apply to selector-based resources,
+// and WithRouteMatch only to routes.
+func BuildLocator(resource string, namespace string, ports []string, cache *kubeinternal.InformerCache, opts ...Option) (Locator, error) {
+	if cache == nil {
+		return nil, fmt.Errorf("kubernetes informer cache is required")
+	}
+
+	o := buildOptions(opts)
+
+	if (o.host != "" || o.path != "") && !isRoutePrefix(strings.SplitN(resource, "/", 2)[0]) {
+		return nil, fmt.Errorf("host/path matching is only supported for ingress and httproute resources: %s", resource)
+	}
+
+	// Regular expressions may contain '/', so only split them on the first separator
+	if prefix, pattern, found := strings.Cut(resource, "/"); found && isPodPrefix(prefix) && strings.HasPrefix(pattern, regexpPrefix) {
+		if o.node != "" {
+			return nil, fmt.Errorf("node selection is not supported for pod resources: %s", resource)
+		}
+		return NewPodPatternLocator(pattern, namespace, ports, cache)
 	}
 
 	parts := strings.Split(resource, "/")
 
+	if (len(parts) == 1 || isPodPrefix(parts[0])) && o.node != "" {
+		return nil, fmt.Errorf("node selection is not supported for pod resources: %s", resource)
+	}
+
 	if len(parts) == 1 {
 		// No prefix: treat as direct pod reference
-		return NewPodLocator(resource, namespace, ports, client)
+		return NewPodLocator(resource, namespace, ports, cache)
 	} else if len(parts) == 2 {
 		prefix := parts[0]
 		name := parts[1]
 
+		// Pod locator, by exact name or glob pattern
+		if isPodPrefix(prefix) {
+			if isGlobPattern(name) {
+				return NewPodPatternLocator(name, namespace, ports, cache)
+			}
+			return NewPodLocator(name, namespace, ports, cache)
+		}
+
 		// Service locator
 		if prefix == "svc" || prefix == "service" || prefix == "services" {
-			return NewServiceLocator(name, namespace, ports, client)
+			return NewServiceLocator(name, namespace, ports, cache, opts...)
 		}
 
 		// Deployment locator
 		if prefix == "dep" || prefix == "deployment" || prefix == "deployments" {
-			return NewSelectorBasedLocator("deployment", name, namespace, ports, client)
+			return NewSelectorBasedLocator("deployment", name, namespace, ports, cache, opts...)
 		}
 
 		// StatefulSet locator
 		if prefix == "sts" || prefix == "statefulset" || prefix == "statefulsets" {
-			return NewSelectorBasedLocator("statefulset", name, namespace, ports, client)
+			return NewSelectorBasedLocator("statefulset", name, namespace, ports, cache, opts...)
 		}
 
 		// DaemonSet locator
 		if prefix == "ds" || prefix == "daemonset" || prefix == "daemonsets" {
-			return NewSelectorBasedLocator("daemonset", name, namespace, ports, client)
+			return NewSelectorBasedLocator("daemonset", name, namespace, ports, cache, opts...)
 		}
 
-		return nil, fmt.Errorf("unsupported resource type: %s (supported: pod, svc/service, dep/deployment, sts/statefulset, ds/daemonset)", prefix)
+		// Ingress locator
+		if prefix == "ing" || prefix == "ingress" || prefix == "ingresses" {
+			return NewRouteLocator("ingress", name, namespace, ports, cache, opts...)
+		}
+
+		// Gateway API HTTPRoute locator
+		if prefix == "httproute" || prefix == "httproutes" {
+			return NewRouteLocator("httproute", name, namespace, ports, cache, opts...)
+		}
+
+		// In-cluster host reached through a relay pod
+		if prefix == "host" {
+			if o.node != "" {
+				return nil, fmt.Errorf("node selection is not supported for host resources: %s", resource)
+			}
+			return NewRelayLocator(name, ports, o.relay)
+		}
+
+		return nil, fmt.Errorf("unsupported resource type: %s (supported: pod, svc/service, dep/deployment, sts/statefulset, ds/daemonset, ing/ingress, httproute, host)", prefix)
 	} else {
-		return nil, fmt.Errorf("invalid resource format: %s (use 'pod-name', 'svc/service-name', 'dep/deployment-name', etc)", resource)
+		return nil, fmt.Errorf("invalid resource format: %s (use 'pod-name', 'pod/pattern-*', 'svc/service-name', 'dep/deployment-name', etc)", resource)
 	}
 }
+
+// isRoutePrefix reports whether a resource prefix designates a route (Ingress or HTTPRoute).
+func isRoutePrefix(prefix string) bool {
+	switch prefix {
+	case "ing", "ingress", "ingresses", "httproute", "httproutes":
+		return true
+	}
+	return false
+}
+
+// isPodPrefix reports whether a resource prefix designates pods.
+func isPodPrefix(prefix string) bool {
+	return prefix == "po" || prefix == "pod" || prefix == "pods"
+}
+
+// sortPodsByName orders pods by name so that candidate selection is deterministic.
+// Listers return cached objects in no particular order, unlike the API server.
+func sortPodsByName(pods []*corev1.Pod) []*corev1.Pod {
+	slices.SortFunc(pods, func(a, b *corev1.Pod) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pods
+}
@@ -2,16 +2,25 @@ package locator
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 )
 
 // newTestMockClient creates a fake Kubernetes client for testing
@@ -19,6 +28,22 @@ func newTestMockClient(objects ...runtime.Object) *fake.Clientset {
 	return fake.NewClientset(objects...)
 }
 
+// newTestCache creates an informer cache over a fake Kubernetes client for testing
+func newTestCache(t *testing.T, objects ...runtime.Object) *kubeinternal.InformerCache {
+	return newTestCacheForClient(t, newTestMockClient(objects...))
+}
+
+// newTestCacheForClient creates an informer cache over the given client, stopped at test cleanup
+func newTestCacheForClient(t *testing.T, client *fake.Clientset) *kubeinternal.InformerCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := kubeinternal.NewInformerCache(ctx, client, nil, "test")
+	t.Cleanup(func() {
+		cancel()
+		cache.Shutdown()
+	})
+	return cache
+}
+
 // TestPodLocatorFound tests that a running pod is found
 func TestPodLocatorFound(t *testing.T) {
 	pod := &corev1.Pod{
@@ -31,24 +56,24 @@ func TestPodLocatorFound(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(pod)
-	locator, err := NewPodLocator("api-server", "default", []string{"8080"}, client)
+	cache := newTestCache(t, pod)
+	locator, err := NewPodLocator("api-server", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	podName, ports, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 
 	assert.NoError(t, err)
-	assert.Equal(t, "api-server", podName)
-	assert.Equal(t, []string{"8080"}, ports)
+	assert.Equal(t, "api-server", target.PodName)
+	assert.Equal(t, []string{"8080"}, target.Ports)
 }
 
 // TestPodLocatorNotFound tests error when pod doesn't exist
 func TestPodLocatorNotFound(t *testing.T) {
-	client := newTestMockClient()
-	locator, err := NewPodLocator("nonexistent", "default", []string{"8080"}, client)
+	cache := newTestCache(t)
+	locator, err := NewPodLocator("nonexistent", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	_, _, err = locator.Locate(context.Background())
+	_, err = locator.Locate(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
@@ -79,11 +104,11 @@ func TestPodLocatorNotRunning(t *testing.T) {
 				},
 			}
 
-			client := newTestMockClient(pod)
-			locator, err := NewPodLocator("api-server", "default", []string{"8080"}, client)
+			cache := newTestCache(t, pod)
+			locator, err := NewPodLocator("api-server", "default", []string{"8080"}, cache)
 			require.NoError(t, err)
 
-			_, _, err = locator.Locate(context.Background())
+			_, err = locator.Locate(context.Background())
 
 			assert.Error(t, err)
 			// For failed pods, we have a specific error; for others, we say "not running"
@@ -129,24 +154,24 @@ func TestServiceLocatorFound(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(svc, pod)
-	locator, err := NewServiceLocator("api-svc", "default", []string{"8080"}, client)
+	cache := newTestCache(t, svc, pod)
+	locator, err := NewServiceLocator("api-svc", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	podName, ports, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 
 	assert.NoError(t, err)
-	assert.Equal(t, "api-server-1", podName)
-	assert.Equal(t, []string{"8080"}, ports)
+	assert.Equal(t, "api-server-1", target.PodName)
+	assert.Equal(t, []string{"8080"}, target.Ports)
 }
 
 // TestServiceLocatorNotFound tests error when service doesn't exist
 func TestServiceLocatorNotFound(t *testing.T) {
-	client := newTestMockClient()
-	locator, err := NewServiceLocator("nonexistent-svc", "default", []string{"8080"}, client)
+	cache := newTestCache(t)
+	locator, err := NewServiceLocator("nonexistent-svc", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	_, _, err = locator.Locate(context.Background())
+	_, err = locator.Locate(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not found")
@@ -183,11 +208,11 @@ func TestServiceLocatorNoRunningPods(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(svc, pod)
-	locator, err := NewServiceLocator("api-svc", "default", []string{"8080"}, client)
+	cache := newTestCache(t, svc, pod)
+	locator, err := NewServiceLocator("api-svc", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	_, _, err = locator.Locate(context.Background())
+	_, err = locator.Locate(context.Background())
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no running pod")
@@ -220,15 +245,15 @@ func TestDeploymentLocatorFound(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(deploy, pod)
-	locator, err := NewSelectorBasedLocator("deployment", "api-deploy", "default", []string{"8080"}, client)
+	cache := newTestCache(t, deploy, pod)
+	locator, err := NewSelectorBasedLocator("deployment", "api-deploy", "default", []string{"8080"}, cache)
 	require.NoError(t, err)
 
-	podName, ports, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 
 	assert.NoError(t, err)
-	assert.Equal(t, "api-deploy-abc123", podName)
-	assert.Equal(t, []string{"8080"}, ports)
+	assert.Equal(t, "api-deploy-abc123", target.PodName)
+	assert.Equal(t, []string{"8080"}, target.Ports)
 }
 
 // TestStatefulSetLocatorFound tests that a statefulset with running pods is found
@@ -258,15 +283,15 @@ func TestStatefulSetLocatorFound(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(sts, pod)
-	locator, err := NewSelectorBasedLocator("statefulset", "postgres-sts", "default", []string{"5432"}, client)
+	cache := newTestCache(t, sts, pod)
+	locator, err := NewSelectorBasedLocator("statefulset", "postgres-sts", "default", []string{"5432"}, cache)
 	require.NoError(t, err)
 
-	podName, ports, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 
 	assert.NoError(t, err)
-	assert.Equal(t, "postgres-sts-0", podName)
-	assert.Equal(t, []string{"5432"}, ports)
+	assert.Equal(t, "postgres-sts-0", target.PodName)
+	assert.Equal(t, []string{"5432"}, target.Ports)
 }
 
 // TestDaemonSetLocatorFound tests that a daemonset with running pods is found
@@ -296,15 +321,15 @@ func TestDaemonSetLocatorFound(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(ds, pod)
-	locator, err := NewSelectorBasedLocator("daemonset", "prometheus-ds", "default", []string{"9090"}, client)
+	cache := newTestCache(t, ds, pod)
+	locator, err := NewSelectorBasedLocator("daemonset", "prometheus-ds", "default", []string{"9090"}, cache)
 	require.NoError(t, err)
 
-	podName, ports, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 
 	assert.NoError(t, err)
-	assert.Equal(t, "prometheus-ds-node1", podName)
-	assert.Equal(t, []string{"9090"}, ports)
+	assert.Equal(t, "prometheus-ds-node1", target.PodName)
+	assert.Equal(t, []string{"9090"}, target.Ports)
 }
 
 // TestBuildLocatorPodFormat tests BuildLocator with pod format
@@ -319,15 +344,15 @@ func TestBuildLocatorPodFormat(t *testing.T) {
 		},
 	}
 
-	client := newTestMockClient(pod)
-	locator, err := BuildLocator("api-server", "default", []string{"8080"}, client)
+	cache := newTestCache(t, pod)
+	locator, err := BuildLocator("api-server", "default", []string{"8080"}, cache)
 
 	require.NoError(t, err)
 	assert.NotNil(t, locator)
 
-	podName, _, err := locator.Locate(context.Background())
+	target, err := locator.Locate(context.Background())
 	assert.NoError(t, err)
-	assert.Equal(t, "api-server", podName)
+	assert.Equal(t, "api-server", target.PodName)
 }
 
 // TestBuildLocatorServiceFormats tests BuildLocator with various service formats
@@ -367,11 +392,11 @@ func TestBuildLocatorServiceFormats(t *testing.T) {
 				},
 			}
 
-			client := newTestMockClient(svc, pod)
-			locator, err := BuildLocator(tc.resource, "default", []string{"8080"}, client)
+			cache := newTestCache(t, svc, pod)
+			locator, err := BuildLocator(tc.resource, "default", []string{"8080"}, cache)
 			require.NoError(t, err)
 
-			_, _, err = locator.Locate(context.Background())
+			_, err = locator.Locate(context.Background())
 			assert.NoError(t, err)
 		})
 	}
@@ -413,11 +438,11 @@ func TestBuildLocatorDeploymentFormats(t *testing.T) {
 				},
 			}
 
-			client := newTestMockClient(deploy, pod)
-			locator, err := BuildLocator(tc.resource, "default", []string{"8080"}, client)
+			cache := newTestCache(t, deploy, pod)
+			locator, err := BuildLocator(tc.resource, "default", []string{"8080"}, cache)
 			require.NoError(t, err)
 
-			_, _, err = locator.Locate(context.Background())
+			_, err = locator.Locate(context.Background())
 			assert.NoError(t, err)
 		})
 	}
@@ -425,7 +450,7 @@ func TestBuildLocatorDeploymentFormats(t *testing.T) {
 
 // TestBuildLocatorInvalidFormat tests BuildLocator with invalid format
 func TestBuildLocatorInvalidFormat(t *testing.T) {
-	client := newTestMockClient()
+	cache := newTestCache(t)
 
 	testCases := []struct {
 		name     string
@@ -437,8 +462,913 @@ func TestBuildLocatorInvalidFormat(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := BuildLocator(tc.resource, "default", []string{"8080"}, client)
+			_, err := BuildLocator(tc.resource, "default", []string{"8080"}, cache)
 			assert.Error(t, err)
 		})
 	}
 }
+
+// TestLocatorsShareInformerCache tests that locators in the same namespace share one watch
+func TestLocatorsShareInformerCache(t *testing.T) {
+	pod1 := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "default"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	pod2 := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-2", Namespace: "default"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	client := newTestMockClient(pod1, pod2)
+	cache := newTestCacheForClient(t, client)
+
+	loc1, err := NewPodLocator("api-1", "default", []string{"8080"}, cache)
+	require.NoError(t, err)
+	loc2, err := NewPodLocator("api-2", "default", []string{"9000"}, cache)
+	require.NoError(t, err)
+
+	// Repeated lookups, as done by retry loops, must not hit the API server again
+	for i := 0; i < 5; i++ {
+		_, err = loc1.Locate(context.Background())
+		require.NoError(t, err)
+		_, err = loc2.Locate(context.Background())
+		require.NoError(t, err)
+	}
+
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "pods" {
+			lists++
+		}
+	}
+	assert.Equal(t, 1, lists, "pods should be listed once per namespace")
+}
+
+// TestLocatorCachePermissionDenied tests that forbidden watches are classified as permission errors
+func TestLocatorCachePermissionDenied(t *testing.T) {
+	client := newTestMockClient()
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+	})
+	cache := newTestCacheForClient(t, client)
+
+	locator, err := NewPodLocator("api-server", "default", []string{"8080"}, cache)
+	require.NoError(t, err)
+
+	_, err = locator.Locate(context.Background())
+
+	require.Error(t, err)
+	assert.Equal(t, ErrorTypePermissionDenied, GetErrorType(err))
+}
+
+// TestSelectorBasedLocatorPicksFirstPodByName tests deterministic selection from the cache
+func TestSelectorBasedLocatorPicksFirstPodByName(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "api"},
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-deploy", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Selector: selector},
+	}
+
+	objects := []runtime.Object{deploy}
+	for _, name := range []string{"api-deploy-c", "api-deploy-a", "api-deploy-b"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: selector.MatchLabels},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	cache := newTestCache(t, objects...)
+	locator, err := NewSelectorBasedLocator("deployment", "api-deploy", "default", []string{"8080"}, cache)
+	require.NoError(t, err)
+
+	target, err := locator.Locate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "api-deploy-a", target.PodName)
+}
+
+// TestBuildLocatorRequiresCache tests that BuildLocator rejects a nil cache
+func TestBuildLocatorRequiresCache(t *testing.T) {
+	_, err := BuildLocator("api-server", "default", []string{"8080"}, nil)
+	assert.Error(t, err)
+}
+
+// TestPodPatternLocator tests glob and regular expression pod name patterns
+func TestPodPatternLocator(t *testing.T) {
+	newPod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		resource string
+		expected string
+	}{
+		{"glob", "pod/api-worker-*", "api-worker-7d9f"},
+		{"glob single char", "pod/api-worker-?d9f", "api-worker-7d9f"},
+		{"regexp", "pod/~^api-[0-9]+$", "api-12"},
+		{"regexp with slash", "pods/~^api-[0-9]+$|a/b", "api-12"},
+		{"exact with prefix", "pod/api-12", "api-12"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := newTestCache(t,
+				newPod("api-12", corev1.PodRunning),
+				newPod("api-worker-1abc", corev1.PodPending),
+				newPod("api-worker-7d9f", corev1.PodRunning),
+				newPod("debug", corev1.PodRunning),
+			)
+
+			locator, err := BuildLocator(tc.resource, "default", []string{"8080"}, cache)
+			require.NoError(t, err)
+
+			target, err := locator.Locate(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, target.PodName)
+			assert.Equal(t, []string{"8080"}, target.Ports)
+		})
+	}
+}
+
+// TestPodPatternLocatorNoMatch tests errors when no pod matches or none is running
+func TestPodPatternLocatorNoMatch(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-worker-1", Namespace: "default"},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	cache := newTestCache(t, pod)
+
+	locator, err := NewPodPatternLocator("db-*", "default", []string{"5432"}, cache)
+	require.NoError(t, err)
+	_, err = locator.Locate(context.Background())
+	assert.Equal(t, ErrorTypeResourceNotFound, GetErrorType(err))
+
+	locator, err = NewPodPatternLocator("api-worker-*", "default", []string{"8080"}, cache)
+	require.NoError(t, err)
+	_, err = locator.Locate(context.Background())
+	assert.Equal(t, ErrorTypeNoPodAvailable, GetErrorType(err))
+}
+
+// TestPodPatternLocatorInvalidPattern tests that malformed patterns are rejected
+func TestPodPatternLocatorInvalidPattern(t *testing.T) {
+	cache := newTestCache(t)
+
+	_, err := BuildLocator("pod/~api-[0-9", "default", []string{"8080"}, cache)
+	assert.Error(t, err)
+
+	_, err = BuildLocator("pod/api-[", "default", []string{"8080"}, cache)
+	assert.Error(t, err)
+}
+
+// newDaemonSetWithPods creates a daemonset with one running pod per node
+func newDaemonSetWithPods(nodes ...string) []runtime.Object {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "exporter"},
+	}
+
+	objects := []runtime.Object{
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-exporter", Namespace: "monitoring"},
+			Spec:       appsv1.DaemonSetSpec{Selector: selector},
+		},
+	}
+
+	for _, node := range nodes {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "node-exporter-" + node,
+				Namespace: "monitoring",
+				Labels:    selector.MatchLabels,
+			},
+			Spec:   corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	return objects
+}
+
+// TestSelectorBasedLocatorNodeName tests selecting the pod running on a named node
+func TestSelectorBasedLocatorNodeName(t *testing.T) {
+	cache := newTestCache(t, newDaemonSetWithPods("node-a", "node-b", "node-c")...)
+
+	locator, err := BuildLocator("ds/node-exporter", "monitoring", []string{"9100"}, cache, WithNode("node-b"))
+	require.NoError(t, err)
+
+	target, err := locator.Locate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "node-exporter-node-b", target.PodName)
+}
+
+// TestSelectorBasedLocatorNodeSelector tests selecting pods by node labels
+func TestSelectorBasedLocatorNodeSelector(t *testing.T) {
+	objects := newDaemonSetWithPods("node-a", "node-b")
+	objects = append(objects,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"zone": "west"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"zone": "east"}}},
+	)
+	cache := newTestCache(t, objects...)
+
+	locator, err := BuildLocator("daemonset/node-exporter", "monitoring", []string{"9100"}, cache, WithNode("zone=east"))
+	require.NoError(t, err)
+
+	target, err := locator.Locate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "node-exporter-node-b", target.PodName)
+}
+
+// TestSelectorBasedLocatorNodeLabelExists tests that a prefixed label key selects the nodes
+// having it, while explicit and bare node names select a node by name
+func TestSelectorBasedLocatorNodeLabelExists(t *testing.T) {
+	objects := newDaemonSetWithPods("node-a", "node-b")
+	objects = append(objects,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"node-role.kubernetes.io/worker": ""}}},
+	)
+	cache := newTestCache(t, objects...)
+
+	for _, node := range []string{"node-role.kubernetes.io/worker", "name:node-b", "node-b"} {
+		locator, err := BuildLocator("ds/node-exporter", "monitoring", []string{"9100"}, cache, WithNode(node))
+		require.NoError(t, err, node)
+
+		target, err := locator.Locate(context.Background())
+
+		assert.NoError(t, err, node)
+		assert.Equal(t, "node-exporter-node-b", target.PodName, node)
+	}
+}
+
+// TestSelectorBasedLocatorNoPodOnNode tests the error reported when no pod runs on the node
+func TestSelectorBasedLocatorNoPodOnNode(t *testing.T) {
+	cache := newTestCache(t, newDaemonSetWithPods("node-a")...)
+
+	locator, err := BuildLocator("ds/node-exporter", "monitoring", []string{"9100"}, cache, WithNode("node-z"))
+	require.NoError(t, err)
+
+	_, err = locator.Locate(context.Background())
+
+	require.Error(t, err)
+	assert.Equal(t, ErrorTypeNoPodOnNode, GetErrorType(err))
+	assert.Contains(t, err.Error(), "node-z")
+}
+
+// TestServiceLocatorNodeName tests node selection for service targets
+func TestServiceLocatorNodeName(t *testing.T) {
+	selector := map[string]string{"app": "api"}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-svc", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports:    []corev1.ServicePort{{Port: 8080, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+
+	objects := []runtime.Object{svc}
+	for _, node := range []string{"node-a", "node-b"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-" + node, Namespace: "default", Labels: selector},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	cache := newTestCache(t, objects...)
+	locator, err := NewServiceLocator("api-svc", "default", []string{"8080"}, cache, WithNode("node-b"))
+	require.NoError(t, err)
+
+	target, err := locator.Locate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "api-node-b", target.PodName)
+}
+
+// TestBuildLocatorNodeValidation tests rejection of invalid node options
+func TestBuildLocatorNodeValidation(t *testing.T) {
+	cache := newTestCache(t)
+
+	_, err := BuildLocator("api-server", "default", []string{"8080"}, cache, WithNode("node-a"))
+	assert.Error(t, err, "node selection does not apply to pods")
+
+	_, err = BuildLocator("pod/api-*", "default", []string{"8080"}, cache, WithNode("node-a"))
+	assert.Error(t, err, "node selection does not apply to pods")
+
+	_, err = BuildLocator("ds/node-exporter", "default", []string{"9100"}, cache, WithNode("zone in (east"))
+	assert.Error(t, err, "invalid node selector")
+
+	_, err = BuildLocator("ds/node-exporter", "default", []string{"9100"}, cache, WithNode("name:Node_A"))
+	assert.Error(t, err, "invalid node name")
+}
+
+// TestLocateTargetMetadata tests that the located target carries the pod metadata
+func TestLocateTargetMetadata(t *testing.T) {
+	selector := map[string]string{"app": "db"}
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "databases"},
+		Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: selector}},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgres-0",
+			Namespace: "databases",
+			UID:       "uid-1",
+			Labels:    selector,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "worker-2",
+			Containers: []corev1.Container{
+				{Name: "metrics", Ports: []corev1.ContainerPort{{ContainerPort: 9187}}},
+				{Name: "postgres", Ports: []corev1.ContainerPort{{ContainerPort: 5432}}},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "metrics", RestartCount: 0},
+				{Name: "postgres", RestartCount: 3},
+			},
+		},
+	}
+
+	cache := newTestCache(t, sts, pod)
+	locator, err := BuildLocator("sts/postgres", "databases", []string{"15432:5432"}, cache)
+	require.NoError(t, err)
+
+	target, err := locator.Locate(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, Target{
+		Namespace:    "databases",
+		PodName:      "postgres-0",
+		PodUID:       "uid-1",
+		NodeName:     "worker-2",
+		Container:    "postgres",
+		RestartCount: 3,
+		Ports:        []string{"15432:5432"},
+		Strategy:     StrategySelector,
+	}, target)
+}
+
+// TestLocateTargetStrategy tests that the target records how the pod was picked
+func TestLocateTargetStrategy(t *testing.T) {
+	objects := newDaemonSetWithPods("node-a")
+	objects = append(objects, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug-1", Namespace: "monitoring"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+	cache := newTestCache(t, objects...)
+
+	testCases := []struct {
+		resource string
+		opts     []Option
+		expected Strategy
+	}{
+		{"debug-1", nil, StrategyPodName},
+		{"pod/debug-*", nil, StrategyPodPattern},
+		{"ds/node-exporter", nil, StrategySelector},
+		{"ds/node-exporter", []Option{WithNode("node-a")}, StrategyNodeSelector},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.resource, func(t *testing.T) {
+			locator, err := BuildLocator(tc.resource, "monitoring", []string{"9100"}, cache, tc.opts...)
+			require.NoError(t, err)
+
+			target, err := locator.Locate(context.Background())
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, target.Strategy)
+			assert.Equal(t, "monitoring", target.Namespace)
+		})
+	}
+}
+
+// newRouteBackends creates services "web-svc" (port 80 -> 8080) and "api-svc" (port 9000, named "grpc")
+// with one running pod each, as route backends
+func newRouteBackends() []runtime.Object {
+	newService := func(name string, port corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": name},
+				Ports:    []corev1.ServicePort{port},
+			},
+		}
+	}
+	newPod := func(name string, app string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	return []runtime.Object{
+		newService("web-svc", corev1.ServicePort{Port: 80, TargetPort: intstr.FromInt32(8080)}),
+		newService("api-svc", corev1.ServicePort{Name: "grpc", Port: 9000, TargetPort: intstr.FromInt32(9000)}),
+		newPod("web-1", "web-svc"),
+		newPod("api-1", "api-svc"),
+	}
+}
+
+// newTestIngress creates an ingress routing app.example.com/api to api-svc (named port)
+// and everything else to web-svc
+func newTestIngress() *networkingv1.Ingress {
+	prefix := networkingv1.PathTypePrefix
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "app.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &prefix,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{Name: "web-svc", Port: networkingv1.ServiceBackendPort{Number: 80}},
+									},
+								},
+								{
+									Path:     "/api",
+									PathType: &prefix,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{Name: "api-svc", Port: networkingv1.ServiceBackendPort{Name: "grpc"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: "web-svc", Port: networkingv1.ServiceBackendPort{Number: 80}},
+			},
+		},
+	}
+}
+
+// TestIngressLocator tests backend selection by host and path on an ingress
+func TestIngressLocator(t *testing.T) {
+	cache := newTestCache(t, append(newRouteBackends(), newTestIngress())...)
+
+	testCases := []struct {
+		name          string
+		host          string
+		path          string
+		ports         []string
+		expectedPod   string
+		expectedPorts []string
+	}{
+		{"no match uses first path", "", "", []string{"8000"}, "web-1", []string{"8000:8080"}},
+		{"root path", "app.example.com", "/", []string{"8000"}, "web-1", []string{"8000:8080"}},
+		{"longest prefix wins", "app.example.com", "/api/v1", []string{"9000"}, "api-1", []string{"9000"}},
+		{"explicit remote port is kept", "app.example.com", "/", []string{"8000:80"}, "web-1", []string{"8000:8080"}},
+		{"unknown host uses default backend", "other.example.com", "/api", []string{"8000"}, "web-1", []string{"8000:8080"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			locator, err := BuildLocator("ingress/app", "default", tc.ports, cache, WithRouteMatch(tc.host, tc.path))
+			require.NoError(t, err)
+
+			target, err := locator.Locate(context.Background())
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPod, target.PodName)
+			assert.Equal(t, tc.expectedPorts, target.Ports)
+		})
+	}
+}
+
+// TestIngressLocatorNotFound tests the error reported for a missing ingress
+func TestIngressLocatorNotFound(t *testing.T) {
+	cache := newTestCache(t, newRouteBackends()...)
+
+	locator, err := BuildLocator("ing/missing", "default", []string{"8000"}, cache)
+	require.NoError(t, err)
+
+	_, err = locator.Locate(context.Background())
+
+	require.Error(t, err)
+	assert.Equal(t, ErrorTypeResourceNotFound, GetErrorType(err))
+}
+
+// TestIngressLocatorNoBackend tests that an ingress without a matching backend is a configuration error
+func TestIngressLocatorNoBackend(t *testing.T) {
+	ing := newTestIngress()
+	ing.Spec.DefaultBackend = nil
+	cache := newTestCache(t, append(newRouteBackends(), ing)...)
+
+	locator, err := BuildLocator("ingress/app", "default", []string{"8000"}, cache, WithRouteMatch("other.example.com", ""))
+	require.NoError(t, err)
+
+	_, err = locator.Locate(context.Background())
+
+	require.Error(t, err)
+	assert.Equal(t, ErrorTypeConfigInvalid, GetErrorType(err))
+}
+
+// TestHTTPRouteLocator tests backend selection on a Gateway API HTTPRoute
+func TestHTTPRouteLocator(t *testing.T) {
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"hostnames": []interface{}{"*.example.com"},
+			"rules": []interface{}{
+				map[string]interface{}{
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": "web-svc", "port": int64(80)},
+					},
+				},
+				map[string]interface{}{
+					"matches": []interface{}{
+						map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/api"}},
+					},
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": "api-svc", "port": int64(9000)},
+					},
+				},
+			},
+		},
+	}}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{kubeinternal.HTTPRouteResource: "HTTPRouteList"}, route)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := kubeinternal.NewInformerCache(ctx, newTestMockClient(newRouteBackends()...), dynamicClient, "test")
+	t.Cleanup(func() {
+		cancel()
+		cache.Shutdown()
+	})
+
+	testCases := []struct {
+		name          string
+		host          string
+		path          string
+		expectedPod   string
+		expectedPorts []string
+	}{
+		{"rule without matches", "", "", "web-1", []string{"8000:8080"}},
+		{"path prefix", "app.example.com", "/api/users", "api-1", []string{"8000:9000"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			locator, err := BuildLocator("httproute/app", "default", []string{"8000"}, cache, WithRouteMatch(tc.host, tc.path))
+			require.NoError(t, err)
+
+			target, err := locator.Locate(context.Background())
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPod, target.PodName)
+			assert.Equal(t, tc.expectedPorts, target.Ports)
+		})
+	}
+
+	t.Run("host not served", func(t *testing.T) {
+		locator, err := BuildLocator("httproute/app", "default", []string{"8000"}, cache, WithRouteMatch("example.org", ""))
+		require.NoError(t, err)
+
+		_, err = locator.Locate(context.Background())
+
+		require.Error(t, err)
+		assert.Equal(t, ErrorTypeConfigInvalid, GetErrorType(err))
+	})
+}
+
+// TestHTTPRouteLocatorCrossNamespace tests that a backend in another namespace is only used
+// when a ReferenceGrant in that namespace allows it
+func TestHTTPRouteLocatorCrossNamespace(t *testing.T) {
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "frontend"},
+		"spec": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{
+					"backendRefs": []interface{}{
+						map[string]interface{}{"name": "web-svc", "namespace": "default", "port": int64(80)},
+					},
+				},
+			},
+		},
+	}}
+	grant := func(from string, to string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "gateway.networking.k8s.io/v1beta1",
+			"kind":       "ReferenceGrant",
+			"metadata":   map[string]interface{}{"name": "routes-" + from, "namespace": "default"},
+			"spec": map[string]interface{}{
+				"from": []interface{}{map[string]interface{}{"group": "gateway.networking.k8s.io", "kind": "HTTPRoute", "namespace": from}},
+				"to":   []interface{}{map[string]interface{}{"group": "", "kind": "Service", "name": to}},
+			},
+		}}
+	}
+
+	testCases := []struct {
+		name        string
+		grants      []runtime.Object
+		expectedPod string
+	}{
+		{"no grant", nil, ""},
+		{"grant for another namespace", []runtime.Object{grant("other", "web-svc")}, ""},
+		{"grant for another service", []runtime.Object{grant("frontend", "api-svc")}, ""},
+		{"grant for the service", []runtime.Object{grant("frontend", "web-svc")}, "web-1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{
+					kubeinternal.HTTPRouteResource:      "HTTPRouteList",
+					kubeinternal.ReferenceGrantResource: "ReferenceGrantList",
+				}, append([]runtime.Object{route}, tc.grants...)...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cache := kubeinternal.NewInformerCache(ctx, newTestMockClient(newRouteBackends()...), dynamicClient, "test")
+			t.Cleanup(func() {
+				cancel()
+				cache.Shutdown()
+			})
+
+			locator, err := BuildLocator("httproute/app", "frontend", []string{"8000"}, cache)
+			require.NoError(t, err)
+
+			target, err := locator.Locate(context.Background())
+
+			if tc.expectedPod == "" {
+				require.Error(t, err)
+				assert.Equal(t, ErrorTypeConfigInvalid, GetErrorType(err))
+				assert.Contains(t, err.Error(), "ReferenceGrant")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPod, target.PodName)
+		})
+	}
+}
+
+// TestBuildLocatorRouteMatchValidation tests that route matches are rejected on other resources
+func TestBuildLocatorRouteMatchValidation(t *testing.T) {
+	cache := newTestCache(t)
+
+	_, err := BuildLocator("svc/web-svc", "default", []string{"80"}, cache, WithRouteMatch("app.example.com", ""))
+	assert.Error(t, err)
+
+	_, err = BuildLocator("ingress/app", "default", []string{"80"}, cache, WithRouteMatch("app.example.com", "/"))
+	assert.NoError(t, err)
+}
+
+// TestHostMatches tests route host matching, including wildcards
+func TestHostMatches(t *testing.T) {
+	assert.True(t, hostMatches("", "app.example.com"))
+	assert.True(t, hostMatches("app.example.com", ""))
+	assert.True(t, hostMatches("APP.example.com", "app.example.com"))
+	assert.True(t, hostMatches("*.example.com", "app.example.com"))
+	assert.False(t, hostMatches("*.example.com", "a.b.example.com"))
+	assert.False(t, hostMatches("*.example.com", "example.com"))
+	assert.False(t, hostMatches("app.example.com", "web.example.com"))
+}
+
+// TestPathMatches tests element-wise prefix and exact path matching
+func TestPathMatches(t *testing.T) {
+	assert.True(t, pathMatches("/api", "/api", false))
+	assert.True(t, pathMatches("/api", "/api/v1", false))
+	assert.True(t, pathMatches("/api/", "/api/v1", false))
+	assert.True(t, pathMatches("/", "/anything", false))
+	assert.False(t, pathMatches("/api", "/apis", false))
+	assert.True(t, pathMatches("/api", "/api", true))
+	assert.False(t, pathMatches("/api", "/api/v1", true))
+}
+
+// TestErrorTypeNames tests that error types round-trip through their names
+func TestErrorTypeNames(t *testing.T) {
+	for errType := ErrorTypeUnknown; errType <= ErrorTypeNoPodOnNode; errType++ {
+		parsed, err := ParseErrorType(errType.String())
+		require.NoError(t, err)
+		assert.Equal(t, errType, parsed)
+	}
+
+	assert.Equal(t, "permission-denied", ErrorTypePermissionDenied.String())
+
+	_, err := ParseErrorType("bogus")
+	assert.Error(t, err)
+}
+
+// TestSelectorBasedLocatorLocateAll tests that all running pods are returned in name order, up to max
+func TestSelectorBasedLocatorLocateAll(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "api"},
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-deploy", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Selector: selector},
+	}
+
+	objects := []runtime.Object{deploy}
+	for name, phase := range map[string]corev1.PodPhase{
+		"api-deploy-c": corev1.PodRunning,
+		"api-deploy-a": corev1.PodRunning,
+		"api-deploy-d": corev1.PodPending,
+		"api-deploy-b": corev1.PodRunning,
+	} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: selector.MatchLabels},
+			Status:     corev1.PodStatus{Phase: phase},
+		})
+	}
+
+	cache := newTestCache(t, objects...)
+	locator, err := NewSelectorBasedLocator("deployment", "api-deploy", "default", []string{"8080"}, cache)
+	require.NoError(t, err)
+
+	podNames := func(targets []Target) []string {
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.PodName)
+		}
+		return names
+	}
+
+	targets, err := locator.LocateAll(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api-deploy-a", "api-deploy-b", "api-deploy-c"}, podNames(targets))
+
+	targets, err = locator.LocateAll(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api-deploy-a", "api-deploy-b"}, podNames(targets))
+}
+
+// TestServiceLocatorLocateAll tests that every running pod of a service gets the mapped ports
+func TestServiceLocatorLocateAll(t *testing.T) {
+	selector := map[string]string{"app": "api"}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-svc", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+
+	objects := []runtime.Object{svc}
+	for _, name := range []string{"api-2", "api-1"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: selector},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+
+	cache := newTestCache(t, objects...)
+	locator, err := NewServiceLocator("api-svc", "default", []string{"9000:80"}, cache)
+	require.NoError(t, err)
+
+	targets, err := locator.LocateAll(context.Background(), 0)
+
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "api-1", targets[0].PodName)
+	assert.Equal(t, "api-2", targets[1].PodName)
+	assert.Equal(t, []string{"9000:8080"}, targets[1].Ports)
+}
+
+// fakeRelay returns a relay pod in a given phase
+type fakeRelay struct {
+	phase corev1.PodPhase
+	err   error
+
+	host string
+	port int
+}
+
+func (r *fakeRelay) Ensure(ctx context.Context, host string, port int) (*corev1.Pod, error) {
+	r.host, r.port = host, port
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "fwkeeper-relay-abc", Namespace: "tools", UID: "relay-uid"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "relay"}}},
+		Status:     corev1.PodStatus{Phase: r.phase},
+	}, nil
+}
+
+// TestRelayLocator tests that host resources resolve to the running relay pod
+func TestRelayLocator(t *testing.T) {
+	relay := &fakeRelay{phase: corev1.PodRunning}
+	cache := newTestCache(t)
+
+	loc, err := BuildLocator("host/db.internal:5432", "default", []string{"15432", "15433:5432"}, cache, WithRelay(relay))
+	require.NoError(t, err)
+
+	target, err := loc.Locate(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", relay.host)
+	assert.Equal(t, 5432, relay.port)
+	assert.Equal(t, "tools", target.Namespace)
+	assert.Equal(t, "fwkeeper-relay-abc", target.PodName)
+	assert.Equal(t, []string{"15432:5432", "15433:5432"}, target.Ports)
+	assert.Equal(t, StrategyRelay, target.Strategy)
+}
+
+// TestRelayLocatorErrors tests the classification of relay errors
+func TestRelayLocatorErrors(t *testing.T) {
+	loc, err := NewRelayLocator("db.internal:5432", []string{"5432"}, &fakeRelay{phase: corev1.PodPending})
+	require.NoError(t, err)
+	_, err = loc.Locate(context.Background())
+	assert.Equal(t, ErrorTypePodNotRunning, GetErrorType(err))
+
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+	loc, err = NewRelayLocator("db.internal:5432", []string{"5432"}, &fakeRelay{err: forbidden})
+	require.NoError(t, err)
+	_, err = loc.Locate(context.Background())
+	assert.Equal(t, ErrorTypePermissionDenied, GetErrorType(err))
+}
+
+// TestBuildLocatorHostValidation tests the validation of host resources
+func TestBuildLocatorHostValidation(t *testing.T) {
+	cache := newTestCache(t)
+	relay := &fakeRelay{}
+
+	for _, tc := range []struct {
+		resource string
+		ports    []string
+		opts     []Option
+	}{
+		{"host/db.internal:5432", []string{"5432"}, nil},                                       // no relay
+		{"host/db.internal", []string{"5432"}, []Option{WithRelay(relay)}},                     // no port
+		{"host/db.internal:99999", []string{"5432"}, []Option{WithRelay(relay)}},               // invalid port
+		{"host/db.internal:5432", []string{"5432:6000"}, []Option{WithRelay(relay)}},           // remote port differs
+		{"host/db.internal:5432", []string{"5432"}, []Option{WithRelay(relay), WithNode("n")}}, // node selection
+	} {
+		_, err := BuildLocator(tc.resource, "default", tc.ports, cache, tc.opts...)
+		assert.Error(t, err, tc.resource)
+	}
+}
+
+// fakeReverseRelay returns a reverse relay pod with a tunnel port
+type fakeReverseRelay struct {
+	fakeRelay
+	tunnelPort int32
+}
+
+func (r *fakeReverseRelay) EnsureReverse(ctx context.Context, port int) (*corev1.Pod, error) {
+	pod, err := r.Ensure(ctx, "", port)
+	if err != nil {
+		return nil, err
+	}
+	if r.tunnelPort != 0 {
+		pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{Name: ReverseTunnelPortName, ContainerPort: r.tunnelPort}}
+	}
+	return pod, nil
+}
+
+// TestReverseLocator tests that reverse forwards map the exposed port to the tunnel port of the relay pod
+func TestReverseLocator(t *testing.T) {
+	relay := &fakeReverseRelay{fakeRelay: fakeRelay{phase: corev1.PodRunning}, tunnelPort: 17170}
+
+	loc, err := NewReverseLocator(8080, relay)
+	require.NoError(t, err)
+
+	target, err := loc.Locate(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 8080, relay.port)
+	assert.Equal(t, "fwkeeper-relay-abc", target.PodName)
+	assert.Equal(t, []string{"8080:17170"}, target.Ports)
+	assert.Equal(t, StrategyReverse, target.Strategy)
+
+	// Errors
+	_, err = NewReverseLocator(8080, nil)
+	assert.Error(t, err)
+
+	loc, err = NewReverseLocator(8080, &fakeReverseRelay{fakeRelay: fakeRelay{phase: corev1.PodPending}, tunnelPort: 17170})
+	require.NoError(t, err)
+	_, err = loc.Locate(context.Background())
+	assert.Equal(t, ErrorTypePodNotRunning, GetErrorType(err))
+
+	loc, err = NewReverseLocator(8080, &fakeReverseRelay{fakeRelay: fakeRelay{phase: corev1.PodRunning}})
+	require.NoError(t, err)
+	_, err = loc.Locate(context.Background())
+	assert.Equal(t, ErrorTypeConfigInvalid, GetErrorType(err))
+}
@@ -0,0 +1,141 @@
+package locator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
+)
+
+// Option customizes how a selector-based locator picks candidate pods.
+type Option func(*options)
+
+// options holds the candidate selection settings shared by selector-based locators.
+type options struct {
+	node string
+
+	// host and path select the backend of route resources (Ingress, HTTPRoute)
+	host string
+	path string
+
+	// relay creates the relay pod of host resources
+	relay Relay
+}
+
+// WithNode restricts candidate pods to those scheduled on a node.
+// The node is either an exact node name, optionally written "name:<node>", or a label
+// selector on nodes. Values that are valid node names without '/' are node names, so that
+// label-existence selectors need a prefixed key (e.g. "node-role.kubernetes.io/worker").
+func WithNode(node string) Option {
+	return func(o *options) {
+		o.node = node
+	}
+}
+
+// WithRouteMatch selects which backend of an Ingress or HTTPRoute to use, by the
+// host and path a request would carry. Empty values match any host or path.
+func WithRouteMatch(host string, path string) Option {
+	return func(o *options) {
+		o.host = host
+		o.path = path
+	}
+}
+
+// buildOptions applies the given options over the defaults.
+func buildOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// nodeNamePrefix marks an exact node name, see WithNode.
+const nodeNamePrefix = "name:"
+
+// nodeFilter keeps only the pods scheduled on the requested node(s).
+type nodeFilter struct {
+	node     string
+	selector labels.Selector // nil when node is an exact node name
+}
+
+// newNodeFilter parses a node name or node label selector, see WithNode.
+// It returns nil when no node restriction is requested.
+func newNodeFilter(node string) (*nodeFilter, error) {
+	if node == "" {
+		return nil, nil
+	}
+
+	if name, ok := strings.CutPrefix(node, nodeNamePrefix); ok {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node name %q: %s", name, strings.Join(errs, ", "))
+		}
+		return &nodeFilter{node: name}, nil
+	}
+
+	selector, err := labels.Parse(node)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %w", node, err)
+	}
+
+	// Any node name is also a label-existence selector: a bare name without '/' is a node
+	if !strings.Contains(node, "/") && len(validation.IsDNS1123Subdomain(node)) == 0 {
+		return &nodeFilter{node: node}, nil
+	}
+
+	return &nodeFilter{node: node, selector: selector}, nil
+}
+
+// filter returns the pods running on a matching node.
+// resource describes the located resource and is only used in error messages.
+func (f *nodeFilter) filter(ctx context.Context, cache *kubeinternal.InformerCache, pods []*corev1.Pod, resource string) ([]*corev1.Pod, error) {
+	if len(pods) == 0 {
+		return pods, nil
+	}
+
+	nodeNames := sets.New(f.node)
+
+	if f.selector != nil {
+		nodes, err := cache.Nodes(ctx)
+		if err != nil {
+			return nil, NewCacheSyncError("nodes", "cluster", err)
+		}
+
+		matching, err := nodes.List(f.selector)
+		if err != nil {
+			return nil, NewAPITransientError(fmt.Sprintf("failed to list nodes matching %s", f.node), err)
+		}
+
+		nodeNames = sets.New[string]()
+		for _, n := range matching {
+			nodeNames.Insert(n.Name)
+		}
+	}
+
+	result := []*corev1.Pod{}
+	for _, p := range pods {
+		if nodeNames.Has(p.Spec.NodeName) {
+			result = append(result, p)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, NewNoPodOnNodeError(resource, f.node)
+	}
+
+	return result, nil
+}
+
+// strategy returns the selection strategy for selector-based locators using this filter.
+func (f *nodeFilter) strategy() Strategy {
+	if f == nil {
+		return StrategySelector
+	}
+	return StrategyNodeSelector
+}
@@ -3,57 +3,135 @@ package locator
 import (
 	"context"
 	"fmt"
+	"path"
+	"regexp"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 )
 
-// PodLocator locates a specific pod by name and returns its port mappings.
+// regexpPrefix marks a pod name pattern as a regular expression.
+const regexpPrefix = "~"
+
+// PodLocator locates a specific pod by name and returns it with its port mappings.
+// When built with a pattern, it picks the first running pod whose name matches.
 type PodLocator struct {
 	podName   string
 	namespace string
 	ports     []string
-	client    kubernetes.Interface
+	cache     *kubeinternal.InformerCache
+
+	// match is set for pattern locators; podName then holds the pattern
+	match func(name string) bool
 }
 
 // NewPodLocator creates a new pod locator for the specified pod name.
-func NewPodLocator(podName string, namespace string, ports []string, client kubernetes.Interface) (*PodLocator, error) {
+func NewPodLocator(podName string, namespace string, ports []string, cache *kubeinternal.InformerCache) (*PodLocator, error) {
 	return &PodLocator{
 		podName:   podName,
 		namespace: namespace,
 		ports:     ports,
-		client:    client,
+		cache:     cache,
 	}, nil
 }
 
-// Locate finds the pod and verifies it's running, then returns its name and ports.
-func (l *PodLocator) Locate(ctx context.Context) (string, []string, error) {
-	pod, err := l.client.CoreV1().Pods(l.namespace).Get(ctx, l.podName, metav1.GetOptions{})
-	if err != nil {
-		// Classify API errors
-		if apierrors.IsNotFound(err) {
-			return "", []string{}, NewResourceNotFoundError("pod", l.podName, err)
+// NewPodPatternLocator creates a pod locator matching pod names against a pattern.
+// Patterns starting with "~" are regular expressions, anything else is a glob (see path.Match).
+func NewPodPatternLocator(pattern string, namespace string, ports []string, cache *kubeinternal.InformerCache) (*PodLocator, error) {
+	var match func(string) bool
+
+	if expr, ok := strings.CutPrefix(pattern, regexpPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod name regular expression %q: %w", expr, err)
 		}
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return "", []string{}, NewAPITransientError(fmt.Sprintf("API timeout getting pod %s", l.podName), err)
+		match = re.MatchString
+	} else {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pod name pattern %q: %w", pattern, err)
 		}
-		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
-			return "", []string{}, NewPermissionDeniedError("get", fmt.Sprintf("pod %s", l.podName), err)
+		match = func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
 		}
-		// Other API errors (network issues, etc.)
-		return "", []string{}, NewAPITransientError(fmt.Sprintf("failed to get pod %s", l.podName), err)
+	}
+
+	return &PodLocator{
+		podName:   pattern,
+		namespace: namespace,
+		ports:     ports,
+		cache:     cache,
+		match:     match,
+	}, nil
+}
+
+// Locate finds the pod and verifies it's running, then returns it as the target.
+func (l *PodLocator) Locate(ctx context.Context) (Target, error) {
+	pods, err := l.cache.Pods(ctx, l.namespace)
+	if err != nil {
+		return Target{}, NewCacheSyncError("pods", l.namespace, err)
+	}
+
+	if l.match != nil {
+		return l.locateByPattern(pods)
+	}
+
+	pod, err := pods.Get(l.podName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return Target{}, NewResourceNotFoundError("pod", l.podName, err)
+		}
+		return Target{}, NewAPITransientError(fmt.Sprintf("failed to get pod %s", l.podName), err)
 	}
 
 	// Check pod status
 	if pod.Status.Phase == corev1.PodFailed {
-		return "", []string{}, NewPodFailedError(l.podName, nil)
+		return Target{}, NewPodFailedError(l.podName, nil)
 	}
 
 	if pod.Status.Phase != corev1.PodRunning {
-		return "", []string{}, NewPodNotRunningError(l.podName, string(pod.Status.Phase), nil)
+		return Target{}, NewPodNotRunningError(l.podName, string(pod.Status.Phase), nil)
+	}
+
+	return newTarget(pod, l.ports, StrategyPodName), nil
+}
+
+// locateByPattern returns the first running pod whose name matches the pattern.
+func (l *PodLocator) locateByPattern(pods corelisters.PodNamespaceLister) (Target, error) {
+	candidates, err := pods.List(labels.Everything())
+	if err != nil {
+		return Target{}, NewAPITransientError(fmt.Sprintf("failed to list pods matching %s", l.podName), err)
+	}
+
+	matched := false
+	for _, p := range sortPodsByName(candidates) {
+		if !l.match(p.Name) {
+			continue
+		}
+		matched = true
+
+		if p.Status.Phase == corev1.PodRunning {
+			return newTarget(p, l.ports, StrategyPodPattern), nil
+		}
 	}
 
-	return l.podName, l.ports, nil
+	if !matched {
+		return Target{}, NewResourceNotFoundError("pod matching", l.podName, nil)
+	}
+
+	return Target{}, &LocateError{
+		Type:    ErrorTypeNoPodAvailable,
+		Message: fmt.Sprintf("no running pod found matching %s", l.podName),
+		Err:     nil,
+	}
+}
+
+// isGlobPattern reports whether a pod name contains glob metacharacters.
+func isGlobPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
 }
@@ -0,0 +1,147 @@
+package locator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// Relay provisions the relay pod of a host forward: a pod that pipes its port to an
+// address only reachable from inside the cluster.
+type Relay interface {
+	// Ensure returns the relay pod for host:port, creating it when missing.
+	Ensure(ctx context.Context, host string, port int) (*corev1.Pod, error)
+}
+
+// ReverseRelay provisions the relay pod of a reverse forward: a pod that accepts in-cluster
+// connections on a port and hands each one to a connection made to its tunnel port.
+type ReverseRelay interface {
+	// EnsureReverse returns the relay pod exposing port, creating it when missing.
+	EnsureReverse(ctx context.Context, port int) (*corev1.Pod, error)
+}
+
+// ReverseTunnelPortName is the name of the container port reverse relay pods hand connections over on.
+const ReverseTunnelPortName = "tunnel"
+
+// WithRelay sets the relay used by host resources.
+func WithRelay(relay Relay) Option {
+	return func(o *options) {
+		o.relay = relay
+	}
+}
+
+// RelayLocator locates the relay pod forwarding to an in-cluster host.
+// Unlike other locators it reads the relay pod from the API server, since it creates it.
+type RelayLocator struct {
+	host  string
+	port  int
+	ports []string
+	relay Relay
+}
+
+// NewRelayLocator creates a locator for an in-cluster "host:port" address reached through relay.
+// Local ports without a remote port are forwarded to the port of the host.
+func NewRelayLocator(address string, ports []string, relay Relay) (*RelayLocator, error) {
+	if relay == nil {
+		return nil, fmt.Errorf("no relay configured for host %s", address)
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host address %s (use 'host/name:port'): %w", address, err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 || host == "" {
+		return nil, fmt.Errorf("invalid host address %s (use 'host/name:port')", address)
+	}
+
+	// The relay listens on the port of the host, the only remote port it can serve
+	ports, err = withDefaultRemotePort(ports, int32(port))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ports {
+		if spec, err := config.ParsePort(p); err != nil || spec.RemotePort() != port {
+			return nil, fmt.Errorf("invalid port %s for host %s: the remote port must be %d", p, address, port)
+		}
+	}
+
+	return &RelayLocator{
+		host:  host,
+		port:  port,
+		ports: ports,
+		relay: relay,
+	}, nil
+}
+
+// Locate ensures the relay pod exists and returns it once running.
+func (l *RelayLocator) Locate(ctx context.Context) (Target, error) {
+	address := net.JoinHostPort(l.host, strconv.Itoa(l.port))
+
+	pod, err := l.relay.Ensure(ctx, l.host, l.port)
+	if err != nil {
+		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+			return Target{}, NewPermissionDeniedError("create", fmt.Sprintf("relay pod for %s", address), err)
+		}
+		return Target{}, NewAPITransientError(fmt.Sprintf("failed to create relay pod for %s", address), err)
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return Target{}, NewPodNotRunningError(pod.Name, string(pod.Status.Phase), nil)
+	}
+
+	return newTarget(pod, l.ports, StrategyRelay), nil
+}
+
+// ReverseLocator locates the relay pod of a reverse forward. Its targets map the exposed
+// port to the tunnel port of the relay pod.
+type ReverseLocator struct {
+	port  int
+	relay ReverseRelay
+}
+
+// NewReverseLocator creates a locator for the relay pod exposing port in the cluster.
+func NewReverseLocator(port int, relay ReverseRelay) (*ReverseLocator, error) {
+	if relay == nil {
+		return nil, fmt.Errorf("no relay configured for reverse port %d", port)
+	}
+
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid reverse port %d", port)
+	}
+
+	return &ReverseLocator{port: port, relay: relay}, nil
+}
+
+// Locate ensures the reverse relay pod exists and returns it once running.
+func (l *ReverseLocator) Locate(ctx context.Context) (Target, error) {
+	pod, err := l.relay.EnsureReverse(ctx, l.port)
+	if err != nil {
+		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+			return Target{}, NewPermissionDeniedError("create", fmt.Sprintf("reverse relay pod for port %d", l.port), err)
+		}
+		return Target{}, NewAPITransientError(fmt.Sprintf("failed to create reverse relay pod for port %d", l.port), err)
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return Target{}, NewPodNotRunningError(pod.Name, string(pod.Status.Phase), nil)
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == ReverseTunnelPortName {
+				ports := []string{fmt.Sprintf("%d:%d", l.port, p.ContainerPort)}
+				return newTarget(pod, ports, StrategyReverse), nil
+			}
+		}
+	}
+
+	return Target{}, NewConfigInvalidError(fmt.Sprintf("reverse relay pod %s has no %s port", pod.Name, ReverseTunnelPortName), nil)
+}
@@ -0,0 +1,479 @@
+package locator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
+)
+
+// routeBackend is the Service (and service port) a route sends matching traffic to.
+type routeBackend struct {
+	namespace string
+	service   string
+	port      int32  // service port number, 0 when portName is used
+	portName  string // service port name (Ingress only)
+}
+
+// RouteLocator resolves an Ingress or Gateway API HTTPRoute to its backend Service,
+// then delegates to a ServiceLocator. Ports without a remote part are forwarded to
+// the backend port defined by the route.
+type RouteLocator struct {
+	routeType string // "ingress", "httproute"
+	routeName string
+	namespace string
+	ports     []string
+	cache     *kubeinternal.InformerCache
+
+	host string
+	path string
+
+	// opts are passed on to the delegated ServiceLocator
+	opts []Option
+}
+
+// NewRouteLocator creates a locator for an Ingress or HTTPRoute.
+func NewRouteLocator(routeType string, routeName string, namespace string, ports []string, cache *kubeinternal.InformerCache, opts ...Option) (*RouteLocator, error) {
+	o := buildOptions(opts)
+
+	if _, err := newNodeFilter(o.node); err != nil {
+		return nil, err
+	}
+
+	return &RouteLocator{
+		routeType: routeType,
+		routeName: routeName,
+		namespace: namespace,
+		ports:     ports,
+		cache:     cache,
+		host:      o.host,
+		path:      o.path,
+		opts:      opts,
+	}, nil
+}
+
+// Locate resolves the route backend and returns a running pod of the backend service.
+func (l *RouteLocator) Locate(ctx context.Context) (Target, error) {
+	targets, err := l.LocateAll(ctx, 1)
+	if err != nil {
+		return Target{}, err
+	}
+	return targets[0], nil
+}
+
+// LocateAll resolves the route backend and returns up to max running pods of the backend service.
+func (l *RouteLocator) LocateAll(ctx context.Context, max int) ([]Target, error) {
+	var backend routeBackend
+	var err error
+
+	switch l.routeType {
+	case "ingress":
+		backend, err = l.ingressBackend(ctx)
+	case "httproute":
+		backend, err = l.httpRouteBackend(ctx)
+	default:
+		err = NewConfigInvalidError(fmt.Sprintf("unsupported route type: %s", l.routeType), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := l.backendPort(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := withDefaultRemotePort(l.ports, port)
+	if err != nil {
+		return nil, err
+	}
+
+	svcLocator, err := NewServiceLocator(backend.service, backend.namespace, ports, l.cache, l.opts...)
+	if err != nil {
+		return nil, NewConfigInvalidError(fmt.Sprintf("invalid backend for %s %s", l.routeType, l.routeName), err)
+	}
+
+	return svcLocator.LocateAll(ctx, max)
+}
+
+// ingressBackend picks the Ingress backend matching the requested host and path.
+func (l *RouteLocator) ingressBackend(ctx context.Context) (routeBackend, error) {
+	ingresses, err := l.cache.Ingresses(ctx, l.namespace)
+	if err != nil {
+		return routeBackend{}, NewCacheSyncError("ingresses", l.namespace, err)
+	}
+
+	ing, err := ingresses.Get(l.routeName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return routeBackend{}, NewResourceNotFoundError("ingress", l.routeName, err)
+		}
+		return routeBackend{}, NewAPITransientError(fmt.Sprintf("failed to get ingress %s", l.routeName), err)
+	}
+
+	var best *networkingv1.IngressBackend
+	bestLen := -1
+
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil || !hostMatches(rule.Host, l.host) {
+			continue
+		}
+
+		for i := range rule.HTTP.Paths {
+			p := &rule.HTTP.Paths[i]
+
+			// Without a requested path, the first path of the first matching rule wins
+			if l.path == "" {
+				if best == nil {
+					best = &p.Backend
+				}
+				continue
+			}
+
+			exact := p.PathType != nil && *p.PathType == networkingv1.PathTypeExact
+			if pathMatches(p.Path, l.path, exact) && len(p.Path) > bestLen {
+				best = &p.Backend
+				bestLen = len(p.Path)
+			}
+		}
+	}
+
+	if best == nil {
+		best = ing.Spec.DefaultBackend
+	}
+
+	if best == nil || best.Service == nil {
+		return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("ingress %s has no service backend for %s", l.routeName, l.matchInfo()), nil)
+	}
+
+	return routeBackend{
+		namespace: l.namespace,
+		service:   best.Service.Name,
+		port:      best.Service.Port.Number,
+		portName:  best.Service.Port.Name,
+	}, nil
+}
+
+// httpRoute mirrors the subset of the Gateway API HTTPRoute spec needed to find a backend.
+type httpRoute struct {
+	Spec struct {
+		Hostnames []string        `json:"hostnames"`
+		Rules     []httpRouteRule `json:"rules"`
+	} `json:"spec"`
+}
+
+// httpRouteRule is a single HTTPRoute rule.
+type httpRouteRule struct {
+	Matches     []httpRouteMatch      `json:"matches"`
+	BackendRefs []httpRouteBackendRef `json:"backendRefs"`
+}
+
+// httpRouteMatch is the path part of an HTTPRoute match; other conditions are ignored.
+type httpRouteMatch struct {
+	Path *struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"path"`
+}
+
+// httpRouteBackendRef references the backend of an HTTPRoute rule.
+type httpRouteBackendRef struct {
+	Group     *string `json:"group"`
+	Kind      *string `json:"kind"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace"`
+	Port      *int32  `json:"port"`
+	Weight    *int32  `json:"weight"`
+}
+
+// pathMatch returns the path match type and value, applying the Gateway API defaults.
+func (m httpRouteMatch) pathMatch() (string, string) {
+	pathType, value := "PathPrefix", "/"
+	if m.Path != nil {
+		if m.Path.Type != "" {
+			pathType = m.Path.Type
+		}
+		if m.Path.Value != "" {
+			value = m.Path.Value
+		}
+	}
+	return pathType, value
+}
+
+// httpRouteBackend picks the HTTPRoute backend matching the requested host and path.
+func (l *RouteLocator) httpRouteBackend(ctx context.Context) (routeBackend, error) {
+	routes, err := l.cache.HTTPRoutes(ctx, l.namespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return routeBackend{}, NewResourceNotFoundError("resource type", kubeinternal.HTTPRouteResource.GroupResource().String(), err)
+		}
+		return routeBackend{}, NewCacheSyncError("httproutes", l.namespace, err)
+	}
+
+	obj, err := routes.Get(l.routeName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return routeBackend{}, NewResourceNotFoundError("httproute", l.routeName, err)
+		}
+		return routeBackend{}, NewAPITransientError(fmt.Sprintf("failed to get httproute %s", l.routeName), err)
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return routeBackend{}, NewAPITransientError(fmt.Sprintf("unexpected object type %T for httproute %s", obj, l.routeName), nil)
+	}
+
+	var route httpRoute
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &route); err != nil {
+		return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("cannot decode httproute %s", l.routeName), err)
+	}
+
+	if l.host != "" && len(route.Spec.Hostnames) > 0 {
+		matched := false
+		for _, h := range route.Spec.Hostnames {
+			if hostMatches(h, l.host) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("httproute %s does not serve host %s", l.routeName, l.host), nil)
+		}
+	}
+
+	requestPath := l.path
+	if requestPath == "" {
+		requestPath = "/"
+	}
+
+	bestRule, bestLen := -1, -1
+	for i, rule := range route.Spec.Rules {
+		// A rule without matches matches every request, as a "/" prefix
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []httpRouteMatch{{}}
+		}
+
+		for _, m := range matches {
+			pathType, value := m.pathMatch()
+
+			var ok bool
+			switch pathType {
+			case "Exact":
+				ok = pathMatches(value, requestPath, true)
+			case "RegularExpression":
+				re, err := regexp.Compile(value)
+				ok = err == nil && re.MatchString(requestPath)
+			default:
+				ok = pathMatches(value, requestPath, false)
+			}
+
+			if ok && len(value) > bestLen {
+				bestRule, bestLen = i, len(value)
+			}
+		}
+	}
+
+	if bestRule < 0 {
+		return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("httproute %s has no rule for %s", l.routeName, l.matchInfo()), nil)
+	}
+
+	for _, ref := range route.Spec.Rules[bestRule].BackendRefs {
+		if ref.Group != nil && *ref.Group != "" {
+			continue
+		}
+		if ref.Kind != nil && *ref.Kind != "Service" {
+			continue
+		}
+		if ref.Weight != nil && *ref.Weight == 0 {
+			continue
+		}
+		if ref.Port == nil {
+			return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("httproute %s backend %s has no port", l.routeName, ref.Name), nil)
+		}
+
+		namespace := l.namespace
+		if ref.Namespace != nil && *ref.Namespace != "" {
+			namespace = *ref.Namespace
+		}
+		if namespace != l.namespace {
+			if err := l.checkReferenceGrant(ctx, namespace, ref.Name); err != nil {
+				return routeBackend{}, err
+			}
+		}
+
+		return routeBackend{namespace: namespace, service: ref.Name, port: *ref.Port}, nil
+	}
+
+	return routeBackend{}, NewConfigInvalidError(fmt.Sprintf("httproute %s has no service backend for %s", l.routeName, l.matchInfo()), nil)
+}
+
+// referenceGrant allows references from resources in other namespaces to resources in its own.
+type referenceGrant struct {
+	Spec struct {
+		From []struct {
+			Group     string `json:"group"`
+			Kind      string `json:"kind"`
+			Namespace string `json:"namespace"`
+		} `json:"from"`
+		To []struct {
+			Group string  `json:"group"`
+			Kind  string  `json:"kind"`
+			Name  *string `json:"name"`
+		} `json:"to"`
+	} `json:"spec"`
+}
+
+// allows reports whether the grant lets HTTPRoutes in namespace reference the Service.
+func (g referenceGrant) allows(namespace string, service string) bool {
+	from := false
+	for _, f := range g.Spec.From {
+		if f.Group == kubeinternal.HTTPRouteResource.Group && f.Kind == "HTTPRoute" && f.Namespace == namespace {
+			from = true
+			break
+		}
+	}
+	if !from {
+		return false
+	}
+
+	for _, t := range g.Spec.To {
+		if t.Group == "" && t.Kind == "Service" && (t.Name == nil || *t.Name == "" || *t.Name == service) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReferenceGrant makes sure a ReferenceGrant in the backend namespace allows the route to
+// reference the Service, as Gateway API implementations require for cross-namespace backends.
+func (l *RouteLocator) checkReferenceGrant(ctx context.Context, namespace string, service string) error {
+	denied := NewConfigInvalidError(fmt.Sprintf("httproute %s references service %s/%s in another namespace, but no ReferenceGrant in %s allows it",
+		l.routeName, namespace, service, namespace), nil)
+
+	grants, err := l.cache.ReferenceGrants(ctx, namespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return denied
+		}
+		return NewCacheSyncError("referencegrants", namespace, err)
+	}
+
+	objs, err := grants.List(labels.Everything())
+	if err != nil {
+		return NewAPITransientError(fmt.Sprintf("failed to list referencegrants in %s", namespace), err)
+	}
+
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		var grant referenceGrant
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &grant); err != nil {
+			continue
+		}
+		if grant.allows(l.namespace, service) {
+			return nil
+		}
+	}
+
+	return denied
+}
+
+// backendPort resolves the backend service port number, looking up named ports on the service.
+func (l *RouteLocator) backendPort(ctx context.Context, backend routeBackend) (int32, error) {
+	if backend.portName == "" {
+		return backend.port, nil
+	}
+
+	services, err := l.cache.Services(ctx, backend.namespace)
+	if err != nil {
+		return 0, NewCacheSyncError("services", backend.namespace, err)
+	}
+
+	svc, err := services.Get(backend.service)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return 0, NewResourceNotFoundError("service", backend.service, err)
+		}
+		return 0, NewAPITransientError(fmt.Sprintf("failed to get service %s", backend.service), err)
+	}
+
+	for _, p := range svc.Spec.Ports {
+		if p.Name == backend.portName {
+			return p.Port, nil
+		}
+	}
+
+	return 0, NewConfigInvalidError(fmt.Sprintf("service %s has no port named %s", backend.service, backend.portName), nil)
+}
+
+// matchInfo describes the requested host and path for error messages.
+func (l *RouteLocator) matchInfo() string {
+	host, path := l.host, l.path
+	if host == "" {
+		host = "*"
+	}
+	if path == "" {
+		path = "/"
+	}
+	return host + path
+}
+
+// withDefaultRemotePort completes port mappings without a remote part with the backend port.
+// Explicit remote ports are kept and interpreted as service ports.
+func withDefaultRemotePort(ports []string, remote int32) ([]string, error) {
+	result := []string{}
+
+	for _, port := range ports {
+		spec, err := config.ParsePort(port)
+		if err != nil {
+			return []string{}, NewConfigInvalidError(fmt.Sprintf("invalid port %s", port), err)
+		}
+
+		if spec.Remote == 0 {
+			spec.Remote = int(remote)
+		}
+		result = append(result, fmt.Sprintf("%s:%d", spec.LocalName(), spec.Remote))
+	}
+
+	return result, nil
+}
+
+// hostMatches reports whether a route host (possibly a "*." wildcard) serves the requested host.
+// Empty route hosts match any host, and an empty requested host matches any route.
+func hostMatches(routeHost string, host string) bool {
+	if routeHost == "" || host == "" {
+		return true
+	}
+
+	if suffix, ok := strings.CutPrefix(routeHost, "*"); ok {
+		// A wildcard covers exactly one extra DNS label
+		label, found := strings.CutSuffix(host, suffix)
+		return found && label != "" && !strings.Contains(label, ".")
+	}
+
+	return strings.EqualFold(routeHost, host)
+}
+
+// pathMatches reports whether a route path matches the requested path, either
+// exactly or as an element-wise prefix ("/api" matches "/api/v1" but not "/apis").
+func pathMatches(routePath string, path string, exact bool) bool {
+	if exact {
+		return routePath == path
+	}
+
+	prefix := strings.TrimSuffix(routePath, "/")
+	return path == prefix || prefix == "" || strings.HasPrefix(path, prefix+"/")
+}
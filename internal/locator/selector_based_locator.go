@@ -7,8 +7,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 )
 
 // SelectorBasedLocator locates a pod backing a Kubernetes resource with a selector
@@ -18,51 +18,83 @@ type SelectorBasedLocator struct {
 	resourceName string
 	namespace    string
 	ports        []string
-	client       kubernetes.Interface
+	cache        *kubeinternal.InformerCache
+	nodeFilter   *nodeFilter
 }
 
 // NewSelectorBasedLocator creates a locator for any resource type with a selector.
-func NewSelectorBasedLocator(resourceType string, resourceName string, namespace string, ports []string, client kubernetes.Interface) (*SelectorBasedLocator, error) {
+func NewSelectorBasedLocator(resourceType string, resourceName string, namespace string, ports []string, cache *kubeinternal.InformerCache, opts ...Option) (*SelectorBasedLocator, error) {
+	o := buildOptions(opts)
+
+	nf, err := newNodeFilter(o.node)
+	if err != nil {
+		return nil, err
+	}
+
 	return &SelectorBasedLocator{
 		resourceType: resourceType,
 		resourceName: resourceName,
 		namespace:    namespace,
 		ports:        ports,
-		client:       client,
+		cache:        cache,
+		nodeFilter:   nf,
 	}, nil
 }
 
-// Locate finds a running pod backing the resource and returns its name and ports.
-func (l *SelectorBasedLocator) Locate(ctx context.Context) (string, []string, error) {
+// Locate finds a running pod backing the resource and returns it as the target.
+func (l *SelectorBasedLocator) Locate(ctx context.Context) (Target, error) {
+	targets, err := l.LocateAll(ctx, 1)
+	if err != nil {
+		return Target{}, err
+	}
+	return targets[0], nil
+}
+
+// LocateAll returns up to max running pods of the resource (all when max <= 0), sorted by name.
+func (l *SelectorBasedLocator) LocateAll(ctx context.Context, max int) ([]Target, error) {
 	// Get the selector based on resource type
 	labelSelector, err := l.getSelector(ctx)
 	if err != nil {
-		return "", []string{}, err
+		return nil, err
+	}
+
+	pods, err := l.cache.Pods(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("pods", l.namespace, err)
 	}
 
 	// List pods matching the selector
-	pods, err := l.client.CoreV1().Pods(l.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector.String(),
-	})
+	candidates, err := pods.List(labelSelector)
 	if err != nil {
-		// Classify API errors
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return "", []string{}, NewAPITransientError(fmt.Sprintf("API timeout listing pods for %s %s", l.resourceType, l.resourceName), err)
-		}
-		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
-			return "", []string{}, NewPermissionDeniedError("list", fmt.Sprintf("pods for %s %s", l.resourceType, l.resourceName), err)
+		return nil, NewAPITransientError(fmt.Sprintf("failed to list pods for %s %s", l.resourceType, l.resourceName), err)
+	}
+
+	// Restrict to the requested node(s)
+	if l.nodeFilter != nil {
+		candidates, err = l.nodeFilter.filter(ctx, l.cache, candidates, fmt.Sprintf("%s %s", l.resourceType, l.resourceName))
+		if err != nil {
+			return nil, err
 		}
-		return "", []string{}, NewAPITransientError(fmt.Sprintf("failed to list pods for %s %s", l.resourceType, l.resourceName), err)
 	}
 
-	// Find the first running pod
-	for _, p := range pods.Items {
-		if p.Status.Phase == corev1.PodRunning {
-			return p.Name, l.ports, nil
+	// Collect the running pods
+	targets := []Target{}
+	for _, p := range sortPodsByName(candidates) {
+		if p.Status.Phase != corev1.PodRunning {
+			continue
 		}
+
+		targets = append(targets, newTarget(p, l.ports, l.nodeFilter.strategy()))
+		if max > 0 && len(targets) == max {
+			break
+		}
+	}
+
+	if len(targets) > 0 {
+		return targets, nil
 	}
 
-	return "", []string{}, &LocateError{
+	return nil, &LocateError{
 		Type:    ErrorTypeNoPodAvailable,
 		Message: fmt.Sprintf("no running pod found for %s %s", l.resourceType, l.resourceName),
 		Err:     nil,
@@ -85,14 +117,16 @@ func (l *SelectorBasedLocator) getSelector(ctx context.Context) (labels.Selector
 
 // getDeploymentSelector retrieves the selector from a Deployment.
 func (l *SelectorBasedLocator) getDeploymentSelector(ctx context.Context) (labels.Selector, error) {
-	deployment, err := l.client.AppsV1().Deployments(l.namespace).Get(ctx, l.resourceName, metav1.GetOptions{})
+	lister, err := l.cache.Deployments(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("deployments", l.namespace, err)
+	}
+
+	deployment, err := lister.Get(l.resourceName)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return nil, NewResourceNotFoundError("deployment", l.resourceName, err)
 		}
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return nil, NewAPITransientError(fmt.Sprintf("API timeout getting deployment %s", l.resourceName), err)
-		}
 		return nil, NewAPITransientError(fmt.Sprintf("failed to get deployment %s", l.resourceName), err)
 	}
 
@@ -105,14 +139,16 @@ func (l *SelectorBasedLocator) getDeploymentSelector(ctx context.Context) (label
 
 // getStatefulSetSelector retrieves the selector from a StatefulSet.
 func (l *SelectorBasedLocator) getStatefulSetSelector(ctx context.Context) (labels.Selector, error) {
-	statefulSet, err := l.client.AppsV1().StatefulSets(l.namespace).Get(ctx, l.resourceName, metav1.GetOptions{})
+	lister, err := l.cache.StatefulSets(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("statefulsets", l.namespace, err)
+	}
+
+	statefulSet, err := lister.Get(l.resourceName)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return nil, NewResourceNotFoundError("statefulset", l.resourceName, err)
 		}
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return nil, NewAPITransientError(fmt.Sprintf("API timeout getting statefulset %s", l.resourceName), err)
-		}
 		return nil, NewAPITransientError(fmt.Sprintf("failed to get statefulset %s", l.resourceName), err)
 	}
 
@@ -125,14 +161,16 @@ func (l *SelectorBasedLocator) getStatefulSetSelector(ctx context.Context) (labe
 
 // getDaemonSetSelector retrieves the selector from a DaemonSet.
 func (l *SelectorBasedLocator) getDaemonSetSelector(ctx context.Context) (labels.Selector, error) {
-	daemonSet, err := l.client.AppsV1().DaemonSets(l.namespace).Get(ctx, l.resourceName, metav1.GetOptions{})
+	lister, err := l.cache.DaemonSets(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("daemonsets", l.namespace, err)
+	}
+
+	daemonSet, err := lister.Get(l.resourceName)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return nil, NewResourceNotFoundError("daemonset", l.resourceName, err)
 		}
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return nil, NewAPITransientError(fmt.Sprintf("API timeout getting daemonset %s", l.resourceName), err)
-		}
 		return nil, NewAPITransientError(fmt.Sprintf("failed to get daemonset %s", l.resourceName), err)
 	}
 
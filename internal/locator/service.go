@@ -3,16 +3,15 @@ package locator
 import (
 	"context"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/client-go/kubernetes"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
 )
 
 // ServiceLocator locates a pod backing a service and maps service ports to pod ports.
@@ -20,65 +19,94 @@ type ServiceLocator struct {
 	svcName   string
 	namespace string
 	ports     []string
-	client    kubernetes.Interface
+	cache     *kubeinternal.InformerCache
+
+	nodeFilter *nodeFilter
 }
 
 // NewServiceLocator creates a new service locator for the specified service name.
-func NewServiceLocator(svcName string, namespace string, ports []string, client kubernetes.Interface) (*ServiceLocator, error) {
+func NewServiceLocator(svcName string, namespace string, ports []string, cache *kubeinternal.InformerCache, opts ...Option) (*ServiceLocator, error) {
+	o := buildOptions(opts)
+
+	nf, err := newNodeFilter(o.node)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ServiceLocator{
-		svcName:   svcName,
-		namespace: namespace,
-		ports:     ports,
-		client:    client,
+		svcName:    svcName,
+		namespace:  namespace,
+		ports:      ports,
+		cache:      cache,
+		nodeFilter: nf,
 	}, nil
 }
 
-// Locate finds a running pod backing the service and returns its name and mapped ports.
-func (l *ServiceLocator) Locate(ctx context.Context) (string, []string, error) {
-	svc, err := l.client.CoreV1().Services(l.namespace).Get(ctx, l.svcName, metav1.GetOptions{})
+// Locate finds a running pod backing the service and returns it with the mapped ports.
+func (l *ServiceLocator) Locate(ctx context.Context) (Target, error) {
+	targets, err := l.LocateAll(ctx, 1)
+	if err != nil {
+		return Target{}, err
+	}
+	return targets[0], nil
+}
+
+// LocateAll returns up to max running pods backing the service (all when max <= 0), sorted by name.
+func (l *ServiceLocator) LocateAll(ctx context.Context, max int) ([]Target, error) {
+	services, err := l.cache.Services(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("services", l.namespace, err)
+	}
+
+	svc, err := services.Get(l.svcName)
 	if err != nil {
-		// Classify API errors
 		if apierrors.IsNotFound(err) {
-			return "", []string{}, NewResourceNotFoundError("service", l.svcName, err)
+			return nil, NewResourceNotFoundError("service", l.svcName, err)
 		}
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return "", []string{}, NewAPITransientError(fmt.Sprintf("API timeout getting service %s", l.svcName), err)
-		}
-		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
-			return "", []string{}, NewPermissionDeniedError("get", fmt.Sprintf("service %s", l.svcName), err)
-		}
-		return "", []string{}, NewAPITransientError(fmt.Sprintf("failed to get service %s", l.svcName), err)
+		return nil, NewAPITransientError(fmt.Sprintf("failed to get service %s", l.svcName), err)
 	}
 
-	labelSelector := labels.Set(svc.Spec.Selector).AsSelector()
+	pods, err := l.cache.Pods(ctx, l.namespace)
+	if err != nil {
+		return nil, NewCacheSyncError("pods", l.namespace, err)
+	}
 
-	pods, err := l.client.CoreV1().Pods(l.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector.String(),
-	})
+	candidates, err := pods.List(labels.Set(svc.Spec.Selector).AsSelector())
 	if err != nil {
-		// Classify API errors
-		if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) {
-			return "", []string{}, NewAPITransientError(fmt.Sprintf("API timeout listing pods for service %s", l.svcName), err)
-		}
-		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
-			return "", []string{}, NewPermissionDeniedError("list", fmt.Sprintf("pods for service %s", l.svcName), err)
+		return nil, NewAPITransientError(fmt.Sprintf("failed to list pods for service %s", l.svcName), err)
+	}
+
+	// Restrict to the requested node(s)
+	if l.nodeFilter != nil {
+		candidates, err = l.nodeFilter.filter(ctx, l.cache, candidates, fmt.Sprintf("service %s", l.svcName))
+		if err != nil {
+			return nil, err
 		}
-		return "", []string{}, NewAPITransientError(fmt.Sprintf("failed to list pods for service %s", l.svcName), err)
 	}
 
-	for _, p := range pods.Items {
-		if p.Status.Phase == corev1.PodRunning {
-			ports, err := l.mapPorts(svc, &p)
-			if err != nil {
-				return "", []string{}, err
-			}
+	targets := []Target{}
+	for _, p := range sortPodsByName(candidates) {
+		if p.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		ports, err := l.mapPorts(svc, p)
+		if err != nil {
+			return nil, err
+		}
 
-			return p.Name, ports, nil
+		targets = append(targets, newTarget(p, ports, l.nodeFilter.strategy()))
+		if max > 0 && len(targets) == max {
+			break
 		}
 	}
 
+	if len(targets) > 0 {
+		return targets, nil
+	}
+
 	// No running pods found for service
-	return "", []string{}, &LocateError{
+	return nil, &LocateError{
 		Type:    ErrorTypeNoPodAvailable,
 		Message: fmt.Sprintf("no running pod found for service %s", l.svcName),
 		Err:     nil,
@@ -91,20 +119,12 @@ func (l *ServiceLocator) mapPorts(svc *corev1.Service, pod *corev1.Pod) ([]strin
 	result := []string{}
 
 	for i := range l.ports {
-		parts := strings.Split(l.ports[i], ":")
-
-		srcPort, err := strconv.Atoi(parts[0])
+		spec, err := config.ParsePort(l.ports[i])
 		if err != nil {
-			return []string{}, NewConfigInvalidError(fmt.Sprintf("invalid local port %s", parts[0]), err)
+			return []string{}, NewConfigInvalidError(fmt.Sprintf("invalid port %s", l.ports[i]), err)
 		}
 
-		dstPort := srcPort
-		if len(parts) > 1 {
-			dstPort, err = strconv.Atoi(parts[1])
-			if err != nil {
-				return []string{}, NewConfigInvalidError(fmt.Sprintf("invalid remote port %s", parts[1]), err)
-			}
-		}
+		dstPort := spec.RemotePort()
 
 		sp, ok := lo.Find(svc.Spec.Ports, func(p corev1.ServicePort) bool {
 			return p.Port == int32(dstPort)
@@ -128,11 +148,8 @@ func (l *ServiceLocator) mapPorts(svc *corev1.Service, pod *corev1.Pod) ([]strin
 			dstPort = int(pp.ContainerPort)
 		}
 
-		if dstPort == srcPort {
-			result = append(result, fmt.Sprintf("%d", srcPort))
-		} else {
-			result = append(result, fmt.Sprintf("%d:%d", srcPort, dstPort))
-		}
+		spec.Remote = dstPort
+		result = append(result, spec.String())
 	}
 
 	return result, nil
@@ -0,0 +1,82 @@
+package locator
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+// Strategy describes how a locator picked its target pod.
+type Strategy string
+
+const (
+	StrategyPodName      Strategy = "pod-name"      // Pod referenced by its exact name
+	StrategyPodPattern   Strategy = "pod-pattern"   // First running pod matching a name pattern
+	StrategySelector     Strategy = "selector"      // First running pod matching the resource selector
+	StrategyNodeSelector Strategy = "node-selector" // As StrategySelector, restricted to the requested node(s)
+	StrategyRelay        Strategy = "relay"         // Relay pod created to reach an in-cluster host
+	StrategyReverse      Strategy = "reverse"       // Relay pod created to expose a local address
+)
+
+// Target is the result of a successful Locate: the pod to forward to and its metadata.
+type Target struct {
+	Namespace string
+	PodName   string
+	PodUID    types.UID
+	NodeName  string
+
+	// Container is the container exposing the first forwarded port (or the first container)
+	Container    string
+	RestartCount int32
+
+	// Ports are the port mappings to forward, translated to pod ports when needed
+	Ports []string
+
+	Strategy Strategy
+}
+
+// newTarget builds a Target from a located pod.
+func newTarget(pod *corev1.Pod, ports []string, strategy Strategy) Target {
+	container := targetContainer(pod, ports)
+
+	var restarts int32
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container {
+			restarts = cs.RestartCount
+		}
+	}
+
+	return Target{
+		Namespace:    pod.Namespace,
+		PodName:      pod.Name,
+		PodUID:       pod.UID,
+		NodeName:     pod.Spec.NodeName,
+		Container:    container,
+		RestartCount: restarts,
+		Ports:        ports,
+		Strategy:     strategy,
+	}
+}
+
+// targetContainer returns the name of the container declaring the first remote port,
+// falling back to the first container of the pod.
+func targetContainer(pod *corev1.Pod, ports []string) string {
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+
+	if len(ports) > 0 {
+		if spec, err := config.ParsePort(ports[0]); err == nil {
+			for _, c := range pod.Spec.Containers {
+				for _, p := range c.Ports {
+					if int(p.ContainerPort) == spec.RemotePort() {
+						return c.Name
+					}
+				}
+			}
+		}
+	}
+
+	return pod.Spec.Containers[0].Name
+}
@@ -0,0 +1,203 @@
+// Package loopback allocates loopback addresses to forwards, so that several forwards can
+// listen on the same local port. Allocations are persisted in a file to keep the address
+// of a forward stable across runs.
+package loopback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DefaultPool is the range addresses are allocated from.
+const DefaultPool = "127.1.0.0/16"
+
+// fileName is the name of the allocation file in the user configuration directory.
+const fileName = "addresses.json"
+
+// loopbackPrefix is the range every pool must be part of.
+var loopbackPrefix = netip.MustParsePrefix("127.0.0.0/8")
+
+// reserved are never allocated: the default address of forwards.
+var reserved = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+
+// DefaultPath returns the allocation file in the user configuration directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the user configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "fwkeeper", fileName), nil
+}
+
+// ParsePool parses an IPv4 loopback range in CIDR notation.
+func ParsePool(pool string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(pool)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address pool %s: %w", pool, err)
+	}
+
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || prefix.Bits() < loopbackPrefix.Bits() || !loopbackPrefix.Contains(prefix.Addr()) {
+		return netip.Prefix{}, fmt.Errorf("invalid address pool %s: not an IPv4 loopback range", pool)
+	}
+
+	return prefix, nil
+}
+
+// Allocator allocates the addresses of a pool, persisting them in a file.
+type Allocator struct {
+	pool netip.Prefix
+	path string
+}
+
+// NewAllocator creates an allocator of the addresses of pool, persisted in the file at path.
+func NewAllocator(pool string, path string) (*Allocator, error) {
+	prefix, err := ParsePool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Allocator{pool: prefix, path: path}, nil
+}
+
+// Path returns the path of the allocation file.
+func (a *Allocator) Path() string {
+	return a.path
+}
+
+// Allocate returns a distinct address for each name. A name keeps the address it was given
+// before, as long as it is part of the pool. Addresses of names no longer allocated are kept
+// for them, unless the pool is exhausted.
+func (a *Allocator) Allocate(names []string) (map[string]netip.Addr, error) {
+	loaded, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	persisted := maps.Clone(loaded)
+
+	allocated := make(map[string]netip.Addr, len(names))
+	used := make(map[netip.Addr]bool)
+
+	// Keep previous allocations first, so that they win over new names
+	for _, name := range names {
+		addr, ok := persisted[name]
+		if !ok || used[addr] || !a.allocatable(addr) {
+			continue
+		}
+		allocated[name] = addr
+		used[addr] = true
+	}
+
+	// Addresses of names no longer allocated are only reused when the pool is exhausted
+	stale := make(map[netip.Addr]string)
+	for name, addr := range persisted {
+		if !slices.Contains(names, name) {
+			stale[addr] = name
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := allocated[name]; ok {
+			continue
+		}
+
+		addr, ok := a.next(func(addr netip.Addr) bool {
+			_, isStale := stale[addr]
+			return !used[addr] && !isStale
+		})
+		if !ok {
+			addr, ok = a.next(func(addr netip.Addr) bool { return !used[addr] })
+		}
+		if !ok {
+			return nil, fmt.Errorf("address pool %s is exhausted", a.pool)
+		}
+
+		if previous, isStale := stale[addr]; isStale {
+			delete(persisted, previous)
+		}
+		allocated[name] = addr
+		used[addr] = true
+	}
+
+	updated := make(map[string]netip.Addr, len(persisted)+len(allocated))
+	for name, addr := range persisted {
+		if !used[addr] || allocated[name] == addr {
+			updated[name] = addr
+		}
+	}
+	for name, addr := range allocated {
+		updated[name] = addr
+	}
+
+	if err := a.save(loaded, updated); err != nil {
+		return nil, err
+	}
+
+	return allocated, nil
+}
+
+// allocatable checks that an address can be allocated.
+func (a *Allocator) allocatable(addr netip.Addr) bool {
+	return a.pool.Contains(addr) && addr != a.pool.Addr() && !slices.Contains(reserved, addr)
+}
+
+// next returns the first allocatable address of the pool accepted by free.
+func (a *Allocator) next(free func(netip.Addr) bool) (netip.Addr, bool) {
+	for addr := a.pool.Addr(); a.pool.Contains(addr); addr = addr.Next() {
+		if a.allocatable(addr) && free(addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// load reads the allocation file, empty when missing.
+func (a *Allocator) load() (map[string]netip.Addr, error) {
+	content, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]netip.Addr), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address allocations: %w", err)
+	}
+
+	allocations := make(map[string]netip.Addr)
+	if err := json.Unmarshal(content, &allocations); err != nil {
+		return nil, fmt.Errorf("invalid address allocations in %s: %w", a.path, err)
+	}
+	return allocations, nil
+}
+
+// save writes the allocation file, unless allocations did not change.
+func (a *Allocator) save(previous, allocations map[string]netip.Addr) error {
+	if _, err := os.Stat(a.path); err == nil && maps.Equal(previous, allocations) {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(allocations, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write address allocations: %w", err)
+	}
+
+	// Replaced atomically, so that a crash never leaves a truncated file
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, append(content, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write address allocations: %w", err)
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write address allocations: %w", err)
+	}
+
+	return nil
+}
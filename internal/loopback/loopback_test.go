@@ -0,0 +1,83 @@
+package loopback
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParsePool tests that pools must be IPv4 loopback ranges
+func TestParsePool(t *testing.T) {
+	pool, err := ParsePool("127.1.2.3/16")
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("127.1.0.0/16"), pool)
+
+	for _, invalid := range []string{"127.1.0.0", "10.0.0.0/8", "0.0.0.0/0", "::1/128", "127.0.0.0/7"} {
+		_, err := ParsePool(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// TestAllocatorStable tests that forwards keep their address across runs
+func TestAllocatorStable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fwkeeper", "addresses.json")
+
+	a, err := NewAllocator("127.1.0.0/24", path)
+	require.NoError(t, err)
+
+	addresses, err := a.Allocate([]string{"api", "db"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]netip.Addr{
+		"api": netip.MustParseAddr("127.1.0.1"),
+		"db":  netip.MustParseAddr("127.1.0.2"),
+	}, addresses)
+
+	// db keeps its address, the address of api is not given to a new forward
+	a, err = NewAllocator("127.1.0.0/24", path)
+	require.NoError(t, err)
+	addresses, err = a.Allocate([]string{"cache", "db"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.1.0.2"), addresses["db"])
+	assert.Equal(t, netip.MustParseAddr("127.1.0.3"), addresses["cache"])
+
+	// api gets its address back
+	addresses, err = a.Allocate([]string{"api"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.1.0.1"), addresses["api"])
+
+	// Allocations out of a new pool are replaced
+	a, err = NewAllocator("127.2.0.0/24", path)
+	require.NoError(t, err)
+	addresses, err = a.Allocate([]string{"db"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.2.0.1"), addresses["db"])
+}
+
+// TestAllocatorExhausted tests that stale allocations are reused once the pool is exhausted
+func TestAllocatorExhausted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addresses.json")
+
+	// 127.0.0.0/30 offers 127.0.0.2 and 127.0.0.3: the network and default addresses are skipped
+	a, err := NewAllocator("127.0.0.0/30", path)
+	require.NoError(t, err)
+
+	addresses, err := a.Allocate([]string{"api", "db"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.2"), addresses["api"])
+	assert.Equal(t, netip.MustParseAddr("127.0.0.3"), addresses["db"])
+
+	_, err = a.Allocate([]string{"api", "db", "cache"})
+	assert.ErrorContains(t, err, "exhausted")
+
+	addresses, err = a.Allocate([]string{"db", "cache"})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.2"), addresses["cache"])
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "api")
+}
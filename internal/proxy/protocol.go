@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// handshakeTimeout bounds the proxy handshake of a client.
+const handshakeTimeout = 10 * time.Second
+
+// SOCKS5 protocol values (RFC 1928).
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCommandConnect = 0x01
+
+	socksAddressIPv4   = 0x01
+	socksAddressDomain = 0x03
+	socksAddressIPv6   = 0x04
+
+	socksSucceeded               = 0x00
+	socksGeneralFailure          = 0x01
+	socksNotAllowed              = 0x02
+	socksHostUnreachable         = 0x04
+	socksConnectionRefused       = 0x05
+	socksCommandNotSupported     = 0x07
+	socksAddressTypeNotSupported = 0x08
+)
+
+// serveSOCKS serves a SOCKS5 CONNECT request. Only the "no authentication" method and
+// domain name addresses are supported, as only cluster Service names can be resolved.
+func (s *Server) serveSOCKS(ctx context.Context, log *zerolog.Logger, conn net.Conn) {
+	// The forwarder closes the connection once handed over
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			conn.Close()
+		}
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	// Greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil || method == socksMethodNoAcceptable {
+		return
+	}
+
+	// Request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	case socksAddressIPv4, socksAddressIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddressIPv6 {
+			size = net.IPv6len
+		}
+		// Discard the address and port before replying
+		if _, err := io.ReadFull(conn, make([]byte, size+2)); err != nil {
+			return
+		}
+		writeSOCKSReply(conn, socksAddressTypeNotSupported)
+		return
+	default:
+		writeSOCKSReply(conn, socksAddressTypeNotSupported)
+		return
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return
+	}
+	port := int(binary.BigEndian.Uint16(portBytes))
+
+	if request[1] != socksCommandConnect {
+		writeSOCKSReply(conn, socksCommandNotSupported)
+		return
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+
+	attach, err := s.connect(ctx, host, port)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Proxy request to %s failed", target)
+		writeSOCKSReply(conn, socksReplyCode(err))
+		return
+	}
+
+	if err := writeSOCKSReply(conn, socksSucceeded); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	log.Debug().Msgf("Proxying SOCKS connection to %s", target)
+	handedOver = true
+	attach(conn)
+}
+
+// writeSOCKSReply writes a reply without bound address, which clients do not need here.
+func writeSOCKSReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAddressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksReplyCode maps a resolution error to a SOCKS reply code.
+func socksReplyCode(err error) byte {
+	if errors.Is(err, errUnknownHost) {
+		return socksHostUnreachable
+	}
+
+	switch locator.GetErrorType(err) {
+	case locator.ErrorTypeResourceNotFound:
+		return socksHostUnreachable
+	case locator.ErrorTypePermissionDenied:
+		return socksNotAllowed
+	case locator.ErrorTypeNoPodAvailable, locator.ErrorTypePodNotRunning, locator.ErrorTypePodFailed:
+		return socksConnectionRefused
+	default:
+		return socksGeneralFailure
+	}
+}
+
+// serveConnect serves an HTTP CONNECT request. Other methods are rejected, the proxy
+// only tunnels connections.
+func (s *Server) serveConnect(ctx context.Context, log *zerolog.Logger, conn *bufferedConn) {
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			conn.Close()
+		}
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	req, err := http.ReadRequest(conn.r)
+	if err != nil {
+		return
+	}
+
+	if req.Method != http.MethodConnect {
+		writeHTTPError(conn, http.StatusMethodNotAllowed, "only CONNECT requests are supported")
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(req.Host)
+	port, portErr := strconv.Atoi(portStr)
+	if err != nil || portErr != nil || port < 1 || port > 65535 {
+		writeHTTPError(conn, http.StatusBadRequest, "invalid CONNECT address")
+		return
+	}
+
+	attach, err := s.connect(ctx, host, port)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Proxy request to %s failed", req.Host)
+		writeHTTPError(conn, httpStatusCode(err), err.Error())
+		return
+	}
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	log.Debug().Msgf("Proxying CONNECT connection to %s", req.Host)
+	handedOver = true
+	attach(conn)
+}
+
+// writeHTTPError writes an error response, telling the client the connection is closed.
+func writeHTTPError(conn net.Conn, code int, message string) {
+	resp := &http.Response{
+		StatusCode:    code,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(message) + 1),
+		Body:          io.NopCloser(strings.NewReader(message + "\n")),
+		Close:         true,
+	}
+	_ = resp.Write(conn)
+}
+
+// httpStatusCode maps a resolution error to an HTTP status code.
+func httpStatusCode(err error) int {
+	switch socksReplyCode(err) {
+	case socksHostUnreachable:
+		return http.StatusNotFound
+	case socksNotAllowed:
+		return http.StatusForbidden
+	case socksConnectionRefused:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,334 @@
+// Package proxy implements a SOCKS5 and HTTP CONNECT proxy to the Services of the cluster.
+// Requests for name.namespace.svc[.cluster.local]:port are resolved through the Service
+// locator, and served by forwarders started on demand and stopped once idle.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/forwarder"
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// DefaultIdleTimeout is how long the tunnel of a Service port is kept without connections.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// locateTimeout bounds the resolution of a requested Service.
+const locateTimeout = 10 * time.Second
+
+// clusterDomain is the optional suffix of Service names.
+const clusterDomain = "cluster.local"
+
+// serviceAddress is a Service port requested through the proxy.
+type serviceAddress struct {
+	name      string
+	namespace string
+	port      int
+}
+
+func (a serviceAddress) String() string {
+	return fmt.Sprintf("%s.%s.svc:%d", a.name, a.namespace, a.port)
+}
+
+// parseServiceAddress parses a "name.namespace.svc[.cluster.local]" host. It returns false
+// for any other host.
+func parseServiceAddress(host string, port int) (serviceAddress, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	host = strings.TrimSuffix(host, "."+clusterDomain)
+
+	rest, ok := strings.CutSuffix(host, ".svc")
+	if !ok {
+		return serviceAddress{}, false
+	}
+
+	name, namespace, ok := strings.Cut(rest, ".")
+	if !ok || name == "" || namespace == "" || strings.Contains(namespace, ".") {
+		return serviceAddress{}, false
+	}
+
+	return serviceAddress{name: name, namespace: namespace, port: port}, true
+}
+
+// errUnknownHost is returned for hosts that are not cluster Service names.
+var errUnknownHost = errors.New("not a cluster service name")
+
+// Server is a SOCKS5 and HTTP CONNECT proxy to the Services of the cluster.
+type Server struct {
+	listener    net.Listener
+	idleTimeout time.Duration
+
+	client  kubernetes.Interface
+	restCfg *rest.Config
+	cache   *kubeinternal.InformerCache
+	options []forwarder.Option
+
+	// dial resolves a Service port and returns the function handing connections to it (test seam)
+	dial func(ctx context.Context, addr serviceAddress) (func(net.Conn), error)
+
+	// forwards are the forwarders of the Service ports in use, guarded by mu
+	mu       sync.Mutex
+	forwards map[serviceAddress]*forward
+
+	wg sync.WaitGroup
+}
+
+// forward is the forwarder of a Service port, stopped by cancel.
+type forward struct {
+	forwarder *forwarder.Forwarder
+	ctx       context.Context
+	cancel    context.CancelFunc
+	lastUsed  time.Time
+}
+
+// New creates a proxy listening on address. Forwarders are created with opts.
+func New(cfg config.ProxyConfiguration, client kubernetes.Interface, restCfg *rest.Config, cache *kubeinternal.InformerCache, opts ...forwarder.Option) (*Server, error) {
+	idleTimeout := DefaultIdleTimeout
+	if cfg.IdleTimeout != "" {
+		d, err := time.ParseDuration(cfg.IdleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy idle timeout: %w", err)
+		}
+		idleTimeout = d
+	}
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", cfg.Listen, err)
+	}
+
+	s := &Server{
+		listener:    ln,
+		idleTimeout: idleTimeout,
+		client:      client,
+		restCfg:     restCfg,
+		cache:       cache,
+		options:     opts,
+		forwards:    make(map[serviceAddress]*forward),
+	}
+	s.dial = s.open
+
+	return s, nil
+}
+
+// Addr returns the address the proxy listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts proxy connections until ctx is done, then stops all forwarders.
+func (s *Server) Serve(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+
+	log.Info().Msgf("Proxy listening on %s (SOCKS5 and HTTP CONNECT)", s.listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.collectIdle(ctx)
+	}()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Msg("Error accepting proxy connection")
+			}
+			break
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(ctx, log, conn)
+		}()
+	}
+
+	s.mu.Lock()
+	for addr, fwd := range s.forwards {
+		fwd.cancel()
+		delete(s.forwards, addr)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	log.Info().Msg("Proxy stopped")
+}
+
+// handle serves one proxy connection, speaking SOCKS5 when it starts with the SOCKS version,
+// HTTP otherwise.
+func (s *Server) handle(ctx context.Context, log *zerolog.Logger, conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	version, err := r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	client := &bufferedConn{Conn: conn, r: r}
+	if version[0] == socksVersion {
+		s.serveSOCKS(ctx, log, client)
+	} else {
+		s.serveConnect(ctx, log, client)
+	}
+}
+
+// connect resolves a requested host and port. On success, the returned function hands
+// the client connection over, once the client was told the connection succeeded.
+func (s *Server) connect(ctx context.Context, host string, port int) (func(net.Conn), error) {
+	addr, ok := parseServiceAddress(host, port)
+	if !ok {
+		return nil, errUnknownHost
+	}
+
+	return s.dial(ctx, addr)
+}
+
+// open returns the forwarder of a Service port, starting it when the Service resolves to a pod.
+func (s *Server) open(ctx context.Context, addr serviceAddress) (func(net.Conn), error) {
+	s.mu.Lock()
+	fwd, exists := s.forwards[addr]
+	if exists && fwd.forwarder.Status().State == forwarder.StateFailed {
+		fwd.cancel()
+		delete(s.forwards, addr)
+		exists = false
+	}
+	if exists {
+		fwd.lastUsed = time.Now()
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		var err error
+		fwd, err = s.start(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(conn net.Conn) {
+		fwd.forwarder.Attach(fwd.ctx, conn, addr.port)
+	}, nil
+}
+
+// start checks that a Service port resolves to a pod, then starts its forwarder.
+func (s *Server) start(ctx context.Context, addr serviceAddress) (*forward, error) {
+	ports := []string{strconv.Itoa(addr.port)}
+
+	loc, err := locator.NewServiceLocator(addr.name, addr.namespace, ports, s.cache)
+	if err != nil {
+		return nil, err
+	}
+
+	locateCtx, cancel := context.WithTimeout(ctx, locateTimeout)
+	defer cancel()
+	if _, err := loc.Locate(locateCtx); err != nil {
+		return nil, err
+	}
+
+	pf := config.PortForwardConfiguration{
+		Name:      "proxy/" + addr.String(),
+		Namespace: addr.namespace,
+		Resource:  "svc/" + addr.name,
+		Ports:     ports,
+	}
+
+	opts := append([]forwarder.Option{forwarder.WithoutListeners()}, s.options...)
+	f, err := forwarder.New(loc, pf, s.client, s.restCfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another request may have started it meanwhile
+	if existing, exists := s.forwards[addr]; exists {
+		existing.lastUsed = time.Now()
+		return existing, nil
+	}
+
+	fwdCtx, fwdCancel := context.WithCancel(ctx)
+	fwd := &forward{forwarder: f, ctx: fwdCtx, cancel: fwdCancel, lastUsed: time.Now()}
+	s.forwards[addr] = fwd
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f.Start(fwdCtx)
+	}()
+
+	return fwd, nil
+}
+
+// collectIdle stops the forwarders without connections for the idle timeout, until ctx is done.
+func (s *Server) collectIdle(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+
+	ticker := time.NewTicker(max(s.idleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		for addr, fwd := range s.forwards {
+			if fwd.forwarder.Metrics().ActiveConnections > 0 {
+				fwd.lastUsed = time.Now()
+				continue
+			}
+			if time.Since(fwd.lastUsed) >= s.idleTimeout {
+				log.Info().Msgf("Closing idle proxy tunnel to %s", addr)
+				fwd.cancel()
+				delete(s.forwards, addr)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Status returns the status of the forwarders of the Service ports in use.
+func (s *Server) Status() []forwarder.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]forwarder.Status, 0, len(s.forwards))
+	for _, fwd := range s.forwards {
+		statuses = append(statuses, fwd.forwarder.Status())
+	}
+	return statuses
+}
+
+// bufferedConn is a connection whose first bytes were read into a buffer.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
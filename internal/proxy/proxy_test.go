@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/forwarder"
+	kubeinternal "github.com/codozor/fwkeeper/internal/kubernetes"
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// TestParseServiceAddress tests the cluster Service names accepted by the proxy
+func TestParseServiceAddress(t *testing.T) {
+	for _, tc := range []struct {
+		host     string
+		expected serviceAddress
+		ok       bool
+	}{
+		{"api.default.svc", serviceAddress{name: "api", namespace: "default", port: 80}, true},
+		{"api.default.svc.cluster.local", serviceAddress{name: "api", namespace: "default", port: 80}, true},
+		{"API.Default.svc.cluster.local.", serviceAddress{name: "api", namespace: "default", port: 80}, true},
+		{"api.default", serviceAddress{}, false},
+		{"api.svc", serviceAddress{}, false},
+		{"api.team.default.svc", serviceAddress{}, false},
+		{"example.com", serviceAddress{}, false},
+		{".default.svc", serviceAddress{}, false},
+	} {
+		addr, ok := parseServiceAddress(tc.host, 80)
+		assert.Equal(t, tc.ok, ok, tc.host)
+		assert.Equal(t, tc.expected, addr, tc.host)
+	}
+}
+
+// startTestServer starts a proxy whose only known Service is api.default:80, echoing data
+func startTestServer(t *testing.T) *Server {
+	s, err := New(config.ProxyConfiguration{Listen: "127.0.0.1:0"}, nil, &rest.Config{}, nil)
+	require.NoError(t, err)
+
+	s.dial = func(ctx context.Context, addr serviceAddress) (func(net.Conn), error) {
+		switch addr {
+		case serviceAddress{name: "api", namespace: "default", port: 80}:
+			return func(conn net.Conn) {
+				go func() {
+					defer conn.Close()
+					_, _ = io.Copy(conn, conn)
+				}()
+			}, nil
+		case serviceAddress{name: "secret", namespace: "default", port: 80}:
+			return nil, locator.NewPermissionDeniedError("list", "services", errors.New("denied"))
+		default:
+			return nil, locator.NewResourceNotFoundError("service", addr.name, nil)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(zerolog.Nop().WithContext(context.Background()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return s
+}
+
+// socksConnect sends a SOCKS5 CONNECT request with the given address type and address,
+// and returns the connection and the reply code
+func socksConnect(t *testing.T, s *Server, command byte, addressType byte, address []byte, port int) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte{socksVersion, 1, socksMethodNoAuth})
+	require.NoError(t, err)
+
+	method := make([]byte, 2)
+	_, err = io.ReadFull(conn, method)
+	require.NoError(t, err)
+	require.Equal(t, []byte{socksVersion, socksMethodNoAuth}, method)
+
+	request := append([]byte{socksVersion, command, 0x00, addressType}, address...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	return conn, reply[1]
+}
+
+func domain(host string) []byte {
+	return append([]byte{byte(len(host))}, host...)
+}
+
+// TestSOCKSConnect tests SOCKS5 CONNECT requests and their reply codes
+func TestSOCKSConnect(t *testing.T) {
+	s := startTestServer(t)
+
+	conn, code := socksConnect(t, s, socksCommandConnect, socksAddressDomain, domain("api.default.svc.cluster.local"), 80)
+	require.Equal(t, byte(socksSucceeded), code)
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+
+	_, code = socksConnect(t, s, socksCommandConnect, socksAddressDomain, domain("missing.default.svc"), 80)
+	assert.Equal(t, byte(socksHostUnreachable), code)
+
+	_, code = socksConnect(t, s, socksCommandConnect, socksAddressDomain, domain("example.com"), 443)
+	assert.Equal(t, byte(socksHostUnreachable), code)
+
+	_, code = socksConnect(t, s, socksCommandConnect, socksAddressDomain, domain("secret.default.svc"), 80)
+	assert.Equal(t, byte(socksNotAllowed), code)
+
+	_, code = socksConnect(t, s, socksCommandConnect, socksAddressIPv4, []byte{10, 0, 0, 1}, 80)
+	assert.Equal(t, byte(socksAddressTypeNotSupported), code)
+
+	_, code = socksConnect(t, s, 0x02, socksAddressDomain, domain("api.default.svc"), 80)
+	assert.Equal(t, byte(socksCommandNotSupported), code)
+}
+
+// httpConnect sends raw on a new connection and returns the response and its reader
+func httpConnect(t *testing.T, s *Server, raw string) (*http.Response, *bufio.Reader, net.Conn) {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	return resp, r, conn
+}
+
+// TestHTTPConnect tests HTTP CONNECT requests and their status codes
+func TestHTTPConnect(t *testing.T) {
+	s := startTestServer(t)
+
+	// Data sent right after the request is not lost
+	resp, r, _ := httpConnect(t, s, "CONNECT api.default.svc:80 HTTP/1.1\r\nHost: api.default.svc:80\r\n\r\nping")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reply := make([]byte, 4)
+	_, err := io.ReadFull(r, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+
+	resp, _, _ = httpConnect(t, s, "CONNECT missing.default.svc:80 HTTP/1.1\r\nHost: missing.default.svc:80\r\n\r\n")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _, _ = httpConnect(t, s, "CONNECT secret.default.svc:80 HTTP/1.1\r\nHost: secret.default.svc:80\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _, _ = httpConnect(t, s, "GET http://api.default.svc/ HTTP/1.1\r\nHost: api.default.svc\r\n\r\n")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// TestServerOpenUnknownService tests that Services are resolved through the informer cache
+func TestServerOpenUnknownService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := kubeinternal.NewInformerCache(ctx, fake.NewClientset(), nil, "test")
+	t.Cleanup(func() {
+		cancel()
+		cache.Shutdown()
+	})
+
+	s, err := New(config.ProxyConfiguration{Listen: "127.0.0.1:0"}, nil, &rest.Config{}, cache)
+	require.NoError(t, err)
+	defer s.listener.Close()
+
+	_, err = s.connect(ctx, "api.default.svc", 80)
+
+	assert.Equal(t, locator.ErrorTypeResourceNotFound, locator.GetErrorType(err))
+	assert.Equal(t, byte(socksHostUnreachable), socksReplyCode(err))
+	assert.Empty(t, s.Status())
+}
+
+// TestServerCollectIdle tests that tunnels without connections are stopped after the idle timeout
+func TestServerCollectIdle(t *testing.T) {
+	s, err := New(config.ProxyConfiguration{Listen: "127.0.0.1:0", IdleTimeout: "10ms"}, nil, &rest.Config{}, nil)
+	require.NoError(t, err)
+	defer s.listener.Close()
+
+	f, err := forwarder.New(nil, config.PortForwardConfiguration{Name: "proxy/api.default.svc:80", Ports: []string{"80"}}, nil, &rest.Config{}, forwarder.WithoutListeners())
+	require.NoError(t, err)
+
+	fwdCtx, fwdCancel := context.WithCancel(context.Background())
+	addr := serviceAddress{name: "api", namespace: "default", port: 80}
+	s.forwards[addr] = &forward{forwarder: f, ctx: fwdCtx, cancel: fwdCancel, lastUsed: time.Now()}
+	require.Len(t, s.Status(), 1)
+
+	ctx, cancel := context.WithCancel(zerolog.Nop().WithContext(context.Background()))
+	defer cancel()
+	go s.collectIdle(ctx)
+
+	require.Eventually(t, func() bool { return len(s.Status()) == 0 }, 3*time.Second, 10*time.Millisecond)
+	assert.Error(t, fwdCtx.Err(), "the forwarder of an idle tunnel should be stopped")
+}
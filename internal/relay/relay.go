@@ -0,0 +1,527 @@
+// Package relay manages the relay pods of host forwards: small pods that pipe a port to
+// an address only reachable from inside the cluster, created on demand and removed on shutdown.
+// Reverse forwards use relay pods too, accepting in-cluster connections that are tunnelled back.
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/codozor/fwkeeper/internal/config"
+	"github.com/codozor/fwkeeper/internal/locator"
+)
+
+// Labels and annotations of relay pods. Orphans are found by the managed-by, component
+// and owner labels, so that a fwkeeper instance only collects its own relay pods.
+const (
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	LabelComponent = "app.kubernetes.io/component"
+	LabelOwner     = "fwkeeper.io/owner"
+	LabelForward   = "fwkeeper.io/forward"
+
+	AnnotationOwner  = "fwkeeper.io/owner"
+	AnnotationTarget = "fwkeeper.io/target"
+	AnnotationSpec   = "fwkeeper.io/spec"
+
+	managedBy = "fwkeeper"
+	component = "relay"
+)
+
+// ReverseTunnelPort is the port reverse relay pods hand in-cluster connections over on,
+// ReverseTunnelPort+1 when it is the exposed port.
+const ReverseTunnelPort = 17170
+
+// objectRef identifies a relay pod or Service.
+type objectRef struct {
+	namespace string
+	name      string
+}
+
+// Manager creates the relay pods of host and reverse forwards, one per forward, and the
+// Services of reverse forwards, and deletes them on release.
+type Manager struct {
+	client kubernetes.Interface
+
+	// owner identifies this fwkeeper instance (user and host), ownerLabel is its label value
+	owner      string
+	ownerLabel string
+
+	mu            sync.Mutex
+	configuration config.RelayConfiguration
+	pods          map[string]objectRef // forward name -> relay pod
+	services      map[string]objectRef // forward name -> Service of a reverse forward
+}
+
+// NewManager creates a relay manager. owner identifies the fwkeeper instance, see DefaultOwner.
+func NewManager(client kubernetes.Interface, configuration config.RelayConfiguration, owner string) *Manager {
+	sum := sha256.Sum256([]byte(owner))
+
+	return &Manager{
+		client:        client,
+		owner:         owner,
+		ownerLabel:    hex.EncodeToString(sum[:])[:16],
+		configuration: configuration,
+		pods:          make(map[string]objectRef),
+		services:      make(map[string]objectRef),
+	}
+}
+
+// DefaultOwner identifies the current user on the current host.
+func DefaultOwner() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return username + "@" + hostname
+}
+
+// Configure changes the settings of the relay pods created from now on.
+func (m *Manager) Configure(configuration config.RelayConfiguration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configuration = configuration
+}
+
+// For returns the relay of a forward, to be used by its locator.
+func (m *Manager) For(forward string) locator.Relay {
+	return &forwardRelay{manager: m, forward: forward}
+}
+
+// Reverse returns the relay of a reverse forward, to be used by its locator. The relay pod
+// runs in namespace, behind a Service named service unless empty.
+func (m *Manager) Reverse(forward string, namespace string, service string) locator.ReverseRelay {
+	return &reverseRelay{manager: m, forward: forward, namespace: namespace, service: service}
+}
+
+// forwardRelay is the relay of one forward.
+type forwardRelay struct {
+	manager *Manager
+	forward string
+}
+
+func (r *forwardRelay) Ensure(ctx context.Context, host string, port int) (*corev1.Pod, error) {
+	return r.manager.Ensure(ctx, r.forward, host, port)
+}
+
+// reverseRelay is the relay of one reverse forward.
+type reverseRelay struct {
+	manager   *Manager
+	forward   string
+	namespace string
+	service   string
+}
+
+func (r *reverseRelay) EnsureReverse(ctx context.Context, port int) (*corev1.Pod, error) {
+	return r.manager.EnsureReverse(ctx, r.forward, r.namespace, port, r.service)
+}
+
+// Ensure returns the relay pod of a forward, creating it when missing. A relay pod that
+// terminated or is being deleted is replaced.
+func (m *Manager) Ensure(ctx context.Context, forward string, host string, port int) (*corev1.Pod, error) {
+	return m.ensurePod(ctx, forward, func(configuration config.RelayConfiguration) *corev1.Pod {
+		return m.podSpec(configuration, forward, host, port)
+	})
+}
+
+// EnsureReverse returns the relay pod of a reverse forward exposing port in namespace, creating
+// it when missing, and makes sure the Service in front of it exists when service is not empty.
+func (m *Manager) EnsureReverse(ctx context.Context, forward string, namespace string, port int, service string) (*corev1.Pod, error) {
+	pod, err := m.ensurePod(ctx, forward, func(configuration config.RelayConfiguration) *corev1.Pod {
+		return m.reversePodSpec(configuration, forward, namespace, port)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if service != "" {
+		if err := m.ensureService(ctx, forward, namespace, service, port); err != nil {
+			return nil, err
+		}
+	}
+
+	return pod, nil
+}
+
+// ensurePod returns the relay pod of a forward, creating it from build when missing. A relay
+// pod built for another target, namespace or relay configuration is replaced.
+func (m *Manager) ensurePod(ctx context.Context, forward string, build func(config.RelayConfiguration) *corev1.Pod) (*corev1.Pod, error) {
+	m.mu.Lock()
+	ref, exists := m.pods[forward]
+	configuration := m.configuration
+	m.mu.Unlock()
+
+	spec := build(configuration)
+	spec.Annotations[AnnotationSpec] = specHash(spec)
+
+	if exists {
+		pod, err := m.client.CoreV1().Pods(ref.namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		switch {
+		case err == nil && pod.DeletionTimestamp == nil && pod.Status.Phase != corev1.PodFailed && pod.Status.Phase != corev1.PodSucceeded &&
+			pod.Namespace == spec.Namespace && pod.Annotations[AnnotationSpec] == spec.Annotations[AnnotationSpec]:
+			return pod, nil
+		case err == nil:
+			if err := m.delete(ctx, ref); err != nil {
+				return nil, err
+			}
+		case !apierrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+
+	pod, err := m.client.CoreV1().Pods(spec.Namespace).Create(ctx, spec, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.pods[forward] = objectRef{namespace: pod.Namespace, name: pod.Name}
+	m.mu.Unlock()
+
+	return pod, nil
+}
+
+// ensureService creates the Service of a reverse forward, selecting its relay pod. An existing
+// Service is only updated when it was created by this instance for the same forward.
+func (m *Manager) ensureService(ctx context.Context, forward string, namespace string, name string, port int) error {
+	spec := m.serviceSpec(forward, namespace, name, port)
+
+	existing, err := m.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := m.client.CoreV1().Services(namespace).Create(ctx, spec, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case existing.Labels[LabelOwner] != m.ownerLabel || existing.Labels[LabelForward] != labelValue(forward):
+		return fmt.Errorf("service %s/%s already exists and is not managed by this forward", namespace, name)
+	default:
+		existing.Spec.Selector = spec.Spec.Selector
+		existing.Spec.Ports = spec.Spec.Ports
+		if _, err := m.client.CoreV1().Services(namespace).Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.services[forward] = objectRef{namespace: namespace, name: name}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Release deletes the relay pod and Service of a forward, if any.
+func (m *Manager) Release(ctx context.Context, forward string) error {
+	if release := m.Detach(forward); release != nil {
+		return release(ctx)
+	}
+	return nil
+}
+
+// Detach forgets the relay pod and Service of a forward at once, so that new ones can be
+// created for it, and returns the function deleting them. It returns nil when the forward
+// has none.
+func (m *Manager) Detach(forward string) func(ctx context.Context) error {
+	m.mu.Lock()
+	pod, podExists := m.pods[forward]
+	service, serviceExists := m.services[forward]
+	delete(m.pods, forward)
+	delete(m.services, forward)
+	m.mu.Unlock()
+
+	if !podExists && !serviceExists {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		var errs []error
+		if serviceExists {
+			errs = append(errs, m.deleteService(ctx, service))
+		}
+		if podExists {
+			errs = append(errs, m.delete(ctx, pod))
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Shutdown deletes all relay pods and Services created by the manager.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	pods := m.pods
+	services := m.services
+	m.pods = make(map[string]objectRef)
+	m.services = make(map[string]objectRef)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, ref := range services {
+		errs = append(errs, m.deleteService(ctx, ref))
+	}
+	for _, ref := range pods {
+		errs = append(errs, m.delete(ctx, ref))
+	}
+	return errors.Join(errs...)
+}
+
+// CollectOrphans deletes the relay pods and Services left in the relay namespace, and in the
+// given namespaces of reverse forwards, by a previous run of this instance (same owner), for
+// example after a crash. It returns the number of deleted objects.
+func (m *Manager) CollectOrphans(ctx context.Context, namespaces ...string) (int, error) {
+	m.mu.Lock()
+	known := make(map[objectRef]bool, len(m.pods)+len(m.services))
+	for _, ref := range m.pods {
+		known[ref] = true
+	}
+	for _, ref := range m.services {
+		known[ref] = true
+	}
+	namespaces = append([]string{m.configuration.Namespace}, namespaces...)
+	m.mu.Unlock()
+
+	selector := labels.SelectorFromSet(labels.Set{
+		LabelManagedBy: managedBy,
+		LabelComponent: component,
+		LabelOwner:     m.ownerLabel,
+	})
+	options := metav1.ListOptions{LabelSelector: selector.String()}
+
+	deleted := 0
+	var errs []error
+	collect := func(ref objectRef, remove func(context.Context, objectRef) error) {
+		if known[ref] {
+			return
+		}
+		if err := remove(ctx, ref); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		deleted++
+	}
+
+	slices.Sort(namespaces)
+	for _, namespace := range slices.Compact(namespaces) {
+		services, err := m.client.CoreV1().Services(namespace).List(ctx, options)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, service := range services.Items {
+			collect(objectRef{namespace: service.Namespace, name: service.Name}, m.deleteService)
+		}
+
+		pods, err := m.client.CoreV1().Pods(namespace).List(ctx, options)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, pod := range pods.Items {
+			collect(objectRef{namespace: pod.Namespace, name: pod.Name}, m.delete)
+		}
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+// delete deletes a relay pod without waiting for its grace period. A missing pod is not an error.
+func (m *Manager) delete(ctx context.Context, ref objectRef) error {
+	grace := int64(0)
+	err := m.client.CoreV1().Pods(ref.namespace).Delete(ctx, ref.name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete relay pod %s/%s: %w", ref.namespace, ref.name, err)
+	}
+	return nil
+}
+
+// deleteService deletes the Service of a reverse forward. A missing Service is not an error.
+func (m *Manager) deleteService(ctx context.Context, ref objectRef) error {
+	err := m.client.CoreV1().Services(ref.namespace).Delete(ctx, ref.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete relay service %s/%s: %w", ref.namespace, ref.name, err)
+	}
+	return nil
+}
+
+// selector returns the labels identifying the relay objects of a forward.
+func (m *Manager) selector(forward string) map[string]string {
+	return map[string]string{
+		LabelManagedBy: managedBy,
+		LabelComponent: component,
+		LabelOwner:     m.ownerLabel,
+		LabelForward:   labelValue(forward),
+	}
+}
+
+// objectMeta returns the metadata of a relay object, with the configured labels and annotations.
+func (m *Manager) objectMeta(configuration config.RelayConfiguration, forward string, target string) metav1.ObjectMeta {
+	objectLabels := maps.Clone(configuration.Labels)
+	if objectLabels == nil {
+		objectLabels = make(map[string]string)
+	}
+	maps.Copy(objectLabels, m.selector(forward))
+
+	annotations := maps.Clone(configuration.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[AnnotationOwner] = m.owner
+	annotations[AnnotationTarget] = target
+
+	return metav1.ObjectMeta{
+		Labels:      objectLabels,
+		Annotations: annotations,
+	}
+}
+
+// reversePodSpec builds the relay pod of a reverse forward, accepting in-cluster connections
+// on port and handing them over on the tunnel port.
+func (m *Manager) reversePodSpec(configuration config.RelayConfiguration, forward string, namespace string, port int) *corev1.Pod {
+	tunnelPort := ReverseTunnelPort
+	if tunnelPort == port {
+		tunnelPort++
+	}
+
+	pod := m.podSpec(configuration, forward, "", port)
+	pod.Namespace = namespace
+	pod.Annotations[AnnotationTarget] = "reverse:" + strconv.Itoa(port)
+
+	container := &pod.Spec.Containers[0]
+	container.Command = configuration.ReverseCommand
+	container.Env = []corev1.EnvVar{
+		{Name: "RELAY_PORT", Value: strconv.Itoa(port)},
+		{Name: "RELAY_TUNNEL_PORT", Value: strconv.Itoa(tunnelPort)},
+	}
+	container.Ports = append(container.Ports, corev1.ContainerPort{
+		Name:          locator.ReverseTunnelPortName,
+		ContainerPort: int32(tunnelPort),
+		Protocol:      corev1.ProtocolTCP,
+	})
+
+	return pod
+}
+
+// serviceSpec builds the Service of a reverse forward.
+func (m *Manager) serviceSpec(forward string, namespace string, name string, port int) *corev1.Service {
+	m.mu.Lock()
+	meta := m.objectMeta(m.configuration, forward, "reverse:"+strconv.Itoa(port))
+	m.mu.Unlock()
+
+	meta.Name = name
+	meta.Namespace = namespace
+
+	return &corev1.Service{
+		ObjectMeta: meta,
+		Spec: corev1.ServiceSpec{
+			Selector: m.selector(forward),
+			Ports: []corev1.ServicePort{{
+				Name:       "relay",
+				Port:       int32(port),
+				TargetPort: intstr.FromInt32(int32(port)),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+}
+
+// podSpec builds the relay pod of a forward.
+func (m *Manager) podSpec(configuration config.RelayConfiguration, forward string, host string, port int) *corev1.Pod {
+	meta := m.objectMeta(configuration, forward, fmt.Sprintf("%s:%d", host, port))
+
+	resources := corev1.ResourceList{}
+	if q, err := resource.ParseQuantity(configuration.CPU); err == nil && configuration.CPU != "" {
+		resources[corev1.ResourceCPU] = q
+	}
+	if q, err := resource.ParseQuantity(configuration.Memory); err == nil && configuration.Memory != "" {
+		resources[corev1.ResourceMemory] = q
+	}
+
+	automount := false
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "fwkeeper-relay-" + utilrand.String(8),
+			Namespace:   configuration.Namespace,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyAlways,
+			AutomountServiceAccountToken:  &automount,
+			ServiceAccountName:            configuration.ServiceAccountName,
+			NodeSelector:                  configuration.NodeSelector,
+			TerminationGracePeriodSeconds: new(int64),
+			Containers: []corev1.Container{{
+				Name:            "relay",
+				Image:           configuration.Image,
+				ImagePullPolicy: corev1.PullPolicy(configuration.ImagePullPolicy),
+				Command:         configuration.Command,
+				Env: []corev1.EnvVar{
+					{Name: "RELAY_HOST", Value: host},
+					{Name: "RELAY_PORT", Value: strconv.Itoa(port)},
+				},
+				Ports: []corev1.ContainerPort{{
+					Name:          "relay",
+					ContainerPort: int32(port),
+					Protocol:      corev1.ProtocolTCP,
+				}},
+				Resources: corev1.ResourceRequirements{
+					Requests: resources,
+					Limits:   resources.DeepCopy(),
+				},
+			}},
+		},
+	}
+}
+
+// specHash identifies what a relay pod is built from: its target, labels, annotations and spec.
+func specHash(pod *corev1.Pod) string {
+	data, _ := json.Marshal(struct {
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		Spec        corev1.PodSpec    `json:"spec"`
+	}{pod.Labels, pod.Annotations, pod.Spec})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// labelValue makes a forward name a valid label value, hashing names that do not fit.
+func labelValue(name string) string {
+	valid := len(name) <= 63
+	for i, c := range name {
+		alnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !alnum && (i == 0 || i == len(name)-1 || (c != '-' && c != '_' && c != '.')) {
+			valid = false
+		}
+	}
+	if valid {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,249 @@
+package relay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/codozor/fwkeeper/internal/config"
+)
+
+func testConfiguration() config.RelayConfiguration {
+	return config.RelayConfiguration{
+		Namespace:       "tools",
+		Image:           "alpine/socat:1.8.0.3",
+		ImagePullPolicy: "IfNotPresent",
+		Command:         []string{"socat", "TCP-LISTEN:$(RELAY_PORT),fork,reuseaddr", "TCP:$(RELAY_HOST):$(RELAY_PORT)"},
+		ReverseCommand:  []string{"socat", "TCP-LISTEN:$(RELAY_TUNNEL_PORT),fork,reuseaddr", "TCP-LISTEN:$(RELAY_PORT),reuseaddr,reuseport"},
+		Labels:          map[string]string{"team": "platform"},
+		CPU:             "50m",
+		Memory:          "32Mi",
+	}
+}
+
+func listRelayPods(t *testing.T, client *fake.Clientset, namespace string) []corev1.Pod {
+	pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	return pods.Items
+}
+
+// TestManagerEnsureCreatesRelayPod tests the relay pod spec and its labels
+func TestManagerEnsureCreatesRelayPod(t *testing.T) {
+	client := fake.NewClientset()
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+
+	pod, err := m.For("db").Ensure(context.Background(), "db.internal", 5432)
+
+	require.NoError(t, err)
+	assert.Equal(t, "tools", pod.Namespace)
+	assert.Contains(t, pod.Name, "fwkeeper-relay-")
+	assert.Equal(t, "fwkeeper", pod.Labels[LabelManagedBy])
+	assert.Equal(t, "relay", pod.Labels[LabelComponent])
+	assert.Equal(t, "db", pod.Labels[LabelForward])
+	assert.Equal(t, "platform", pod.Labels["team"])
+	assert.NotEmpty(t, pod.Labels[LabelOwner])
+	assert.Equal(t, "alice@laptop", pod.Annotations[AnnotationOwner])
+	assert.Equal(t, "db.internal:5432", pod.Annotations[AnnotationTarget])
+
+	require.Len(t, pod.Spec.Containers, 1)
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, "alpine/socat:1.8.0.3", container.Image)
+	assert.Equal(t, testConfiguration().Command, container.Command)
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "RELAY_HOST", Value: "db.internal"})
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "RELAY_PORT", Value: "5432"})
+	assert.Equal(t, int32(5432), container.Ports[0].ContainerPort)
+	assert.Equal(t, "50m", container.Resources.Limits.Cpu().String())
+
+	// The same pod is returned while it lives
+	again, err := m.For("db").Ensure(context.Background(), "db.internal", 5432)
+	require.NoError(t, err)
+	assert.Equal(t, pod.Name, again.Name)
+	assert.Len(t, listRelayPods(t, client, "tools"), 1)
+}
+
+// TestManagerEnsureReplacesTerminatedPod tests that a terminated or deleted relay pod is recreated
+func TestManagerEnsureReplacesTerminatedPod(t *testing.T) {
+	client := fake.NewClientset()
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	ctx := context.Background()
+
+	pod, err := m.Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+
+	pod.Status.Phase = corev1.PodFailed
+	_, err = client.CoreV1().Pods("tools").UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	replaced, err := m.Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+	assert.NotEqual(t, pod.Name, replaced.Name)
+	assert.Len(t, listRelayPods(t, client, "tools"), 1)
+
+	require.NoError(t, client.CoreV1().Pods("tools").Delete(ctx, replaced.Name, metav1.DeleteOptions{}))
+
+	recreated, err := m.Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+	assert.NotEqual(t, replaced.Name, recreated.Name)
+}
+
+// TestManagerEnsureReplacesChangedPod tests that a relay pod is replaced when the target or
+// the relay configuration of its forward changed
+func TestManagerEnsureReplacesChangedPod(t *testing.T) {
+	client := fake.NewClientset()
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	ctx := context.Background()
+
+	pod, err := m.Ensure(ctx, "db", "a.internal", 5432)
+	require.NoError(t, err)
+
+	retargeted, err := m.Ensure(ctx, "db", "b.internal", 5432)
+	require.NoError(t, err)
+	assert.NotEqual(t, pod.Name, retargeted.Name)
+	assert.Equal(t, "b.internal:5432", retargeted.Annotations[AnnotationTarget])
+	assert.Len(t, listRelayPods(t, client, "tools"), 1)
+
+	configuration := testConfiguration()
+	configuration.Image = "alpine/socat:1.8.0.4"
+	m.Configure(configuration)
+	upgraded, err := m.Ensure(ctx, "db", "b.internal", 5432)
+	require.NoError(t, err)
+	assert.NotEqual(t, retargeted.Name, upgraded.Name)
+	assert.Equal(t, "alpine/socat:1.8.0.4", upgraded.Spec.Containers[0].Image)
+
+	configuration.Namespace = "relays"
+	m.Configure(configuration)
+	moved, err := m.Ensure(ctx, "db", "b.internal", 5432)
+	require.NoError(t, err)
+	assert.Equal(t, "relays", moved.Namespace)
+	assert.Empty(t, listRelayPods(t, client, "tools"))
+
+	again, err := m.Ensure(ctx, "db", "b.internal", 5432)
+	require.NoError(t, err)
+	assert.Equal(t, moved.Name, again.Name)
+}
+
+// TestManagerReleaseAndShutdown tests that relay pods are deleted on release and shutdown
+func TestManagerReleaseAndShutdown(t *testing.T) {
+	client := fake.NewClientset()
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	ctx := context.Background()
+
+	db, err := m.Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+	_, err = m.Ensure(ctx, "cache", "redis.internal", 6379)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Release(ctx, "db"))
+	_, err = client.CoreV1().Pods("tools").Get(ctx, db.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.NoError(t, m.Release(ctx, "db"))
+	assert.Nil(t, m.Detach("db"), "nothing to delete for a released forward")
+
+	require.NoError(t, m.Shutdown(ctx))
+	assert.Empty(t, listRelayPods(t, client, "tools"))
+}
+
+// TestManagerCollectOrphans tests that only the relay pods of the same owner are collected
+func TestManagerCollectOrphans(t *testing.T) {
+	client := fake.NewClientset()
+	ctx := context.Background()
+
+	// Left over by a previous run of the same owner, and by another user
+	_, err := NewManager(client, testConfiguration(), "alice@laptop").Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+	other, err := NewManager(client, testConfiguration(), "bob@desktop").Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	current, err := m.Ensure(ctx, "db", "db.internal", 5432)
+	require.NoError(t, err)
+
+	deleted, err := m.CollectOrphans(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	var names []string
+	for _, pod := range listRelayPods(t, client, "tools") {
+		names = append(names, pod.Name)
+	}
+	assert.ElementsMatch(t, []string{other.Name, current.Name}, names)
+}
+
+// TestManagerEnsureReverse tests the reverse relay pod and the Service in front of it
+func TestManagerEnsureReverse(t *testing.T) {
+	client := fake.NewClientset()
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	ctx := context.Background()
+
+	pod, err := m.Reverse("webhook", "dev", "webhook-receiver").EnsureReverse(ctx, 8080)
+
+	require.NoError(t, err)
+	assert.Equal(t, "dev", pod.Namespace)
+	assert.Equal(t, "webhook", pod.Labels[LabelForward])
+
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, testConfiguration().ReverseCommand, container.Command)
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "RELAY_PORT", Value: "8080"})
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "RELAY_TUNNEL_PORT", Value: "17170"})
+	assert.Contains(t, container.Ports, corev1.ContainerPort{Name: "tunnel", ContainerPort: 17170, Protocol: corev1.ProtocolTCP})
+
+	service, err := client.CoreV1().Services("dev").Get(ctx, "webhook-receiver", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, m.selector("webhook"), service.Spec.Selector)
+	assert.Equal(t, int32(8080), service.Spec.Ports[0].Port)
+	assert.Equal(t, int32(8080), service.Spec.Ports[0].TargetPort.IntVal)
+
+	// The tunnel port moves aside when it is the exposed port
+	pod, err = m.EnsureReverse(ctx, "other", "dev", ReverseTunnelPort, "")
+	require.NoError(t, err)
+	assert.Contains(t, pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "RELAY_TUNNEL_PORT", Value: "17171"})
+
+	require.NoError(t, m.Shutdown(ctx))
+	assert.Empty(t, listRelayPods(t, client, "dev"))
+	_, err = client.CoreV1().Services("dev").Get(ctx, "webhook-receiver", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+// TestManagerEnsureReverseServiceConflict tests that a Service not created for the forward is left alone
+func TestManagerEnsureReverseServiceConflict(t *testing.T) {
+	client := fake.NewClientset(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"}})
+	m := NewManager(client, testConfiguration(), "alice@laptop")
+	ctx := context.Background()
+
+	_, err := m.EnsureReverse(ctx, "webhook", "dev", 8080, "api")
+	assert.Error(t, err)
+
+	require.NoError(t, m.Release(ctx, "webhook"))
+	_, err = client.CoreV1().Services("dev").Get(ctx, "api", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+// TestManagerCollectOrphansReverse tests that orphans are collected in the namespaces of reverse forwards
+func TestManagerCollectOrphansReverse(t *testing.T) {
+	client := fake.NewClientset()
+	ctx := context.Background()
+
+	_, err := NewManager(client, testConfiguration(), "alice@laptop").EnsureReverse(ctx, "webhook", "dev", 8080, "webhook-receiver")
+	require.NoError(t, err)
+
+	deleted, err := NewManager(client, testConfiguration(), "alice@laptop").CollectOrphans(ctx, "dev", "tools")
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Empty(t, listRelayPods(t, client, "dev"))
+}
+
+// TestLabelValue tests that forward names are turned into valid label values
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "db-primary", labelValue("db-primary"))
+	assert.Len(t, labelValue("db primary"), 16)
+	assert.Len(t, labelValue("-db"), 16)
+	assert.Len(t, labelValue(string(make([]byte, 64))), 16)
+}